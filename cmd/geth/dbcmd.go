@@ -0,0 +1,305 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	verifyBlockFlag = cli.Uint64Flag{
+		Name:  "block",
+		Usage: "Number of the block to verify the state of (default = head block)",
+	}
+	dbCommand = cli.Command{
+		Name:      "db",
+		Usage:     "Low level database operations",
+		ArgsUsage: "",
+		Category:  "DATABASE COMMANDS",
+		Description: `
+The db commands inspect and maintain the chain database. The node must not be
+running while they are executed.`,
+		Subcommands: []cli.Command{
+			{
+				Name:   "stats",
+				Usage:  "Print the statistics of every bucket of the chain database",
+				Action: utils.MigrateFlags(dbStats),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DatabaseEngineFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    geth db stats
+
+Prints, for every bucket, the number of keys, the total size of the keys and
+the values, the share of the allocated pages in use (for page based engines),
+and, for the history buckets, the range of blocks covered by the change sets.`,
+			},
+			{
+				Name:   "compact",
+				Usage:  "Compact the chain database by copying it into a fresh one",
+				Action: utils.MigrateFlags(dbCompact),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DatabaseEngineFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    geth db compact
+
+Copies every bucket of the chain database into a fresh database next to it,
+verifies that the copy has exactly the same content, and replaces the original
+database with the copy. The database files never shrink by themselves, so this
+is the way to reclaim the space freed by unwinds and deleted buckets. Make sure
+there is enough free disk space for the second copy of the database.`,
+			},
+			{
+				Name:   "verify-state",
+				Usage:  "Verify that the flat state hashes to the state root of a block",
+				Action: utils.MigrateFlags(dbVerifyState),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DatabaseEngineFlag,
+					utils.SyncModeFlag,
+					verifyBlockFlag,
+				},
+				Description: `
+    geth db verify-state [--block N]
+
+Streams the accounts and the storage of the flat state buckets through a trie
+hasher, without building the tries in memory, and compares the computed roots
+with the state root of the block header and with the storage roots of the
+accounts. The state of a block before the head is read from the history. For
+the head block, the mismatching tries are narrowed down to the subtries that
+differ from the intermediate hashes, and the first keys of every such subtrie
+are printed.`,
+			},
+		},
+	}
+)
+
+// chainDbName returns the name of the chain database within the data directory.
+func chainDbName(ctx *cli.Context) string {
+	if ctx.GlobalString(utils.SyncModeFlag.Name) == "light" {
+		return "lightchaindata"
+	}
+	return "chaindata"
+}
+
+func dbStats(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	stats, err := ethdb.Stats(db)
+	if err != nil {
+		utils.Fatalf("Failed to collect database statistics: %v", err)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+
+	var total ethdb.BucketStats
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Bucket", "Keys", "Key size", "Value size", "Page fill", "Change sets", "Oldest change", "Newest change"})
+	for _, s := range stats {
+		total.Keys += s.Keys
+		total.KeyBytes += s.KeyBytes
+		total.ValueBytes += s.ValueBytes
+
+		pageFill, changeSets, oldest, newest := "-", "-", "-", "-"
+		if s.PageFill > 0 {
+			pageFill = fmt.Sprintf("%.1f%%", s.PageFill*100)
+		}
+		if s.ChangeSets > 0 {
+			changeSets = strconv.FormatUint(s.ChangeSets, 10)
+			oldest = strconv.FormatUint(s.OldestChange, 10)
+			newest = strconv.FormatUint(s.NewestChange, 10)
+		}
+		table.Append([]string{
+			fmt.Sprintf("%q", s.Name),
+			strconv.FormatUint(s.Keys, 10),
+			common.StorageSize(s.KeyBytes).String(),
+			common.StorageSize(s.ValueBytes).String(),
+			pageFill, changeSets, oldest, newest,
+		})
+	}
+	table.Append([]string{
+		"Total",
+		strconv.FormatUint(total.Keys, 10),
+		common.StorageSize(total.KeyBytes).String(),
+		common.StorageSize(total.ValueBytes).String(),
+		"", "", "", "",
+	})
+	table.Render()
+
+	if horizon, err := ethdb.HistoryHorizon(db); err == nil && horizon > 0 {
+		fmt.Printf("History is pruned below block %d\n", horizon)
+	}
+	return nil
+}
+
+// diskUsage returns the size of the file, or of all the files in the directory.
+func diskUsage(path string) (common.StorageSize, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return common.StorageSize(size), err
+}
+
+func dbCompact(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	engine := ctx.GlobalString(utils.DatabaseEngineFlag.Name)
+	path := stack.ResolvePath(chainDbName(ctx))
+	if !common.FileExist(path) {
+		utils.Fatalf("Database %s does not exist", path)
+	}
+	compactPath, oldPath := path+".compact", path+".old"
+	for _, p := range []string{compactPath, oldPath} {
+		if common.FileExist(p) {
+			utils.Fatalf("%s exists, it is left by an interrupted compaction and has to be removed first", p)
+		}
+	}
+	sizeBefore, err := diskUsage(path)
+	if err != nil {
+		utils.Fatalf("Failed to measure the database: %v", err)
+	}
+	src, err := ethdb.Open(engine, path)
+	if err != nil {
+		utils.Fatalf("Failed to open the database: %v", err)
+	}
+	dst, err := ethdb.Open(engine, compactPath)
+	if err != nil {
+		src.Close()
+		utils.Fatalf("Failed to create the compacted database: %v", err)
+	}
+	log.Info("Compacting database", "path", path, "size", sizeBefore)
+
+	start := time.Now()
+	logged := time.Now()
+	if err := ethdb.CopyDatabase(src, dst, func(bucket []byte, keys uint64) {
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Copying database", "bucket", fmt.Sprintf("%q", bucket), "keys", keys, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}); err != nil {
+		dst.Close()
+		src.Close()
+		os.RemoveAll(compactPath)
+		utils.Fatalf("Failed to copy the database: %v", err)
+	}
+	log.Info("Verifying the compacted database", "elapsed", common.PrettyDuration(time.Since(start)))
+	if err := ethdb.VerifyCopy(src, dst); err != nil {
+		dst.Close()
+		src.Close()
+		os.RemoveAll(compactPath)
+		utils.Fatalf("Verification of the compacted database failed: %v", err)
+	}
+	dst.Close()
+	src.Close()
+
+	// Swap the databases, keeping the original until the copy is in place
+	if err := os.Rename(path, oldPath); err != nil {
+		utils.Fatalf("Failed to move the original database away: %v", err)
+	}
+	if err := os.Rename(compactPath, path); err != nil {
+		utils.Fatalf("Failed to move the compacted database in place, the original is kept at %s: %v", oldPath, err)
+	}
+	if err := os.RemoveAll(oldPath); err != nil {
+		utils.Fatalf("Failed to remove the original database: %v", err)
+	}
+	sizeAfter, err := diskUsage(path)
+	if err != nil {
+		utils.Fatalf("Failed to measure the compacted database: %v", err)
+	}
+	log.Info("Database compacted", "before", sizeBefore, "after", sizeAfter, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+func dbVerifyState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if head == nil {
+		utils.Fatalf("Head block is missing")
+	}
+	number := *head
+	if ctx.IsSet(verifyBlockFlag.Name) {
+		number = ctx.Uint64(verifyBlockFlag.Name)
+	}
+	if number > *head {
+		utils.Fatalf("Block %d is above the head block %d", number, *head)
+	}
+	header := rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, number), number)
+	if header == nil {
+		utils.Fatalf("Block %d is missing", number)
+	}
+	log.Info("Verifying state", "block", number, "root", header.Root)
+
+	start := time.Now()
+	result, err := state.VerifyState(db, header.Root, number, number < *head)
+	if err != nil {
+		utils.Fatalf("Failed to verify the state: %v", err)
+	}
+	log.Info("Hashed state", "accounts", result.Accounts, "storage", result.Storage, "root", result.Root, "elapsed", common.PrettyDuration(time.Since(start)))
+	if len(result.Mismatches) == 0 {
+		fmt.Printf("State of block %d matches the root %x\n", number, header.Root)
+		return nil
+	}
+	for _, m := range result.Mismatches {
+		if m.Account == (common.Hash{}) {
+			fmt.Printf("Account subtrie %x: expected %x, computed %x\n", m.Path, m.Expected, m.Computed)
+		} else if m.Address == (common.Address{}) {
+			fmt.Printf("Storage of account %x: address unknown, expected root %x\n", m.Account, m.Expected)
+		} else {
+			fmt.Printf("Storage subtrie %x of %x: expected %x, computed %x\n", m.Path, m.Address, m.Expected, m.Computed)
+		}
+		for _, key := range m.Keys {
+			fmt.Printf("    %x\n", key)
+		}
+	}
+	utils.Fatalf("State of block %d does not match the root %x, %d mismatching subtries", number, header.Root, len(result.Mismatches))
+	return nil
+}
@@ -61,6 +61,8 @@ var (
 		utils.BootnodesV4Flag,
 		utils.BootnodesV5Flag,
 		utils.DataDirFlag,
+		utils.DatabaseEngineFlag,
+		utils.DatabaseSpillFlag,
 		utils.KeyStoreDirFlag,
 		utils.ExternalSignerFlag,
 		utils.NoUSBFlag,
@@ -92,6 +94,10 @@ var (
 		utils.SyncModeFlag,
 		utils.ExitWhenSyncedFlag,
 		utils.GCModeFlag,
+		utils.HistoryRetainFlag,
+		utils.WitnessesFlag,
+		utils.AppearancesFlag,
+		utils.StateDiffsFlag,
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
@@ -100,6 +106,7 @@ var (
 		utils.CacheDatabaseFlag,
 		utils.CacheTrieFlag,
 		utils.CacheGCFlag,
+		utils.TrieCacheSizeFlag,
 		utils.TrieCacheGenFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
@@ -163,6 +170,7 @@ var (
 		utils.WSAllowedOriginsFlag,
 		utils.IPCDisabledFlag,
 		utils.IPCPathFlag,
+		utils.DatabaseSocketFlag,
 	}
 
 	metricsFlags = []cli.Flag{
@@ -190,6 +198,12 @@ func init() {
 		copydbCommand,
 		removedbCommand,
 		dumpCommand,
+		// See dbcmd.go:
+		dbCommand,
+		// See snapshotcmd.go:
+		snapshotCommand,
+		// See replaycmd.go:
+		replayCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
@@ -0,0 +1,181 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	replayFromFlag = cli.Uint64Flag{
+		Name:  "from",
+		Usage: "Number of the first block to replay",
+		Value: 1,
+	}
+	replayToFlag = cli.Uint64Flag{
+		Name:  "to",
+		Usage: "Number of the last block to replay (default = head block)",
+	}
+	replayTracerFlag = cli.StringFlag{
+		Name:  "tracer",
+		Usage: "Name of the tracer to run, or the code of a JavaScript tracer",
+		Value: "callTracer",
+	}
+	replayOutFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "File to write the results into (default = standard output)",
+	}
+	replayWorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of block ranges replayed in parallel",
+		Value: runtime.NumCPU(),
+	}
+	replayCommand = cli.Command{
+		Action:    utils.MigrateFlags(replayBlocks),
+		Name:      "replay",
+		Usage:     "Replay the blocks with a tracer, writing out its results",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.DatabaseEngineFlag,
+			utils.SyncModeFlag,
+			replayFromFlag,
+			replayToFlag,
+			replayTracerFlag,
+			replayOutFlag,
+			replayWorkersFlag,
+		},
+		Description: `
+    geth replay --from N --to M --tracer <name> [--out <filename>]
+
+Executes the transactions of the canonical blocks N to M, inclusive, on the
+historical state of their parents with the tracer, and writes a line of JSON
+with the result of the tracer for every transaction, in the order of the
+transactions. The blocks have to be above the history horizon. The ranges of
+the blocks are replayed in parallel by the workers.
+
+The tracer is either the code of a JavaScript tracer, as accepted by
+debug_traceTransaction, or one of the registered tracers:
+
+    ` + strings.Join(replay.Names(), ", ") + `
+
+If the file name ends with .gz, the output is gzipped. The node must not be
+running while the command is executed.`,
+	}
+)
+
+func replayBlocks(ctx *cli.Context) error {
+	name := ctx.String(replayTracerFlag.Name)
+	newTracer, ok := replay.Lookup(name)
+	if !ok {
+		// Not a registered tracer, try running it as JavaScript
+		if _, err := tracers.New(name); err != nil {
+			utils.Fatalf("Unknown tracer %q: %v", name, err)
+		}
+		newTracer = func() replay.Tracer {
+			tracer, _ := tracers.New(name)
+			return tracer
+		}
+		name = "javascript"
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack)
+	defer db.Close()
+	defer chain.Stop()
+
+	cfg := &replay.Config{
+		From:    ctx.Uint64(replayFromFlag.Name),
+		To:      chain.CurrentBlock().NumberU64(),
+		Workers: ctx.Int(replayWorkersFlag.Name),
+	}
+	if ctx.IsSet(replayToFlag.Name) {
+		cfg.To = ctx.Uint64(replayToFlag.Name)
+	}
+	var writer io.Writer = os.Stdout
+	if fn := ctx.String(replayOutFlag.Name); fn != "" {
+		fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+		if err != nil {
+			utils.Fatalf("Failed to create the output file: %v", err)
+		}
+		defer fh.Close()
+
+		writer = fh
+		if strings.HasSuffix(fn, ".gz") {
+			writer = gzip.NewWriter(writer)
+			defer writer.(*gzip.Writer).Close()
+		}
+	}
+	buffered := bufio.NewWriter(writer)
+	defer buffered.Flush()
+
+	// Stop at the next block on interrupt, keeping the results written so far
+	interrupt, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	go func() {
+		select {
+		case <-sigc:
+			log.Info("Interrupted during replay, stopping")
+			cancel()
+		case <-interrupt.Done():
+		}
+	}()
+	log.Info("Replaying blocks", "from", cfg.From, "to", cfg.To, "tracer", name, "workers", cfg.Workers)
+
+	var (
+		enc    = json.NewEncoder(buffered)
+		start  = time.Now()
+		logged = time.Now()
+		txs    int
+	)
+	err := replay.Replay(interrupt, db, chain, chain.Config(), cfg, newTracer, func(result *replay.Result) error {
+		txs++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Replaying blocks", "number", result.Block, "txs", txs, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		return enc.Encode(result)
+	})
+	if err != nil && err != context.Canceled {
+		utils.Fatalf("Replay error: %v", err)
+	}
+	log.Info("Replayed blocks", "from", cfg.From, "to", cfg.To, "txs", txs, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/snapshot"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	snapshotBlockFlag = cli.Uint64Flag{
+		Name:  "block",
+		Usage: "Number of the block to export the state of (default = head block)",
+	}
+	snapshotCommand = cli.Command{
+		Name:      "snapshot",
+		Usage:     "Export and import the state in a portable snapshot file",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+The snapshot commands move the whole state at a block between the nodes, so
+that a node can start from that block without replaying the history before it.
+The node must not be running while they are executed.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Export the state at a block into a snapshot file",
+				ArgsUsage: "<filename>",
+				Action:    utils.MigrateFlags(exportSnapshot),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DatabaseEngineFlag,
+					utils.SyncModeFlag,
+					snapshotBlockFlag,
+				},
+				Description: `
+    geth snapshot export [--block N] <filename>
+
+Writes all the accounts, storage and contract codes at the canonical block N,
+together with the block and the headers of its recent ancestors, into the file.
+The state of the past blocks is read from the history, so N has to be above the
+history horizon. If the file name ends with .gz, the output is gzipped.`,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import the state from a snapshot file into a fresh database",
+				ArgsUsage: "<filename>",
+				Action:    utils.MigrateFlags(importSnapshot),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DatabaseEngineFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    geth snapshot import <filename>
+
+Rebuilds the state from the snapshot, checks it against the state root of the
+block of the snapshot, and makes the block the head of the chain. The database
+must not have any blocks after the genesis; for the custom networks, initialise
+it with geth init first. The history before the block is not available on the
+imported node. If the file name ends with .gz, the input is gunzipped.`,
+			},
+		},
+	}
+)
+
+func exportSnapshot(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	number := ctx.Uint64(snapshotBlockFlag.Name)
+	if !ctx.IsSet(snapshotBlockFlag.Name) {
+		head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+		if head == nil {
+			utils.Fatalf("Head block is missing")
+		}
+		number = *head
+	}
+	fn := ctx.Args().First()
+	log.Info("Exporting state snapshot", "block", number, "file", fn)
+
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		utils.Fatalf("Failed to create the snapshot file: %v", err)
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	start := time.Now()
+	stats, err := snapshot.Export(writer, db, number)
+	if err != nil {
+		utils.Fatalf("Export error: %v", err)
+	}
+	log.Info("Exported state snapshot", "block", number, "accounts", stats.Accounts, "storage", stats.Storage, "codes", stats.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+func importSnapshot(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	if _, _, _, err := core.SetupGenesisBlock(db, utils.MakeGenesis(ctx)); err != nil {
+		utils.Fatalf("Failed to set up the genesis: %v", err)
+	}
+	fn := ctx.Args().First()
+	fh, err := os.Open(fn)
+	if err != nil {
+		utils.Fatalf("Failed to open the snapshot file: %v", err)
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			utils.Fatalf("Failed to open the snapshot file: %v", err)
+		}
+	}
+	if _, _, err := snapshot.Import(reader, db); err != nil {
+		utils.Fatalf("Import error: %v", err)
+	}
+	return nil
+}
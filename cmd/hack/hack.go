@@ -44,6 +44,16 @@ var reset = flag.Int("reset", -1, "reset to given block number")
 var rewind = flag.Int("rewind", 1, "rewind to given number of blocks")
 var block = flag.Int("block", 1, "specifies a block number for operation")
 var account = flag.String("account", "0x", "specifies account to investigate")
+var remoteDb = flag.String("remote-db", "", "read the chaindata of the running node served on the given unix `socket`")
+
+// openChaindata opens the chaindata at the path, or the chaindata of the running
+// node served on the socket given by the -remote-db flag, which is read-only.
+func openChaindata(path string) (ethdb.Database, error) {
+	if *remoteDb != "" {
+		return ethdb.NewRemoteDatabase(*remoteDb, "chaindata")
+	}
+	return ethdb.NewLDBDatabase(path)
+}
 
 func bucketList(db *bolt.DB) [][]byte {
 	bucketList := [][]byte{}
@@ -405,7 +415,7 @@ func printOccupancies(t *trie.Trie, db ethdb.Database, blockNr uint64) {
 
 func trieStats() {
 	//db, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
-	db, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
+	db, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	if err != nil {
 		panic(err)
 	}
@@ -679,7 +689,7 @@ func trieChart() {
 }
 
 func execToBlock(block int) {
-	blockDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
+	blockDb, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
 	check(err)
 	bcb, err := core.NewBlockChain(blockDb, nil, params.TestnetChainConfig, ethash.NewFaker(), vm.Config{}, nil)
@@ -746,7 +756,7 @@ func extractTrie(block int) {
 }
 
 func testRewind(block, rewind int) {
-	ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
+	ethDb, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("statedb")
 	check(err)
@@ -815,7 +825,7 @@ func testRewind(block, rewind int) {
 func testStartup() {
 	startTime := time.Now()
 	//ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
-	ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
+	ethDb, err := openChaindata("/home/akhounov/.ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
 	bc, err := core.NewBlockChain(ethDb, nil, params.MainnetChainConfig, ethash.NewFaker(), vm.Config{}, nil)
@@ -950,7 +960,7 @@ func testDifficulty() {
 }
 
 func testBlockHashes() {
-	ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
+	ethDb, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	check(err)
 	hash := rawdb.ReadCanonicalHash(ethDb, 823144)
 	fmt.Printf("Canonical hash: %x\n", hash)
@@ -960,7 +970,7 @@ func testBlockHashes() {
 }
 
 func printTxHashes() {
-	ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
+	ethDb, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
 	for b := uint64(0); b < uint64(100000); b++ {
@@ -982,7 +992,7 @@ func relayoutKeys() {
 	defer db.Close()
 	var count int
 	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("SUFFIX"))
+		b := tx.Bucket(ethdb.ChangeSetBucket)
 		if b == nil {
 			return nil
 		}
@@ -1070,7 +1080,7 @@ func invTree(wrong, right, diff string, block int, encodeToBytes bool) {
 
 func preimage() {
 	//ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
-	ethDb, err := ethdb.NewLDBDatabase("/Volumes/tb4/turbo-geth-10/geth/chaindata")
+	ethDb, err := openChaindata("/Volumes/tb4/turbo-geth-10/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
@@ -1109,7 +1119,7 @@ func decodeTimestamp(suffix []byte) (uint64, []byte) {
 }
 
 func loadAccount() {
-	ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
+	ethDb, err := openChaindata("/home/akhounov/.ethereum/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/Volumes/tb4/turbo-geth/geth/chaindata")
 	check(err)
@@ -1290,7 +1300,7 @@ func loadAccount() {
 }
 
 func printBranches(block uint64) {
-	ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
+	ethDb, err := openChaindata("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
@@ -1358,7 +1368,7 @@ func repair() {
 	}()
 
 	//historyDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
-	historyDb, err := ethdb.NewLDBDatabase("/Volumes/tb4/turbo-geth-10/geth/chaindata")
+	historyDb, err := openChaindata("/Volumes/tb4/turbo-geth-10/geth/chaindata")
 	//historyDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	//historyDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/testnet/geth/chaindata")
 	//historyDb, err := ethdb.NewLDBDatabase("/Volumes/tb4/turbo-geth/ropsten/geth/chaindata")
@@ -47,6 +47,16 @@ var block = flag.Int("block", 1, "specifies a block number for operation")
 var account = flag.String("account", "0x", "specifies account to investigate")
 var genLag = flag.Int("genlag", 4096, "how many blocks to accumulate block proofs over for generator")
 var consLag = flag.Int("conslag", 256, "how many blocks to accumulate block proofs over for consumer")
+var remoteDb = flag.String("remote-db", "", "read the chaindata of the running node served on the given unix `socket`")
+
+// openChaindata opens the chaindata at the path, or the chaindata of the running
+// node served on the socket given by the -remote-db flag, which is read-only.
+func openChaindata(path string) (ethdb.Database, error) {
+	if *remoteDb != "" {
+		return ethdb.NewRemoteDatabase(*remoteDb, "chaindata")
+	}
+	return ethdb.NewLDBDatabase(path)
+}
 
 func check(e error) {
 	if e != nil {
@@ -1015,7 +1025,7 @@ func makeCreators() {
 	}()
 
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
-	ethDb, err := ethdb.NewLDBDatabase("/Volumes/tb41/turbo-geth/geth/chaindata")
+	ethDb, err := openChaindata("/Volumes/tb41/turbo-geth/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
@@ -1618,7 +1628,7 @@ func makeSha3Preimages() {
 	}()
 
 	//ethDb, err := ethdb.NewLDBDatabase("/home/akhounov/.ethereum/geth/chaindata")
-	ethDb, err := ethdb.NewLDBDatabase("/Volumes/tb4/turbo-geth/geth/chaindata")
+	ethDb, err := openChaindata("/Volumes/tb4/turbo-geth/geth/chaindata")
 	//ethDb, err := ethdb.NewLDBDatabase("/Users/alexeyakhunov/Library/Ethereum/geth/chaindata")
 	check(err)
 	defer ethDb.Close()
@@ -118,6 +118,16 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	DatabaseEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: fmt.Sprintf("Storage engine for the databases (%s)", strings.Join(ethdb.Engines(), ", ")),
+		Value: ethdb.DefaultEngine,
+	}
+	DatabaseSpillFlag = cli.IntFlag{
+		Name:  "db.spill-mb",
+		Usage: "Memory allowance (MB) of the block import batch, past which it spills into files in the datadir (0 = no spilling)",
+		Value: eth.DefaultConfig.DatabaseSpill,
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -187,7 +197,7 @@ var (
 	defaultSyncMode = eth.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "full", "light" or "stateless")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -195,6 +205,23 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	HistoryRetainFlag = cli.Uint64Flag{
+		Name:  "history.retain",
+		Usage: "Number of recent blocks to keep the state history for (0 = keep all)",
+		Value: eth.DefaultConfig.HistoryRetain,
+	}
+	WitnessesFlag = cli.BoolFlag{
+		Name:  "witnesses",
+		Usage: "Store the witness of every imported block and serve it to peers (wit/1)",
+	}
+	AppearancesFlag = cli.BoolFlag{
+		Name:  "appearances",
+		Usage: "Index the addresses appearing in the call frames of every block (ots_searchTransactions)",
+	}
+	StateDiffsFlag = cli.BoolFlag{
+		Name:  "statediffs",
+		Usage: "Store the state changes of every transaction of the imported blocks (debug_getStateDiff)",
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -338,10 +365,14 @@ var (
 		Usage: "Percentage of cache memory allowance to use for trie pruning",
 		Value: 25,
 	}
+	TrieCacheSizeFlag = cli.IntFlag{
+		Name:  "trie.cache-mb",
+		Usage: "Megabytes of memory allocated to the trie nodes kept in memory",
+		Value: int(state.MaxTrieCacheSize / 1024 / 1024),
+	}
 	TrieCacheGenFlag = cli.IntFlag{
 		Name:  "trie-cache-gens",
-		Usage: "Number of trie node generations to keep in memory",
-		Value: int(state.MaxTrieCacheGen),
+		Usage: "Number of trie node generations to keep in memory (deprecated, has no effect, use --trie.cache-mb)",
 	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
@@ -509,6 +540,10 @@ var (
 		Name:  "ipcpath",
 		Usage: "Filename for IPC socket/pipe within the datadir (explicit paths escape it)",
 	}
+	DatabaseSocketFlag = DirectoryFlag{
+		Name:  "dbsocket",
+		Usage: "Filename for the UNIX socket serving read-only access to the databases within the datadir (explicit paths escape it)",
+	}
 	WSEnabledFlag = cli.BoolFlag{
 		Name:  "ws",
 		Usage: "Enable the WS-RPC server",
@@ -887,6 +922,9 @@ func setIPC(ctx *cli.Context, cfg *node.Config) {
 	case ctx.GlobalIsSet(IPCPathFlag.Name):
 		cfg.IPCPath = ctx.GlobalString(IPCPathFlag.Name)
 	}
+	if ctx.GlobalIsSet(DatabaseSocketFlag.Name) {
+		cfg.DatabaseSocket = ctx.GlobalString(DatabaseSocketFlag.Name)
+	}
 }
 
 // SetULC setup ULC config from file if given.
@@ -1082,6 +1120,9 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setNodeUserIdent(ctx, cfg)
 	setDataDir(ctx, cfg)
 
+	if ctx.GlobalIsSet(DatabaseEngineFlag.Name) {
+		cfg.DatabaseEngine = ctx.GlobalString(DatabaseEngineFlag.Name)
+	}
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
 		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
 	}
@@ -1288,11 +1329,26 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(DatabaseSpillFlag.Name) {
+		cfg.DatabaseSpill = ctx.GlobalInt(DatabaseSpillFlag.Name)
+	}
 
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+	if ctx.GlobalIsSet(HistoryRetainFlag.Name) {
+		cfg.HistoryRetain = ctx.GlobalUint64(HistoryRetainFlag.Name)
+	}
+	if ctx.GlobalIsSet(WitnessesFlag.Name) {
+		cfg.StoreWitnesses = ctx.GlobalBool(WitnessesFlag.Name)
+	}
+	if ctx.GlobalIsSet(AppearancesFlag.Name) {
+		cfg.IndexAppearances = ctx.GlobalBool(AppearancesFlag.Name)
+	}
+	if ctx.GlobalIsSet(StateDiffsFlag.Name) {
+		cfg.StoreStateDiffs = ctx.GlobalBool(StateDiffsFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -1390,9 +1446,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 			cfg.MinerGasPrice = big.NewInt(1)
 		}
 	}
-	// TODO(fjl): move trie cache generations into config
-	if gen := ctx.GlobalInt(TrieCacheGenFlag.Name); gen > 0 {
-		state.MaxTrieCacheGen = uint32(gen)
+	if ctx.GlobalIsSet(TrieCacheGenFlag.Name) {
+		log.Warn("The flag --trie-cache-gens is deprecated and has no effect, please use --trie.cache-mb")
+	}
+	// TODO(fjl): move trie cache size into config
+	if size := ctx.GlobalInt(TrieCacheSizeFlag.Name); size > 0 {
+		state.MaxTrieCacheSize = uint64(size) * 1024 * 1024
 	}
 }
 
@@ -1534,6 +1593,9 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieCleanLimit: eth.DefaultConfig.TrieCleanCache,
 		TrieDirtyLimit: eth.DefaultConfig.TrieDirtyCache,
 		TrieTimeLimit:  eth.DefaultConfig.TrieTimeout,
+		HistoryRetain:  ctx.GlobalUint64(HistoryRetainFlag.Name),
+		SpillLimit:     ctx.GlobalInt(DatabaseSpillFlag.Name),
+		SpillDir:       stack.ResolvePath("spill"),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
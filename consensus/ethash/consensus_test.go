@@ -84,3 +84,24 @@ func TestCalcDifficulty(t *testing.T) {
 		}
 	}
 }
+
+func TestAccumulateRewards(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(10)}
+	uncles := []*types.Header{{Number: big.NewInt(9)}, {Number: big.NewInt(8)}}
+
+	miner, rewards := AccumulateRewards(params.TestChainConfig, header, nil)
+	if miner.Cmp(ConstantinopleBlockReward) != 0 || len(rewards) != 0 {
+		t.Errorf("Constantinople without uncles: miner %v, uncles %v", miner, rewards)
+	}
+	// The miner gets 1/32 of the block reward for each uncle, the miners of
+	// the uncles (8 + uncle - block)/8 of it
+	miner, rewards = AccumulateRewards(params.MainnetChainConfig, header, uncles)
+	if want := big.NewInt(5e+18 + 2*5e+18/32); miner.Cmp(want) != 0 {
+		t.Errorf("Frontier miner reward: have %v, want %v", miner, want)
+	}
+	for i, want := range []*big.Int{big.NewInt(5e+18 * 7 / 8), big.NewInt(5e+18 * 6 / 8)} {
+		if rewards[i].Cmp(want) != 0 {
+			t.Errorf("Frontier uncle %d reward: have %v, want %v", i, rewards[i], want)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"math/big"
 	//mrand "math/rand"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -64,10 +65,17 @@ const (
 	badBlockLimit       = 10
 	triesInMemory       = 128
 
+	historyPruneInterval = 10 * time.Second // Time between the runs of the history pruner
+	historyPruneBatch    = 1000             // Number of blocks to prune the history of in one database batch
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	BlockChainVersion uint64 = 3
 )
 
+// spillLimitUnit is the number of bytes in a unit of CacheConfig.SpillLimit,
+// it is only lowered by the tests to make a short chain spill.
+var spillLimitUnit = 1024 * 1024
+
 // CacheConfig contains the configuration values for the trie caching/pruning
 // that's resident in a blockchain.
 type CacheConfig struct {
@@ -75,6 +83,9 @@ type CacheConfig struct {
 	TrieCleanLimit int           // Memory allowance (MB) to use for caching trie nodes in memory
 	TrieDirtyLimit int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieTimeLimit  time.Duration // Time limit after which to flush the current in-memory trie to disk
+	HistoryRetain  uint64        // Number of recent blocks to keep the state history for (0 = keep all)
+	SpillLimit     int           // Memory limit (MB) of the insertion batch, past which it spills into SpillDir (0 = no spilling)
+	SpillDir       string        // Directory for the files spilled by the insertion batch, emptied on start
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -134,11 +145,15 @@ type BlockChain struct {
 	validator Validator // block and state validator interface
 	vmConfig  vm.Config
 
-	badBlocks      *lru.Cache              // Bad block cache
-	shouldPreserve func(*types.Block) bool // Function used to determine whether should preserve the given block.
-	noHistory      bool
-	enableReceipts bool // Whether receipts need to be written to the database
-	resolveReads   bool
+	badBlocks       *lru.Cache              // Bad block cache
+	shouldPreserve  func(*types.Block) bool // Function used to determine whether should preserve the given block.
+	noHistory       bool
+	enableReceipts  bool // Whether receipts need to be written to the database
+	resolveReads    bool
+	enableWitnesses bool           // Whether block witnesses need to be written to the database
+	witnessFetcher  WitnessFetcher // Retrieves the witnesses of blocks to verify statelessly, nil if the state is kept
+
+	enableStateDiffs bool // Whether the state diffs of the transactions need to be written to the database
 }
 
 // NewBlockChain returns a fully initialised block chain using information
@@ -159,6 +174,18 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	futureBlocks, _ := lru.New(maxFutureBlocks)
 	badBlocks, _ := lru.New(badBlockLimit)
 	cdb := db.NewBatch()
+	if cacheConfig.SpillLimit > 0 {
+		// The runs of a batch that did not get committed are of no use any more
+		if cacheConfig.SpillDir != "" {
+			if err := os.RemoveAll(cacheConfig.SpillDir); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(cacheConfig.SpillDir, 0700); err != nil {
+				return nil, err
+			}
+		}
+		cdb = ethdb.NewSpillingBatch(db, cacheConfig.SpillLimit*spillLimitUnit, cacheConfig.SpillDir)
+	}
 
 	bc := &BlockChain{
 		chainConfig:   chainConfig,
@@ -192,6 +219,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	if err := bc.verifyHead(); err != nil {
+		return nil, err
+	}
 	// Check the current state of the block hashes and make sure that we do not have any of the bad blocks in our chain
 	for hash := range BadHashes {
 		if header := bc.GetHeaderByHash(hash); header != nil {
@@ -207,6 +237,10 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	}
 	// Take ownership of this particular state
 	go bc.update()
+	if cacheConfig.HistoryRetain > 0 {
+		bc.wg.Add(1)
+		go bc.pruneHistory()
+	}
 	return bc, nil
 }
 
@@ -222,6 +256,30 @@ func (bc *BlockChain) EnableReceipts(er bool) {
 	bc.enableReceipts = er
 }
 
+// EnableWitnesses makes the chain persist the witness of every imported block,
+// i.e. the proof of the parts of the state the block has read. Witnesses can
+// only be produced while the reads are resolved, so enabling them also turns
+// on read resolution.
+func (bc *BlockChain) EnableWitnesses(ew bool) {
+	bc.enableWitnesses = ew
+	if ew {
+		bc.resolveReads = true
+	}
+}
+
+// EnableStateDiffs makes the chain persist the changes made to the state by
+// every transaction of the imported blocks, with the values before and after.
+func (bc *BlockChain) EnableStateDiffs(esd bool) {
+	bc.enableStateDiffs = esd
+}
+
+// SetStateless switches the chain into the stateless mode, in which no state is
+// kept in the database. Instead, every imported block is executed against the
+// witness retrieved with the given fetcher and its post-state root verified.
+func (bc *BlockChain) SetStateless(fetcher WitnessFetcher) {
+	bc.witnessFetcher = fetcher
+}
+
 func (bc *BlockChain) GetTrieDbState() *state.TrieDbState {
 	if bc.trieDbState == nil {
 		currentBlockNr := bc.CurrentBlock().NumberU64()
@@ -247,6 +305,41 @@ func (bc *BlockChain) GetVMConfig() *vm.Config {
 	return &bc.vmConfig
 }
 
+// verifyHead checks on startup that the head block is complete and that the
+// state history does not go beyond it. The blocks and the state they produce
+// are committed together, in one transaction or through the journal of the
+// database, so the failed check means that the database is damaged. The state
+// itself is checked against the root of the head block when the trie is rebuilt.
+// The state ahead of the head is unwound to it, because the import of the next
+// block can only unwind the state starting from the head.
+func (bc *BlockChain) verifyHead() error {
+	head := bc.CurrentBlock()
+	number := head.NumberU64()
+	if hash := rawdb.ReadCanonicalHash(bc.db, number); hash != head.Hash() {
+		return fmt.Errorf("head block %d [%x…] is not canonical, canonical hash is [%x…]", number, head.Hash().Bytes()[:4], hash.Bytes()[:4])
+	}
+	if bc.GetTd(head.Hash(), number) == nil {
+		return fmt.Errorf("total difficulty of the head block %d [%x…] is missing", number, head.Hash().Bytes()[:4])
+	}
+	last, err := ethdb.LastChangeSetAfter(bc.db, number)
+	if err != nil {
+		return err
+	}
+	if last == number {
+		return nil
+	}
+	log.Warn("Unwinding the state beyond the head block", "number", number, "hash", head.Hash(), "last", last)
+	if err := state.UnwindFlatState(bc.db, last, number); err != nil {
+		bc.db.Rollback()
+		return err
+	}
+	if _, err := bc.db.Commit(); err != nil {
+		bc.db.Rollback()
+		return err
+	}
+	return nil
+}
+
 // loadLastState loads the last known chain state from the database. This method
 // assumes that the chain manager mutex is held.
 func (bc *BlockChain) loadLastState() error {
@@ -365,9 +458,11 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	if _, err := trie.NewSecure(block.Root(), state.AccountsBucket, nil, false); err != nil {
 		return err
 	}
-	// If all checks out, manually set the head block
+	// If all checks out, manually set the head block. The trie state is built
+	// again from the synced state on the next use.
 	bc.chainmu.Lock()
 	bc.currentBlock.Store(block)
+	bc.trieDbState = nil
 	bc.chainmu.Unlock()
 
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
@@ -660,6 +755,30 @@ func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
 	return receipts
 }
 
+// GetWitness retrieves the encoded witness of a block from the database by
+// hash, or nil if the witness has not been stored.
+func (bc *BlockChain) GetWitness(hash common.Hash) []byte {
+	number := bc.hc.GetBlockNumber(bc.db, hash)
+	if number == nil {
+		return nil
+	}
+	return rawdb.ReadWitness(bc.db, hash, *number)
+}
+
+// GetStateDiffs retrieves the state diffs of the transactions of a block from
+// the database by hash, or nil if they have not been stored.
+func (bc *BlockChain) GetStateDiffs(hash common.Hash) ([]state.StateDiff, error) {
+	number := bc.hc.GetBlockNumber(bc.db, hash)
+	if number == nil {
+		return nil, nil
+	}
+	enc := rawdb.ReadStateDiffs(bc.db, hash, *number)
+	if enc == nil {
+		return nil, nil
+	}
+	return state.DecodeStateDiffs(enc)
+}
+
 // GetBlocksFromHash returns the block corresponding to hash and up to n-1 ancestors.
 // [deprecated by eth/62]
 func (bc *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*types.Block) {
@@ -691,9 +810,10 @@ func (bc *BlockChain) GetUnclesInChain(block *types.Block, length int) []*types.
 }
 
 // TrieNode retrieves a blob of data associated with a trie node (or code hash)
-// either from ephemeral in-memory cache, or from persistent storage.
+// either from ephemeral in-memory cache, or from persistent storage. The trie
+// nodes are not kept in the database, so only the contract codes are found.
 func (bc *BlockChain) TrieNode(hash common.Hash) ([]byte, error) {
-	return nil, nil
+	return bc.db.Get(state.CodeBucket, hash[:])
 }
 
 // Stop stops the blockchain service. If any imports are currently in progress
@@ -929,9 +1049,12 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 		rawdb.WriteHeader(bc.db, block.Header())
 	}
 
-	tds.SetBlockNr(block.NumberU64())
-	if err := state.Commit(bc.chainConfig.IsEIP158(block.Number()), tds.DbStateWriter()); err != nil {
-		return NonStatTy, err
+	// The state of stateless blocks has already been committed to their witness
+	if tds != nil {
+		tds.SetBlockNr(block.NumberU64())
+		if err := state.Commit(bc.chainConfig.IsEIP158(block.Number()), tds.DbStateWriter()); err != nil {
+			return NonStatTy, err
+		}
 	}
 	if bc.enableReceipts {
 		rawdb.WriteReceipts(bc.db, block.Hash(), block.NumberU64(), receipts)
@@ -1039,6 +1162,9 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 	if atomic.LoadInt32(&bc.procInterrupt) == 1 {
 		return 0, nil, nil, nil
 	}
+	if bc.witnessFetcher != nil {
+		return bc.insertStatelessChain(chain, verifySeals)
+	}
 	// Start a parallel signature recovery (signer will fluke on fork transition, minimal perf loss)
 	senderCacher.recoverFromBlocks(types.MakeSigner(bc.chainConfig, chain[0].Number()), chain)
 
@@ -1257,6 +1383,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 			}
 		}
 		stateDB := state.New(bc.trieDbState)
+		bc.trieDbState.SetStateDiffs(bc.enableStateDiffs)
 		// Process block using the parent state as reference point.
 		t0 := time.Now()
 		receipts, logs, usedGas, err := bc.processor.Process(block, stateDB, bc.trieDbState, bc.vmConfig)
@@ -1280,6 +1407,12 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 
 		// Write the block to the chain and get the status.
 		status, err := bc.writeBlockWithState(block, receipts, stateDB, bc.trieDbState)
+		if err == nil && bc.enableWitnesses {
+			err = bc.writeWitness(block)
+		}
+		if err == nil && bc.enableStateDiffs {
+			err = bc.writeStateDiffs(block)
+		}
 		t3 := time.Now()
 		if err != nil {
 			bc.db.Rollback()
@@ -1315,7 +1448,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 		stats.processed++
 		stats.usedGas += usedGas
 		stats.report(chain, i, bc.db)
-		if commitStats.needToCommit(chain, bc.db, i) {
+		if commitStats.needToCommit(chain, bc.db, i, bc.batchSizeLimit()) {
 			var written uint64
 			if written, err = bc.db.Commit(); err != nil {
 				log.Error("Could not commit chainDb", err)
@@ -1335,17 +1468,50 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, []
 	return 0, events, coalescedLogs, nil
 }
 
+// writeWitness extracts the proofs collected while the block was executed and
+// stores them as the witness of the block.
+func (bc *BlockChain) writeWitness(block *types.Block) error {
+	proof := bc.trieDbState.ExtractProofs(false)
+	enc, err := state.EncodeBlockProof(&proof)
+	if err != nil {
+		return err
+	}
+	rawdb.WriteWitness(bc.db, block.Hash(), block.NumberU64(), enc)
+	return nil
+}
+
+// writeStateDiffs stores the changes recorded while the block was executed as
+// the state diffs of its transactions.
+func (bc *BlockChain) writeStateDiffs(block *types.Block) error {
+	enc, err := state.EncodeStateDiffs(bc.trieDbState.StateDiffWriter().ExtractDiffs())
+	if err != nil {
+		return err
+	}
+	rawdb.WriteStateDiffs(bc.db, block.Hash(), block.NumberU64(), enc)
+	return nil
+}
+
 // statsReportLimit is the time limit during import and export after which we
 // always print out progress. This avoids the user wondering what's going on.
 const statsReportLimit = 8 * time.Second
 const commitLimit = 60 * time.Second
 
-func (st *insertStats) needToCommit(chain []*types.Block, db ethdb.Mutation, index int) bool {
+// batchSizeLimit returns the size of the insertion batch that triggers its
+// commit, or 0 if the batch spills into files and so only needs to be committed
+// at the end of the chain or after commitLimit.
+func (bc *BlockChain) batchSizeLimit() int {
+	if bc.cacheConfig.SpillLimit > 0 {
+		return 0
+	}
+	return ethdb.IdealBatchSize
+}
+
+func (st *insertStats) needToCommit(chain []*types.Block, db ethdb.Mutation, index int, sizeLimit int) bool {
 	var (
 		now     = mclock.Now()
 		elapsed = time.Duration(now) - time.Duration(st.startTime)
 	)
-	if index == len(chain)-1 || elapsed >= commitLimit || db.BatchSize() >= ethdb.IdealBatchSize {
+	if index == len(chain)-1 || elapsed >= commitLimit || (sizeLimit > 0 && db.BatchSize() >= sizeLimit) {
 		*st = insertStats{startTime: now, lastIndex: index + 1}
 		return true
 	}
@@ -1542,6 +1708,66 @@ func (bc *BlockChain) update() {
 	}
 }
 
+// pruneHistory periodically deletes the state history of the blocks that are
+// more than HistoryRetain blocks behind the head, in batches of bounded size.
+func (bc *BlockChain) pruneHistory() {
+	defer bc.wg.Done()
+
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			head := bc.CurrentBlock().NumberU64()
+			if head <= bc.cacheConfig.HistoryRetain {
+				continue
+			}
+			before := head - bc.cacheConfig.HistoryRetain
+			for {
+				start := time.Now()
+				horizon, err := bc.pruneHistoryBatch(before)
+				if err != nil {
+					log.Error("Failed to prune history", "err", err)
+					break
+				}
+				log.Debug("Pruned history", "horizon", horizon, "elapsed", common.PrettyDuration(time.Since(start)))
+				if horizon >= before {
+					break
+				}
+				select {
+				case <-bc.quit:
+					return
+				default:
+				}
+			}
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
+// pruneHistoryBatch deletes the next batch of the history before the given
+// block. The deletions go through the chain batch under the insertion lock, so
+// that they don't race with the history written by the inserted blocks, and the
+// batch is committed right away.
+func (bc *BlockChain) pruneHistoryBatch(before uint64) (uint64, error) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	horizon, err := ethdb.PruneHistoryInto(bc.db, before, historyPruneBatch)
+	if err != nil {
+		bc.db.Rollback()
+		bc.trieDbState = nil
+		return 0, err
+	}
+	if _, err := bc.db.Commit(); err != nil {
+		bc.db.Rollback()
+		bc.trieDbState = nil
+		return 0, err
+	}
+	return horizon, nil
+}
+
 // BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 func (bc *BlockChain) BadBlocks() []*types.Block {
 	blocks := make([]*types.Block, 0, bc.badBlocks.Len())
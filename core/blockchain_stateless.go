@@ -0,0 +1,301 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	witnessFetchTimer    = metrics.NewRegisteredTimer("chain/witness/fetch", nil)
+	witnessSizeMeter     = metrics.NewRegisteredMeter("chain/witness/size", nil)
+	statelessVerifyTimer = metrics.NewRegisteredTimer("chain/stateless/verify", nil)
+)
+
+// WitnessFetcher retrieves the encoded witnesses of the given blocks, in the
+// same order as the blocks.
+type WitnessFetcher func(blocks types.Blocks) ([][]byte, error)
+
+// statelessStats tracks and reports on the throughput of stateless verification.
+type statelessStats struct {
+	blocks, txs   int
+	usedGas       uint64
+	witnessBytes  int
+	fetchDuration time.Duration
+	startTime     mclock.AbsTime
+}
+
+// report prints the verification throughput of the chain segment.
+func (st *statelessStats) report(end *types.Block) {
+	elapsed := time.Duration(mclock.Now()) - time.Duration(st.startTime)
+	context := []interface{}{
+		"blocks", st.blocks, "txs", st.txs, "mgas", float64(st.usedGas) / 1000000,
+		"witness", common.StorageSize(st.witnessBytes), "fetch", common.PrettyDuration(st.fetchDuration),
+		"elapsed", common.PrettyDuration(elapsed),
+		"blkps", float64(st.blocks) * float64(time.Second) / float64(elapsed),
+		"mgasps", float64(st.usedGas) * 1000 / float64(elapsed),
+		"number", end.Number(), "hash", end.Hash(),
+	}
+	if timestamp := time.Unix(end.Time().Int64(), 0); time.Since(timestamp) > time.Minute {
+		context = append(context, []interface{}{"age", common.PrettyAge(timestamp)}...)
+	}
+	log.Info("Verified new chain segment", context...)
+}
+
+// insertStatelessChain is the stateless counterpart of insertChain. Instead of
+// executing the blocks on top of the state in the database, it retrieves their
+// witnesses, executes every block against the state reconstructed from its
+// witness and verifies the resulting state root against the header. Only the
+// blocks themselves are written to the database.
+//
+// It assumes that the chain is contiguous and that the chain mutex is held.
+func (bc *BlockChain) insertStatelessChain(chain types.Blocks, verifySeals bool) (int, []interface{}, []*types.Log, error) {
+	var (
+		stats         = statelessStats{startTime: mclock.Now()}
+		commitStats   = insertStats{startTime: mclock.Now()}
+		events        = make([]interface{}, 0, len(chain))
+		lastCanon     *types.Block
+		coalescedLogs []*types.Log
+	)
+	parent := bc.GetBlock(chain[0].ParentHash(), chain[0].NumberU64()-1)
+	ptd := bc.GetTd(chain[0].ParentHash(), chain[0].NumberU64()-1)
+	if parent == nil || ptd == nil {
+		return 0, events, coalescedLogs, consensus.ErrUnknownAncestor
+	}
+	// Only verify the segment if it is heavier than the current chain, but still
+	// write the blocks to the database because others might build on top of them
+	externTd := new(big.Int).Set(ptd)
+	for _, block := range chain {
+		externTd.Add(externTd, block.Difficulty())
+	}
+	if localTd := bc.GetTd(bc.CurrentBlock().Hash(), bc.CurrentBlock().NumberU64()); localTd.Cmp(externTd) >= 0 {
+		log.Warn("Ignoring the chain segment because of insufficient difficulty", "external", externTd, "local", localTd)
+		td := ptd
+		for _, block := range chain {
+			td = new(big.Int).Add(block.Difficulty(), td)
+			rawdb.WriteBlock(bc.db, block)
+			rawdb.WriteTd(bc.db, block.Hash(), block.NumberU64(), td)
+		}
+		return 0, events, coalescedLogs, nil
+	}
+	// Start a parallel signature recovery and header verification, and retrieve
+	// the witnesses not yet known locally while those are running
+	senderCacher.recoverFromBlocks(types.MakeSigner(bc.chainConfig, chain[0].Number()), chain)
+
+	headers := make([]*types.Header, len(chain))
+	seals := make([]bool, len(chain))
+	for i, block := range chain {
+		headers[i] = block.Header()
+		seals[i] = verifySeals
+	}
+	abort, results := bc.engine.VerifyHeaders(bc, headers, seals)
+	defer close(abort)
+
+	witnesses, err := bc.fetchWitnesses(chain)
+	stats.fetchDuration = time.Duration(mclock.Now()) - time.Duration(stats.startTime)
+	if err != nil {
+		return 0, events, coalescedLogs, err
+	}
+	// Iterate over the blocks and verify them one by one. As there is no state
+	// to keep consistent, the blocks verified before a failing one are retained.
+	it := newInsertIterator(chain, results, bc.Validator())
+	fail := func(block *types.Block, receipts types.Receipts, err error) (int, []interface{}, []*types.Log, error) {
+		bc.reportBlock(block, receipts, err)
+		if _, err := bc.db.Commit(); err != nil {
+			log.Error("Could not commit chainDb", "err", err)
+			bc.db.Rollback()
+		}
+		return it.index, events, coalescedLogs, err
+	}
+	for block, err := it.next(); block != nil; block, err = it.next() {
+		start := time.Now()
+		if BadHashes[block.Hash()] {
+			return fail(block, nil, ErrBlacklistedHash)
+		}
+		switch {
+		case err == consensus.ErrFutureBlock:
+			// Allow up to MaxFuture second in the future blocks. If this limit is exceeded
+			// the chain is discarded and processed at a later time if given.
+			if err := bc.addFutureBlock(block); err != nil {
+				return fail(block, nil, err)
+			}
+			continue
+
+		case err == consensus.ErrUnknownAncestor && bc.futureBlocks.Contains(block.ParentHash()):
+			bc.futureBlocks.Add(block.Hash(), block)
+			continue
+
+		case err != nil:
+			return fail(block, nil, err)
+		}
+		if prev := it.previous(); prev != nil {
+			parent = prev
+		}
+		proof, err := state.DecodeBlockProof(witnesses[it.index])
+		if err == nil {
+			err = proof.Verify(parent.Root())
+		}
+		if err != nil {
+			return fail(block, nil, fmt.Errorf("invalid witness for block %d: %v", block.NumberU64(), err))
+		}
+		receipts, logs, usedGas, statedb, err := bc.processStateless(block, parent, *proof)
+		if err != nil {
+			return fail(block, receipts, err)
+		}
+		statelessVerifyTimer.UpdateSince(start)
+
+		status, err := bc.writeBlockWithState(block, receipts, statedb, nil)
+		if err == nil && bc.enableWitnesses {
+			rawdb.WriteWitness(bc.db, block.Hash(), block.NumberU64(), witnesses[it.index])
+		}
+		if err != nil {
+			bc.db.Rollback()
+			return it.index, events, coalescedLogs, err
+		}
+		if status == CanonStatTy {
+			log.Debug("Verified new block", "number", block.Number(), "hash", block.Hash(),
+				"txs", len(block.Transactions()), "gas", block.GasUsed(), "witness", common.StorageSize(len(witnesses[it.index])),
+				"elapsed", common.PrettyDuration(time.Since(start)), "root", block.Root())
+
+			coalescedLogs = append(coalescedLogs, logs...)
+			events = append(events, ChainEvent{block, block.Hash(), logs})
+			lastCanon = block
+		}
+		blockInsertTimer.UpdateSince(start)
+		stats.blocks++
+		stats.txs += len(block.Transactions())
+		stats.usedGas += usedGas
+		stats.witnessBytes += len(witnesses[it.index])
+
+		if commitStats.needToCommit(chain, bc.db, it.index, bc.batchSizeLimit()) {
+			if _, err := bc.db.Commit(); err != nil {
+				log.Error("Could not commit chainDb", "err", err)
+				bc.db.Rollback()
+				return it.index, events, coalescedLogs, err
+			}
+		}
+	}
+	if lastCanon != nil {
+		stats.report(lastCanon)
+	}
+	// Append a single chain head event if we've progressed the chain
+	if lastCanon != nil && bc.CurrentBlock().Hash() == lastCanon.Hash() {
+		events = append(events, ChainHeadEvent{lastCanon})
+	}
+	return 0, events, coalescedLogs, nil
+}
+
+// fetchWitnesses returns the witnesses of the given blocks, reading the ones
+// stored in the database and retrieving the rest with the witness fetcher.
+func (bc *BlockChain) fetchWitnesses(chain types.Blocks) ([][]byte, error) {
+	var (
+		witnesses = make([][]byte, len(chain))
+		missing   types.Blocks
+		indices   []int
+	)
+	for i, block := range chain {
+		if witness := rawdb.ReadWitness(bc.db, block.Hash(), block.NumberU64()); len(witness) != 0 {
+			witnesses[i] = witness
+		} else {
+			missing = append(missing, block)
+			indices = append(indices, i)
+		}
+	}
+	if len(missing) == 0 {
+		return witnesses, nil
+	}
+	start := time.Now()
+	fetched, err := bc.witnessFetcher(missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched) != len(missing) {
+		return nil, fmt.Errorf("witness count mismatch: have %d, want %d", len(fetched), len(missing))
+	}
+	witnessFetchTimer.UpdateSince(start)
+	for i, witness := range fetched {
+		witnesses[indices[i]] = witness
+		witnessSizeMeter.Mark(int64(len(witness)))
+	}
+	return witnesses, nil
+}
+
+// processStateless executes the block against the state reconstructed from its
+// witness, validates the receipts and checks the resulting state root against
+// the one in the header.
+func (bc *BlockChain) processStateless(block, parent *types.Block, proof state.BlockProof) (types.Receipts, []*types.Log, uint64, *state.StateDB, error) {
+	dbstate, err := state.NewStateless(parent.Root(), proof, parent.NumberU64(), false)
+	if err != nil {
+		return nil, nil, 0, nil, err
+	}
+	dbstate.SetBlockNr(block.NumberU64())
+	var (
+		receipts types.Receipts
+		usedGas  = new(uint64)
+		header   = block.Header()
+		allLogs  []*types.Log
+		gp       = new(GasPool).AddGas(block.GasLimit())
+		statedb  = state.New(dbstate)
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if bc.chainConfig.DAOForkSupport && bc.chainConfig.DAOForkBlock != nil && bc.chainConfig.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	// Iterate over and process the individual transactions
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, _, err := ApplyTransaction(bc.chainConfig, bc, nil, gp, statedb, dbstate, header, tx, usedGas, bc.vmConfig)
+		if err != nil {
+			return nil, nil, 0, nil, err
+		}
+		if !bc.chainConfig.IsByzantium(header.Number) {
+			rootHash, err := dbstate.Root()
+			if err != nil {
+				return nil, nil, 0, nil, err
+			}
+			receipt.PostState = rootHash.Bytes()
+		}
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	if _, err := bc.engine.Finalize(bc.chainConfig, header, statedb, block.Transactions(), block.Uncles(), receipts); err != nil {
+		return receipts, allLogs, *usedGas, nil, err
+	}
+	if err := validateReceipts(block, receipts, *usedGas); err != nil {
+		return receipts, allLogs, *usedGas, nil, err
+	}
+	if err := statedb.Commit(bc.chainConfig.IsEIP158(header.Number), dbstate); err != nil {
+		return receipts, allLogs, *usedGas, nil, err
+	}
+	if err := dbstate.CheckRoot(header.Root, true); err != nil {
+		return receipts, allLogs, *usedGas, nil, fmt.Errorf("invalid merkle root: %v", err)
+	}
+	return receipts, allLogs, *usedGas, statedb, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// counterCode deploys a contract incrementing its storage slot 0 on every call.
+var counterCode = hexutil.MustDecode("0x600a600c600039600a6000f3600054600101600055" + "00")
+
+// makeWitnessedChain generates a chain of transfers and contract calls, imports
+// it into a full node storing the witnesses and returns the blocks together
+// with the full node.
+func makeWitnessedChain(t *testing.T, gspec *Genesis, n int) (types.Blocks, *BlockChain) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = crypto.CreateAddress(address, 0)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+		gendb    = ethdb.NewMemDatabase()
+		genesis  = gspec.MustCommit(gendb)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, n, func(i int, block *BlockGen) {
+		var txs []*types.Transaction
+		if i == 0 {
+			txs = append(txs, types.NewContractCreation(block.TxNonce(address), new(big.Int), 200000, nil, counterCode))
+		} else {
+			txs = append(txs, types.NewTransaction(block.TxNonce(address), contract, new(big.Int), 100000, nil, nil))
+			txs = append(txs, types.NewTransaction(block.TxNonce(address)+1, common.Address{byte(i)}, big.NewInt(1000), params.TxGas, nil, nil))
+		}
+		for _, tx := range txs {
+			signed, err := types.SignTx(tx, signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(signed)
+		}
+	})
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	full, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	full.EnableWitnesses(true)
+	if _, err := full.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain into the full node: %v", err)
+	}
+	return blocks, full
+}
+
+// Tests that a stateless chain verifies the blocks against their witnesses
+// without keeping any state.
+func TestStatelessInsert(t *testing.T) {
+	testStatelessInsert(t, params.TestChainConfig)
+}
+
+// Tests that the intermediate roots of pre-Byzantium receipts are computed from
+// the witnesses.
+func TestStatelessInsertPreByzantium(t *testing.T) {
+	testStatelessInsert(t, &params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: new(big.Int),
+		EIP150Block:    new(big.Int),
+		EIP155Block:    new(big.Int),
+		EIP158Block:    new(big.Int),
+	})
+}
+
+func testStatelessInsert(t *testing.T, config *params.ChainConfig) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	gspec := &Genesis{
+		Config: config,
+		Alloc:  GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(1000000000)}},
+	}
+	blocks, full := makeWitnessedChain(t, gspec, 8)
+	defer full.Stop()
+
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	stateless, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer stateless.Stop()
+
+	var fetched int
+	stateless.SetStateless(func(blocks types.Blocks) ([][]byte, error) {
+		witnesses := make([][]byte, len(blocks))
+		for i, block := range blocks {
+			witnesses[i] = full.GetWitness(block.Hash())
+		}
+		fetched += len(blocks)
+		return witnesses, nil
+	})
+	if n, err := stateless.InsertChain(blocks[:3]); err != nil {
+		t.Fatalf("block %d: failed to verify: %v", n, err)
+	}
+	if n, err := stateless.InsertChain(blocks[3:]); err != nil {
+		t.Fatalf("block %d: failed to verify: %v", n, err)
+	}
+	if head := stateless.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Errorf("head mismatch: have #%d [%x…], want #%d [%x…]", head.NumberU64(), head.Hash().Bytes()[:4], blocks[len(blocks)-1].NumberU64(), blocks[len(blocks)-1].Hash().Bytes()[:4])
+	}
+	if fetched != len(blocks) {
+		t.Errorf("fetched witnesses mismatch: have %d, want %d", fetched, len(blocks))
+	}
+	contract := crypto.CreateAddress(crypto.PubkeyToAddress(key.PublicKey), 0)
+	if _, err := db.Get(state.AccountsBucket, crypto.Keccak256(contract[:])); err == nil {
+		t.Errorf("stateless chain stored the state of the contract")
+	}
+}
+
+// Tests that blocks are rejected if their witness does not prove the state
+// the block is executed against.
+func TestStatelessInsertBadWitness(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc:  GenesisAlloc{crypto.PubkeyToAddress(key.PublicKey): {Balance: big.NewInt(1000000000)}},
+	}
+	blocks, full := makeWitnessedChain(t, gspec, 4)
+	defer full.Stop()
+
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	stateless, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer stateless.Stop()
+
+	// Serve the witness of the previous block for the last one
+	stateless.SetStateless(func(blocks types.Blocks) ([][]byte, error) {
+		witnesses := make([][]byte, len(blocks))
+		for i, block := range blocks {
+			hash := block.Hash()
+			if block.NumberU64() == 4 {
+				hash = block.ParentHash()
+			}
+			witnesses[i] = full.GetWitness(hash)
+		}
+		return witnesses, nil
+	})
+	n, err := stateless.InsertChain(blocks)
+	if err == nil {
+		t.Fatalf("bad witness accepted")
+	}
+	if n != 3 {
+		t.Errorf("failing block index mismatch: have %d, want %d", n, 3)
+	}
+	if head := stateless.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Errorf("head mismatch: have #%d, want #%d", head.NumberU64(), blocks[2].NumberU64())
+	}
+}
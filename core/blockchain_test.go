@@ -18,8 +18,11 @@ package core
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -1233,6 +1236,151 @@ func TestEIP161AccountRemoval(t *testing.T) {
 	}
 }
 
+// Tests that the witnesses stored for imported blocks are sufficient to
+// reconstruct the state read by each block.
+func TestBlockWitnesses(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		signer  = types.HomesteadSigner{}
+		gendb   = ethdb.NewMemDatabase()
+		genesis = gspec.MustCommit(gendb)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 4, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i + 1)}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer blockchain.Stop()
+	blockchain.EnableWitnesses(true)
+
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	parent := genesis
+	for _, block := range blocks {
+		enc := blockchain.GetWitness(block.Hash())
+		if len(enc) == 0 {
+			t.Fatalf("block %d: witness missing", block.NumberU64())
+		}
+		proof, err := state.DecodeBlockProof(enc)
+		if err != nil {
+			t.Fatalf("block %d: failed to decode witness: %v", block.NumberU64(), err)
+		}
+		if err := proof.Verify(parent.Root()); err != nil {
+			t.Fatalf("block %d: failed to verify witness: %v", block.NumberU64(), err)
+		}
+		stateless, err := state.NewStateless(parent.Root(), *proof, block.NumberU64()-1, false)
+		if err != nil {
+			t.Fatalf("block %d: failed to construct state from witness: %v", block.NumberU64(), err)
+		}
+		account, err := stateless.ReadAccountData(address)
+		if err != nil {
+			t.Fatalf("block %d: failed to read sender: %v", block.NumberU64(), err)
+		}
+		if account == nil || account.Nonce != block.NumberU64()-1 {
+			t.Errorf("block %d: sender account mismatch: have %v, want nonce %d", block.NumberU64(), account, block.NumberU64()-1)
+		}
+		parent = block
+	}
+	if blockchain.GetWitness(genesis.Hash()) != nil {
+		t.Errorf("genesis should not have a witness")
+	}
+}
+
+// Tests that a chain imported through the insertion batch spilling into files
+// ends up the same as the one imported in memory.
+func TestSpillingImport(t *testing.T) {
+	defer func(unit int) { spillLimitUnit = unit }(spillLimitUnit)
+	spillLimitUnit = 1024
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		signer  = types.HomesteadSigner{}
+		gendb   = ethdb.NewMemDatabase()
+		genesis = gspec.MustCommit(gendb)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 32, func(i int, block *BlockGen) {
+		for j := 0; j < 4; j++ {
+			to := common.BigToAddress(big.NewInt(int64(0x1000 + i*4 + j)))
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), to, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	dir, err := ioutil.TempDir("", "spill-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spillDir := filepath.Join(dir, "spill")
+	if err := os.MkdirAll(spillDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// A run left over by a crashed import
+	if err := ioutil.WriteFile(filepath.Join(spillDir, "ethdb-spill-stale"), []byte{1}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	cacheConfig := &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, SpillLimit: 4, SpillDir: spillDir}
+	blockchain, err := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blockchain.Stop()
+	blockchain.EnableReceipts(true)
+
+	if files, _ := ioutil.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("stale runs not removed: %d files", len(files))
+	}
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if files, _ := ioutil.ReadDir(spillDir); len(files) != 0 {
+		t.Errorf("runs not removed after the commit: %d files", len(files))
+	}
+	head := blocks[len(blocks)-1]
+	if have := blockchain.CurrentBlock().Hash(); have != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, head.Hash())
+	}
+	// Read everything back from the database rather than the batch
+	for _, block := range blocks {
+		if rawdb.ReadBlock(db, block.Hash(), block.NumberU64()) == nil {
+			t.Fatalf("block %d missing from the database", block.NumberU64())
+		}
+		if receipts := rawdb.ReadReceipts(db, block.Hash(), block.NumberU64()); len(receipts) != 4 {
+			t.Fatalf("block %d: receipt count mismatch: have %d, want 4", block.NumberU64(), len(receipts))
+		}
+	}
+	for i, block := range blocks {
+		statedb := state.New(state.NewDbState(db, block.NumberU64()))
+		if nonce := statedb.GetNonce(address); nonce != uint64(4*(i+1)) {
+			t.Errorf("block %d: sender nonce mismatch: have %d, want %d", block.NumberU64(), nonce, 4*(i+1))
+		}
+		to := common.BigToAddress(big.NewInt(int64(0x1000 + i*4)))
+		if balance := statedb.GetBalance(to); balance.Cmp(big.NewInt(1000)) != 0 {
+			t.Errorf("block %d: recipient balance mismatch: have %v, want 1000", block.NumberU64(), balance)
+		}
+	}
+}
+
 // This is a regression test (i.e. as weird as it is, don't delete it ever), which
 // tests that under weird reorg conditions the blockchain and its internal header-
 // chain return the same latest block/header.
@@ -1438,3 +1586,68 @@ func BenchmarkBlockChain_1x1000Executions(b *testing.B) {
 
 	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
 }
+
+// Tests that the state written beyond the head block, e.g. by a crash between
+// the state and the head updates, is unwound when the chain is reopened.
+func TestUnwindStateBeyondHead(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		to      = common.HexToAddress("0xaa")
+		db      = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: big.NewInt(1000000000)}}}
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	gendb := ethdb.NewMemDatabase()
+	gspec.MustCommit(gendb)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 5, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), to, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	chain.Stop()
+
+	// Move the head back without unwinding the state and the change sets
+	rawdb.WriteHeadBlockHash(db, blocks[2].Hash())
+	rawdb.WriteHeadHeaderHash(db, blocks[2].Hash())
+	rawdb.WriteHeadFastBlockHash(db, blocks[2].Hash())
+	if last, err := ethdb.LastChangeSetAfter(db, 3); err != nil || last != 5 {
+		t.Fatalf("change sets before reopening: have %d (%v), want 5", last, err)
+	}
+
+	chain, err = NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	defer chain.Stop()
+	if head := chain.CurrentBlock().NumberU64(); head != 3 {
+		t.Fatalf("head block: have %d, want 3", head)
+	}
+	if last, err := ethdb.LastChangeSetAfter(db, 3); err != nil || last != 3 {
+		t.Fatalf("change sets after reopening: have %d (%v), want 3", last, err)
+	}
+	statedb, _, err := chain.StateAt(blocks[2].Root(), 3)
+	if err != nil {
+		t.Fatalf("failed to open the head state: %v", err)
+	}
+	if balance := statedb.GetBalance(to); balance.Cmp(big.NewInt(3000)) != 0 {
+		t.Errorf("balance at the head: have %v, want 3000", balance)
+	}
+	// The blocks after the head import on top of the unwound state
+	if _, err := chain.InsertChain(blocks[3:]); err != nil {
+		t.Fatalf("failed to reinsert blocks: %v", err)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 5 {
+		t.Errorf("head block after reinsertion: have %d, want 5", head)
+	}
+}
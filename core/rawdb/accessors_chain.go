@@ -326,6 +326,48 @@ func DeleteReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
 	}
 }
 
+// ReadWitness retrieves the encoded witness of the block, or nil if the block
+// has no witness stored.
+func ReadWitness(db DatabaseReader, hash common.Hash, number uint64) []byte {
+	data, _ := db.Get(blockWitnessPrefix, blockWitnessKey(number, hash))
+	return data
+}
+
+// WriteWitness stores the encoded witness of the block.
+func WriteWitness(db DatabaseWriter, hash common.Hash, number uint64, witness []byte) {
+	if err := db.Put(blockWitnessPrefix, blockWitnessKey(number, hash), witness); err != nil {
+		log.Crit("Failed to store block witness", "err", err)
+	}
+}
+
+// DeleteWitness removes the witness of the block.
+func DeleteWitness(db DatabaseDeleter, hash common.Hash, number uint64) {
+	if err := db.Delete(blockWitnessPrefix, blockWitnessKey(number, hash)); err != nil {
+		log.Crit("Failed to delete block witness", "err", err)
+	}
+}
+
+// ReadStateDiffs retrieves the encoded state diffs of the transactions of the
+// block, or nil if the block has no state diffs stored.
+func ReadStateDiffs(db DatabaseReader, hash common.Hash, number uint64) []byte {
+	data, _ := db.Get(blockStateDiffsPrefix, blockStateDiffsKey(number, hash))
+	return data
+}
+
+// WriteStateDiffs stores the encoded state diffs of the transactions of the block.
+func WriteStateDiffs(db DatabaseWriter, hash common.Hash, number uint64, diffs []byte) {
+	if err := db.Put(blockStateDiffsPrefix, blockStateDiffsKey(number, hash), diffs); err != nil {
+		log.Crit("Failed to store block state diffs", "err", err)
+	}
+}
+
+// DeleteStateDiffs removes the state diffs of the transactions of the block.
+func DeleteStateDiffs(db DatabaseDeleter, hash common.Hash, number uint64) {
+	if err := db.Delete(blockStateDiffsPrefix, blockStateDiffsKey(number, hash)); err != nil {
+		log.Crit("Failed to delete block state diffs", "err", err)
+	}
+}
+
 // ReadBlock retrieves an entire block corresponding to the hash, assembling it
 // back from the stored header and body. If either the header or body could not
 // be retrieved nil is returned.
@@ -353,6 +395,8 @@ func WriteBlock(db DatabaseWriter, block *types.Block) {
 // DeleteBlock removes all block data associated with a hash.
 func DeleteBlock(db DatabaseDeleter, hash common.Hash, number uint64) {
 	DeleteReceipts(db, hash, number)
+	DeleteWitness(db, hash, number)
+	DeleteStateDiffs(db, hash, number)
 	DeleteHeader(db, hash, number)
 	DeleteBody(db, hash, number)
 	DeleteTd(db, hash, number)
@@ -206,6 +206,50 @@ func TestTdStorage(t *testing.T) {
 	}
 }
 
+// Tests block witness storage and retrieval operations.
+func TestWitnessStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+
+	hash, witness := common.Hash{0: 0xff}, []byte{0x01, 0x02, 0x03}
+	if entry := ReadWitness(db, hash, 0); entry != nil {
+		t.Fatalf("Non existent witness returned: %x", entry)
+	}
+	// Write and verify the witness in the database
+	WriteWitness(db, hash, 0, witness)
+	if entry := ReadWitness(db, hash, 0); entry == nil {
+		t.Fatalf("Stored witness not found")
+	} else if !bytes.Equal(entry, witness) {
+		t.Fatalf("Retrieved witness mismatch: have %x, want %x", entry, witness)
+	}
+	// Delete the block and verify that the witness is gone with it
+	DeleteBlock(db, hash, 0)
+	if entry := ReadWitness(db, hash, 0); entry != nil {
+		t.Fatalf("Deleted witness returned: %x", entry)
+	}
+}
+
+// Tests block state diffs storage and retrieval operations.
+func TestStateDiffsStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+
+	hash, diffs := common.Hash{0: 0xff}, []byte{0xc1, 0xc0}
+	if entry := ReadStateDiffs(db, hash, 0); entry != nil {
+		t.Fatalf("Non existent state diffs returned: %x", entry)
+	}
+	// Write and verify the state diffs in the database
+	WriteStateDiffs(db, hash, 0, diffs)
+	if entry := ReadStateDiffs(db, hash, 0); entry == nil {
+		t.Fatalf("Stored state diffs not found")
+	} else if !bytes.Equal(entry, diffs) {
+		t.Fatalf("Retrieved state diffs mismatch: have %x, want %x", entry, diffs)
+	}
+	// Delete the block and verify that the state diffs are gone with it
+	DeleteBlock(db, hash, 0)
+	if entry := ReadStateDiffs(db, hash, 0); entry != nil {
+		t.Fatalf("Deleted state diffs returned: %x", entry)
+	}
+}
+
 // Tests that canonical numbers can be mapped to hashes and retrieved.
 func TestCanonicalMappingStorage(t *testing.T) {
 	db := ethdb.NewMemDatabase()
@@ -17,8 +17,11 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 )
@@ -105,3 +108,67 @@ func WriteBloomBits(db DatabaseWriter, bit uint, section uint64, head common.Has
 		log.Crit("Failed to store bloom bits", "err", err)
 	}
 }
+
+// WriteAppearances stores the indexes of the transactions of the block in which
+// each address appears, along with the list of the addresses of the block used
+// to unwind it.
+func WriteAppearances(db DatabaseWriter, number uint64, appearances map[common.Address][]uint64) {
+	if len(appearances) == 0 {
+		return
+	}
+	addresses := make([]byte, 0, len(appearances)*common.AddressLength)
+	for address, txs := range appearances {
+		data, err := rlp.EncodeToBytes(txs)
+		if err != nil {
+			log.Crit("Failed to RLP encode address appearances", "err", err)
+		}
+		if err := db.Put(addressAppearancePrefix, addressAppearanceKey(address, number), data); err != nil {
+			log.Crit("Failed to store address appearances", "err", err)
+		}
+		addresses = append(addresses, address[:]...)
+	}
+	if err := db.Put(blockAppearancePrefix, encodeBlockNumber(number), addresses); err != nil {
+		log.Crit("Failed to store block appearances", "err", err)
+	}
+}
+
+// DeleteAppearances removes the address appearances of the block.
+func DeleteAppearances(db interface {
+	DatabaseReader
+	DatabaseDeleter
+}, number uint64) {
+	addresses, _ := db.Get(blockAppearancePrefix, encodeBlockNumber(number))
+	for i := 0; i+common.AddressLength <= len(addresses); i += common.AddressLength {
+		address := common.BytesToAddress(addresses[i : i+common.AddressLength])
+		if err := db.Delete(addressAppearancePrefix, addressAppearanceKey(address, number)); err != nil {
+			log.Crit("Failed to delete address appearances", "err", err)
+		}
+	}
+	if err := db.Delete(blockAppearancePrefix, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to delete block appearances", "err", err)
+	}
+}
+
+// ReadAppearanceBlocks returns the numbers of the blocks from the given one on
+// that have address appearances stored.
+func ReadAppearanceBlocks(db ethdb.Getter, from uint64) ([]uint64, error) {
+	var numbers []uint64
+	err := db.Walk(blockAppearancePrefix, encodeBlockNumber(from), 0, func(k, _ []byte) (bool, error) {
+		numbers = append(numbers, binary.BigEndian.Uint64(k))
+		return true, nil
+	})
+	return numbers, err
+}
+
+// WalkAppearances calls the walker with the indexes of the transactions the
+// address appears in, for every block from the given one on, in ascending order,
+// until the walker returns false.
+func WalkAppearances(db ethdb.Getter, address common.Address, from uint64, walker func(number uint64, txs []uint64) (bool, error)) error {
+	return db.Walk(addressAppearancePrefix, addressAppearanceKey(address, from), 8*common.AddressLength, func(k, v []byte) (bool, error) {
+		var txs []uint64
+		if err := rlp.DecodeBytes(v, &txs); err != nil {
+			return false, err
+		}
+		return walker(binary.BigEndian.Uint64(k[common.AddressLength:]), txs)
+	})
+}
@@ -66,3 +66,47 @@ func TestLookupStorage(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the address appearances can be stored, walked and unwound.
+func TestAppearanceStorage(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+
+	alice, bob := common.BytesToAddress([]byte{0x11}), common.BytesToAddress([]byte{0x22})
+	WriteAppearances(db, 5, map[common.Address][]uint64{alice: {0, 2}, bob: {1}})
+	WriteAppearances(db, 7, map[common.Address][]uint64{alice: {3}})
+	WriteAppearances(db, 9, map[common.Address][]uint64{bob: {0}})
+
+	walk := func(address common.Address, from uint64) map[uint64][]uint64 {
+		found := make(map[uint64][]uint64)
+		if err := WalkAppearances(db, address, from, func(number uint64, txs []uint64) (bool, error) {
+			found[number] = txs
+			return true, nil
+		}); err != nil {
+			t.Fatalf("failed to walk appearances: %v", err)
+		}
+		return found
+	}
+	if found := walk(alice, 0); len(found) != 2 || len(found[5]) != 2 || found[5][1] != 2 || found[7][0] != 3 {
+		t.Fatalf("alice appearances mismatch: %v", found)
+	}
+	if found := walk(bob, 6); len(found) != 1 || found[9][0] != 0 {
+		t.Fatalf("bob appearances mismatch: %v", found)
+	}
+	// Unwind the last two blocks
+	blocks, err := ReadAppearanceBlocks(db, 6)
+	if err != nil || len(blocks) != 2 || blocks[0] != 7 || blocks[1] != 9 {
+		t.Fatalf("blocks mismatch: %v, %v", blocks, err)
+	}
+	for _, number := range blocks {
+		DeleteAppearances(db, number)
+	}
+	if found := walk(alice, 0); len(found) != 1 || len(found[5]) != 2 {
+		t.Fatalf("alice appearances after unwind mismatch: %v", found)
+	}
+	if found := walk(bob, 0); len(found) != 1 || found[5][0] != 1 {
+		t.Fatalf("bob appearances after unwind mismatch: %v", found)
+	}
+	if blocks, _ := ReadAppearanceBlocks(db, 0); len(blocks) != 1 || blocks[0] != 5 {
+		t.Fatalf("blocks after unwind mismatch: %v", blocks)
+	}
+}
@@ -29,7 +29,7 @@ import (
 func ReadDatabaseVersion(db DatabaseReader) *uint64 {
 	var version uint64
 
-	enc, _ := db.Get(databaseVerisionKey, databaseVerisionKey)
+	enc, _ := db.Get(databaseVersionKey, databaseVersionKey)
 	if len(enc) == 0 {
 		return nil
 	}
@@ -46,7 +46,7 @@ func WriteDatabaseVersion(db DatabaseWriter, version uint64) {
 	if err != nil {
 		log.Crit("Failed to encode database version", "err", err)
 	}
-	if err = db.Put(databaseVerisionKey, databaseVerisionKey, enc); err != nil {
+	if err = db.Put(databaseVersionKey, databaseVersionKey, enc); err != nil {
 		log.Crit("Failed to store the database version", "err", err)
 	}
 }
@@ -0,0 +1,98 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// migrationBatchKeys is the number of keys a migration converts in one batch.
+const migrationBatchKeys = 1000
+
+// Migrations returns the migrations of the chain database schema, in the order
+// they have to be applied. New migrations go to the end of the list, and the
+// existing ones must never be removed or reordered, as the schema version
+// stored in the database is the number of the migrations applied to it.
+func Migrations(config *params.ChainConfig) []ethdb.Migration {
+	return []ethdb.Migration{
+		{Name: "block_body_senders", Up: bodySendersMigration(config)},
+	}
+}
+
+// bodySendersMigration converts the block bodies stored without the senders
+// of the transactions into the bodies with the senders.
+func bodySendersMigration(config *params.ChainConfig) func(batch ethdb.Mutation, progress []byte) ([]byte, error) {
+	return func(batch ethdb.Mutation, progress []byte) ([]byte, error) {
+		var (
+			keys, values [][]byte
+			complete     = true
+		)
+		if err := batch.Walk(blockBodyPrefix, progress, 0, func(k, v []byte) (bool, error) {
+			if len(keys) == migrationBatchKeys {
+				complete = false
+				return false, nil
+			}
+			keys = append(keys, common.CopyBytes(k))
+			values = append(values, common.CopyBytes(v))
+			return true, nil
+		}); err != nil {
+			return nil, err
+		}
+		for i, k := range keys {
+			if err := rlp.DecodeBytes(values[i], new(types.Body)); err == nil {
+				continue // Converted already
+			}
+			smallBody := new(types.SmallBody)
+			if err := rlp.DecodeBytes(values[i], smallBody); err != nil {
+				return nil, err
+			}
+			number := binary.BigEndian.Uint64(k[:8])
+			signer := types.MakeSigner(config, new(big.Int).SetUint64(number))
+			body := &types.Body{
+				Transactions: smallBody.Transactions,
+				Senders:      make([]common.Address, len(smallBody.Transactions)),
+				Uncles:       smallBody.Uncles,
+			}
+			for j, tx := range smallBody.Transactions {
+				sender, err := types.Sender(signer, tx)
+				if err != nil {
+					return nil, err
+				}
+				body.Senders[j] = sender
+			}
+			enc, err := rlp.EncodeToBytes(body)
+			if err != nil {
+				return nil, err
+			}
+			if err := batch.Put(blockBodyPrefix, k, enc); err != nil {
+				return nil, err
+			}
+		}
+		if complete {
+			return nil, nil
+		}
+		// Continue right after the last converted key
+		return append(keys[len(keys)-1], 0), nil
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestBodySendersMigration(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	key, _ := crypto.GenerateKey()
+	config := params.TestChainConfig
+
+	var hashes []common.Hash
+	for number := uint64(1); number <= 3; number++ {
+		signer := types.MakeSigner(config, new(big.Int).SetUint64(number))
+		tx, err := types.SignTx(types.NewTransaction(number, common.Address{1}, big.NewInt(1), 21000, big.NewInt(1), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := rlp.EncodeToBytes(&types.SmallBody{Transactions: []*types.Transaction{tx}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash := common.Hash{byte(number)}
+		if err := db.Put(blockBodyPrefix, blockBodyKey(number, hash), enc); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := ethdb.Migrate(db, Migrations(config)); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	for i, hash := range hashes {
+		body := ReadBody(db, hash, uint64(i+1))
+		if body == nil || len(body.Senders) != 1 {
+			t.Fatalf("body %d is not converted: %v", i+1, body)
+		}
+		if body.Senders[0] != crypto.PubkeyToAddress(key.PublicKey) {
+			t.Errorf("body %d has sender %x", i+1, body.Senders[0])
+		}
+	}
+	if version, err := ethdb.SchemaVersion(db); err != nil || version != uint64(len(Migrations(config))) {
+		t.Errorf("schema version is %d, %v", version, err)
+	}
+}
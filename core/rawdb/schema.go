@@ -21,43 +21,116 @@ import (
 	"encoding/binary"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
-// The fields below define the low level database schema prefixing.
+// The fields below define the low level database schema. In the databases
+// with buckets the prefixes name the buckets, and the keys do not include them.
 var (
-	// databaseVerisionKey tracks the current database version.
-	databaseVerisionKey = []byte("DatabaseVersion")
+	// databaseVersionKey tracks the current database version.
+	databaseVersionKey = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("DatabaseVersion"),
+		Key:   "\"DatabaseVersion\"",
+		Value: "RLP encoded version of the block chain format",
+	})
 
 	// headHeaderKey tracks the latest know header's hash.
-	headHeaderKey = []byte("LastHeader")
+	headHeaderKey = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("LastHeader"),
+		Key:   "\"LastHeader\"",
+		Value: "hash of the head header",
+	})
 
 	// headBlockKey tracks the latest know full block's hash.
-	headBlockKey = []byte("LastBlock")
+	headBlockKey = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("LastBlock"),
+		Key:   "\"LastBlock\"",
+		Value: "hash of the head block",
+	})
 
 	// headFastBlockKey tracks the latest known incomplete block's hash during fast sync.
-	headFastBlockKey = []byte("LastFast")
+	headFastBlockKey = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("LastFast"),
+		Key:   "\"LastFast\"",
+		Value: "hash of the head fast block",
+	})
 
 	// fastTrieProgressKey tracks the number of trie entries imported during fast sync.
-	fastTrieProgressKey = []byte("TrieSync")
+	fastTrieProgressKey = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("TrieSync"),
+		Key:   "\"TrieSync\"",
+		Value: "number of the trie entries (big endian)",
+	})
 
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`, used for indexes).
-	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
+	headerPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("h"),
+		Key:   "num (uint64 big endian) + hash [+ headerTDSuffix], or num (uint64 big endian) + headerHashSuffix",
+		Value: "RLP encoded header, total difficulty or canonical hash",
+	})
 	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
 	headerHashSuffix   = []byte("n") // headerPrefix + num (uint64 big endian) + headerHashSuffix -> hash
-	headerNumberPrefix = []byte("H") // headerNumberPrefix + hash -> num (uint64 big endian)
-
-	blockBodyPrefix     = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
-	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
-
-	txLookupPrefix  = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
-	bloomBitsPrefix = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
-
-	preimagePrefix = []byte("secure-key-")      // preimagePrefix + hash -> preimage
-	configPrefix   = []byte("ethereum-config-") // config prefix for the db
+	headerNumberPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("H"),
+		Key:   "hash",
+		Value: "block number (uint64 big endian)",
+	})
+
+	blockBodyPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("b"),
+		Key:   "num (uint64 big endian) + hash",
+		Value: "RLP encoded block body with the transaction senders",
+	})
+	blockReceiptsPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("r"),
+		Key:   "num (uint64 big endian) + hash",
+		Value: "RLP encoded block receipts",
+	})
+	blockWitnessPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("w"),
+		Key:   "num (uint64 big endian) + hash",
+		Value: "encoded block witness (proof of the state read by the block)",
+	})
+	blockStateDiffsPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("d"),
+		Key:   "num (uint64 big endian) + hash",
+		Value: "RLP encoded state diffs of the transactions of the block",
+	})
+
+	txLookupPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("l"),
+		Key:   "hash",
+		Value: "RLP encoded transaction/receipt lookup metadata",
+	})
+	bloomBitsPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("B"),
+		Key:   "bit (uint16 big endian) + section (uint64 big endian) + hash",
+		Value: "bloom bits",
+	})
+
+	addressAppearancePrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("a"),
+		Key:   "address + num (uint64 big endian)",
+		Value: "RLP encoded indexes of the transactions of the block whose call frames the address appears in",
+	})
+	blockAppearancePrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("A"),
+		Key:   "num (uint64 big endian)",
+		Value: "concatenated addresses appearing in the call frames of the block",
+	})
+
+	preimagePrefix = trie.SecureKeyPrefix // Registered by the trie package
+	configPrefix   = ethdb.RegisterBucket(ethdb.BucketInfo{
+		Name:  []byte("ethereum-config-"),
+		Key:   "genesis hash",
+		Value: "JSON encoded chain configuration",
+	})
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
-	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	BloomBitsIndexPrefix  = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	AppearanceIndexPrefix = []byte("iA") // AppearanceIndexPrefix is the data table of the address appearance indexer to track its progress
 
 	preimageCounter    = metrics.NewRegisteredCounter("db/preimage/total", nil)
 	preimageHitCounter = metrics.NewRegisteredCounter("db/preimage/hits", nil)
@@ -108,6 +181,16 @@ func blockReceiptsKey(number uint64, hash common.Hash) []byte {
 	return append(encodeBlockNumber(number), hash.Bytes()...)
 }
 
+// blockWitnessKey = blockWitnessPrefix + num (uint64 big endian) + hash
+func blockWitnessKey(number uint64, hash common.Hash) []byte {
+	return append(encodeBlockNumber(number), hash.Bytes()...)
+}
+
+// blockStateDiffsKey = blockStateDiffsPrefix + num (uint64 big endian) + hash
+func blockStateDiffsKey(number uint64, hash common.Hash) []byte {
+	return append(encodeBlockNumber(number), hash.Bytes()...)
+}
+
 // txLookupKey = txLookupPrefix + hash
 func txLookupKey(hash common.Hash) []byte {
 	return append(txLookupPrefix, hash.Bytes()...)
@@ -123,6 +206,11 @@ func bloomBitsKey(bit uint, section uint64, hash common.Hash) []byte {
 	return key
 }
 
+// addressAppearanceKey = addressAppearancePrefix + address + num (uint64 big endian)
+func addressAppearanceKey(address common.Address, number uint64) []byte {
+	return append(address.Bytes(), encodeBlockNumber(number)...)
+}
+
 // preimageKey = preimagePrefix + hash
 func preimageKey(hash common.Hash) []byte {
 	return append(preimagePrefix, hash.Bytes()...)
@@ -0,0 +1,183 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func init() {
+	Register("deps", func() Tracer { return newDepsTracer() })
+}
+
+// accessSet is a set of the accounts and the storage items touched by a
+// transaction or one of its call frames.
+type accessSet struct {
+	accounts map[common.Address]struct{}
+	storage  map[common.Address]map[common.Hash]struct{}
+}
+
+func newAccessSet() *accessSet {
+	return &accessSet{
+		accounts: make(map[common.Address]struct{}),
+		storage:  make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+func (s *accessSet) addAccount(address common.Address) {
+	s.accounts[address] = struct{}{}
+}
+
+func (s *accessSet) addStorage(address common.Address, key common.Hash) {
+	keys, ok := s.storage[address]
+	if !ok {
+		keys = make(map[common.Hash]struct{})
+		s.storage[address] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (s *accessSet) merge(other *accessSet) {
+	for address := range other.accounts {
+		s.addAccount(address)
+	}
+	for address, keys := range other.storage {
+		for key := range keys {
+			s.addStorage(address, key)
+		}
+	}
+}
+
+// accessList is the JSON form of an accessSet, with the items sorted.
+type accessList struct {
+	Accounts []common.Address                 `json:"accounts"`
+	Storage  map[common.Address][]common.Hash `json:"storage"`
+}
+
+func (s *accessSet) list() *accessList {
+	list := &accessList{
+		Accounts: make([]common.Address, 0, len(s.accounts)),
+		Storage:  make(map[common.Address][]common.Hash, len(s.storage)),
+	}
+	for address := range s.accounts {
+		list.Accounts = append(list.Accounts, address)
+	}
+	sort.Slice(list.Accounts, func(i, j int) bool {
+		return bytes.Compare(list.Accounts[i][:], list.Accounts[j][:]) < 0
+	})
+	for address, keys := range s.storage {
+		sorted := make([]common.Hash, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+		})
+		list.Storage[address] = sorted
+	}
+	return list
+}
+
+// depsTracer collects the read and the write sets of a transaction, the data
+// its dependencies on the other transactions of the block are derived from. The
+// writes of the call frames which fail are left out, as they are reverted.
+type depsTracer struct {
+	reads  *accessSet
+	writes *accessSet
+	frames []*accessSet // Writes of the call frames entered, innermost last
+}
+
+func newDepsTracer() *depsTracer {
+	return &depsTracer{
+		reads:  newAccessSet(),
+		writes: newAccessSet(),
+	}
+}
+
+// frame returns the write set of the innermost call frame, or the one of the
+// transaction outside of the frames.
+func (t *depsTracer) frame() *accessSet {
+	if len(t.frames) == 0 {
+		return t.writes
+	}
+	return t.frames[len(t.frames)-1]
+}
+
+func (t *depsTracer) CaptureStart(depth int, from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	t.frames = append(t.frames, newAccessSet())
+	return nil
+}
+
+func (t *depsTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if stack.Len() == 0 {
+		return nil
+	}
+	switch op {
+	case vm.SLOAD:
+		t.reads.addStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+	case vm.SSTORE:
+		t.frame().addStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+	}
+	return nil
+}
+
+func (t *depsTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements vm.Tracer, merging the writes of the frame into its
+// parent unless the frame failed.
+func (t *depsTracer) CaptureEnd(depth int, output []byte, gasUsed uint64, d time.Duration, err error) error {
+	if len(t.frames) == 0 {
+		return nil
+	}
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	if err == nil {
+		t.frame().merge(frame)
+	}
+	return nil
+}
+
+func (t *depsTracer) CaptureCreate(creator common.Address, creation common.Address) error {
+	return nil
+}
+
+func (t *depsTracer) CaptureAccountRead(account common.Address) error {
+	t.reads.addAccount(account)
+	return nil
+}
+
+func (t *depsTracer) CaptureAccountWrite(account common.Address) error {
+	t.frame().addAccount(account)
+	return nil
+}
+
+// GetResult implements Tracer, returning the read and the write sets.
+func (t *depsTracer) GetResult() (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Reads  *accessList `json:"reads"`
+		Writes *accessList `json:"writes"`
+	}{t.reads.list(), t.writes.list()})
+}
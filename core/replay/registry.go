@@ -0,0 +1,84 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package replay re-executes the historical blocks on the state of their parents
+// with a tracer, collecting a result for every transaction.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Tracer is a transaction tracer returning its result as JSON. Besides the EVM
+// events, it receives the account reads and writes of the state.
+type Tracer interface {
+	vm.Tracer
+
+	// GetResult returns the result of the tracing, or any accumulated error.
+	GetResult() (json.RawMessage, error)
+}
+
+// Factory creates a new tracer. A separate tracer is created for every
+// replayed transaction, and the factory is called concurrently.
+type Factory func() Tracer
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Factory)
+)
+
+// Register makes a tracer available under the given name. It is meant to be
+// called from the init functions of the packages implementing the tracers, and
+// panics if the name is already taken.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if factory == nil {
+		panic("replay: nil tracer factory for " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("replay: tracer %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory of the tracer registered under the given name.
+func Lookup(name string) (Factory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Names returns the sorted names of the registered tracers.
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// DefaultRangeSize is the number of consecutive blocks replayed by a worker in
+// one go when the configuration doesn't say otherwise.
+const DefaultRangeSize = 100
+
+// Config are the parameters of a replay.
+type Config struct {
+	From      uint64 // First block to replay
+	To        uint64 // Last block to replay, inclusive
+	Workers   int    // Number of block ranges replayed in parallel
+	RangeSize uint64 // Number of blocks in a range
+}
+
+// Result is the outcome of the tracing of a replayed transaction.
+type Result struct {
+	Block  uint64          `json:"block"`
+	Index  int             `json:"index"`
+	Hash   common.Hash     `json:"hash"`
+	Failed bool            `json:"failed"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"` // Error of the tracer, if any
+}
+
+// task is a range of blocks replayed by one of the workers.
+type task struct {
+	first, last uint64
+	results     []*Result
+	err         error
+	done        chan struct{}
+}
+
+// Replay executes the transactions of the canonical blocks in the configured
+// range with a tracer created by the factory for each of them, and passes the
+// results to the callback in the order of the transactions.
+//
+// Every block is executed on the historical state of its parent read from the
+// database, so the blocks are independent of each other, and the ranges of the
+// blocks are replayed in parallel. The results of the ranges finished ahead of
+// their turn are held back until the ones before them are passed on. The blocks
+// must be above the history horizon.
+func Replay(ctx context.Context, db ethdb.Database, chain core.ChainContext, config *params.ChainConfig, cfg *Config, newTracer Factory, emit func(*Result) error) error {
+	if cfg.From > cfg.To {
+		return fmt.Errorf("invalid block range #%d - #%d", cfg.From, cfg.To)
+	}
+	// The genesis block has no transactions to replay
+	from := cfg.From
+	if from == 0 {
+		from = 1
+	}
+	if from > cfg.To {
+		return nil
+	}
+	if err := ethdb.CheckHistoryHorizon(db, from); err != nil {
+		return err
+	}
+	workers, size := cfg.Workers, cfg.RangeSize
+	if workers <= 0 {
+		workers = 1
+	}
+	if size == 0 {
+		size = DefaultRangeSize
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		tasks   = make(chan *task)
+		pending = make(chan *task, workers) // Tasks in the order of their results
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				t.results, t.err = replayRange(ctx, db, chain, config, t.first, t.last, newTracer)
+				close(t.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(tasks)
+		defer close(pending)
+
+		for first := from; ; {
+			last := first + size - 1
+			if last < first || last > cfg.To {
+				last = cfg.To
+			}
+			t := &task{first: first, last: last, done: make(chan struct{})}
+			select {
+			case pending <- t:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case tasks <- t:
+			case <-ctx.Done():
+				return
+			}
+			if last == cfg.To {
+				return
+			}
+			first = last + 1
+		}
+	}()
+	for t := range pending {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if t.err != nil {
+			return t.err
+		}
+		for _, result := range t.results {
+			if err := emit(result); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// replayRange replays the blocks from first to last, inclusive.
+func replayRange(ctx context.Context, db ethdb.Database, chain core.ChainContext, config *params.ChainConfig, first, last uint64, newTracer Factory) ([]*Result, error) {
+	var results []*Result
+	for number := first; number <= last && number >= first; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := rawdb.ReadBlock(db, rawdb.ReadCanonicalHash(db, number), number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		blockResults, err := replayBlock(db, chain, config, block, newTracer)
+		if err != nil {
+			return nil, fmt.Errorf("block #%d: %v", number, err)
+		}
+		results = append(results, blockResults...)
+	}
+	return results, nil
+}
+
+// replayBlock executes the transactions of the block on the state of its parent.
+func replayBlock(db ethdb.Database, chain core.ChainContext, config *params.ChainConfig, block *types.Block, newTracer Factory) ([]*Result, error) {
+	if len(block.Transactions()) == 0 {
+		return nil, nil
+	}
+	var (
+		header  = block.Header()
+		dbstate = state.NewDbState(db, block.NumberU64()-1)
+		statedb = state.New(dbstate)
+		signer  = types.MakeSigner(config, block.Number())
+		results = make([]*Result, 0, len(block.Transactions()))
+	)
+	for i, tx := range block.Transactions() {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, err
+		}
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		tracer := newTracer()
+		statedb.SetTracer(tracer)
+		vmctx := core.NewEVMContext(msg, header, chain, nil)
+		vmenv := vm.NewEVM(vmctx, statedb, config, vm.Config{Debug: true, Tracer: tracer})
+		_, _, failed, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()))
+		if err != nil {
+			return nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(config.IsEIP158(block.Number()), dbstate)
+
+		result := &Result{Block: block.NumberU64(), Index: i, Hash: tx.Hash(), Failed: failed}
+		if result.Result, err = tracer.GetResult(); err != nil {
+			result.Result, result.Error = nil, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the blocks replayed in parallel produce the results of their
+// transactions in order, and that the deps tracer reports the storage written.
+func TestReplay(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xc0de")
+		config   = params.TestChainConfig
+		signer   = types.NewEIP155Signer(config.ChainID)
+		gspec    = &core.Genesis{
+			Config: config,
+			Alloc: core.GenesisAlloc{
+				sender:   {Balance: big.NewInt(1000000000000000000)},
+				contract: {Balance: new(big.Int), Code: []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x00}}, // sstore(0, 1)
+			},
+		}
+		db    = ethdb.NewMemDatabase()
+		gendb = ethdb.NewMemDatabase()
+	)
+	gspec.MustCommit(db)
+	genesis := gspec.MustCommit(gendb)
+
+	// Every block sends some ether to a new account and calls the contract,
+	// except for every third one, which is empty
+	blocks, _ := core.GenerateChain(config, genesis, ethash.NewFaker(), gendb, 12, func(i int, gen *core.BlockGen) {
+		if i%3 == 2 {
+			return
+		}
+		to := common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		tx1, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), to, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		gen.AddTx(tx1)
+		tx2, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), contract, new(big.Int), 100000, nil, nil), signer, key)
+		gen.AddTx(tx2)
+	})
+	chain, _ := core.NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	newTracer, ok := Lookup("deps")
+	if !ok {
+		t.Fatalf("deps tracer not registered, have %v", Names())
+	}
+	var results []*Result
+	cfg := &Config{From: 0, To: 12, Workers: 3, RangeSize: 2}
+	err := Replay(context.Background(), db, chain, config, cfg, newTracer, func(result *Result) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if len(results) != 16 {
+		t.Fatalf("result count mismatch: have %d, want 16", len(results))
+	}
+	for i, result := range results {
+		block := blocks[result.Block-1]
+		if result.Block != uint64(i/4*3+i%4/2+1) || result.Index != i%2 || result.Hash != block.Transactions()[result.Index].Hash() {
+			t.Errorf("result %d: unexpected transaction #%d/%d %x", i, result.Block, result.Index, result.Hash)
+			continue
+		}
+		if result.Failed || result.Error != "" {
+			t.Errorf("result %d: transaction failed: %s", i, result.Error)
+		}
+		var deps struct {
+			Writes accessList `json:"writes"`
+		}
+		if err := json.Unmarshal(result.Result, &deps); err != nil {
+			t.Fatalf("result %d: invalid result %s: %v", i, result.Result, err)
+		}
+		if written := deps.Writes.Storage[contract]; result.Index == 1 && (len(written) != 1 || written[0] != (common.Hash{})) {
+			t.Errorf("result %d: storage writes mismatch: have %v", i, deps.Writes.Storage)
+		}
+	}
+	// The interrupted replays stop with the error of the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Replay(ctx, db, chain, config, cfg, newTracer, func(*Result) error { return nil }); err != context.Canceled {
+		t.Errorf("interrupted replay error mismatch: have %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// logInterval is the time between the progress reports of the long operations.
+const logInterval = 8 * time.Second
+
+var emptyCodeHash = crypto.Keccak256(nil)
+
+// Export writes the snapshot of the state after the canonical block with the
+// given number. The state is read as of that block from the history, within
+// one view of the database, so the node may keep importing blocks meanwhile.
+func Export(w io.Writer, db ethdb.Database, number uint64) (Stats, error) {
+	var stats Stats
+	err := db.View(func(tx ethdb.Getter) error {
+		var err error
+		stats, err = export(w, tx, number)
+		return err
+	})
+	return stats, err
+}
+
+func export(w io.Writer, db ethdb.Getter, number uint64) (Stats, error) {
+	var stats Stats
+
+	hash := rawdb.ReadCanonicalHash(db, number)
+	if hash == (common.Hash{}) {
+		return stats, fmt.Errorf("block %d is not known", number)
+	}
+	head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if head == nil || *head < number {
+		return stats, fmt.Errorf("state of block %d is not available", number)
+	}
+	// The state after the block is the state as of the next one
+	if err := ethdb.CheckHistoryHorizon(db, number+1); err != nil {
+		return stats, fmt.Errorf("state of block %d: %v", number, err)
+	}
+	h := header{
+		Genesis: rawdb.ReadCanonicalHash(db, 0),
+		Block:   rawdb.ReadBlock(db, hash, number),
+		TD:      rawdb.ReadTd(db, hash, number),
+	}
+	if h.Block == nil || h.TD == nil {
+		return stats, fmt.Errorf("block %d [%x…] is incomplete", number, hash[:4])
+	}
+	for parent := h.Block.Header(); parent.Number.Uint64() > 0 && len(h.Ancestors) < maxAncestors; {
+		if parent = rawdb.ReadHeader(db, parent.ParentHash, parent.Number.Uint64()-1); parent == nil {
+			return stats, fmt.Errorf("ancestor of block %d is missing", number)
+		}
+		h.Ancestors = append(h.Ancestors, parent)
+	}
+	for i, j := 0, len(h.Ancestors)-1; i < j; i, j = i+1, j-1 {
+		h.Ancestors[i], h.Ancestors[j] = h.Ancestors[j], h.Ancestors[i]
+	}
+	enc, err := rlp.EncodeToBytes(&h)
+	if err != nil {
+		return stats, err
+	}
+	cw, err := newChunkWriter(w)
+	if err != nil {
+		return stats, err
+	}
+	if err := cw.writeChunk(chunkHeader, enc); err != nil {
+		return stats, err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		codes  = make(map[common.Hash]struct{})
+		buf    [binary.MaxVarintLen64]byte
+		item   []byte
+	)
+	report := func(msg string) {
+		if time.Since(logged) > logInterval {
+			log.Info(msg, "block", number, "accounts", stats.Accounts, "storage", stats.Storage, "codes", stats.Codes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	// Stream the accounts, collecting the hashes of their codes
+	err = db.WalkAsOf(state.AccountsBucket, state.AccountsHistoryBucket, make([]byte, common.HashLength), 0, number+1, func(k, v []byte) (bool, error) {
+		if len(v) == 0 {
+			return true, nil
+		}
+		account, err := state.DecodeAccount(v)
+		if err != nil {
+			return false, fmt.Errorf("account %x: %v", k, err)
+		}
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			codes[common.BytesToHash(account.CodeHash)] = struct{}{}
+		}
+		item = append(append(append(item[:0], k...), buf[:binary.PutUvarint(buf[:], uint64(len(v)))]...), v...)
+		if err := cw.add(chunkAccounts, item); err != nil {
+			return false, err
+		}
+		stats.Accounts++
+		report("Exporting accounts")
+		return true, nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// Stream the storage of the contracts
+	err = db.WalkAsOf(state.StorageBucket, state.StorageHistoryBucket, make([]byte, common.AddressLength+common.HashLength), 0, number+1, func(k, v []byte) (bool, error) {
+		if len(v) == 0 {
+			return true, nil
+		}
+		item = append(append(append(item[:0], k...), buf[:binary.PutUvarint(buf[:], uint64(len(v)))]...), v...)
+		if err := cw.add(chunkStorage, item); err != nil {
+			return false, err
+		}
+		stats.Storage++
+		report("Exporting storage")
+		return true, nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// Stream the codes, in the order of their hashes to make the file deterministic
+	hashes := make([]common.Hash, 0, len(codes))
+	for hash := range codes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	for _, hash := range hashes {
+		code, err := db.Get(state.CodeBucket, hash[:])
+		if err != nil || len(code) == 0 {
+			return stats, fmt.Errorf("code %x is missing", hash)
+		}
+		item = append(append(item[:0], buf[:binary.PutUvarint(buf[:], uint64(len(code)))]...), code...)
+		if err := cw.add(chunkCodes, item); err != nil {
+			return stats, err
+		}
+		stats.Codes++
+		report("Exporting codes")
+	}
+	if enc, err = rlp.EncodeToBytes(&stats); err != nil {
+		return stats, err
+	}
+	return stats, cw.close(enc)
+}
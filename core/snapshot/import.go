@@ -0,0 +1,361 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var emptyRoot = types.EmptyRootHash
+
+// Import rebuilds the state buckets of the database from the snapshot, checks
+// the state root and the storage roots, and makes the block of the snapshot
+// the head of the chain. The database has to be initialised with the same
+// genesis, and hold no blocks after it. The history before the block is
+// unavailable, the history horizon is moved past it.
+//
+// The state is written in several batches. If the import fails, the database
+// is left with the genesis head and a partial state, and the import can be
+// run again.
+func Import(r io.Reader, db ethdb.Database) (*types.Block, Stats, error) {
+	var stats Stats
+
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	if genesis == (common.Hash{}) {
+		return nil, stats, errors.New("database is not initialised with the genesis")
+	}
+	if head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db)); head == nil || *head != 0 {
+		return nil, stats, errors.New("database already has blocks after the genesis")
+	}
+	cr, err := newChunkReader(r)
+	if err != nil {
+		return nil, stats, err
+	}
+	kind, payload, err := cr.next()
+	if err == io.EOF {
+		err = errTruncated
+	}
+	if err != nil {
+		return nil, stats, err
+	}
+	if kind != chunkHeader {
+		return nil, stats, fmt.Errorf("unexpected snapshot chunk %d, want the header", kind)
+	}
+	var h header
+	if err := rlp.DecodeBytes(payload, &h); err != nil {
+		return nil, stats, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if err := h.check(genesis); err != nil {
+		return nil, stats, err
+	}
+	block := h.Block
+	log.Info("Importing state snapshot", "number", block.NumberU64(), "hash", block.Hash(), "root", block.Root())
+
+	// Replace the genesis state with the one of the snapshot
+	for _, bucket := range [][]byte{state.AccountsBucket, state.StorageBucket, state.CodeBucket, trie.IntermediateHashBucket} {
+		if err := ethdb.DeletePrefix(db, bucket, nil); err != nil {
+			return nil, stats, err
+		}
+	}
+	im := &importer{
+		db:       db,
+		batch:    db.NewBatch(),
+		start:    time.Now(),
+		logged:   time.Now(),
+		number:   block.NumberU64(),
+		roots:    make(map[common.Hash]common.Hash),
+		codes:    make(map[common.Hash]struct{}),
+		prevKind: chunkHeader,
+	}
+	defer im.batch.Rollback()
+	for {
+		kind, payload, err := cr.next()
+		if err == io.EOF {
+			return nil, stats, errTruncated
+		}
+		if err != nil {
+			return nil, stats, err
+		}
+		if kind == chunkHeader || kind < im.prevKind || kind > chunkEnd {
+			return nil, stats, fmt.Errorf("unexpected snapshot chunk %d after chunk %d", kind, im.prevKind)
+		}
+		if kind != im.prevKind {
+			im.prevKind, im.prevKey = kind, nil
+		}
+		if kind == chunkEnd {
+			if err := im.finish(payload); err != nil {
+				return nil, stats, err
+			}
+			break
+		}
+		if err := im.process(kind, payload); err != nil {
+			return nil, stats, err
+		}
+	}
+	if _, _, err := cr.next(); err != io.EOF {
+		return nil, stats, errors.New("unexpected data after the end of the snapshot")
+	}
+	if err := verifyState(db, block.Root(), im.addresses); err != nil {
+		return nil, stats, err
+	}
+	if err := writeChain(db, &h); err != nil {
+		return nil, stats, err
+	}
+	log.Info("Imported state snapshot", "number", block.NumberU64(), "hash", block.Hash(),
+		"accounts", im.stats.Accounts, "storage", im.stats.Storage, "codes", im.stats.Codes, "elapsed", common.PrettyDuration(time.Since(im.start)))
+	return block, im.stats, nil
+}
+
+// check validates the header of the snapshot against the genesis of the database.
+func (h *header) check(genesis common.Hash) error {
+	if h.Genesis != genesis {
+		return fmt.Errorf("snapshot genesis mismatch: have %x, want %x", h.Genesis, genesis)
+	}
+	if h.Block == nil || h.TD == nil || h.Block.NumberU64() == 0 {
+		return errors.New("snapshot header has no block")
+	}
+	// The ancestors go back to the genesis, or maxAncestors blocks back
+	want := h.Block.NumberU64()
+	if want > maxAncestors {
+		want = maxAncestors
+	}
+	if uint64(len(h.Ancestors)) != want {
+		return fmt.Errorf("snapshot has %d ancestor headers, want %d", len(h.Ancestors), want)
+	}
+	child := h.Block.Header()
+	for i := len(h.Ancestors) - 1; i >= 0; i-- {
+		ancestor := h.Ancestors[i]
+		if ancestor.Hash() != child.ParentHash || ancestor.Number.Uint64()+1 != child.Number.Uint64() {
+			return fmt.Errorf("snapshot ancestor %d does not link to block %d", ancestor.Number, child.Number)
+		}
+		child = ancestor
+	}
+	if child.Number.Uint64() == 0 && child.Hash() != genesis {
+		return errors.New("snapshot chain does not link to the genesis")
+	}
+	return nil
+}
+
+// importer writes the items of the snapshot into the database and checks them.
+type importer struct {
+	db    ethdb.Database
+	batch ethdb.Mutation
+	stats Stats
+
+	start, logged time.Time
+	number        uint64
+
+	prevKind byte
+	prevKey  []byte
+
+	roots     map[common.Hash]common.Hash    // Storage roots of the accounts with storage not yet seen, by address hash
+	codes     map[common.Hash]struct{}       // Hashes of the codes of the accounts not yet seen
+	addresses map[common.Address]common.Hash // Contracts with storage and their storage roots
+}
+
+// process imports the items of one chunk.
+func (im *importer) process(kind byte, payload []byte) error {
+	for len(payload) > 0 {
+		var key, value []byte
+		switch kind {
+		case chunkAccounts:
+			key, payload = splitKey(payload, common.HashLength)
+		case chunkStorage:
+			key, payload = splitKey(payload, common.AddressLength+common.HashLength)
+		}
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || size == 0 || size > uint64(len(payload)-n) {
+			return fmt.Errorf("malformed snapshot chunk %d", kind)
+		}
+		value, payload = payload[n:n+int(size)], payload[n+int(size):]
+
+		var err error
+		switch kind {
+		case chunkAccounts:
+			err = im.account(key, value)
+		case chunkStorage:
+			err = im.storage(key, value)
+		case chunkCodes:
+			err = im.code(value)
+		}
+		if err != nil {
+			return err
+		}
+		if im.batch.BatchSize() >= ethdb.IdealBatchSize {
+			if _, err := im.batch.Commit(); err != nil {
+				return err
+			}
+		}
+		if time.Since(im.logged) > logInterval {
+			log.Info("Importing state snapshot", "block", im.number, "accounts", im.stats.Accounts, "storage", im.stats.Storage, "codes", im.stats.Codes, "elapsed", common.PrettyDuration(time.Since(im.start)))
+			im.logged = time.Now()
+		}
+	}
+	return nil
+}
+
+// splitKey cuts the key of the given length off the payload, or returns nil
+// if the payload is too short.
+func splitKey(payload []byte, length int) ([]byte, []byte) {
+	if len(payload) < length {
+		return nil, nil
+	}
+	return payload[:length], payload[length:]
+}
+
+// checkOrder checks that the keys of one kind are strictly ascending.
+func (im *importer) checkOrder(key []byte) error {
+	if key == nil {
+		return errors.New("malformed snapshot key")
+	}
+	if im.prevKey != nil && bytes.Compare(im.prevKey, key) >= 0 {
+		return fmt.Errorf("snapshot key %x out of order", key)
+	}
+	im.prevKey = common.CopyBytes(key)
+	return nil
+}
+
+func (im *importer) account(key, value []byte) error {
+	if err := im.checkOrder(key); err != nil {
+		return err
+	}
+	account, err := state.DecodeAccount(value)
+	if err != nil {
+		return fmt.Errorf("account %x: %v", key, err)
+	}
+	if account.Root != emptyRoot && account.Root != (common.Hash{}) {
+		im.roots[common.BytesToHash(key)] = account.Root
+	}
+	if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+		im.codes[common.BytesToHash(account.CodeHash)] = struct{}{}
+	}
+	im.stats.Accounts++
+	return im.batch.Put(state.AccountsBucket, common.CopyBytes(key), common.CopyBytes(value))
+}
+
+func (im *importer) storage(key, value []byte) error {
+	if err := im.checkOrder(key); err != nil {
+		return err
+	}
+	address := common.BytesToAddress(key[:common.AddressLength])
+	if _, ok := im.addresses[address]; !ok {
+		if im.addresses == nil {
+			im.addresses = make(map[common.Address]common.Hash)
+		}
+		addrHash := crypto.Keccak256Hash(address[:])
+		root, ok := im.roots[addrHash]
+		if !ok {
+			return fmt.Errorf("storage of account %x without storage", address)
+		}
+		delete(im.roots, addrHash)
+		im.addresses[address] = root
+	}
+	im.stats.Storage++
+	return im.batch.Put(state.StorageBucket, common.CopyBytes(key), common.CopyBytes(value))
+}
+
+func (im *importer) code(code []byte) error {
+	hash := crypto.Keccak256Hash(code)
+	if _, ok := im.codes[hash]; !ok {
+		return fmt.Errorf("code %x is not used or duplicate", hash)
+	}
+	delete(im.codes, hash)
+	im.stats.Codes++
+	return im.batch.Put(state.CodeBucket, hash[:], common.CopyBytes(code))
+}
+
+// finish checks the end of the snapshot and commits the last batch.
+func (im *importer) finish(payload []byte) error {
+	var end Stats
+	if err := rlp.DecodeBytes(payload, &end); err != nil {
+		return fmt.Errorf("invalid snapshot end: %v", err)
+	}
+	if end != im.stats {
+		return fmt.Errorf("snapshot item count mismatch: have %+v, want %+v", im.stats, end)
+	}
+	for addrHash := range im.roots {
+		return fmt.Errorf("storage of account %x is missing", addrHash)
+	}
+	for hash := range im.codes {
+		return fmt.Errorf("code %x is missing", hash)
+	}
+	_, err := im.batch.Commit()
+	return err
+}
+
+// verifyState rebuilds the account trie and the storage tries from the
+// imported state and checks their roots.
+func verifyState(db ethdb.Database, root common.Hash, addresses map[common.Address]common.Hash) error {
+	start := time.Now()
+	if err := state.VerifyRoot(db, state.AccountsBucket, nil, root); err != nil {
+		return fmt.Errorf("state root mismatch: %v", err)
+	}
+	for address, root := range addresses {
+		if err := state.VerifyRoot(db, state.StorageBucket, address[:], root); err != nil {
+			return fmt.Errorf("storage root mismatch for %x: %v", address, err)
+		}
+	}
+	log.Info("Verified state snapshot", "root", root, "contracts", len(addresses), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// writeChain makes the block of the snapshot the head of the chain, with its
+// ancestors as the canonical headers before it, and moves the history horizon
+// past the block.
+func writeChain(db ethdb.Database, h *header) error {
+	batch := db.NewBatch()
+	defer batch.Rollback()
+
+	td := new(big.Int).Set(h.TD)
+	block := h.Block
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+
+	parentTd := new(big.Int).Sub(td, block.Difficulty())
+	for i := len(h.Ancestors) - 1; i >= 0 && h.Ancestors[i].Number.Uint64() > 0; i-- {
+		header := h.Ancestors[i]
+		rawdb.WriteHeader(batch, header)
+		rawdb.WriteTd(batch, header.Hash(), header.Number.Uint64(), parentTd)
+		rawdb.WriteCanonicalHash(batch, header.Hash(), header.Number.Uint64())
+		parentTd = new(big.Int).Sub(parentTd, header.Difficulty)
+	}
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	rawdb.WriteHeadFastBlockHash(batch, block.Hash())
+	if _, err := batch.Commit(); err != nil {
+		return err
+	}
+	_, err := ethdb.PruneHistory(db, block.NumberU64()+1, 0)
+	return err
+}
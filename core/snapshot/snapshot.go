@@ -0,0 +1,217 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot exports the whole state at a block into a portable file,
+// and imports it into a fresh database, so that a node can start from that
+// block without replaying the history before it.
+//
+// The snapshot file is
+//
+//   magic | version | chunk*
+//
+// where the magic is "TGSNAP", the version is one byte, currently Version,
+// and every chunk is
+//
+//   kind (1 byte) | payload length (uvarint) | payload | checksum
+//
+// with the checksum being the CRC-32 (Castagnoli) of the kind and the payload,
+// big endian. The chunks of the kinds below follow in this order:
+//
+//   header   one chunk, RLP of the genesis hash, the block, its total difficulty
+//            and up to 256 ancestor headers of the block, oldest first
+//   accounts (key (32 bytes) | value length (uvarint) | value)*, in the order of
+//            the keys, as in the AT bucket
+//   storage  (key (52 bytes) | value length (uvarint) | value)*, in the order of
+//            the keys, as in the ST bucket
+//   codes    (code length (uvarint) | code)*, in the order of the code hashes,
+//            every code once
+//   end      one chunk, RLP of the numbers of the accounts, storage items and
+//            codes in the snapshot
+//
+// The end chunk guards against the truncated files. The chunks of one kind
+// are at most ChunkSize long, unless a single item is longer.
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Version is the version of the snapshot format written by Export.
+const Version = 1
+
+// ChunkSize is the size of the payload at which the chunks are cut.
+const ChunkSize = 1024 * 1024
+
+const (
+	maxChunkSize = 64 * 1024 * 1024 // Maximum payload accepted by the reader
+	maxAncestors = 256              // Number of ancestor headers kept for BLOCKHASH
+)
+
+var magic = []byte("TGSNAP")
+
+// Kinds of the chunks, in the order they appear in the file.
+const (
+	chunkHeader byte = iota
+	chunkAccounts
+	chunkStorage
+	chunkCodes
+	chunkEnd
+)
+
+var (
+	errBadMagic      = errors.New("not a state snapshot")
+	errBadChecksum   = errors.New("snapshot chunk checksum mismatch")
+	errChunkTooLarge = errors.New("snapshot chunk too large")
+	errTruncated     = errors.New("snapshot is truncated")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// header is the payload of the header chunk.
+type header struct {
+	Genesis   common.Hash
+	Block     *types.Block
+	TD        *big.Int
+	Ancestors []*types.Header
+}
+
+// Stats are the numbers of the items in the snapshot, which are also the
+// payload of the end chunk.
+type Stats struct {
+	Accounts uint64
+	Storage  uint64
+	Codes    uint64
+}
+
+// chunkWriter writes the chunks into the snapshot file.
+type chunkWriter struct {
+	w       *bufio.Writer
+	kind    byte
+	payload []byte
+}
+
+func newChunkWriter(w io.Writer) (*chunkWriter, error) {
+	cw := &chunkWriter{w: bufio.NewWriter(w)}
+	if _, err := cw.w.Write(magic); err != nil {
+		return nil, err
+	}
+	if err := cw.w.WriteByte(Version); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// add appends the item to the current chunk of the kind, writing out the
+// chunk first if the item does not fit in it or if the kind changes.
+func (cw *chunkWriter) add(kind byte, item []byte) error {
+	if len(cw.payload) > 0 && (kind != cw.kind || len(cw.payload)+len(item) > ChunkSize) {
+		if err := cw.flush(); err != nil {
+			return err
+		}
+	}
+	cw.kind = kind
+	cw.payload = append(cw.payload, item...)
+	return nil
+}
+
+// flush writes out the current chunk, if there is one.
+func (cw *chunkWriter) flush() error {
+	if len(cw.payload) == 0 {
+		return nil
+	}
+	if err := cw.writeChunk(cw.kind, cw.payload); err != nil {
+		return err
+	}
+	cw.payload = cw.payload[:0]
+	return nil
+}
+
+func (cw *chunkWriter) writeChunk(kind byte, payload []byte) error {
+	var buf [binary.MaxVarintLen64]byte
+	cw.w.WriteByte(kind)
+	cw.w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(payload)))])
+	cw.w.Write(payload)
+
+	crc := crc32.Update(crc32.Checksum([]byte{kind}, crcTable), crcTable, payload)
+	binary.BigEndian.PutUint32(buf[:4], crc)
+	_, err := cw.w.Write(buf[:4])
+	return err
+}
+
+// close writes out the current chunk and the end chunk.
+func (cw *chunkWriter) close(end []byte) error {
+	if err := cw.flush(); err != nil {
+		return err
+	}
+	if err := cw.writeChunk(chunkEnd, end); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+// chunkReader reads the chunks from the snapshot file and checks them.
+type chunkReader struct {
+	r *bufio.Reader
+}
+
+func newChunkReader(r io.Reader) (*chunkReader, error) {
+	cr := &chunkReader{r: bufio.NewReader(r)}
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(cr.r, head); err != nil {
+		return nil, errBadMagic
+	}
+	if string(head[:len(magic)]) != string(magic) {
+		return nil, errBadMagic
+	}
+	if head[len(magic)] != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", head[len(magic)])
+	}
+	return cr, nil
+}
+
+// next reads the next chunk. It returns io.EOF if the file ends before it.
+func (cr *chunkReader) next() (byte, []byte, error) {
+	kind, err := cr.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return 0, nil, errTruncated
+	}
+	if size > maxChunkSize {
+		return 0, nil, errChunkTooLarge
+	}
+	payload := make([]byte, size+4)
+	if _, err := io.ReadFull(cr.r, payload); err != nil {
+		return 0, nil, errTruncated
+	}
+	payload, sum := payload[:size], payload[size:]
+	crc := crc32.Update(crc32.Checksum([]byte{kind}, crcTable), crcTable, payload)
+	if binary.BigEndian.Uint32(sum) != crc {
+		return 0, nil, errBadChecksum
+	}
+	return kind, payload, nil
+}
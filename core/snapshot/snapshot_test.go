@@ -0,0 +1,210 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+
+	// counterCode deploys a contract incrementing its storage slot 0 on every call.
+	counterCode = hexutil.MustDecode("0x600a600c600039600a6000f3600054600101600055" + "00")
+)
+
+// newTestChain generates a chain of transfers and contract calls and imports
+// it into a full node.
+func newTestChain(t *testing.T, n int) (*core.Genesis, types.Blocks, ethdb.Database) {
+	var (
+		gspec = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{testAddress: {Balance: big.NewInt(1000000000)}},
+		}
+		gendb    = ethdb.NewMemDatabase()
+		genesis  = gspec.MustCommit(gendb)
+		contract = crypto.CreateAddress(testAddress, 0)
+		signer   = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, n, func(i int, block *core.BlockGen) {
+		var tx *types.Transaction
+		switch {
+		case i == 0:
+			tx = types.NewContractCreation(block.TxNonce(testAddress), new(big.Int), 200000, nil, counterCode)
+		case i%2 == 0:
+			tx = types.NewTransaction(block.TxNonce(testAddress), contract, new(big.Int), 100000, nil, nil)
+		default:
+			tx = types.NewTransaction(block.TxNonce(testAddress), common.Address{byte(i)}, big.NewInt(1000), params.TxGas, nil, nil)
+		}
+		signed, err := types.SignTx(tx, signer, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(signed)
+	})
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return gspec, blocks, db
+}
+
+func exportTestSnapshot(t *testing.T, db ethdb.Database, number uint64) []byte {
+	var buf bytes.Buffer
+	if _, err := Export(&buf, db, number); err != nil {
+		t.Fatalf("failed to export the snapshot: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Tests that a node started from the snapshot of a past block continues to
+// import the chain after that block.
+func TestExportImport(t *testing.T) {
+	gspec, blocks, db := newTestChain(t, 10)
+
+	enc := exportTestSnapshot(t, db, 5)
+	if again := exportTestSnapshot(t, db, 5); !bytes.Equal(enc, again) {
+		t.Errorf("snapshot is not deterministic")
+	}
+	imported := ethdb.NewMemDatabase()
+	gspec.MustCommit(imported)
+	block, stats, err := Import(bytes.NewReader(enc), imported)
+	if err != nil {
+		t.Fatalf("failed to import the snapshot: %v", err)
+	}
+	if block.Hash() != blocks[4].Hash() {
+		t.Errorf("block mismatch: have #%d, want #%d", block.NumberU64(), blocks[4].NumberU64())
+	}
+	if stats.Codes != 1 || stats.Storage != 1 {
+		t.Errorf("stats mismatch: have %+v, want 1 code and 1 storage item", stats)
+	}
+	chain, err := core.NewBlockChain(imported, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to start from the snapshot: %v", err)
+	}
+	defer chain.Stop()
+	if head := chain.CurrentBlock(); head.Hash() != blocks[4].Hash() {
+		t.Fatalf("head mismatch: have #%d, want #%d", head.NumberU64(), blocks[4].NumberU64())
+	}
+	if _, err := chain.InsertChain(blocks[5:]); err != nil {
+		t.Fatalf("failed to continue from the snapshot: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Errorf("head mismatch: have #%d, want #%d", head.NumberU64(), blocks[9].NumberU64())
+	}
+	// The history before the snapshot is not available
+	if _, err := Export(new(bytes.Buffer), imported, 3); err == nil {
+		t.Errorf("exported the state before the snapshot")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	gspec, _, db := newTestChain(t, 4)
+	enc := exportTestSnapshot(t, db, 3)
+
+	tests := []struct {
+		name string
+		enc  []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("XX"), enc[2:]...)},
+		{"truncated", enc[:len(enc)-10]},
+		{"no end", enc[:len(enc)-9]},
+		{"corrupt", func() []byte {
+			b := common.CopyBytes(enc)
+			b[len(b)/2] ^= 0x01
+			return b
+		}()},
+		{"trailing data", append(common.CopyBytes(enc), 0)},
+	}
+	for _, tt := range tests {
+		imported := ethdb.NewMemDatabase()
+		gspec.MustCommit(imported)
+		if _, _, err := Import(bytes.NewReader(tt.enc), imported); err == nil {
+			t.Errorf("%s: snapshot imported", tt.name)
+		}
+	}
+	// The state not matching the root is rejected, even with valid checksums
+	imported := ethdb.NewMemDatabase()
+	gspec.MustCommit(imported)
+	if _, _, err := Import(bytes.NewReader(tamperAccounts(t, enc)), imported); err == nil {
+		t.Errorf("tampered snapshot imported")
+	}
+	// The snapshot of a different chain is rejected
+	other := &core.Genesis{Config: gspec.Config, ExtraData: []byte("other")}
+	imported = ethdb.NewMemDatabase()
+	other.MustCommit(imported)
+	if _, _, err := Import(bytes.NewReader(enc), imported); err == nil {
+		t.Errorf("snapshot imported on top of a different genesis")
+	}
+	// The database with blocks is rejected
+	if _, _, err := Import(bytes.NewReader(enc), db); err == nil {
+		t.Errorf("snapshot imported into a database with blocks")
+	}
+}
+
+// tamperAccounts rewrites the snapshot with the last byte of the first account
+// changed, keeping the chunks well formed.
+func tamperAccounts(t *testing.T, enc []byte) []byte {
+	cr, err := newChunkReader(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cw, err := newChunkWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := false
+	for {
+		kind, payload, err := cr.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind == chunkEnd {
+			if err := cw.close(payload); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		}
+		if kind == chunkAccounts && !tampered {
+			size, n := binary.Uvarint(payload[common.HashLength:])
+			payload[common.HashLength+n+int(size)-1] ^= 0x01
+			tampered = true
+		}
+		if err := cw.writeChunk(kind, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
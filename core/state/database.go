@@ -24,24 +24,62 @@ import (
 	"math/big"
 	"runtime"
 	"sort"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
 	"golang.org/x/crypto/sha3"
 )
 
-// Trie cache generation limit after which to evict trie nodes from memory.
+// Trie cache generation limit after which to evict trie nodes from memory,
+// used by RepairDbState.
 var MaxTrieCacheGen = uint32(4 * 1024 * 1024)
 
-var AccountsBucket = []byte("AT")
+// MaxTrieCacheSize is the memory budget of the tries of TrieDbState in bytes,
+// above which the least recently touched trie nodes are evicted from memory.
+var MaxTrieCacheSize = uint64(1024 * 1024 * 1024)
+
+// maxStorageTrieShare limits the memory taken by a single storage trie to the
+// given fraction of MaxTrieCacheSize. Larger storage tries are evicted as a
+// whole when the budget is exceeded, even if they are touched by every block.
+const maxStorageTrieShare = 8
+
+// TrieResolveWorkers is the number of the workers resolving the trie nodes
+// missing from memory in parallel, each over its own read transaction.
+var TrieResolveWorkers = runtime.NumCPU()
+
+var (
+	trieCacheHitMeter   = metrics.NewRegisteredMeter("trie/cache/hit", nil)
+	trieCacheMissMeter  = metrics.NewRegisteredMeter("trie/cache/miss", nil)
+	trieCacheSizeGauge  = metrics.NewRegisteredGauge("trie/cache/size", nil)
+	trieCacheEvictMeter = metrics.NewRegisteredMeter("trie/cache/evict", nil)
+	trieResolveTimer    = metrics.NewRegisteredTimer("trie/resolve", nil)
+)
+
 var AccountsHistoryBucket = []byte("hAT")
-var StorageBucket = []byte("ST")
+var AccountsBucket = ethdb.RegisterBucket(ethdb.BucketInfo{
+	Name:          []byte("AT"),
+	Key:           "hash of the address",
+	Value:         "encoded account",
+	HistoryBucket: AccountsHistoryBucket,
+})
 var StorageHistoryBucket = []byte("hST")
-var CodeBucket = []byte("CODE")
+var StorageBucket = ethdb.RegisterBucket(ethdb.BucketInfo{
+	Name:          []byte("ST"),
+	Key:           "address + hash of the storage key",
+	Value:         "storage value with the leading zeroes stripped",
+	HistoryBucket: StorageHistoryBucket,
+})
+var CodeBucket = ethdb.RegisterBucket(ethdb.BucketInfo{
+	Name:  []byte("CODE"),
+	Key:   "code hash",
+	Value: "contract code",
+})
 
 const (
 	// Number of past tries to keep. This value is chosen such that
@@ -123,37 +161,35 @@ func (nw *NoopWriter) WriteAccountStorage(address common.Address, key, original,
 
 // Implements StateReader by wrapping a trie and a database, where trie acts as a cache for the database
 type TrieDbState struct {
-	t                *trie.Trie
-	db               ethdb.Database
-	blockNr          uint64
-	storageTries     map[common.Hash]*trie.Trie
-	storageUpdates   map[common.Address]map[common.Hash][]byte
-	accountUpdates   map[common.Hash]*Account
-	deleted          map[common.Hash]struct{}
-	codeCache        *lru.Cache
-	codeSizeCache    *lru.Cache
-	historical       bool
-	generationCounts map[uint64]int
-	nodeCount        int
-	oldestGeneration uint64
-	noHistory        bool
-	resolveReads     bool
-	proofMasks       map[string]uint32
-	sMasks           map[string]map[string]uint32
-	proofHashes      map[string][16]common.Hash
-	sHashes          map[string]map[string][16]common.Hash
-	soleHashes       map[string]common.Hash
-	sSoleHashes      map[string]map[string]common.Hash
-	createdProofs    map[string]struct{}
-	sCreatedProofs   map[string]map[string]struct{}
-	proofShorts      map[string][]byte
-	sShorts          map[string]map[string][]byte
-	createdShorts    map[string]struct{}
-	sCreatedShorts   map[string]map[string]struct{}
-	proofValues      map[string][]byte
-	sValues          map[string]map[string][]byte
-	proofCodes       map[common.Hash][]byte
-	createdCodes     map[common.Hash]struct{}
+	t              *trie.Trie
+	db             ethdb.Database
+	blockNr        uint64
+	storageTries   map[common.Hash]*trie.Trie
+	storageUpdates map[common.Address]map[common.Hash][]byte
+	accountUpdates map[common.Hash]*Account
+	deleted        map[common.Hash]struct{}
+	codeCache      *lru.Cache
+	codeSizeCache  *lru.Cache
+	historical     bool
+	noHistory      bool
+	resolveReads   bool
+	proofMasks     map[string]uint32
+	sMasks         map[string]map[string]uint32
+	proofHashes    map[string][16]common.Hash
+	sHashes        map[string]map[string][16]common.Hash
+	soleHashes     map[string]common.Hash
+	sSoleHashes    map[string]map[string]common.Hash
+	createdProofs  map[string]struct{}
+	sCreatedProofs map[string]map[string]struct{}
+	proofShorts    map[string][]byte
+	sShorts        map[string]map[string][]byte
+	createdShorts  map[string]struct{}
+	sCreatedShorts map[string]map[string]struct{}
+	proofValues    map[string][]byte
+	sValues        map[string]map[string][]byte
+	proofCodes     map[common.Hash][]byte
+	createdCodes   map[common.Hash]struct{}
+	stateDiffs     *StateDiffWriter
 }
 
 func NewTrieDbState(root common.Hash, db ethdb.Database, blockNr uint64) (*TrieDbState, error) {
@@ -193,10 +229,7 @@ func NewTrieDbState(root common.Hash, db ethdb.Database, blockNr uint64) (*TrieD
 		codeCache:      cc,
 		codeSizeCache:  csc,
 	}
-	t.MakeListed(tds.joinGeneration, tds.leftGeneration)
 	t.ProofFunctions(tds.addProof, tds.addSoleHash, tds.createProof, tds.addValue, tds.addShort, tds.createShort)
-	tds.generationCounts = make(map[uint64]int, 4096)
-	tds.oldestGeneration = blockNr
 	return &tds, nil
 }
 
@@ -215,6 +248,23 @@ func (tds *TrieDbState) SetResolveReads(rr bool) {
 	}
 }
 
+// SetStateDiffs turns the recording of the changes made by every transaction
+// on or off, see StateDiffWriter.
+func (tds *TrieDbState) SetStateDiffs(sd bool) {
+	switch {
+	case sd && tds.stateDiffs == nil:
+		tds.stateDiffs = NewStateDiffWriter(tds.TrieStateWriter())
+	case !sd:
+		tds.stateDiffs = nil
+	}
+}
+
+// StateDiffWriter returns the writer recording the changes of the transactions,
+// or nil if the recording is off.
+func (tds *TrieDbState) StateDiffWriter() *StateDiffWriter {
+	return tds.stateDiffs
+}
+
 func (tds *TrieDbState) SetNoHistory(nh bool) {
 	tds.noHistory = nh
 }
@@ -580,7 +630,11 @@ func (tds *TrieDbState) trieRoot(forward bool) (common.Hash, error) {
 	for len(oldContinuations) > 0 {
 		var resolver *trie.TrieResolver
 		for _, c := range oldContinuations {
-			if !c.RunWithDb(tds.db, tds.blockNr) {
+			done := c.RunWithDb(tds.db, tds.blockNr)
+			if it == 0 {
+				markTrieCache(done)
+			}
+			if !done {
 				newContinuations = append(newContinuations, c)
 				if resolver == nil {
 					resolver = trie.NewResolver(tds.db, false, false)
@@ -590,9 +644,11 @@ func (tds *TrieDbState) trieRoot(forward bool) (common.Hash, error) {
 			}
 		}
 		if len(newContinuations) > 0 {
-			if err := resolver.ResolveWithDb(tds.db, tds.blockNr); err != nil {
+			start := time.Now()
+			if err := resolver.ResolveParallel(tds.db, tds.blockNr, TrieResolveWorkers); err != nil {
 				return common.Hash{}, err
 			}
+			trieResolveTimer.UpdateSince(start)
 			resolver = nil
 		}
 		oldContinuations, newContinuations = newContinuations, []*trie.TrieContinuation{}
@@ -647,7 +703,11 @@ func (tds *TrieDbState) trieRoot(forward bool) (common.Hash, error) {
 	for len(oldContinuations) > 0 {
 		var resolver *trie.TrieResolver
 		for _, c := range oldContinuations {
-			if !c.RunWithDb(tds.db, tds.blockNr) {
+			done := c.RunWithDb(tds.db, tds.blockNr)
+			if it == 0 {
+				markTrieCache(done)
+			}
+			if !done {
 				newContinuations = append(newContinuations, c)
 				if resolver == nil {
 					resolver = trie.NewResolver(tds.db, false, true)
@@ -657,9 +717,11 @@ func (tds *TrieDbState) trieRoot(forward bool) (common.Hash, error) {
 			}
 		}
 		if len(newContinuations) > 0 {
-			if err := resolver.ResolveWithDb(tds.db, tds.blockNr); err != nil {
+			start := time.Now()
+			if err := resolver.ResolveParallel(tds.db, tds.blockNr, TrieResolveWorkers); err != nil {
 				return common.Hash{}, err
 			}
+			trieResolveTimer.UpdateSince(start)
 			resolver = nil
 		}
 		oldContinuations, newContinuations = newContinuations, []*trie.TrieContinuation{}
@@ -672,15 +734,31 @@ func (tds *TrieDbState) trieRoot(forward bool) (common.Hash, error) {
 	return hash, nil
 }
 
+// markTrieCache counts the trie operation as the cache hit if it was done
+// without resolving any nodes from the database.
+func markTrieCache(done bool) {
+	if done {
+		trieCacheHitMeter.Mark(1)
+	} else {
+		trieCacheMissMeter.Mark(1)
+	}
+}
+
 func (tds *TrieDbState) clearUpdates() {
 	tds.storageUpdates = make(map[common.Address]map[common.Hash][]byte)
 	tds.accountUpdates = make(map[common.Hash]*Account)
 	tds.deleted = make(map[common.Hash]struct{})
 }
 
+// Rebuild resolves the whole account trie from a snapshot of the database.
 func (tds *TrieDbState) Rebuild() {
 	tr := tds.AccountTrie()
-	tr.Rebuild(tds.db, tds.blockNr)
+	if err := tds.db.View(func(tx ethdb.Getter) error {
+		tr.Rebuild(tx, tds.blockNr)
+		return nil
+	}); err != nil {
+		log.Error("Could not take the snapshot of the database to rebuild the trie", "err", err)
+	}
 }
 
 func (tds *TrieDbState) SetBlockNr(blockNr uint64) {
@@ -784,6 +862,48 @@ func (tds *TrieDbState) UnwindTo(blockNr uint64) error {
 	return nil
 }
 
+// UnwindFlatState rewinds the accounts and the storage in the database from the
+// block from back to the block to, and deletes the history of the blocks in
+// between. Unlike UnwindTo, it does not need the trie of the state it starts
+// from, so it can unwind the state whose root is not known, like the state
+// written beyond the head block.
+func UnwindFlatState(db ethdb.Database, from, to uint64) error {
+	accounts := make(map[string][]byte)
+	storage := make(map[string][]byte)
+	if err := db.RewindData(from, to, func(bucket, key, value []byte) error {
+		if bytes.Equal(bucket, AccountsHistoryBucket) {
+			accounts[string(key)] = common.CopyBytes(value)
+		} else if bytes.Equal(bucket, StorageHistoryBucket) {
+			storage[string(key)] = common.CopyBytes(value)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, changes := range []struct {
+		bucket []byte
+		values map[string][]byte
+	}{{AccountsBucket, accounts}, {StorageBucket, storage}} {
+		for key, value := range changes.values {
+			var err error
+			if len(value) == 0 {
+				err = db.Delete(changes.bucket, []byte(key))
+			} else {
+				err = db.Put(changes.bucket, []byte(key), value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i := from; i > to; i-- {
+		if err := db.DeleteTimestamp(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func accountToEncoding(account *Account) ([]byte, error) {
 	var data []byte
 	var err error
@@ -848,15 +968,10 @@ func encodingToAccount(enc []byte) (*Account, error) {
 	return &data, nil
 }
 
-func (tds *TrieDbState) joinGeneration(gen uint64) {
-	tds.nodeCount++
-	tds.generationCounts[gen]++
-
-}
-
-func (tds *TrieDbState) leftGeneration(gen uint64) {
-	tds.nodeCount--
-	tds.generationCounts[gen]--
+// DecodeAccount decodes the account in the form it is stored in the
+// AccountsBucket, where the accounts without code and storage are shortened.
+func DecodeAccount(enc []byte) (*Account, error) {
+	return encodingToAccount(enc)
 }
 
 func (tds *TrieDbState) addProof(prefix, key []byte, pos int, mask uint32, hashes []common.Hash) {
@@ -1188,7 +1303,6 @@ func (tds *TrieDbState) getStorageTrie(address common.Address, addrHash common.H
 		}
 		t.SetHistorical(tds.historical)
 		t.SetResolveReads(tds.resolveReads)
-		t.MakeListed(tds.joinGeneration, tds.leftGeneration)
 		t.ProofFunctions(tds.addProof, tds.addSoleHash, tds.createProof, tds.addValue, tds.addShort, tds.createShort)
 		tds.storageTries[addrHash] = t
 	}
@@ -1215,6 +1329,43 @@ func (tds *TrieDbState) ReadAccountStorage(address common.Address, key *common.H
 	return enc, nil
 }
 
+// GetProof returns the nodes of the account trie on the path to the account,
+// starting from the root. The missing nodes are resolved from the database,
+// as of the block if the state is historical.
+func (tds *TrieDbState) GetProof(address common.Address) ([][]byte, error) {
+	addrHash, err := tds.HashAddress(&address, false /*save*/)
+	if err != nil {
+		return nil, err
+	}
+	var proof proofList
+	if err := tds.t.Prove(tds.db, addrHash[:], 0, &proof, tds.blockNr); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// GetStorageProof returns the nodes of the storage trie of the account on the
+// path to the storage item, starting from the root.
+func (tds *TrieDbState) GetStorageProof(address common.Address, key common.Hash) ([][]byte, error) {
+	addrHash, err := tds.HashAddress(&address, false /*save*/)
+	if err != nil {
+		return nil, err
+	}
+	t, err := tds.getStorageTrie(address, addrHash, true)
+	if err != nil {
+		return nil, err
+	}
+	seckey, err := tds.HashKey(&key, false /*save*/)
+	if err != nil {
+		return nil, err
+	}
+	var proof proofList
+	if err := t.Prove(tds.db, seckey[:], 0, &proof, tds.blockNr); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
 func (tds *TrieDbState) ReadAccountCode(codeHash common.Hash) (code []byte, err error) {
 	if bytes.Equal(codeHash[:], emptyCodeHash) {
 		return nil, nil
@@ -1267,18 +1418,66 @@ func (tds *TrieDbState) ReadAccountCodeSize(codeHash common.Hash) (codeSize int,
 
 var prevMemStats runtime.MemStats
 
+// PruneTries evicts the least recently touched trie nodes from memory until the
+// tries fit into MaxTrieCacheSize. The storage tries that take more than
+// their share of the budget are evicted first, as a whole, the largest first.
 func (tds *TrieDbState) PruneTries(print bool) {
-	if tds.nodeCount > int(MaxTrieCacheGen) {
-		toRemove := 0
-		excess := tds.nodeCount - int(MaxTrieCacheGen)
-		gen := tds.oldestGeneration
-		for excess > 0 {
-			excess -= tds.generationCounts[gen]
-			toRemove += tds.generationCounts[gen]
-			delete(tds.generationCounts, gen)
-			gen++
-		}
-		// Unload all nodes with touch timestamp < gen
+	budget := int(MaxTrieCacheSize)
+	// Memory taken by the nodes by the block they were last touched at
+	sizes := make(map[uint64]int)
+	total := tds.t.CountNodeSizes(sizes)
+	storageSizes := make(map[common.Hash]map[uint64]int, len(tds.storageTries))
+	storageTotals := make(map[common.Hash]int, len(tds.storageTries))
+	for addrHash, storageTrie := range tds.storageTries {
+		m := make(map[uint64]int)
+		storageTotals[addrHash] = storageTrie.CountNodeSizes(m)
+		storageSizes[addrHash] = m
+		total += storageTotals[addrHash]
+	}
+	if total <= budget {
+		trieCacheSizeGauge.Update(int64(total))
+		return
+	}
+	// Evict the oversized storage tries from the largest one, so that the
+	// fewest of them are dropped and the choice does not depend on map order
+	addrHashes := make([]common.Hash, 0, len(storageTotals))
+	for addrHash := range storageTotals {
+		addrHashes = append(addrHashes, addrHash)
+	}
+	sort.Slice(addrHashes, func(i, j int) bool {
+		if si, sj := storageTotals[addrHashes[i]], storageTotals[addrHashes[j]]; si != sj {
+			return si > sj
+		}
+		return bytes.Compare(addrHashes[i][:], addrHashes[j][:]) < 0
+	})
+	evicted := 0
+	for _, addrHash := range addrHashes {
+		if total > budget && storageTotals[addrHash] > budget/maxStorageTrieShare {
+			delete(tds.storageTries, addrHash)
+			total -= storageTotals[addrHash]
+			evicted += storageTotals[addrHash]
+			continue
+		}
+		for gen, size := range storageSizes[addrHash] {
+			sizes[gen] += size
+		}
+	}
+	if total > budget {
+		// Unload all nodes touched before the generation gen
+		gens := make([]uint64, 0, len(sizes))
+		for gen := range sizes {
+			gens = append(gens, gen)
+		}
+		sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+		var gen uint64
+		for _, g := range gens {
+			if total <= budget {
+				break
+			}
+			total -= sizes[g]
+			evicted += sizes[g]
+			gen = g + 1
+		}
 		for addrHash, storageTrie := range tds.storageTries {
 			empty := storageTrie.UnloadOlderThan(gen, false)
 			if empty {
@@ -1286,14 +1485,14 @@ func (tds *TrieDbState) PruneTries(print bool) {
 			}
 		}
 		tds.t.UnloadOlderThan(gen, false)
-		tds.oldestGeneration = gen
-		tds.nodeCount -= toRemove
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-		log.Info("Memory", "nodes", tds.nodeCount, "alloc", int(m.Alloc/1024), "sys", int(m.Sys/1024), "numGC", int(m.NumGC))
-		if print {
-			fmt.Printf("Pruning done. Nodes: %d, alloc: %d, sys: %d, numGC: %d\n", tds.nodeCount, int(m.Alloc/1024), int(m.Sys/1024), int(m.NumGC))
-		}
+	}
+	trieCacheSizeGauge.Update(int64(total))
+	trieCacheEvictMeter.Mark(int64(evicted))
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	log.Info("Memory", "tries", common.StorageSize(total), "evicted", common.StorageSize(evicted), "alloc", int(m.Alloc/1024), "sys", int(m.Sys/1024), "numGC", int(m.NumGC))
+	if print {
+		fmt.Printf("Pruning done. Tries: %d, evicted: %d, alloc: %d, sys: %d, numGC: %d\n", total, evicted, int(m.Alloc/1024), int(m.Sys/1024), int(m.NumGC))
 	}
 }
 
@@ -1364,6 +1563,9 @@ func (dsw *DbStateWriter) UpdateAccountData(address common.Address, original, ac
 	if err = dsw.tds.db.Put(AccountsBucket, addrHash[:], data); err != nil {
 		return err
 	}
+	if err = dsw.tds.t.PutIntermediateHashes(dsw.tds.db, addrHash[:]); err != nil {
+		return err
+	}
 	if dsw.tds.noHistory {
 		return nil
 	}
@@ -1401,6 +1603,12 @@ func (dsw *DbStateWriter) DeleteAccount(address common.Address, original *Accoun
 	if err := dsw.tds.db.Delete(AccountsBucket, addrHash[:]); err != nil {
 		return err
 	}
+	if err := dsw.tds.t.PutIntermediateHashes(dsw.tds.db, addrHash[:]); err != nil {
+		return err
+	}
+	if err := trie.DeleteIntermediateHashes(dsw.tds.db, address[:]); err != nil {
+		return err
+	}
 	if dsw.tds.noHistory {
 		return nil
 	}
@@ -1475,6 +1683,15 @@ func (dsw *DbStateWriter) WriteAccountStorage(address common.Address, key, origi
 	if err != nil {
 		return err
 	}
+	addrHash, err := dsw.tds.HashAddress(&address, false /*save*/)
+	if err != nil {
+		return err
+	}
+	if storageTrie, ok := dsw.tds.storageTries[addrHash]; ok {
+		if err := storageTrie.PutIntermediateHashes(dsw.tds.db, seckey[:]); err != nil {
+			return err
+		}
+	}
 	if dsw.tds.noHistory {
 		return nil
 	}
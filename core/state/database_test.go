@@ -0,0 +1,158 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// newPruneTestState creates the state with the storage tries of the given
+// sizes, and returns it together with the address hashes of the contracts.
+func newPruneTestState(t *testing.T, slots ...int) (*TrieDbState, []common.Hash) {
+	tds, err := NewTrieDbState(common.Hash{}, ethdb.NewMemDatabase(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := New(tds)
+	for i := 0; i < 64; i++ {
+		statedb.AddBalance(common.BigToAddress(big.NewInt(int64(0x1000+i))), big.NewInt(1))
+	}
+	var addrHashes []common.Hash
+	for i, n := range slots {
+		contract := common.BigToAddress(big.NewInt(int64(0x2000 + i)))
+		statedb.SetCode(contract, []byte{0x60})
+		for j := 0; j < n; j++ {
+			statedb.SetState(contract, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(j+1))))
+		}
+		addrHashes = append(addrHashes, crypto.Keccak256Hash(contract[:]))
+	}
+	if _, err := tds.IntermediateRoot(statedb, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := statedb.Commit(false, tds.DbStateWriter()); err != nil {
+		t.Fatal(err)
+	}
+	for _, addrHash := range addrHashes {
+		if _, ok := tds.storageTries[addrHash]; !ok {
+			t.Fatalf("storage trie of %x not in memory", addrHash)
+		}
+	}
+	return tds, addrHashes
+}
+
+// trieSizes returns the memory taken by the account trie and every storage trie.
+func trieSizes(tds *TrieDbState) (int, map[common.Hash]int) {
+	total := tds.t.CountNodeSizes(make(map[uint64]int))
+	storage := make(map[common.Hash]int)
+	for addrHash, storageTrie := range tds.storageTries {
+		storage[addrHash] = storageTrie.CountNodeSizes(make(map[uint64]int))
+		total += storage[addrHash]
+	}
+	return total, storage
+}
+
+// Tests that the tries within the budget are left alone.
+func TestPruneTriesWithinBudget(t *testing.T) {
+	defer func(size uint64) { MaxTrieCacheSize = size }(MaxTrieCacheSize)
+
+	tds, addrHashes := newPruneTestState(t, 16, 16)
+	total, _ := trieSizes(tds)
+	MaxTrieCacheSize = uint64(total)
+	tds.PruneTries(false)
+
+	if have, _ := trieSizes(tds); have != total {
+		t.Errorf("tries pruned within the budget: have %d, want %d", have, total)
+	}
+	for _, addrHash := range addrHashes {
+		if _, ok := tds.storageTries[addrHash]; !ok {
+			t.Errorf("storage trie %x evicted within the budget", addrHash)
+		}
+	}
+}
+
+// Tests that the oversized storage tries are dropped whole, the largest first,
+// and only as many of them as needed to get under the budget.
+func TestPruneTriesOversizedStorage(t *testing.T) {
+	defer func(size uint64) { MaxTrieCacheSize = size }(MaxTrieCacheSize)
+
+	tds, addrHashes := newPruneTestState(t, 4, 256, 128)
+	small, largest, large := addrHashes[0], addrHashes[1], addrHashes[2]
+
+	total, storage := trieSizes(tds)
+	budget := total - storage[largest]
+	if storage[large] <= budget/maxStorageTrieShare || storage[small] > budget/maxStorageTrieShare {
+		t.Fatalf("unexpected storage trie sizes %v for budget %d", storage, budget)
+	}
+	MaxTrieCacheSize = uint64(budget)
+	tds.PruneTries(false)
+
+	if _, ok := tds.storageTries[largest]; ok {
+		t.Errorf("largest storage trie not evicted")
+	}
+	for _, addrHash := range []common.Hash{large, small} {
+		if _, ok := tds.storageTries[addrHash]; !ok {
+			t.Errorf("storage trie %x evicted, the largest one was enough", addrHash)
+		}
+	}
+	if have, _ := trieSizes(tds); have > budget {
+		t.Errorf("tries over the budget after pruning: have %d, budget %d", have, budget)
+	}
+	// The storage of the evicted trie is still readable from the database
+	statedb := New(tds)
+	if v := statedb.GetState(common.BigToAddress(big.NewInt(0x2001)), common.BigToHash(big.NewInt(7))); v != common.BigToHash(big.NewInt(8)) {
+		t.Errorf("storage of the evicted trie mismatch: have %x, want 8", v)
+	}
+}
+
+// Tests that the tries shrink under the budget when no storage trie is
+// oversized, by unloading the nodes touched before the newest block.
+func TestPruneTriesByGeneration(t *testing.T) {
+	defer func(size uint64) { MaxTrieCacheSize = size }(MaxTrieCacheSize)
+
+	tds, _ := newPruneTestState(t, 8, 8, 8, 8)
+
+	// Touch one account in a later block
+	tds.SetBlockNr(1)
+	statedb := New(tds)
+	statedb.AddBalance(common.BigToAddress(big.NewInt(0x1000)), big.NewInt(1))
+	if _, err := tds.IntermediateRoot(statedb, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := statedb.Commit(false, tds.DbStateWriter()); err != nil {
+		t.Fatal(err)
+	}
+	total, _ := trieSizes(tds)
+	MaxTrieCacheSize = uint64(total) * 8
+	tds.PruneTries(false)
+	if have, _ := trieSizes(tds); have != total {
+		t.Fatalf("tries pruned within the budget: have %d, want %d", have, total)
+	}
+	MaxTrieCacheSize = uint64(total) / 2
+	tds.PruneTries(false)
+	if have, _ := trieSizes(tds); have > total/2 {
+		t.Errorf("tries over the budget after pruning: have %d, budget %d", have, total/2)
+	}
+	statedb = New(tds)
+	if balance := statedb.GetBalance(common.BigToAddress(big.NewInt(0x1001))); balance.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("balance of the unloaded account mismatch: have %v, want 1", balance)
+	}
+}
@@ -22,7 +22,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 type DumpAccount struct {
@@ -44,37 +44,43 @@ func (self *TrieDbState) RawDump() Dump {
 		Root:     fmt.Sprintf("%x", self.t.Hash()),
 		Accounts: make(map[string]DumpAccount),
 	}
-	var prefix [32]byte
-	err := self.db.WalkAsOf(AccountsBucket, AccountsHistoryBucket, prefix[:], 0, self.blockNr, func(k, v []byte) (bool, error) {
-		addr := self.GetKey(k)
-		var data Account
-		var err error
-		if err = rlp.DecodeBytes(v, &data); err != nil {
-			return false, err
-		}
-		var code []byte
-		if !bytes.Equal(data.CodeHash[:], emptyCodeHash) {
-			if code, err = self.db.Get(CodeBucket, data.CodeHash[:]); err != nil {
+	// Walk the accounts and their storage in one snapshot, so that the dump
+	// is consistent even if a block gets committed meanwhile
+	err := self.db.View(func(tx ethdb.Getter) error {
+		var prefix [32]byte
+		return tx.WalkAsOf(AccountsBucket, AccountsHistoryBucket, prefix[:], 0, self.blockNr+1, func(k, v []byte) (bool, error) {
+			addr := self.GetKey(k)
+			data, err := encodingToAccount(v)
+			if err != nil {
 				return false, err
 			}
-		}
-		account := DumpAccount{
-			Balance:  data.Balance.String(),
-			Nonce:    data.Nonce,
-			Root:     common.Bytes2Hex(data.Root[:]),
-			CodeHash: common.Bytes2Hex(data.CodeHash),
-			Code:     common.Bytes2Hex(code),
-			Storage:  make(map[string]string),
-		}
-		err = self.db.WalkAsOf(StorageBucket, StorageHistoryBucket, addr, uint(len(addr)*8), self.blockNr, func(ks, vs []byte) (bool, error) {
-			account.Storage[common.Bytes2Hex(self.GetKey(ks))] = common.Bytes2Hex(vs)
+			if data == nil {
+				return true, nil
+			}
+			var code []byte
+			if !bytes.Equal(data.CodeHash[:], emptyCodeHash) {
+				if code, err = tx.Get(CodeBucket, data.CodeHash[:]); err != nil {
+					return false, err
+				}
+			}
+			account := DumpAccount{
+				Balance:  data.Balance.String(),
+				Nonce:    data.Nonce,
+				Root:     common.Bytes2Hex(data.Root[:]),
+				CodeHash: common.Bytes2Hex(data.CodeHash),
+				Code:     common.Bytes2Hex(code),
+				Storage:  make(map[string]string),
+			}
+			err = tx.WalkAsOf(StorageBucket, StorageHistoryBucket, addr, uint(len(addr)*8), self.blockNr+1, func(ks, vs []byte) (bool, error) {
+				account.Storage[common.Bytes2Hex(self.GetKey(ks))] = common.Bytes2Hex(vs)
+				return true, nil
+			})
+			if err != nil {
+				return false, err
+			}
+			dump.Accounts[common.Bytes2Hex(addr)] = account
 			return true, nil
 		})
-		if err != nil {
-			return false, err
-		}
-		dump.Accounts[common.Bytes2Hex(addr)] = account
-		return true, nil
 	})
 	if err != nil {
 		panic(err)
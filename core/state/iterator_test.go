@@ -17,17 +17,16 @@
 package state
 
 import (
-	"bytes"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // Tests that the node iterator indeed walks over the entire database contents.
 func TestNodeIteratorCoverage(t *testing.T) {
 	// Create some arbitrary test state to iterate
-	db, root, _ := makeTestState()
+	db, root, accounts := makeTestState()
 
 	tds, err := NewTrieDbState(root, db, 1)
 	if err != nil {
@@ -35,15 +34,26 @@ func TestNodeIteratorCoverage(t *testing.T) {
 	}
 	// Gather all the node hashes found by the iterator
 	hashes := make(map[common.Hash]struct{})
-	for it := NewNodeIterator(tds); it.Next(); {
+	it := NewNodeIterator(tds)
+	for it.Next() {
 		if it.Hash != (common.Hash{}) {
 			hashes[it.Hash] = struct{}{}
 		}
 	}
-
-	for keys, i := db.(ethdb.Mutation).Keys(), 0; i < len(keys); i += 2 {
-		if bytes.Equal(keys[i], []byte("secure-key-")) {
+	if it.Error != nil {
+		t.Fatalf("iteration failed: %v", it.Error)
+	}
+	if _, ok := hashes[root]; !ok {
+		t.Errorf("state root %x not found by the iterator", root)
+	}
+	// The trie nodes are only kept in memory, so cross check the contract
+	// codes, which the iterator reports by their hashes
+	for _, acc := range accounts {
+		if acc.code == nil {
 			continue
 		}
+		if _, ok := hashes[crypto.Keccak256Hash(acc.code)]; !ok {
+			t.Errorf("code of account %x not found by the iterator", acc.address)
+		}
 	}
 }
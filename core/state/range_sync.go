@@ -0,0 +1,723 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrRangeUnavailable is returned when the response to a range request has
+// nothing in it, meaning that the peer does not have the requested state.
+var ErrRangeUnavailable = errors.New("requested state is not available")
+
+// ErrRangeNotCached is returned by ReadAccountRange and ReadStorageRanges when
+// the proofs of the range can not be built from the intermediate hashes of the
+// current state, and the full resolution of the tries is not allowed.
+var ErrRangeNotCached = errors.New("proofs of the requested state are not cached")
+
+// rangeProofWalkLimit is the number of the keys the resolution of the proofs
+// of the current state may walk over below the intermediate hashes.
+var rangeProofWalkLimit = 16384
+
+// StorageRange is a contiguous range of the storage items of one account, as
+// they are stored in the StorageBucket.
+type StorageRange struct {
+	Address common.Address
+	Keys    []common.Hash
+	Values  [][]byte
+}
+
+// ReadAccountRange reads the accounts in the state after the given block, with
+// the root, from the AccountsBucket. The range starts at the origin and ends
+// once the accounts take maxBytes, or with the first account after limit. The
+// accounts are returned in the form they are stored in the bucket, together
+// with the proof of the range.
+//
+// The proofs of the current state are built from the intermediate hashes. The
+// proofs of the other states, and of the current one whose intermediate hashes
+// are missing, resolve the whole trie, which is only done if full is set.
+func ReadAccountRange(db ethdb.Database, root common.Hash, blockNr uint64, origin, limit common.Hash, maxBytes int, full bool) ([]common.Hash, [][]byte, [][]byte, error) {
+	current := trie.HasIntermediateHashes(db, nil, root)
+	if !current && !full {
+		return nil, nil, nil, ErrRangeNotCached
+	}
+	if !current {
+		if err := ethdb.CheckHistoryHorizon(db, blockNr+1); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	var (
+		hashes []common.Hash
+		values [][]byte
+		size   int
+	)
+	walker := func(k, v []byte) (bool, error) {
+		if len(v) == 0 {
+			return true, nil
+		}
+		hash := common.BytesToHash(k)
+		hashes = append(hashes, hash)
+		values = append(values, common.CopyBytes(v))
+		size += len(k) + len(v)
+		return size < maxBytes && bytes.Compare(k, limit[:]) < 0, nil
+	}
+	var err error
+	if current {
+		err = db.Walk(AccountsBucket, origin[:], 0, walker)
+	} else {
+		err = db.WalkAsOf(AccountsBucket, AccountsHistoryBucket, origin[:], 0, blockNr+1, walker)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	t := newRangeProofTrie(root, AccountsBucket, nil, current, full)
+	var proof proofList
+	if err := proveRange(t, db, origin, hashes, &proof, blockNr); err != nil {
+		return nil, nil, nil, err
+	}
+	return hashes, values, proof, nil
+}
+
+// ReadStorageRanges reads the storage of the accounts, given by their hashes, in
+// the state after the given block, with the root. The storage of the first
+// account starts at the origin, the others are read from the beginning, and only
+// the first account is served for the non-zero origin. The reading stops once
+// the storage items take maxBytes, or at an account whose address is not known.
+// The proof covers the last range, and is only returned if that range is not the
+// whole storage. The state other than the current one is only read if full is
+// set, and the proofs are built as by ReadAccountRange.
+func ReadStorageRanges(db ethdb.Database, root common.Hash, blockNr uint64, accounts []common.Hash, origin common.Hash, maxBytes int, full bool) ([]StorageRange, [][]byte, error) {
+	current := trie.HasIntermediateHashes(db, nil, root)
+	if !current && !full {
+		return nil, nil, ErrRangeNotCached
+	}
+	if !current {
+		if err := ethdb.CheckHistoryHorizon(db, blockNr+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	if origin != (common.Hash{}) && len(accounts) > 1 {
+		accounts = accounts[:1]
+	}
+	var (
+		ranges []StorageRange
+		size   int
+	)
+	for i, addrHash := range accounts {
+		if size >= maxBytes {
+			break
+		}
+		preimage, _ := db.Get(trie.SecureKeyPrefix, addrHash[:])
+		if len(preimage) != common.AddressLength {
+			break
+		}
+		var (
+			enc []byte
+			err error
+		)
+		if current {
+			enc, err = db.Get(AccountsBucket, addrHash[:])
+		} else {
+			enc, err = db.GetAsOf(AccountsBucket, AccountsHistoryBucket, addrHash[:], blockNr+1)
+		}
+		if err != nil && err != ethdb.ErrKeyNotFound {
+			return nil, nil, err
+		}
+		account, err := encodingToAccount(enc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if account == nil {
+			break
+		}
+		var start common.Hash
+		if i == 0 {
+			start = origin
+		}
+		r := StorageRange{Address: common.BytesToAddress(preimage)}
+		complete := true
+		walker := func(k, v []byte) (bool, error) {
+			if len(v) == 0 {
+				return true, nil
+			}
+			if size >= maxBytes {
+				complete = false
+				return false, nil
+			}
+			r.Keys = append(r.Keys, common.BytesToHash(k[common.AddressLength:]))
+			r.Values = append(r.Values, common.CopyBytes(v))
+			size += len(k) + len(v)
+			return true, nil
+		}
+		startkey := append(r.Address.Bytes(), start[:]...)
+		if current {
+			err = db.Walk(StorageBucket, startkey, 8*common.AddressLength, walker)
+		} else {
+			err = db.WalkAsOf(StorageBucket, StorageHistoryBucket, startkey, 8*common.AddressLength, blockNr+1, walker)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		ranges = append(ranges, r)
+		if complete && start == (common.Hash{}) {
+			continue
+		}
+		t := newRangeProofTrie(account.Root, StorageBucket, r.Address[:], current, full)
+		var proof proofList
+		if err := proveRange(t, db, start, r.Keys, &proof, blockNr); err != nil {
+			return nil, nil, err
+		}
+		return ranges, proof, nil
+	}
+	return ranges, nil, nil
+}
+
+// newRangeProofTrie creates the trie to build the proofs of a range from. The
+// resolution of the current trie is limited to a few keys below the
+// intermediate hashes, unless the full resolution is allowed.
+func newRangeProofTrie(root common.Hash, bucket, prefix []byte, current, full bool) *trie.Trie {
+	t := trie.New(root, bucket, prefix, !bytes.Equal(bucket, AccountsBucket))
+	if !current {
+		t.SetHistorical(true)
+	} else if !full {
+		t.SetWalkLimit(rangeProofWalkLimit)
+	}
+	return t
+}
+
+// proveRange puts the proofs of the origin and of the last key of the range
+// into the proof list.
+func proveRange(t *trie.Trie, db ethdb.Database, origin common.Hash, keys []common.Hash, proof *proofList, blockNr uint64) error {
+	err := t.Prove(db, origin[:], 0, proof, blockNr)
+	if err == nil && len(keys) > 0 {
+		err = t.Prove(db, keys[len(keys)-1][:], 0, proof, blockNr)
+	}
+	if err == trie.ErrWalkLimit {
+		return ErrRangeNotCached
+	}
+	return err
+}
+
+// VerifyRoot checks that the trie built from the keys of the bucket starting
+// with the prefix hashes to the root.
+func VerifyRoot(db ethdb.Database, bucket, prefix []byte, root common.Hash) error {
+	empty := true
+	if err := db.Walk(bucket, prefix, uint(8*len(prefix)), func(_, _ []byte) (bool, error) {
+		empty = false
+		return false, nil
+	}); err != nil {
+		return err
+	}
+	if empty {
+		if root != emptyRoot {
+			return fmt.Errorf("trie with root %x is missing", root)
+		}
+		return nil
+	}
+	accounts := bytes.Equal(bucket, AccountsBucket)
+	t := trie.New(root, bucket, prefix, !accounts)
+	r := trie.NewResolver(nil, false, accounts)
+	r.AddContinuation(t.NewContinuation(nil, 0, root[:]))
+	return r.ResolveWithDb(db, 0)
+}
+
+// RangeRequestKind tells what is requested by a RangeRequest.
+type RangeRequestKind int
+
+const (
+	AccountRangeRequest RangeRequestKind = iota // Range of the accounts
+	StorageRangeRequest                         // Storage of the accounts
+	CodeRequest                                 // Contract codes
+)
+
+// RangeRequest is a request for a part of the state scheduled by RangeSync.
+type RangeRequest struct {
+	Kind     RangeRequestKind
+	Origin   common.Hash   // First account or storage key to retrieve
+	Limit    common.Hash   // Last account to retrieve
+	Accounts []common.Hash // Hashes of the accounts to retrieve the storage of
+	Codes    []common.Hash // Hashes of the codes to retrieve
+
+	task *accountTask
+}
+
+// RangeSyncStats counts the state items written by RangeSync.
+type RangeSyncStats struct {
+	Accounts uint64
+	Storage  uint64
+	Codes    uint64
+}
+
+type accountTask struct {
+	next    common.Hash // First account left to download
+	last    common.Hash // Last account of the task
+	pending bool
+}
+
+type storageTask struct {
+	root    common.Hash // Storage root of the account
+	next    common.Hash // First storage key left to download
+	pending bool
+}
+
+// RangeSync schedules the download of the state with the given root by the
+// ranges of the flat buckets. The accounts are requested by the ranges of their
+// hashes, the storage by the accounts, and the codes by their hashes. The
+// delivered ranges are checked against the roots with their proofs and written
+// straight into the buckets. Once nothing is left to download, Heal rebuilds the
+// tries from the buckets and schedules what does not match the roots again.
+type RangeSync struct {
+	db     ethdb.Database
+	batch  ethdb.Mutation
+	root   common.Hash
+	number uint64
+
+	accountTasks []*accountTask               // Ranges of the accounts left to download
+	storageTasks map[common.Hash]*storageTask // Storage left to download, by account hash
+	storageQueue []common.Hash                // Accounts of the storage tasks in the order of scheduling
+	codeTasks    map[common.Hash]bool         // Codes left to download, true while requested
+
+	stats RangeSyncStats
+}
+
+// NewRangeSync creates the scheduler of the download of the state after the
+// given block, with the root. The state previously kept in the database is deleted.
+func NewRangeSync(db ethdb.Database, root common.Hash, number uint64) (*RangeSync, error) {
+	for _, bucket := range [][]byte{AccountsBucket, StorageBucket, trie.IntermediateHashBucket} {
+		if err := ethdb.DeletePrefix(db, bucket, nil); err != nil {
+			return nil, err
+		}
+	}
+	s := &RangeSync{
+		db:           db,
+		batch:        db.NewBatch(),
+		root:         root,
+		number:       number,
+		storageTasks: make(map[common.Hash]*storageTask),
+		codeTasks:    make(map[common.Hash]bool),
+	}
+	if root != emptyRoot {
+		// The account hashes are split into 16 ranges by the first nibble
+		for i := 0; i < 16; i++ {
+			task := &accountTask{}
+			task.next[0] = byte(i << 4)
+			for j := range task.last {
+				task.last[j] = 0xff
+			}
+			task.last[0] = byte(i<<4) | 0x0f
+			s.accountTasks = append(s.accountTasks, task)
+		}
+	}
+	return s, nil
+}
+
+// Root returns the state root being downloaded.
+func (s *RangeSync) Root() common.Hash {
+	return s.root
+}
+
+// Number returns the number of the block whose state is being downloaded.
+func (s *RangeSync) Number() uint64 {
+	return s.number
+}
+
+// Pending returns the number of the tasks left, including the requested ones.
+func (s *RangeSync) Pending() int {
+	return len(s.accountTasks) + len(s.storageTasks) + len(s.codeTasks)
+}
+
+// Stats returns the counts of the state items written so far.
+func (s *RangeSync) Stats() RangeSyncStats {
+	return s.stats
+}
+
+// Next schedules the next request, for at most the given number of accounts
+// or codes. The codes go first, then the storage, and the account ranges last,
+// which keeps the number of the tasks down. It returns nil if there is nothing
+// left to request.
+func (s *RangeSync) Next(items int) *RangeRequest {
+	if items <= 0 {
+		items = 1
+	}
+	var codes []common.Hash
+	for hash, pending := range s.codeTasks {
+		if len(codes) == items {
+			break
+		}
+		if !pending {
+			s.codeTasks[hash] = true
+			codes = append(codes, hash)
+		}
+	}
+	if len(codes) > 0 {
+		return &RangeRequest{Kind: CodeRequest, Codes: codes}
+	}
+	if req := s.nextStorage(items); req != nil {
+		return req
+	}
+	for _, task := range s.accountTasks {
+		if !task.pending {
+			task.pending = true
+			return &RangeRequest{Kind: AccountRangeRequest, Origin: task.next, Limit: task.last, task: task}
+		}
+	}
+	return nil
+}
+
+// nextStorage schedules the storage of up to the given number of accounts,
+// or the rest of the storage of one account already partly downloaded.
+func (s *RangeSync) nextStorage(items int) *RangeRequest {
+	var (
+		queue    = s.storageQueue[:0]
+		accounts []common.Hash
+		origin   common.Hash
+	)
+	for _, addrHash := range s.storageQueue {
+		task, ok := s.storageTasks[addrHash]
+		if !ok {
+			continue
+		}
+		queue = append(queue, addrHash)
+		if task.pending || len(accounts) == items || origin != (common.Hash{}) {
+			continue
+		}
+		if task.next != (common.Hash{}) {
+			if len(accounts) > 0 {
+				continue
+			}
+			origin = task.next
+		}
+		task.pending = true
+		accounts = append(accounts, addrHash)
+	}
+	s.storageQueue = queue
+	if len(accounts) == 0 {
+		return nil
+	}
+	return &RangeRequest{Kind: StorageRangeRequest, Origin: origin, Accounts: accounts}
+}
+
+// Revert returns the undelivered tasks of the request to the schedule.
+func (s *RangeSync) Revert(req *RangeRequest) {
+	switch req.Kind {
+	case AccountRangeRequest:
+		req.task.pending = false
+	case StorageRangeRequest:
+		for _, addrHash := range req.Accounts {
+			if task, ok := s.storageTasks[addrHash]; ok {
+				task.pending = false
+			}
+		}
+	case CodeRequest:
+		for _, hash := range req.Codes {
+			if _, ok := s.codeTasks[hash]; ok {
+				s.codeTasks[hash] = false
+			}
+		}
+	}
+}
+
+// ProcessAccounts checks the delivered range of the accounts against the state
+// root and writes it into the AccountsBucket. The accounts with storage and code
+// are scheduled for the download of those.
+func (s *RangeSync) ProcessAccounts(req *RangeRequest, hashes []common.Hash, values [][]byte, proof [][]byte) error {
+	if len(hashes) == 0 && len(proof) == 0 {
+		s.Revert(req)
+		return ErrRangeUnavailable
+	}
+	if len(hashes) != len(values) {
+		s.Revert(req)
+		return fmt.Errorf("%d accounts with %d values", len(hashes), len(values))
+	}
+	keys := make([][]byte, len(hashes))
+	leaves := make([][]byte, len(hashes))
+	accounts := make([]*Account, len(hashes))
+	for i := range hashes {
+		account, err := encodingToAccount(values[i])
+		if err == nil && account == nil {
+			err = errors.New("empty value")
+		}
+		if err != nil {
+			s.Revert(req)
+			return fmt.Errorf("invalid account %x: %v", hashes[i], err)
+		}
+		if leaves[i], err = rlp.EncodeToBytes(account); err != nil {
+			s.Revert(req)
+			return err
+		}
+		keys[i], accounts[i] = hashes[i][:], account
+	}
+	task := req.task
+	more, err := trie.VerifyRangeProof(s.root, req.Origin[:], keys, leaves, proof, false)
+	if err != nil {
+		s.Revert(req)
+		return err
+	}
+	for i, account := range accounts {
+		addrHash := hashes[i]
+		if bytes.Compare(addrHash[:], task.last[:]) > 0 {
+			more = false
+			break
+		}
+		enc, err := accountToEncoding(account)
+		if err != nil {
+			return err
+		}
+		if err := s.batch.Put(AccountsBucket, common.CopyBytes(addrHash[:]), enc); err != nil {
+			return err
+		}
+		if account.Root != emptyRoot && account.Root != (common.Hash{}) {
+			s.addStorageTask(addrHash, account.Root)
+		}
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			if err := s.addCodeTask(common.BytesToHash(account.CodeHash)); err != nil {
+				return err
+			}
+		}
+		s.stats.Accounts++
+		if addrHash == task.last {
+			more = false
+		}
+	}
+	if !more {
+		s.removeAccountTask(task)
+		return nil
+	}
+	task.next = incHash(hashes[len(hashes)-1])
+	task.pending = false
+	return nil
+}
+
+// ProcessStorage checks the delivered storage ranges against the storage roots
+// of the accounts and writes them into the StorageBucket.
+func (s *RangeSync) ProcessStorage(req *RangeRequest, ranges []StorageRange, proof [][]byte) error {
+	if len(ranges) == 0 {
+		s.Revert(req)
+		return ErrRangeUnavailable
+	}
+	if len(ranges) > len(req.Accounts) {
+		s.Revert(req)
+		return fmt.Errorf("%d storage ranges for %d accounts", len(ranges), len(req.Accounts))
+	}
+	more := make([]bool, len(ranges))
+	for i, r := range ranges {
+		addrHash := req.Accounts[i]
+		if crypto.Keccak256Hash(r.Address[:]) != addrHash {
+			s.Revert(req)
+			return fmt.Errorf("storage of %x delivered for account %x", r.Address, addrHash)
+		}
+		task, ok := s.storageTasks[addrHash]
+		if !ok {
+			continue
+		}
+		var (
+			origin common.Hash
+			p      [][]byte
+		)
+		if i == 0 {
+			origin = req.Origin
+		}
+		if i == len(ranges)-1 {
+			p = proof
+		}
+		if len(r.Keys) != len(r.Values) {
+			s.Revert(req)
+			return fmt.Errorf("%d storage keys with %d values", len(r.Keys), len(r.Values))
+		}
+		keys := make([][]byte, len(r.Keys))
+		for j := range r.Keys {
+			keys[j] = r.Keys[j][:]
+		}
+		var err error
+		if more[i], err = trie.VerifyRangeProof(task.root, origin[:], keys, r.Values, p, true); err != nil {
+			s.Revert(req)
+			return fmt.Errorf("storage of %x: %v", r.Address, err)
+		}
+	}
+	for i, r := range ranges {
+		addrHash := req.Accounts[i]
+		task, ok := s.storageTasks[addrHash]
+		if !ok {
+			continue
+		}
+		for j, key := range r.Keys {
+			if err := s.batch.Put(StorageBucket, append(r.Address.Bytes(), key[:]...), common.CopyBytes(r.Values[j])); err != nil {
+				return err
+			}
+			s.stats.Storage++
+		}
+		if err := s.batch.Put(trie.SecureKeyPrefix, common.CopyBytes(addrHash[:]), r.Address.Bytes()); err != nil {
+			return err
+		}
+		if more[i] {
+			task.next = incHash(r.Keys[len(r.Keys)-1])
+			task.pending = false
+		} else {
+			delete(s.storageTasks, addrHash)
+		}
+	}
+	// The accounts left out of the response are requested again
+	s.Revert(req)
+	return nil
+}
+
+// ProcessCodes writes the delivered contract codes into the CodeBucket.
+func (s *RangeSync) ProcessCodes(req *RangeRequest, codes [][]byte) error {
+	requested := make(map[common.Hash]struct{}, len(req.Codes))
+	for _, hash := range req.Codes {
+		requested[hash] = struct{}{}
+	}
+	delivered := 0
+	for _, code := range codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := requested[hash]; !ok {
+			continue
+		}
+		if _, ok := s.codeTasks[hash]; !ok {
+			continue
+		}
+		if err := s.batch.Put(CodeBucket, common.CopyBytes(hash[:]), common.CopyBytes(code)); err != nil {
+			return err
+		}
+		delete(s.codeTasks, hash)
+		s.stats.Codes++
+		delivered++
+	}
+	s.Revert(req)
+	if delivered == 0 {
+		return ErrRangeUnavailable
+	}
+	return nil
+}
+
+// Commit writes the downloaded state into the database, unless the batch is
+// smaller than ethdb.IdealBatchSize and force is not set.
+func (s *RangeSync) Commit(force bool) error {
+	if !force && s.batch.BatchSize() < ethdb.IdealBatchSize {
+		return nil
+	}
+	_, err := s.batch.Commit()
+	return err
+}
+
+// Heal checks the downloaded state once nothing is left to download. The
+// accounts were downloaded at different times from different peers, so the
+// account trie is verified as a whole, and the storage tries and the codes
+// that do not match the accounts are scheduled for the download again. It
+// returns true when the state is complete, which also moves the history
+// horizon past the block.
+func (s *RangeSync) Heal() (bool, error) {
+	if err := s.Commit(true); err != nil {
+		return false, err
+	}
+	if err := VerifyRoot(s.db, AccountsBucket, nil, s.root); err != nil {
+		return false, fmt.Errorf("state root mismatch: %v", err)
+	}
+	var (
+		broken []common.Address
+		roots  = make(map[common.Hash]common.Hash)
+	)
+	if err := s.db.Walk(AccountsBucket, nil, 0, func(k, v []byte) (bool, error) {
+		account, err := encodingToAccount(v)
+		if err != nil || account == nil {
+			return false, fmt.Errorf("invalid account %x: %v", k, err)
+		}
+		addrHash := common.BytesToHash(k)
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			if err := s.addCodeTask(common.BytesToHash(account.CodeHash)); err != nil {
+				return false, err
+			}
+		}
+		if account.Root == emptyRoot || account.Root == (common.Hash{}) {
+			return true, nil
+		}
+		preimage, _ := s.db.Get(trie.SecureKeyPrefix, k)
+		if len(preimage) == common.AddressLength {
+			address := common.BytesToAddress(preimage)
+			if VerifyRoot(s.db, StorageBucket, address[:], account.Root) == nil {
+				return true, nil
+			}
+			broken = append(broken, address)
+		}
+		roots[addrHash] = account.Root
+		return true, nil
+	}); err != nil {
+		return false, err
+	}
+	for _, address := range broken {
+		if err := ethdb.DeletePrefix(s.db, StorageBucket, address[:]); err != nil {
+			return false, err
+		}
+	}
+	for addrHash, root := range roots {
+		s.addStorageTask(addrHash, root)
+	}
+	if s.Pending() > 0 {
+		log.Info("Healing downloaded state", "storage", len(s.storageTasks), "codes", len(s.codeTasks))
+		return false, nil
+	}
+	if _, err := ethdb.PruneHistory(s.db, s.number+1, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *RangeSync) addStorageTask(addrHash, root common.Hash) {
+	if task, ok := s.storageTasks[addrHash]; ok {
+		// The account was downloaded again with a different storage
+		*task = storageTask{root: root, pending: task.pending}
+		return
+	}
+	s.storageTasks[addrHash] = &storageTask{root: root}
+	s.storageQueue = append(s.storageQueue, addrHash)
+}
+
+func (s *RangeSync) addCodeTask(hash common.Hash) error {
+	if _, ok := s.codeTasks[hash]; ok {
+		return nil
+	}
+	if ok, err := s.batch.Has(CodeBucket, hash[:]); err != nil || ok {
+		return err
+	}
+	s.codeTasks[hash] = false
+	return nil
+}
+
+func (s *RangeSync) removeAccountTask(task *accountTask) {
+	for i, t := range s.accountTasks {
+		if t == task {
+			s.accountTasks = append(s.accountTasks[:i], s.accountTasks[i+1:]...)
+			return
+		}
+	}
+}
+
+// incHash returns the hash following the given one.
+func incHash(h common.Hash) common.Hash {
+	next := new(big.Int).Add(h.Big(), common.Big1)
+	return common.BigToHash(next)
+}
@@ -0,0 +1,300 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// newRangeServer builds the state of three blocks, with enough accounts and
+// storage for the ranges to be split, and returns the state roots.
+func newRangeServer(t *testing.T) (ethdb.Database, map[uint64]common.Hash) {
+	db := ethdb.NewMemDatabase()
+	tds, _ := NewTrieDbState(common.Hash{}, db, 0)
+	roots := make(map[uint64]common.Hash)
+	for blockNr := uint64(1); blockNr <= 3; blockNr++ {
+		tds.SetBlockNr(blockNr)
+		state := New(tds)
+		for i := 0; i < 300; i++ {
+			state.AddBalance(common.BytesToAddress([]byte{byte(i), byte(i >> 8), 10}), big.NewInt(int64(blockNr)))
+		}
+		for i := byte(0); i < 20; i++ {
+			contract := common.BytesToAddress([]byte{i, 20})
+			state.SetCode(contract, []byte{i, byte(blockNr)})
+			for j := byte(0); j < 50; j++ {
+				state.SetState(contract, common.BytesToHash([]byte{j}), common.BytesToHash([]byte{i, j, byte(blockNr)}))
+			}
+		}
+		root, err := tds.IntermediateRoot(state, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Commit(false, tds.DbStateWriter()); err != nil {
+			t.Fatal(err)
+		}
+		roots[blockNr] = root
+	}
+	return db, roots
+}
+
+// serveRange answers the request from the server database. Like the protocol
+// handler, it only resolves the whole tries if the proofs are not cached.
+func serveRange(t *testing.T, s *RangeSync, server ethdb.Database, req *RangeRequest, maxBytes int) error {
+	switch req.Kind {
+	case AccountRangeRequest:
+		hashes, values, proof, err := ReadAccountRange(server, s.Root(), s.Number(), req.Origin, req.Limit, maxBytes, false)
+		if err == ErrRangeNotCached {
+			hashes, values, proof, err = ReadAccountRange(server, s.Root(), s.Number(), req.Origin, req.Limit, maxBytes, true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ProcessAccounts(req, hashes, values, proof)
+	case StorageRangeRequest:
+		ranges, proof, err := ReadStorageRanges(server, s.Root(), s.Number(), req.Accounts, req.Origin, maxBytes, false)
+		if err == ErrRangeNotCached {
+			ranges, proof, err = ReadStorageRanges(server, s.Root(), s.Number(), req.Accounts, req.Origin, maxBytes, true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.ProcessStorage(req, ranges, proof)
+	default:
+		var codes [][]byte
+		for _, hash := range req.Codes {
+			if code, _ := server.Get(CodeBucket, hash[:]); code != nil {
+				codes = append(codes, code)
+			}
+		}
+		return s.ProcessCodes(req, codes)
+	}
+}
+
+// runRangeSync downloads everything scheduled and heals the state, until it is
+// complete.
+func runRangeSync(t *testing.T, s *RangeSync, server ethdb.Database, maxBytes int) {
+	for round := 0; ; round++ {
+		if round > 3 {
+			t.Fatalf("state not healed after %d rounds", round)
+		}
+		for req := s.Next(8); req != nil; req = s.Next(8) {
+			if err := serveRange(t, s, server, req, maxBytes); err != nil {
+				t.Fatalf("request %+v: %v", req, err)
+			}
+			if err := s.Commit(false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		done, err := s.Heal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+func TestRangeSync(t *testing.T) {
+	server, roots := newRangeServer(t)
+	for _, maxBytes := range []int{300, 5000, 1000000} {
+		// The state of the block before the head is read from the history
+		client := ethdb.NewMemDatabase()
+		s, err := NewRangeSync(client, roots[2], 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runRangeSync(t, s, server, maxBytes)
+
+		if stats := s.Stats(); stats.Accounts != 320 || stats.Storage != 1000 || stats.Codes != 20 {
+			t.Errorf("max %d bytes: wrong stats %+v", maxBytes, stats)
+		}
+		tds, _ := NewTrieDbState(roots[2], client, 2)
+		state := New(tds)
+		contract := common.BytesToAddress([]byte{5, 20})
+		if balance := state.GetBalance(common.BytesToAddress([]byte{7, 0, 10})); balance.Uint64() != 3 {
+			t.Errorf("max %d bytes: balance %d, want 3", maxBytes, balance)
+		}
+		if code := state.GetCode(contract); len(code) != 2 || code[1] != 2 {
+			t.Errorf("max %d bytes: code %x", maxBytes, code)
+		}
+		if value := state.GetState(contract, common.BytesToHash([]byte{9})); value != common.BytesToHash([]byte{5, 9, 2}) {
+			t.Errorf("max %d bytes: storage value %x", maxBytes, value)
+		}
+		if horizon, _ := ethdb.HistoryHorizon(client); horizon != 3 {
+			t.Errorf("max %d bytes: history horizon %d, want 3", maxBytes, horizon)
+		}
+	}
+}
+
+func TestRangeSyncBadResponses(t *testing.T) {
+	server, roots := newRangeServer(t)
+	s, err := NewRangeSync(ethdb.NewMemDatabase(), roots[3], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := s.Next(8)
+	hashes, values, proof, err := ReadAccountRange(server, roots[3], 3, req.Origin, req.Limit, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ProcessAccounts(req, nil, nil, nil); err != ErrRangeUnavailable {
+		t.Errorf("empty response: have %v, want %v", err, ErrRangeUnavailable)
+	}
+	if s.Next(8).task != req.task {
+		t.Fatal("account range not requested again")
+	}
+	tampered := append([][]byte{}, values...)
+	tampered[1] = []byte{0xc0}
+	if err := s.ProcessAccounts(req, hashes, tampered, proof); err == nil {
+		t.Error("tampered account accepted")
+	}
+	if err := s.ProcessAccounts(req, hashes[1:], values[1:], proof); err == nil {
+		t.Error("range without the first account accepted")
+	}
+	if err := s.ProcessAccounts(req, hashes, values, proof); err != nil {
+		t.Fatalf("valid range rejected: %v", err)
+	}
+	// Fetch the accounts of the contracts
+	for req = s.Next(8); req.Kind == AccountRangeRequest || req.Kind == CodeRequest; req = s.Next(8) {
+		if err := serveRange(t, s, server, req, 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ranges, proof, err := ReadStorageRanges(server, roots[3], 3, req.Accounts, req.Origin, 1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := ranges[0]
+	ranges[0].Keys, ranges[0].Values = first.Keys[1:], first.Values[1:]
+	if err := s.ProcessStorage(req, ranges, proof); err == nil {
+		t.Error("storage range without the first item accepted")
+	}
+	ranges[0] = first
+	ranges[0].Address = common.Address{1}
+	if err := s.ProcessStorage(req, ranges, proof); err == nil {
+		t.Error("storage of another account accepted")
+	}
+	ranges[0] = first
+	if err := s.ProcessStorage(req, ranges, proof); err != nil {
+		t.Fatalf("valid storage rejected: %v", err)
+	}
+	runRangeSync(t, s, server, 1000)
+}
+
+// Tests that the proofs of the current state are built from the intermediate
+// hashes, and that the proofs resolving the whole tries are only built if
+// allowed.
+func TestReadRangeProofs(t *testing.T) {
+	defer func(limit int) { rangeProofWalkLimit = limit }(rangeProofWalkLimit)
+
+	server, roots := newRangeServer(t)
+	contract := crypto.Keccak256Hash(common.BytesToAddress([]byte{5, 20}).Bytes())
+	origin := common.Hash{0x80}
+
+	// The proofs of the head state do not need the whole tries
+	s, err := NewRangeSync(ethdb.NewMemDatabase(), roots[3], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := s.Next(8)
+	hashes, values, proof, err := ReadAccountRange(server, roots[3], 3, req.Origin, req.Limit, 1000, false)
+	if err != nil {
+		t.Fatalf("account range of the head state: %v", err)
+	}
+	if err := s.ProcessAccounts(req, hashes, values, proof); err != nil {
+		t.Fatalf("account range of the head state rejected: %v", err)
+	}
+	ranges, proof, err := ReadStorageRanges(server, roots[3], 3, []common.Hash{contract}, origin, 1000, false)
+	if err != nil {
+		t.Fatalf("storage range of the head state: %v", err)
+	}
+	if len(ranges) != 1 || len(ranges[0].Keys) == 0 || len(proof) == 0 {
+		t.Fatalf("storage range of the head state: %d ranges, proof of %d nodes", len(ranges), len(proof))
+	}
+	// The proofs of the state of the past block resolve the whole tries
+	if _, _, _, err := ReadAccountRange(server, roots[2], 2, common.Hash{}, common.Hash{0xff}, 1000, false); err != ErrRangeNotCached {
+		t.Errorf("account range of the past state: have %v, want %v", err, ErrRangeNotCached)
+	}
+	if _, _, err := ReadStorageRanges(server, roots[2], 2, []common.Hash{contract}, origin, 1000, false); err != ErrRangeNotCached {
+		t.Errorf("storage range of the past state: have %v, want %v", err, ErrRangeNotCached)
+	}
+	if _, _, _, err := ReadAccountRange(server, roots[2], 2, common.Hash{}, common.Hash{0xff}, 1000, true); err != nil {
+		t.Errorf("account range of the past state with the full proofs: %v", err)
+	}
+	// So do the proofs of the head state whose intermediate hashes are missing
+	// below the root
+	var stale [][]byte
+	if err := server.Walk(trie.IntermediateHashBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		if len(k) > 1 && len(k) <= trie.IntermediateHashLevels+1 {
+			stale = append(stale, common.CopyBytes(k))
+		}
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range stale {
+		if err := server.Delete(trie.IntermediateHashBucket, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rangeProofWalkLimit = 4
+	if _, _, _, err := ReadAccountRange(server, roots[3], 3, common.Hash{}, common.Hash{0xff}, 1000, false); err != ErrRangeNotCached {
+		t.Errorf("account range without the intermediate hashes: have %v, want %v", err, ErrRangeNotCached)
+	}
+	if _, _, _, err := ReadAccountRange(server, roots[3], 3, common.Hash{}, common.Hash{0xff}, 1000, true); err != nil {
+		t.Errorf("account range without the intermediate hashes with the full proofs: %v", err)
+	}
+}
+
+func TestRangeSyncHeal(t *testing.T) {
+	server, roots := newRangeServer(t)
+	client := ethdb.NewMemDatabase()
+	s, err := NewRangeSync(client, roots[3], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRangeSync(t, s, server, 5000)
+
+	// Lose the storage of one contract and the code of another
+	contract := common.BytesToAddress([]byte{3, 20})
+	if err := ethdb.DeletePrefix(client, StorageBucket, contract[:]); err != nil {
+		t.Fatal(err)
+	}
+	other := common.BytesToAddress([]byte{4, 20})
+	enc, _ := client.Get(AccountsBucket, crypto.Keccak256(other[:]))
+	account, err := DecodeAccount(enc)
+	if err != nil || account == nil {
+		t.Fatalf("contract missing: %v", err)
+	}
+	if err := client.Delete(CodeBucket, account.CodeHash); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := s.Heal(); err != nil || done {
+		t.Fatalf("broken state healed: %t, %v", done, err)
+	}
+	if len(s.storageTasks) != 1 || len(s.codeTasks) != 1 {
+		t.Fatalf("have %d storage and %d code tasks, want 1 and 1", len(s.storageTasks), len(s.codeTasks))
+	}
+	runRangeSync(t, s, server, 5000)
+}
@@ -127,6 +127,9 @@ func (dbs *DbState) ReadAccountData(address common.Address) (*Account, error) {
 	var buf common.Hash
 	h.sha.Read(buf[:])
 	enc, err := dbs.db.GetAsOf(AccountsBucket, AccountsHistoryBucket, buf[:], dbs.blockNr+1)
+	if err == ethdb.ErrHistoryPruned {
+		return nil, err
+	}
 	if err != nil || enc == nil || len(enc) == 0 {
 		return nil, nil
 	}
@@ -141,6 +144,9 @@ func (dbs *DbState) ReadAccountStorage(address common.Address, key *common.Hash)
 	var buf common.Hash
 	h.sha.Read(buf[:])
 	enc, err := dbs.db.GetAsOf(StorageBucket, StorageHistoryBucket, append(address[:], buf[:]...), dbs.blockNr+1)
+	if err == ethdb.ErrHistoryPruned {
+		return nil, err
+	}
 	if err != nil || enc == nil {
 		return nil, nil
 	}
@@ -18,7 +18,6 @@ package state
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"runtime"
 
@@ -60,45 +59,22 @@ func (rds *RepairDbState) SetBlockNr(blockNr uint64) {
 	rds.storageKeys = make(map[string]struct{})
 }
 
-// If highZero is true, the most significant bits of every byte is left zero
-func encodeTimestamp(timestamp uint64) []byte {
-	var suffix []byte
-	var limit uint64
-	limit = 32
-	for bytecount := 1; bytecount <= 8; bytecount++ {
-		if timestamp < limit {
-			suffix = make([]byte, bytecount)
-			b := timestamp
-			for i := bytecount - 1; i > 0; i-- {
-				suffix[i] = byte(b & 0xff)
-				b >>= 8
-			}
-			suffix[0] = byte(b) | (byte(bytecount) << 5) // 3 most significant bits of the first byte are bytecount
-			break
-		}
-		limit <<= 8
+// changeSetKeys returns the keys recorded in the change set of the history bucket for the current block
+func (rds *RepairDbState) changeSetKeys(hBucket []byte) map[string]struct{} {
+	set := make(map[string]struct{})
+	changeSet, err := ethdb.GetChangeSet(rds.historyDb, hBucket, rds.blockNr)
+	if err != nil {
+		fmt.Printf("Could not read change set of %s for block %d: %v\n", hBucket, rds.blockNr, err)
+		return set
+	}
+	for _, c := range changeSet.Changes {
+		set[string(c.Key)] = struct{}{}
 	}
-	return suffix
+	return set
 }
 
 func (rds *RepairDbState) CheckKeys() {
-	aSet := make(map[string]struct{})
-	suffix := encodeTimestamp(rds.blockNr)
-	{
-		suffixkey := make([]byte, len(suffix)+len(AccountsHistoryBucket))
-		copy(suffixkey, suffix)
-		copy(suffixkey[len(suffix):], AccountsHistoryBucket)
-		v, _ := rds.historyDb.Get(ethdb.SuffixBucket, suffixkey)
-		if len(v) > 0 {
-			keycount := int(binary.BigEndian.Uint32(v))
-			for i, ki := 4, 0; ki < keycount; ki++ {
-				l := int(v[i])
-				i++
-				aSet[string(v[i:i+l])] = struct{}{}
-				i += l
-			}
-		}
-	}
+	aSet := rds.changeSetKeys(AccountsHistoryBucket)
 	aDiff := len(aSet) != len(rds.accountsKeys)
 	if !aDiff {
 		for a, _ := range aSet {
@@ -110,42 +86,8 @@ func (rds *RepairDbState) CheckKeys() {
 	}
 	if aDiff {
 		fmt.Printf("Accounts key set does not match for block %d\n", rds.blockNr)
-		newlen := 4 + len(rds.accountsKeys)
-		for key, _ := range rds.accountsKeys {
-			newlen += len(key)
-		}
-		dv := make([]byte, newlen)
-		binary.BigEndian.PutUint32(dv, uint32(len(rds.accountsKeys)))
-		i := 4
-		for key, _ := range rds.accountsKeys {
-			dv[i] = byte(len(key))
-			i++
-			copy(dv[i:], key)
-			i += len(key)
-		}
-		suffixkey := make([]byte, len(suffix)+len(AccountsHistoryBucket))
-		copy(suffixkey, suffix)
-		copy(suffixkey[len(suffix):], AccountsHistoryBucket)
-		//if err := rds.historyDb.Put(ethdb.SuffixBucket, suffixkey, dv); err != nil {
-		//	panic(err)
-		//}
-	}
-	sSet := make(map[string]struct{})
-	{
-		suffixkey := make([]byte, len(suffix)+len(StorageHistoryBucket))
-		copy(suffixkey, suffix)
-		copy(suffixkey[len(suffix):], StorageHistoryBucket)
-		v, _ := rds.historyDb.Get(ethdb.SuffixBucket, suffixkey)
-		if len(v) > 0 {
-			keycount := int(binary.BigEndian.Uint32(v))
-			for i, ki := 4, 0; ki < keycount; ki++ {
-				l := int(v[i])
-				i++
-				sSet[string(v[i:i+l])] = struct{}{}
-				i += l
-			}
-		}
 	}
+	sSet := rds.changeSetKeys(StorageHistoryBucket)
 	sDiff := len(sSet) != len(rds.storageKeys)
 	if !sDiff {
 		for s, _ := range sSet {
@@ -157,25 +99,6 @@ func (rds *RepairDbState) CheckKeys() {
 	}
 	if sDiff {
 		fmt.Printf("Storage key set does not match for block %d\n", rds.blockNr)
-		newlen := 4 + len(rds.storageKeys)
-		for key, _ := range rds.storageKeys {
-			newlen += len(key)
-		}
-		dv := make([]byte, newlen)
-		binary.BigEndian.PutUint32(dv, uint32(len(rds.storageKeys)))
-		i := 4
-		for key, _ := range rds.storageKeys {
-			dv[i] = byte(len(key))
-			i++
-			copy(dv[i:], key)
-			i += len(key)
-		}
-		suffixkey := make([]byte, len(suffix)+len(StorageHistoryBucket))
-		copy(suffixkey, suffix)
-		copy(suffixkey[len(suffix):], StorageHistoryBucket)
-		//if err := rds.historyDb.Put(ethdb.SuffixBucket, suffixkey, dv); err != nil {
-		//	panic(err)
-		//}
 	}
 }
 
@@ -0,0 +1,233 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// StorageDiff is the change of a storage item by a transaction.
+type StorageDiff struct {
+	Key  common.Hash
+	Pre  common.Hash
+	Post common.Hash
+}
+
+// AccountDiff is the change of an account by a transaction. Pre is nil for the
+// accounts created by the transaction, Post for the ones it deleted. Code is
+// the code deployed by the transaction, if any.
+type AccountDiff struct {
+	Address common.Address
+	Pre     *Account `rlp:"nil"`
+	Post    *Account `rlp:"nil"`
+	Code    []byte
+	Storage []StorageDiff
+}
+
+// StateDiff is the change of the state by a transaction, ordered by address.
+type StateDiff []*AccountDiff
+
+// CodeGetter returns the code of an account, which is implemented by StateDB.
+type CodeGetter interface {
+	GetCode(addr common.Address) []byte
+}
+
+// StateDiffWriter is a StateWriter decorator recording the changes written
+// through it into a StateDiff per transaction. The writes are forwarded to the
+// decorated writer untouched.
+//
+// The state objects report the values as of the start of the block as the
+// original ones, and rewrite their dirty storage after every transaction, so
+// the writer tracks the values written since the start of the block itself.
+type StateDiffWriter struct {
+	inner StateWriter
+
+	accounts map[common.Address]*Account                    // Accounts written in the block, nil if deleted
+	storage  map[common.Address]map[common.Hash]common.Hash // Storage written in the block
+	wiped    map[common.Address]struct{}                    // Accounts deleted in the block, their storage read as empty
+
+	current map[common.Address]*AccountDiff  // Changes of the current transaction
+	pending map[common.Address][]StorageDiff // Storage changes of the current transaction awaiting their account
+	diffs   []StateDiff                      // Changes of the finished transactions
+}
+
+// NewStateDiffWriter creates a StateDiffWriter decorating the given writer.
+func NewStateDiffWriter(inner StateWriter) *StateDiffWriter {
+	w := &StateDiffWriter{inner: inner}
+	w.reset()
+	return w
+}
+
+// reset drops everything recorded, ready for a new block.
+func (w *StateDiffWriter) reset() {
+	w.accounts = make(map[common.Address]*Account)
+	w.storage = make(map[common.Address]map[common.Hash]common.Hash)
+	w.wiped = make(map[common.Address]struct{})
+	w.current = make(map[common.Address]*AccountDiff)
+	w.pending = make(map[common.Address][]StorageDiff)
+	w.diffs = nil
+}
+
+// diff returns the change of the account by the current transaction, creating
+// an unchanged one if there is none yet.
+func (w *StateDiffWriter) diff(address common.Address, original *Account) *AccountDiff {
+	d, ok := w.current[address]
+	if !ok {
+		pre, ok := w.accounts[address]
+		if !ok {
+			pre = existingAccount(original)
+		}
+		d = &AccountDiff{Address: address, Pre: pre, Post: pre}
+		w.current[address] = d
+	}
+	if storage, ok := w.pending[address]; ok {
+		d.Storage = append(d.Storage, storage...)
+		delete(w.pending, address)
+	}
+	return d
+}
+
+func (w *StateDiffWriter) UpdateAccountData(address common.Address, original, account *Account) error {
+	post := copyAccount(account)
+	w.diff(address, original).Post = post
+	w.accounts[address] = post
+	return w.inner.UpdateAccountData(address, original, account)
+}
+
+func (w *StateDiffWriter) UpdateAccountCode(codeHash common.Hash, code []byte) error {
+	return w.inner.UpdateAccountCode(codeHash, code)
+}
+
+func (w *StateDiffWriter) DeleteAccount(address common.Address, original *Account) error {
+	w.diff(address, original).Post = nil
+	w.accounts[address] = nil
+	w.storage[address] = make(map[common.Hash]common.Hash)
+	w.wiped[address] = struct{}{}
+	return w.inner.DeleteAccount(address, original)
+}
+
+func (w *StateDiffWriter) WriteAccountStorage(address common.Address, key, original, value *common.Hash) error {
+	storage, ok := w.storage[address]
+	if !ok {
+		storage = make(map[common.Hash]common.Hash)
+		w.storage[address] = storage
+	}
+	pre, ok := storage[*key]
+	if !ok {
+		if _, wiped := w.wiped[address]; !wiped {
+			pre = *original
+		}
+	}
+	if pre != *value {
+		// The storage is written before its account, which the diff is
+		// created with, so the change waits for it
+		w.pending[address] = append(w.pending[address], StorageDiff{Key: *key, Pre: pre, Post: *value})
+		storage[*key] = *value
+	}
+	return w.inner.WriteAccountStorage(address, key, original, value)
+}
+
+// FinishTx closes the diff of the current transaction, leaving out the accounts
+// which were written but didn't change. The code of the accounts whose code has
+// changed is taken from the state the transaction was executed on.
+func (w *StateDiffWriter) FinishTx(state CodeGetter) {
+	for address := range w.pending {
+		w.diff(address, nil)
+	}
+	diff := make(StateDiff, 0, len(w.current))
+	for address, d := range w.current {
+		if len(d.Storage) == 0 && equalAccounts(d.Pre, d.Post) {
+			continue
+		}
+		if d.Post != nil && (d.Pre == nil || !bytes.Equal(d.Pre.CodeHash, d.Post.CodeHash)) && !bytes.Equal(d.Post.CodeHash, emptyCodeHash) {
+			d.Code = common.CopyBytes(state.GetCode(address))
+		}
+		sort.Slice(d.Storage, func(i, j int) bool {
+			return bytes.Compare(d.Storage[i].Key[:], d.Storage[j].Key[:]) < 0
+		})
+		diff = append(diff, d)
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return bytes.Compare(diff[i].Address[:], diff[j].Address[:]) < 0
+	})
+	w.diffs = append(w.diffs, diff)
+	w.current = make(map[common.Address]*AccountDiff)
+}
+
+// DiscardTx drops the changes written since the last finished transaction
+// which are not made by a transaction, like the state changes of the hard
+// forks. The diffs of the next transactions start from the values written.
+func (w *StateDiffWriter) DiscardTx() {
+	w.current = make(map[common.Address]*AccountDiff)
+	w.pending = make(map[common.Address][]StorageDiff)
+}
+
+// ExtractDiffs returns the diffs of the transactions finished since the last
+// extraction, and starts recording a new block.
+func (w *StateDiffWriter) ExtractDiffs() []StateDiff {
+	diffs := w.diffs
+	w.reset()
+	return diffs
+}
+
+// EncodeStateDiffs returns the encoding of the diffs of the transactions of a block.
+func EncodeStateDiffs(diffs []StateDiff) ([]byte, error) {
+	return rlp.EncodeToBytes(diffs)
+}
+
+// DecodeStateDiffs decodes the diffs of the transactions of a block.
+func DecodeStateDiffs(enc []byte) ([]StateDiff, error) {
+	var diffs []StateDiff
+	if err := rlp.DecodeBytes(enc, &diffs); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// existingAccount returns a copy of the original account, or nil if the account
+// did not exist, which the state objects signal with an unset balance.
+func existingAccount(original *Account) *Account {
+	if original == nil || original.Balance == nil {
+		return nil
+	}
+	return copyAccount(original)
+}
+
+func copyAccount(account *Account) *Account {
+	cpy := &Account{
+		Nonce:    account.Nonce,
+		Balance:  new(big.Int),
+		Root:     account.Root,
+		CodeHash: common.CopyBytes(account.CodeHash),
+	}
+	if account.Balance != nil {
+		cpy.Balance.Set(account.Balance)
+	}
+	return cpy
+}
+
+func equalAccounts(a, b *Account) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Nonce == b.Nonce && a.Balance.Cmp(b.Balance) == 0 && a.Root == b.Root && bytes.Equal(a.CodeHash, b.CodeHash)
+}
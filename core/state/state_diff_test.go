@@ -0,0 +1,167 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that the state diff writer records the changes of every transaction of
+// a block with the values right before and after it.
+func TestStateDiffWriter(t *testing.T) {
+	var (
+		alice    = common.BytesToAddress([]byte{1})
+		bob      = common.BytesToAddress([]byte{2})
+		contract = common.BytesToAddress([]byte{3})
+		created  = common.BytesToAddress([]byte{4})
+		k1, k2   = common.BytesToHash([]byte{1}), common.BytesToHash([]byte{2})
+	)
+	db := ethdb.NewMemDatabase()
+	tds, _ := NewTrieDbState(common.Hash{}, db, 0)
+	genesis := New(tds)
+	genesis.AddBalance(alice, big.NewInt(100))
+	genesis.SetCode(contract, []byte{0x60})
+	genesis.SetState(contract, k1, common.BytesToHash([]byte{1}))
+	if _, err := tds.IntermediateRoot(genesis, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := genesis.Commit(false, tds.DbStateWriter()); err != nil {
+		t.Fatal(err)
+	}
+	tds.SetBlockNr(1)
+	statedb := New(tds)
+	w := NewStateDiffWriter(tds.TrieStateWriter())
+
+	// A transfer to a new account and a storage change
+	statedb.SubBalance(alice, big.NewInt(10))
+	statedb.AddBalance(bob, big.NewInt(10))
+	statedb.SetState(contract, k1, common.BytesToHash([]byte{2}))
+	statedb.Finalise(true, w)
+	w.FinishTx(statedb)
+
+	// Changes on top of the previous transaction, and a contract creation
+	statedb.AddBalance(alice, big.NewInt(5))
+	statedb.SetState(contract, k2, common.BytesToHash([]byte{7}))
+	statedb.CreateAccount(created, true)
+	statedb.SetNonce(created, 1)
+	statedb.SetCode(created, []byte{1, 2})
+	statedb.Finalise(true, w)
+	w.FinishTx(statedb)
+
+	// A self-destruct
+	statedb.Suicide(bob)
+	statedb.Finalise(true, w)
+	w.FinishTx(statedb)
+
+	diffs := w.ExtractDiffs()
+	if len(diffs) != 3 {
+		t.Fatalf("diff count mismatch: have %d, want 3", len(diffs))
+	}
+	balance := func(account *Account) int64 {
+		if account == nil {
+			return -1
+		}
+		return account.Balance.Int64()
+	}
+	// Accounts are ordered by address: alice, bob, contract, created
+	if diff := diffs[0]; len(diff) != 3 {
+		t.Fatalf("tx 0: account count mismatch: have %d, want 3", len(diff))
+	} else {
+		if diff[0].Address != alice || balance(diff[0].Pre) != 100 || balance(diff[0].Post) != 90 {
+			t.Errorf("tx 0: alice mismatch: %x %d -> %d", diff[0].Address, balance(diff[0].Pre), balance(diff[0].Post))
+		}
+		if diff[1].Address != bob || diff[1].Pre != nil || balance(diff[1].Post) != 10 {
+			t.Errorf("tx 0: bob mismatch: %x %d -> %d", diff[1].Address, balance(diff[1].Pre), balance(diff[1].Post))
+		}
+		if diff[2].Address != contract || len(diff[2].Storage) != 1 || diff[2].Storage[0] != (StorageDiff{k1, common.BytesToHash([]byte{1}), common.BytesToHash([]byte{2})}) {
+			t.Errorf("tx 0: contract mismatch: %x %v", diff[2].Address, diff[2].Storage)
+		}
+	}
+	if diff := diffs[1]; len(diff) != 3 {
+		t.Fatalf("tx 1: account count mismatch: have %d, want 3", len(diff))
+	} else {
+		if diff[0].Address != alice || balance(diff[0].Pre) != 90 || balance(diff[0].Post) != 95 {
+			t.Errorf("tx 1: alice mismatch: %x %d -> %d", diff[0].Address, balance(diff[0].Pre), balance(diff[0].Post))
+		}
+		// The storage item changed by the previous transaction is not repeated
+		if diff[1].Address != contract || len(diff[1].Storage) != 1 || diff[1].Storage[0] != (StorageDiff{k2, common.Hash{}, common.BytesToHash([]byte{7})}) {
+			t.Errorf("tx 1: contract mismatch: %x %v", diff[1].Address, diff[1].Storage)
+		}
+		if diff[2].Address != created || diff[2].Pre != nil || diff[2].Post == nil || !bytes.Equal(diff[2].Code, []byte{1, 2}) {
+			t.Errorf("tx 1: created mismatch: %x %v -> %v, code %x", diff[2].Address, diff[2].Pre, diff[2].Post, diff[2].Code)
+		}
+	}
+	if diff := diffs[2]; len(diff) != 1 || diff[0].Address != bob || balance(diff[0].Pre) != 10 || diff[0].Post != nil {
+		t.Errorf("tx 2: self-destruct mismatch: %v", diff)
+	}
+	// The diffs survive the round trip through the encoding
+	enc, err := EncodeStateDiffs(diffs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := DecodeStateDiffs(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reenc, _ := EncodeStateDiffs(dec); !bytes.Equal(enc, reenc) {
+		t.Errorf("encoding mismatch:\nhave %x\nwant %x", reenc, enc)
+	}
+	if dec[0][1].Pre != nil || dec[2][0].Post != nil {
+		t.Errorf("missing accounts decoded as %v and %v", dec[0][1].Pre, dec[2][0].Post)
+	}
+}
+
+// Tests that the changes discarded between the transactions are left out of
+// the diffs, while the next transaction starts from their values.
+func TestStateDiffWriterDiscard(t *testing.T) {
+	alice := common.BytesToAddress([]byte{1})
+	db := ethdb.NewMemDatabase()
+	tds, _ := NewTrieDbState(common.Hash{}, db, 0)
+	genesis := New(tds)
+	genesis.AddBalance(alice, big.NewInt(100))
+	if _, err := tds.IntermediateRoot(genesis, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := genesis.Commit(false, tds.DbStateWriter()); err != nil {
+		t.Fatal(err)
+	}
+	tds.SetBlockNr(1)
+	statedb := New(tds)
+	w := NewStateDiffWriter(tds.TrieStateWriter())
+
+	// An irregular change before the first transaction
+	statedb.AddBalance(alice, big.NewInt(50))
+	statedb.Finalise(true, w)
+	w.DiscardTx()
+
+	statedb.SubBalance(alice, big.NewInt(10))
+	statedb.Finalise(true, w)
+	w.FinishTx(statedb)
+
+	diffs := w.ExtractDiffs()
+	if len(diffs) != 1 || len(diffs[0]) != 1 {
+		t.Fatalf("diffs mismatch: %v", diffs)
+	}
+	if d := diffs[0][0]; d.Pre.Balance.Int64() != 150 || d.Post.Balance.Int64() != 140 {
+		t.Errorf("alice mismatch: %d -> %d, want 150 -> 140", d.Pre.Balance, d.Post.Balance)
+	}
+}
@@ -52,7 +52,7 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	s.state.Finalise(false, s.tds.TrieStateWriter())
 	s.tds.TrieRoot()
 	s.tds.SetBlockNr(1)
-	s.state.Finalise(false, s.tds.DbStateWriter())
+	s.state.Commit(false, s.tds.DbStateWriter())
 
 	// check that dump contains the state objects that are in trie
 	got := string(s.tds.Dump())
@@ -105,7 +105,7 @@ func (s *StateSuite) TestNull(c *checker.C) {
 	s.state.SetState(address, common.Hash{}, value)
 	s.state.Finalise(false, s.tds.TrieStateWriter())
 	s.tds.SetBlockNr(1)
-	s.state.Finalise(false, s.tds.DbStateWriter())
+	s.state.Commit(false, s.tds.DbStateWriter())
 	if value := s.state.GetCommittedState(address, common.Hash{}); value != (common.Hash{}) {
 		c.Errorf("expected empty hash. got %x", value)
 	}
@@ -169,8 +169,11 @@ func TestSnapshot2(t *testing.T) {
 
 	tds.IntermediateRoot(state, false)
 	tds.SetBlockNr(1)
-	state.Finalise(false, tds.DbStateWriter())
+	state.Commit(false, tds.DbStateWriter())
 	state.Reset()
+	// The dirty storage is kept until the object is dropped, so the object
+	// reloaded after the reset only has it as the committed storage
+	so0.originStorage, so0.dirtyStorage = so0.dirtyStorage, make(Storage)
 
 	// and one with deleted == true
 	so1 := state.getStateObject(stateobjaddr1)
@@ -19,7 +19,7 @@ package state
 
 import (
 	"bytes"
-	//"errors"
+	"errors"
 	"fmt"
 	"math/big"
 	"sort"
@@ -43,15 +43,27 @@ var (
 
 	// emptyCode is the known hash of the empty EVM bytecode.
 	emptyCode = crypto.Keccak256Hash(nil)
+
+	// errNoTrieState is returned by the proofs of the state not read from the tries.
+	errNoTrieState = errors.New("state proofs require the trie state")
 )
 
+// proofList collects the nodes of a proof in the order from the root.
 type proofList [][]byte
 
-func (n *proofList) Put(key []byte, value []byte) error {
+func (n *proofList) Put(bucket, key, value []byte) error {
 	*n = append(*n, value)
 	return nil
 }
 
+func (n *proofList) PutS(hBucket, key, value []byte, timestamp uint64) error {
+	panic("proofList does not keep the history")
+}
+
+func (n *proofList) DeleteTimestamp(timestamp uint64) error {
+	panic("proofList does not keep the history")
+}
+
 // StateDBs within the ethereum protocol are used to store anything
 // within the merkle trie. StateDBs take care of caching and storing
 // nested states. It's the general query interface to retrieve:
@@ -129,6 +141,7 @@ func (self *StateDB) Error() error {
 func (self *StateDB) Reset() error {
 	self.stateObjects = make(map[common.Address]*stateObject)
 	self.stateObjectsDirty = make(map[common.Address]struct{})
+	self.nilAccounts = make(map[common.Address]struct{})
 	self.thash = common.Hash{}
 	self.bhash = common.Hash{}
 	self.txIndex = 0
@@ -292,24 +305,24 @@ func (self *StateDB) GetState(addr common.Address, hash common.Hash) common.Hash
 	return common.Hash{}
 }
 
-// GetProof returns the MerkleProof for a given Account
+// GetProof returns the Merkle proof of the account. It requires the state to
+// be read from the tries.
 func (self *StateDB) GetProof(a common.Address) ([][]byte, error) {
-	//var proof proofList
-	//err := self.trie.Prove(crypto.Keccak256(a.Bytes()), 0, &proof)
-	//return [][]byte(proof), err
-	return nil, nil
+	tds, ok := self.stateReader.(*TrieDbState)
+	if !ok {
+		return nil, errNoTrieState
+	}
+	return tds.GetProof(a)
 }
 
-// GetProof returns the StorageProof for given key
+// GetStorageProof returns the Merkle proof of the storage item of the account.
+// It requires the state to be read from the tries.
 func (self *StateDB) GetStorageProof(a common.Address, key common.Hash) ([][]byte, error) {
-	//var proof proofList
-	//trie := self.StorageTrie(a)
-	//if trie == nil {
-	//	return proof, errors.New("storage trie for requested address does not exist")
-	//}
-	//err := trie.Prove(crypto.Keccak256(key.Bytes()), 0, &proof)
-	//return [][]byte(proof), err
-	return nil, nil
+	tds, ok := self.stateReader.(*TrieDbState)
+	if !ok {
+		return nil, errNoTrieState
+	}
+	return tds.GetStorageProof(a, key)
 }
 
 // GetCommittedState retrieves a value from the given account's committed storage trie.
@@ -32,7 +32,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
@@ -40,7 +42,7 @@ import (
 // actually committing the state.
 func TestUpdateLeaks(t *testing.T) {
 	// Create an empty state database
-	db := ethdb.NewMemDatabase()
+	db := ethdb.NewMemDatabase().NewBatch()
 	tds, _ := NewTrieDbState(common.Hash{}, db, 0)
 	state := New(tds)
 
@@ -71,8 +73,8 @@ func TestUpdateLeaks(t *testing.T) {
 // only the one right before the commit.
 func TestIntermediateLeaks(t *testing.T) {
 	// Create two state databases, one transitioning to the final state, the other final from the beginning
-	transDb := ethdb.NewMemDatabase()
-	finalDb := ethdb.NewMemDatabase()
+	transDb := ethdb.NewMemDatabase().NewBatch()
+	finalDb := ethdb.NewMemDatabase().NewBatch()
 	transTds, _ := NewTrieDbState(common.Hash{}, transDb, 0)
 	transState := New(transTds)
 	finalTds, _ := NewTrieDbState(common.Hash{}, finalDb, 0)
@@ -475,3 +477,79 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// verifyProof checks the proof against the root and returns the proven value.
+func verifyProof(t *testing.T, root common.Hash, key []byte, proof [][]byte) []byte {
+	proofDb := ethdb.NewMemDatabase().NewBatch()
+	for _, node := range proof {
+		if err := proofDb.Put([]byte{}, crypto.Keccak256(node), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, _, err := trie.VerifyProof(root, key, proofDb)
+	if err != nil {
+		t.Fatalf("invalid proof of %x: %v", key, err)
+	}
+	return value
+}
+
+func TestGetProof(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	tds, _ := NewTrieDbState(common.Hash{}, db, 0)
+	addr := common.BytesToAddress([]byte{1})
+	key := common.BytesToHash([]byte{2})
+
+	roots := make(map[uint64]common.Hash)
+	for blockNr := uint64(1); blockNr <= 3; blockNr++ {
+		tds.SetBlockNr(blockNr)
+		state := New(tds)
+		// Enough accounts and storage items for the proofs to have several nodes
+		for i := byte(0); i < 100; i++ {
+			state.AddBalance(common.BytesToAddress([]byte{i, 10}), big.NewInt(int64(blockNr)))
+			state.SetState(addr, common.BytesToHash([]byte{i, 10}), common.BytesToHash([]byte{i}))
+		}
+		state.SetBalance(addr, big.NewInt(int64(blockNr)))
+		state.SetState(addr, key, common.BytesToHash([]byte{byte(blockNr)}))
+		root, err := tds.IntermediateRoot(state, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.Commit(false, tds.DbStateWriter()); err != nil {
+			t.Fatal(err)
+		}
+		roots[blockNr] = root
+	}
+	// The proofs at the earlier blocks are resolved from the history
+	for blockNr := uint64(1); blockNr <= 3; blockNr++ {
+		htds, _ := NewTrieDbState(roots[blockNr], db, blockNr)
+		htds.SetHistorical(true)
+		proof, err := htds.GetProof(addr)
+		if err != nil {
+			t.Fatalf("block %d: %v", blockNr, err)
+		}
+		var account Account
+		if err := rlp.DecodeBytes(verifyProof(t, roots[blockNr], crypto.Keccak256(addr[:]), proof), &account); err != nil {
+			t.Fatalf("block %d: %v", blockNr, err)
+		}
+		if account.Balance.Uint64() != blockNr {
+			t.Errorf("block %d: balance %d", blockNr, account.Balance)
+		}
+		proof, err = htds.GetStorageProof(addr, key)
+		if err != nil {
+			t.Fatalf("block %d: %v", blockNr, err)
+		}
+		value := verifyProof(t, account.Root, crypto.Keccak256(key[:]), proof)
+		if expected, _ := rlp.EncodeToBytes([]byte{byte(blockNr)}); !bytes.Equal(value, expected) {
+			t.Errorf("block %d: storage value %x, expected %x", blockNr, value, expected)
+		}
+	}
+	// The proof of the missing account ends with the node that proves the absence
+	missing := common.BytesToAddress([]byte{2})
+	proof, err := tds.GetProof(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := verifyProof(t, roots[3], crypto.Keccak256(missing[:]), proof); value != nil {
+		t.Errorf("missing account is proven to be %x", value)
+	}
+}
@@ -18,6 +18,7 @@ package state
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -40,79 +41,251 @@ type BlockProof struct {
 	Values     [][]byte
 }
 
-/* Proof Of Concept for verification of Stateless client proofs */
-type CodeTime struct {
-	bytecode []byte
-	t        uint64
-}
-
-type Stateless struct {
-	blockNr        uint64
-	t              *trie.Trie
-	storageTries   map[common.Hash]*trie.Trie
-	codeMap        map[common.Hash]CodeTime
-	timeToCodeHash map[uint64]map[common.Hash]struct{}
-	trace          bool
-	storageUpdates map[common.Address]map[common.Hash][]byte
-	accountUpdates map[common.Hash]*Account
-	deleted        map[common.Hash]struct{}
+// Verify checks that the block proof is well formed and that the tries built
+// from it hash to the given state root and to the storage roots of the proven
+// contracts. Proofs received from the network should pass it before the block
+// is executed against them.
+func (bp *BlockProof) Verify(root common.Hash) error {
+	_, _, err := bp.buildTries(root, 0, false)
+	return err
 }
 
-func NewStateless(stateRoot common.Hash,
-	blockProof BlockProof,
-	blockNr uint64,
-	trace bool,
-) (*Stateless, error) {
+// buildTries checks the structure of the block proof and builds the account
+// trie and the storage tries from it, verifying their roots.
+func (bp *BlockProof) buildTries(stateRoot common.Hash, blockNr uint64, trace bool) (*trie.Trie, map[common.Hash]*trie.Trie, error) {
+	if err := bp.checkStructure(); err != nil {
+		return nil, nil, err
+	}
 	h := newHasher()
 	defer returnHasherToPool(h)
 	if trace {
 		fmt.Printf("ACCOUNT TRIE ==============================================\n")
 	}
-	t, _, _, _, _ := trie.NewFromProofs(blockNr, AccountsBucket, nil, false, blockProof.Masks, blockProof.ShortKeys, blockProof.Values, blockProof.Hashes, trace)
+	t, _, _, _, _ := trie.NewFromProofs(blockNr, AccountsBucket, nil, false, bp.Masks, bp.ShortKeys, bp.Values, bp.Hashes, trace)
 	if stateRoot != t.Hash() {
-		filename := fmt.Sprintf("root_%d.txt", blockNr)
-		f, err := os.Create(filename)
-		if err == nil {
-			defer f.Close()
-			t.Print(f)
+		// Block proofs may come from the network, only dump them when tracing
+		if trace {
+			filename := fmt.Sprintf("root_%d.txt", blockNr)
+			f, err := os.Create(filename)
+			if err == nil {
+				defer f.Close()
+				t.Print(f)
+			}
 		}
-		return nil, fmt.Errorf("Expected root: %x, Constructed root: %x", stateRoot, t.Hash())
+		return nil, nil, fmt.Errorf("Expected root: %x, Constructed root: %x", stateRoot, t.Hash())
 	}
 	storageTries := make(map[common.Hash]*trie.Trie)
 	var maskIdx, hashIdx, shortIdx, valueIdx int
-	for _, contract := range blockProof.Contracts {
+	for _, contract := range bp.Contracts {
 		if trace {
 			fmt.Printf("TRIE %x ==============================================\n", contract)
 		}
 		st, mIdx, hIdx, sIdx, vIdx := trie.NewFromProofs(blockNr, StorageBucket, nil, true,
-			blockProof.CMasks[maskIdx:], blockProof.CShortKeys[shortIdx:], blockProof.CValues[valueIdx:], blockProof.CHashes[hashIdx:], trace)
+			bp.CMasks[maskIdx:], bp.CShortKeys[shortIdx:], bp.CValues[valueIdx:], bp.CHashes[hashIdx:], trace)
 		h.sha.Reset()
 		h.sha.Write(contract[:])
 		var addrHash common.Hash
 		h.sha.Read(addrHash[:])
 		storageTries[addrHash] = st
-		enc, err := t.TryGet(nil, addrHash[:], blockNr)
-		if err != nil {
-			return nil, err
+		enc, ok := t.TryGetLoaded(addrHash[:], blockNr)
+		if !ok || len(enc) == 0 {
+			return nil, nil, fmt.Errorf("account of contract %x missing from block proof", contract)
 		}
 		account, err := encodingToAccount(enc)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if account.Root != st.Hash() {
-			filename := fmt.Sprintf("root_%d.txt", blockNr-1)
-			f, err := os.Create(filename)
-			if err == nil {
-				defer f.Close()
-				st.Print(f)
+			if trace {
+				filename := fmt.Sprintf("root_%d.txt", blockNr-1)
+				f, err := os.Create(filename)
+				if err == nil {
+					defer f.Close()
+					st.Print(f)
+				}
 			}
-			return nil, fmt.Errorf("[THIN] Expected storage root for %x: %x, constructed root: %x", contract, account.Root, st.Hash())
+			return nil, nil, fmt.Errorf("[THIN] Expected storage root for %x: %x, constructed root: %x", contract, account.Root, st.Hash())
 		}
 		maskIdx += mIdx
 		shortIdx += sIdx
 		hashIdx += hIdx
 		valueIdx += vIdx
 	}
+	return t, storageTries, nil
+}
+
+// checkStructure walks the block proof the same way trie.NewFromProofs builds
+// the tries from it, and checks that the tries can be built without running
+// out of the items, that the keys are well formed and lead exactly to the
+// leaves, and that no items are left over.
+func (bp *BlockProof) checkStructure() error {
+	accounts := &proofCursor{masks: bp.Masks, keys: bp.ShortKeys, values: bp.Values, hashes: bp.Hashes}
+	if err := accounts.checkTrie(); err != nil {
+		return fmt.Errorf("account trie: %v", err)
+	}
+	if err := accounts.checkConsumed(); err != nil {
+		return fmt.Errorf("account trie: %v", err)
+	}
+	storage := &proofCursor{masks: bp.CMasks, keys: bp.CShortKeys, values: bp.CValues, hashes: bp.CHashes}
+	for _, contract := range bp.Contracts {
+		if err := storage.checkTrie(); err != nil {
+			return fmt.Errorf("storage trie of %x: %v", contract, err)
+		}
+	}
+	if err := storage.checkConsumed(); err != nil {
+		return fmt.Errorf("storage tries: %v", err)
+	}
+	return nil
+}
+
+// proofCursor tracks the position in the items of one kind of tries of the
+// block proof, while its structure is checked.
+type proofCursor struct {
+	masks  []uint16
+	keys   [][]byte
+	values [][]byte
+	hashes []common.Hash
+
+	maskIdx, keyIdx, valueIdx, hashIdx int
+}
+
+func (c *proofCursor) nextMask() (uint16, error) {
+	if c.maskIdx >= len(c.masks) {
+		return 0, errors.New("out of masks")
+	}
+	c.maskIdx++
+	return c.masks[c.maskIdx-1], nil
+}
+
+func (c *proofCursor) nextHash() error {
+	if c.hashIdx >= len(c.hashes) {
+		return errors.New("out of hashes")
+	}
+	c.hashIdx++
+	return nil
+}
+
+func (c *proofCursor) checkTrie() error {
+	mask, err := c.nextMask()
+	if err != nil {
+		return err
+	}
+	if mask == 0 {
+		return c.checkFullNode(0)
+	}
+	return c.checkShortNode(0)
+}
+
+// checkFullNode mirrors trie.constructFullNode. The full nodes are not allowed
+// at the depth of the last nibble of the keys, where they have no place.
+func (c *proofCursor) checkFullNode(pos int) error {
+	if pos >= 64 {
+		return fmt.Errorf("full node at depth %d", pos)
+	}
+	var masks [3]uint16
+	for i := range masks {
+		mask, err := c.nextMask()
+		if err != nil {
+			return err
+		}
+		masks[i] = mask
+	}
+	hashmask, fullnodemask, shortnodemask := masks[0], masks[1], masks[2]
+	for nibble := uint(0); nibble < 16; nibble++ {
+		if hashmask&(1<<nibble) != 0 {
+			if err := c.nextHash(); err != nil {
+				return err
+			}
+		}
+	}
+	for nibble := uint(0); nibble < 16; nibble++ {
+		if fullnodemask&(1<<nibble) != 0 {
+			if err := c.checkFullNode(pos + 1); err != nil {
+				return err
+			}
+		} else if shortnodemask&(1<<nibble) != 0 {
+			if err := c.checkShortNode(pos + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkShortNode mirrors trie.constructShortNode.
+func (c *proofCursor) checkShortNode(pos int) error {
+	downmask, err := c.nextMask()
+	if err != nil {
+		return err
+	}
+	if c.keyIdx >= len(c.keys) {
+		return errors.New("out of short keys")
+	}
+	key := c.keys[c.keyIdx]
+	c.keyIdx++
+	if len(key) == 0 || pos+len(key) > 65 {
+		return fmt.Errorf("short key %x at depth %d", key, pos)
+	}
+	for i, nibble := range key {
+		// Only the keys of the leaves end with the terminator
+		if nibble > 16 || (nibble == 16) != (i == len(key)-1 && pos+len(key) == 65) {
+			return fmt.Errorf("short key %x at depth %d", key, pos)
+		}
+	}
+	if pos+len(key) == 65 {
+		if c.valueIdx >= len(c.values) {
+			return errors.New("out of values")
+		}
+		c.valueIdx++
+		return nil
+	}
+	switch downmask {
+	case 0, 4:
+		return c.nextHash()
+	case 1, 6:
+		return c.checkFullNode(pos + len(key))
+	default:
+		return fmt.Errorf("short node mask %d", downmask)
+	}
+}
+
+func (c *proofCursor) checkConsumed() error {
+	if c.maskIdx != len(c.masks) || c.keyIdx != len(c.keys) || c.valueIdx != len(c.values) || c.hashIdx != len(c.hashes) {
+		return fmt.Errorf("unused items: %d masks, %d short keys, %d values, %d hashes",
+			len(c.masks)-c.maskIdx, len(c.keys)-c.keyIdx, len(c.values)-c.valueIdx, len(c.hashes)-c.hashIdx)
+	}
+	return nil
+}
+
+/* Proof Of Concept for verification of Stateless client proofs */
+type CodeTime struct {
+	bytecode []byte
+	t        uint64
+}
+
+type Stateless struct {
+	blockNr        uint64
+	t              *trie.Trie
+	storageTries   map[common.Hash]*trie.Trie
+	codeMap        map[common.Hash]CodeTime
+	timeToCodeHash map[uint64]map[common.Hash]struct{}
+	trace          bool
+	storageUpdates map[common.Address]map[common.Hash][]byte
+	accountUpdates map[common.Hash]*Account
+	deleted        map[common.Hash]struct{}
+}
+
+func NewStateless(stateRoot common.Hash,
+	blockProof BlockProof,
+	blockNr uint64,
+	trace bool,
+) (*Stateless, error) {
+	t, storageTries, err := blockProof.buildTries(stateRoot, blockNr, trace)
+	if err != nil {
+		return nil, err
+	}
+	h := newHasher()
+	defer returnHasherToPool(h)
 	codeMap := make(map[common.Hash]CodeTime)
 	timeToCodeHash := make(map[uint64]map[common.Hash]struct{})
 	var codeHash common.Hash
@@ -505,6 +678,15 @@ func (s *Stateless) CheckRoot(expected common.Hash, check bool) error {
 	return nil
 }
 
+// Root applies the pending updates to the tries and returns the resulting state
+// root, as needed for the receipts of pre-Byzantium blocks.
+func (s *Stateless) Root() (common.Hash, error) {
+	if err := s.CheckRoot(common.Hash{}, false); err != nil {
+		return common.Hash{}, err
+	}
+	return s.t.Hash(), nil
+}
+
 func (s *Stateless) UpdateAccountCode(codeHash common.Hash, code []byte) error {
 	//fmt.Printf("UpdateAccountCode\n")
 	s.touchCodeHash(codeHash, code, s.blockNr)
@@ -63,7 +63,7 @@ func makeTestState() (ethdb.Database, common.Hash, []*testAccount) {
 	}
 	root, _ := tds.IntermediateRoot(state, false)
 	tds.SetBlockNr(1)
-	state.Finalise(false, tds.DbStateWriter())
+	state.Commit(false, tds.DbStateWriter())
 
 	// Return the generated state
 	return diskdb, root, accounts
@@ -0,0 +1,214 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// maxMismatchKeys is the number of the keys listed for every mismatching subtrie.
+const maxMismatchKeys = 16
+
+// StateMismatch is a subtrie of the account trie, or of a storage trie, whose
+// hash computed from the flat buckets differs from the one expected.
+type StateMismatch struct {
+	Account  common.Hash    // Hash of the account owning the storage trie, zero for the account trie
+	Address  common.Address // Address of the account owning the storage trie, zero if not known
+	Path     []byte         // Hex path of the subtrie, one byte per nibble
+	Expected common.Hash    // Expected hash of the subtrie, zero if it should not exist
+	Computed common.Hash    // Hash computed from the buckets, zero if the subtrie is missing
+	Keys     []common.Hash  // First keys found in the bucket under the path
+}
+
+// VerifyStateResult is the outcome of VerifyState.
+type VerifyStateResult struct {
+	Root       common.Hash // State root computed from the AccountsBucket
+	Accounts   uint64      // Number of the accounts hashed
+	Storage    uint64      // Number of the storage items hashed
+	Mismatches []StateMismatch
+}
+
+type stateVerifier struct {
+	db         ethdb.Database
+	blockNr    uint64
+	historical bool
+	result     *VerifyStateResult
+
+	start  time.Time
+	logged time.Time
+}
+
+// VerifyState checks that the flat state after the given block hashes to the
+// root. The accounts and the storage are streamed from the buckets through
+// trie.StreamHasher, so the tries are never held in memory. For the historical
+// state the buckets are read as of the block, otherwise the mismatching tries
+// are narrowed down to the subtries that differ from the intermediate hashes.
+// The storage tries are checked against the roots in the accounts, so they are
+// verified even when the state root matches.
+func VerifyState(db ethdb.Database, root common.Hash, blockNr uint64, historical bool) (*VerifyStateResult, error) {
+	if historical {
+		if err := ethdb.CheckHistoryHorizon(db, blockNr+1); err != nil {
+			return nil, err
+		}
+	}
+	v := &stateVerifier{
+		db:         db,
+		blockNr:    blockNr,
+		historical: historical,
+		result:     &VerifyStateResult{},
+		start:      time.Now(),
+		logged:     time.Now(),
+	}
+	hasher := v.newHasher(false)
+	if err := v.walk(AccountsBucket, AccountsHistoryBucket, make([]byte, common.HashLength), 0, func(k, value []byte) (bool, error) {
+		account, err := encodingToAccount(value)
+		if err != nil || account == nil {
+			return false, fmt.Errorf("invalid account %x: %v", k, err)
+		}
+		enc, err := rlp.EncodeToBytes(account)
+		if err != nil {
+			return false, err
+		}
+		if err := hasher.Add(k, enc); err != nil {
+			return false, err
+		}
+		v.result.Accounts++
+		if account.Root != emptyRoot && account.Root != (common.Hash{}) {
+			if err := v.verifyStorage(common.BytesToHash(k), account.Root); err != nil {
+				return false, err
+			}
+		}
+		if time.Since(v.logged) > 8*time.Second {
+			log.Info("Verifying state", "accounts", v.result.Accounts, "storage", v.result.Storage, "elapsed", common.PrettyDuration(time.Since(v.start)))
+			v.logged = time.Now()
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	v.result.Root = hasher.Hash()
+	if err := v.compare(hasher, common.Hash{}, common.Address{}, root); err != nil {
+		return nil, err
+	}
+	return v.result, nil
+}
+
+// newHasher creates the hasher of a trie, which keeps the branch nodes for
+// the comparison with the intermediate hashes of the current state.
+func (v *stateVerifier) newHasher(encodeToBytes bool) *trie.StreamHasher {
+	hasher := trie.NewStreamHasher(encodeToBytes)
+	if !v.historical {
+		hasher.KeepBranches(trie.IntermediateHashLevels)
+	}
+	return hasher
+}
+
+// walk walks the bucket, or its state as of the block for the historical state,
+// skipping the deleted keys. The startkey has to be of the full length of the keys.
+func (v *stateVerifier) walk(bucket, hBucket, startkey []byte, fixedbits uint, walker func(k, value []byte) (bool, error)) error {
+	skipDeleted := func(k, value []byte) (bool, error) {
+		if len(value) == 0 {
+			return true, nil
+		}
+		return walker(k, value)
+	}
+	if v.historical {
+		return v.db.WalkAsOf(bucket, hBucket, startkey, fixedbits, v.blockNr+1, skipDeleted)
+	}
+	return v.db.Walk(bucket, startkey, fixedbits, skipDeleted)
+}
+
+// verifyStorage hashes the storage of the account and compares it with the
+// storage root of the account.
+func (v *stateVerifier) verifyStorage(addrHash, root common.Hash) error {
+	preimage, _ := v.db.Get(trie.SecureKeyPrefix, addrHash[:])
+	if len(preimage) != common.AddressLength {
+		// Without the address the storage can not be found in the bucket
+		v.result.Mismatches = append(v.result.Mismatches, StateMismatch{Account: addrHash, Expected: root})
+		return nil
+	}
+	address := common.BytesToAddress(preimage)
+	hasher := v.newHasher(true)
+	startkey := append(address.Bytes(), make([]byte, common.HashLength)...)
+	if err := v.walk(StorageBucket, StorageHistoryBucket, startkey, 8*common.AddressLength, func(k, value []byte) (bool, error) {
+		if err := hasher.Add(k[common.AddressLength:], value); err != nil {
+			return false, err
+		}
+		v.result.Storage++
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	return v.compare(hasher, addrHash, address, root)
+}
+
+// compare records the subtries of the hashed trie that differ from the trie with
+// the expected root. The account trie is given by the zero account hash.
+func (v *stateVerifier) compare(hasher *trie.StreamHasher, addrHash common.Hash, address common.Address, root common.Hash) error {
+	computed := hasher.Hash()
+	if computed == root {
+		return nil
+	}
+	bucket, hBucket, prefix := AccountsBucket, AccountsHistoryBucket, []byte(nil)
+	if addrHash != (common.Hash{}) {
+		bucket, hBucket, prefix = StorageBucket, StorageHistoryBucket, address[:]
+	}
+	// The intermediate hashes only describe the current state
+	subtries := []trie.SubtrieMismatch{{Expected: root, Computed: computed}}
+	if !v.historical {
+		subtries = hasher.Mismatches(v.db, prefix, root)
+	}
+	for _, subtrie := range subtries {
+		keys, err := v.keys(bucket, hBucket, prefix, subtrie.Path)
+		if err != nil {
+			return err
+		}
+		v.result.Mismatches = append(v.result.Mismatches, StateMismatch{
+			Account:  addrHash,
+			Address:  address,
+			Path:     subtrie.Path,
+			Expected: subtrie.Expected,
+			Computed: subtrie.Computed,
+			Keys:     keys,
+		})
+	}
+	return nil
+}
+
+// keys returns the first keys of the trie with the prefix under the hex path.
+func (v *stateVerifier) keys(bucket, hBucket, prefix, path []byte) ([]common.Hash, error) {
+	startkey := make([]byte, len(prefix)+common.HashLength)
+	copy(startkey, prefix)
+	for i, nibble := range path {
+		startkey[len(prefix)+i/2] |= nibble << (4 * uint(1-i%2))
+	}
+	var keys []common.Hash
+	if err := v.walk(bucket, hBucket, startkey, uint(8*len(prefix)+4*len(path)), func(k, _ []byte) (bool, error) {
+		keys = append(keys, common.BytesToHash(k[len(prefix):]))
+		return len(keys) < maxMismatchKeys, nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestVerifyState(t *testing.T) {
+	db, roots := newRangeServer(t)
+	for blockNr := uint64(2); blockNr <= 3; blockNr++ {
+		result, err := VerifyState(db, roots[blockNr], blockNr, blockNr < 3)
+		if err != nil {
+			t.Fatalf("block %d: %v", blockNr, err)
+		}
+		if result.Root != roots[blockNr] || len(result.Mismatches) != 0 {
+			t.Errorf("block %d: root %x, mismatches %v", blockNr, result.Root, result.Mismatches)
+		}
+		if result.Accounts != 320 || result.Storage != 1000 {
+			t.Errorf("block %d: %d accounts and %d storage items hashed", blockNr, result.Accounts, result.Storage)
+		}
+	}
+	// Tamper with an account and with a storage item of another contract
+	account := crypto.Keccak256Hash(common.BytesToAddress([]byte{7, 0, 10}).Bytes())
+	if err := db.Put(AccountsBucket, account[:], []byte{0xc0}); err != nil {
+		t.Fatal(err)
+	}
+	contract := common.BytesToAddress([]byte{5, 20})
+	key := crypto.Keccak256Hash(common.BytesToHash([]byte{9}).Bytes())
+	if err := db.Put(StorageBucket, append(contract.Bytes(), key[:]...), []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := VerifyState(db, roots[3], 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Root == roots[3] || len(result.Mismatches) != 2 {
+		t.Fatalf("root %x, mismatches %v", result.Root, result.Mismatches)
+	}
+	found := func(m StateMismatch, key common.Hash) bool {
+		for _, k := range m.Keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range result.Mismatches {
+		switch m.Account {
+		case common.Hash{}:
+			if len(m.Path) == 0 || !found(m, account) {
+				t.Errorf("account mismatch at %x with keys %x", m.Path, m.Keys)
+			}
+		case crypto.Keccak256Hash(contract[:]):
+			if m.Address != contract || !found(m, key) {
+				t.Errorf("storage mismatch of %x at %x with keys %x", m.Address, m.Path, m.Keys)
+			}
+		default:
+			t.Errorf("unexpected mismatch %+v", m)
+		}
+	}
+}
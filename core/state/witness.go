@@ -0,0 +1,360 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Block proofs (witnesses) are exchanged and stored in the following format,
+// in which every count, length and mask is an unsigned varint:
+//
+//   proof     = version accounts contracts storage codes
+//   version   = byte, currently BlockProofVersion
+//   accounts  = trie                      the account trie (Masks, ShortKeys, Values, Hashes)
+//   contracts = count address*            20 bytes each
+//   storage   = trie                      the storage tries of the contracts, one after another
+//   codes     = count (length bytes)*     distinct contract codes
+//   trie      = masks keys values hashes
+//   masks     = count mask*
+//   keys      = count (header nibbles)*   header is nibble count << 1 | terminator flag
+//   values    = count (length bytes)*
+//   hashes    = count hash*               32 bytes each
+//
+// The nibbles of the short keys are packed two per byte, the high nibble first,
+// with the odd key padded by a zero nibble, and the terminator (the nibble 16
+// ending the keys of the leaves) is kept in the header. The masks of the short
+// nodes take one byte, and the hashes are written without any framing. The
+// proofs follow each other without separators, so several of them can be read
+// from one stream.
+
+// BlockProofVersion is the version of the block proof encoding written by the
+// encoder. The decoder rejects the other versions.
+const BlockProofVersion = 1
+
+const (
+	maxProofItems    = 1 << 24 // Maximum number of items in one list of the proof
+	maxProofItemSize = 1 << 24 // Maximum size of one value or code of the proof
+)
+
+var (
+	// ErrUnknownProofVersion is returned by the decoder if the proof is encoded
+	// with a version it does not know.
+	ErrUnknownProofVersion = errors.New("unknown block proof version")
+
+	errInvalidProofKey = errors.New("invalid block proof key")
+	errProofTooLarge   = errors.New("block proof too large")
+	errProofTrailing   = errors.New("trailing data after block proof")
+)
+
+// BlockProofEncoder writes block proofs to the output stream.
+type BlockProofEncoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewBlockProofEncoder returns the encoder writing to w.
+func NewBlockProofEncoder(w io.Writer) *BlockProofEncoder {
+	return &BlockProofEncoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes the block proof to the stream. The duplicates of the codes
+// are left out.
+func (e *BlockProofEncoder) Encode(bp *BlockProof) error {
+	if err := e.w.WriteByte(BlockProofVersion); err != nil {
+		return err
+	}
+	if err := e.writeTrie(bp.Masks, bp.ShortKeys, bp.Values, bp.Hashes); err != nil {
+		return err
+	}
+	e.writeUint(uint64(len(bp.Contracts)))
+	for _, contract := range bp.Contracts {
+		e.w.Write(contract[:])
+	}
+	if err := e.writeTrie(bp.CMasks, bp.CShortKeys, bp.CValues, bp.CHashes); err != nil {
+		return err
+	}
+	codes := make([][]byte, 0, len(bp.Codes))
+	seen := make(map[common.Hash]struct{}, len(bp.Codes))
+	for _, code := range bp.Codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := seen[hash]; !ok {
+			seen[hash] = struct{}{}
+			codes = append(codes, code)
+		}
+	}
+	e.writeBytesList(codes)
+	return e.w.Flush()
+}
+
+func (e *BlockProofEncoder) writeTrie(masks []uint16, keys [][]byte, values [][]byte, hashes []common.Hash) error {
+	e.writeUint(uint64(len(masks)))
+	for _, mask := range masks {
+		e.writeUint(uint64(mask))
+	}
+	e.writeUint(uint64(len(keys)))
+	for _, key := range keys {
+		if err := e.writeKey(key); err != nil {
+			return err
+		}
+	}
+	e.writeBytesList(values)
+	e.writeUint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		e.w.Write(hash[:])
+	}
+	return nil
+}
+
+// writeKey packs the nibbles of the short key.
+func (e *BlockProofEncoder) writeKey(key []byte) error {
+	var term uint64
+	if len(key) > 0 && key[len(key)-1] == 16 {
+		key, term = key[:len(key)-1], 1
+	}
+	for _, nibble := range key {
+		if nibble > 15 {
+			return errInvalidProofKey
+		}
+	}
+	e.writeUint(uint64(len(key))<<1 | term)
+	for i := 0; i < len(key); i += 2 {
+		b := key[i] << 4
+		if i+1 < len(key) {
+			b |= key[i+1]
+		}
+		e.w.WriteByte(b)
+	}
+	return nil
+}
+
+func (e *BlockProofEncoder) writeBytesList(list [][]byte) {
+	e.writeUint(uint64(len(list)))
+	for _, b := range list {
+		e.writeUint(uint64(len(b)))
+		e.w.Write(b)
+	}
+}
+
+func (e *BlockProofEncoder) writeUint(n uint64) {
+	l := binary.PutUvarint(e.buf[:], n)
+	e.w.Write(e.buf[:l])
+}
+
+// proofReader is the input of the block proof decoder.
+type proofReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// BlockProofDecoder reads block proofs from the input stream.
+type BlockProofDecoder struct {
+	r proofReader
+}
+
+// NewBlockProofDecoder returns the decoder reading from r. The decoder may
+// read past the end of the proofs if r is not an io.ByteReader.
+func NewBlockProofDecoder(r io.Reader) *BlockProofDecoder {
+	if br, ok := r.(proofReader); ok {
+		return &BlockProofDecoder{r: br}
+	}
+	return &BlockProofDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next block proof from the stream. It returns io.EOF if the
+// stream ends before the proof, and io.ErrUnexpectedEOF if it ends within.
+func (d *BlockProofDecoder) Decode(bp *BlockProof) error {
+	version, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != BlockProofVersion {
+		return ErrUnknownProofVersion
+	}
+	var proof BlockProof
+	if err := d.decode(&proof); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	*bp = proof
+	return nil
+}
+
+func (d *BlockProofDecoder) decode(bp *BlockProof) (err error) {
+	if bp.Masks, bp.ShortKeys, bp.Values, bp.Hashes, err = d.readTrie(); err != nil {
+		return err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var contract common.Address
+		if _, err := io.ReadFull(d.r, contract[:]); err != nil {
+			return err
+		}
+		bp.Contracts = append(bp.Contracts, contract)
+	}
+	if bp.CMasks, bp.CShortKeys, bp.CValues, bp.CHashes, err = d.readTrie(); err != nil {
+		return err
+	}
+	bp.Codes, err = d.readBytesList()
+	return err
+}
+
+func (d *BlockProofDecoder) readTrie() (masks []uint16, keys [][]byte, values [][]byte, hashes []common.Hash, err error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for i := 0; i < n; i++ {
+		mask, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if mask > 0xffff {
+			return nil, nil, nil, nil, fmt.Errorf("block proof mask %d out of range", mask)
+		}
+		masks = append(masks, uint16(mask))
+	}
+	if n, err = d.readCount(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.readKey()
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		keys = append(keys, key)
+	}
+	if values, err = d.readBytesList(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if n, err = d.readCount(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	for i := 0; i < n; i++ {
+		var hash common.Hash
+		if _, err := io.ReadFull(d.r, hash[:]); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return masks, keys, values, hashes, nil
+}
+
+// readKey unpacks the nibbles of the short key.
+func (d *BlockProofDecoder) readKey() ([]byte, error) {
+	header, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	// Keys are at most 64 nibbles long, the format does not need more
+	if header>>1 > 64 {
+		return nil, errInvalidProofKey
+	}
+	n := int(header >> 1)
+	packed := make([]byte, (n+1)/2)
+	if _, err := io.ReadFull(d.r, packed); err != nil {
+		return nil, err
+	}
+	key := make([]byte, n, n+1)
+	for i := range key {
+		if i%2 == 0 {
+			key[i] = packed[i/2] >> 4
+		} else {
+			key[i] = packed[i/2] & 0x0f
+		}
+	}
+	// The padding nibble must be zero, so that every key has one encoding
+	if n%2 == 1 && packed[n/2]&0x0f != 0 {
+		return nil, errInvalidProofKey
+	}
+	if header&1 == 1 {
+		key = append(key, 16)
+	}
+	return key, nil
+}
+
+func (d *BlockProofDecoder) readBytesList() ([][]byte, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for i := 0; i < n; i++ {
+		size, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxProofItemSize {
+			return nil, errProofTooLarge
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, nil
+}
+
+func (d *BlockProofDecoder) readCount() (int, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxProofItems {
+		return 0, errProofTooLarge
+	}
+	return int(n), nil
+}
+
+// EncodeBlockProof returns the encoding of the block proof.
+func EncodeBlockProof(bp *BlockProof) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewBlockProofEncoder(&buf).Encode(bp); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeBlockProof decodes the block proof, which must take all of the input.
+func DecodeBlockProof(enc []byte) (*BlockProof, error) {
+	r := bytes.NewReader(enc)
+	bp := new(BlockProof)
+	if err := NewBlockProofDecoder(r).Decode(bp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, errProofTrailing
+	}
+	return bp, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package state
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Fuzz implements a go-fuzz fuzzer method to test the block proof decoding,
+// reencoding and verification.
+func Fuzz(data []byte) int {
+	bp, err := DecodeBlockProof(data)
+	if err != nil {
+		return 0
+	}
+	// The proof must survive the round trip, apart from the duplicate codes
+	enc, err := EncodeBlockProof(bp)
+	if err != nil {
+		panic(err)
+	}
+	dec, err := DecodeBlockProof(enc)
+	if err != nil {
+		panic(err)
+	}
+	reenc, err := EncodeBlockProof(dec)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(enc, reenc) {
+		panic("encoding mismatch")
+	}
+	dec.Codes, bp.Codes = nil, nil
+	if !reflect.DeepEqual(bp, dec) {
+		panic("content mismatch")
+	}
+	// Arbitrary proofs must be rejected, not crash the verification
+	bp.Verify(common.Hash{})
+	return 1
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// randomProof fills the lists of a block proof with random items, which follow
+// the format but not the structure of the tries.
+func randomProof(r *rand.Rand) *BlockProof {
+	bp := new(BlockProof)
+	for i := r.Intn(4); i > 0; i-- {
+		var contract common.Address
+		r.Read(contract[:])
+		bp.Contracts = append(bp.Contracts, contract)
+	}
+	bp.Masks, bp.ShortKeys, bp.Values, bp.Hashes = randomTrieItems(r)
+	bp.CMasks, bp.CShortKeys, bp.CValues, bp.CHashes = randomTrieItems(r)
+	for i := r.Intn(4); i > 0; i-- {
+		code := make([]byte, 1+r.Intn(64))
+		r.Read(code)
+		bp.Codes = append(bp.Codes, code)
+	}
+	return bp
+}
+
+func randomTrieItems(r *rand.Rand) (masks []uint16, keys [][]byte, values [][]byte, hashes []common.Hash) {
+	for i := r.Intn(16); i > 0; i-- {
+		masks = append(masks, uint16(r.Intn(1<<16)))
+	}
+	for i := r.Intn(8); i > 0; i-- {
+		key := make([]byte, r.Intn(65))
+		for j := range key {
+			key[j] = byte(r.Intn(16))
+		}
+		if r.Intn(2) == 0 {
+			key = append(key, 16)
+		}
+		keys = append(keys, key)
+	}
+	for i := r.Intn(8); i > 0; i-- {
+		value := make([]byte, r.Intn(100))
+		r.Read(value)
+		values = append(values, value)
+	}
+	for i := r.Intn(8); i > 0; i-- {
+		var hash common.Hash
+		r.Read(hash[:])
+		hashes = append(hashes, hash)
+	}
+	return masks, keys, values, hashes
+}
+
+func TestBlockProofEncodingRandom(t *testing.T) {
+	check := func(seed int64) bool {
+		bp := randomProof(rand.New(rand.NewSource(seed)))
+		enc, err := EncodeBlockProof(bp)
+		if err != nil {
+			t.Logf("seed %d: failed to encode: %v", seed, err)
+			return false
+		}
+		dec, err := DecodeBlockProof(enc)
+		if err != nil {
+			t.Logf("seed %d: failed to decode: %v", seed, err)
+			return false
+		}
+		if !reflect.DeepEqual(bp, dec) {
+			t.Logf("seed %d: proof mismatch:\nhave %+v\nwant %+v", seed, dec, bp)
+			return false
+		}
+		// Random proofs are not well formed, but must not break the verification
+		if dec.Verify(common.Hash{}) == nil {
+			t.Logf("seed %d: random proof verified", seed)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlockProofEncodingStream(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	proofs := []*BlockProof{randomProof(r), new(BlockProof), randomProof(r)}
+
+	var buf bytes.Buffer
+	enc := NewBlockProofEncoder(&buf)
+	for _, bp := range proofs {
+		if err := enc.Encode(bp); err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+	}
+	dec := NewBlockProofDecoder(&buf)
+	for i, want := range proofs {
+		have := new(BlockProof)
+		if err := dec.Decode(have); err != nil {
+			t.Fatalf("proof %d: failed to decode: %v", i, err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("proof %d: mismatch:\nhave %+v\nwant %+v", i, have, want)
+		}
+	}
+	if err := dec.Decode(new(BlockProof)); err != io.EOF {
+		t.Errorf("error mismatch at the end of the stream: have %v, want %v", err, io.EOF)
+	}
+}
+
+func TestBlockProofEncodingDedupCodes(t *testing.T) {
+	code := []byte{0x60, 0x00}
+	bp := &BlockProof{Codes: [][]byte{code, {0x00}, code}}
+	enc, err := EncodeBlockProof(bp)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	dec, err := DecodeBlockProof(enc)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if want := [][]byte{code, {0x00}}; !reflect.DeepEqual(dec.Codes, want) {
+		t.Errorf("codes mismatch: have %x, want %x", dec.Codes, want)
+	}
+}
+
+func TestBlockProofEncodingErrors(t *testing.T) {
+	if _, err := EncodeBlockProof(&BlockProof{ShortKeys: [][]byte{{1, 16, 2}}}); err != errInvalidProofKey {
+		t.Errorf("invalid key: error mismatch: have %v, want %v", err, errInvalidProofKey)
+	}
+	enc, err := EncodeBlockProof(randomProof(rand.New(rand.NewSource(2))))
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	for i := 0; i < len(enc); i++ {
+		if _, err := DecodeBlockProof(enc[:i]); err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated to %d bytes: error mismatch: have %v, want %v", i, err, io.ErrUnexpectedEOF)
+		}
+	}
+	if _, err := DecodeBlockProof(append(enc, 0)); err != errProofTrailing {
+		t.Errorf("trailing data: error mismatch: have %v, want %v", err, errProofTrailing)
+	}
+	if _, err := DecodeBlockProof(append([]byte{BlockProofVersion + 1}, enc[1:]...)); err != ErrUnknownProofVersion {
+		t.Errorf("unknown version: error mismatch: have %v, want %v", err, ErrUnknownProofVersion)
+	}
+}
+
+// testProof returns the proof of the account trie with two accounts, whose
+// keys differ in the first nibble, together with the root of the trie.
+func testProof(t *testing.T) (*BlockProof, common.Hash) {
+	var (
+		key1   = bytes.Repeat([]byte{0x11}, 32)
+		key2   = bytes.Repeat([]byte{0x22}, 32)
+		value1 = []byte{0x01}
+		value2 = []byte{0x02}
+	)
+	tr := trie.New(common.Hash{}, AccountsBucket, nil, false)
+	tr.Update(nil, key1, value1, 0)
+	tr.Update(nil, key2, value2, 0)
+
+	leafKey := func(b byte) []byte {
+		key := bytes.Repeat([]byte{b}, 63)
+		return append(key, 16)
+	}
+	return &BlockProof{
+		Masks:     []uint16{0, 0, 0, 1<<1 | 1<<2, 0, 0},
+		ShortKeys: [][]byte{leafKey(1), leafKey(2)},
+		Values:    [][]byte{value1, value2},
+	}, tr.Hash()
+}
+
+func TestBlockProofVerify(t *testing.T) {
+	bp, root := testProof(t)
+	if err := bp.Verify(root); err != nil {
+		t.Fatalf("failed to verify the proof: %v", err)
+	}
+	if err := bp.Verify(crypto.Keccak256Hash(root[:])); err == nil {
+		t.Errorf("proof verified against the wrong root")
+	}
+	tests := []struct {
+		name   string
+		mutate func(bp *BlockProof)
+	}{
+		{"no masks", func(bp *BlockProof) { bp.Masks = nil }},
+		{"missing mask", func(bp *BlockProof) { bp.Masks = bp.Masks[:5] }},
+		{"extra mask", func(bp *BlockProof) { bp.Masks = append(bp.Masks, 0) }},
+		{"missing key", func(bp *BlockProof) { bp.ShortKeys = bp.ShortKeys[:1] }},
+		{"missing value", func(bp *BlockProof) { bp.Values = bp.Values[:1] }},
+		{"extra hash", func(bp *BlockProof) { bp.Hashes = append(bp.Hashes, common.Hash{}) }},
+		{"missing hash", func(bp *BlockProof) { bp.Masks[1] = 1 }},
+		{"short key", func(bp *BlockProof) { bp.ShortKeys[0] = bp.ShortKeys[0][1:] }},
+		{"long key", func(bp *BlockProof) { bp.ShortKeys[0] = append([]byte{1}, bp.ShortKeys[0]...) }},
+		{"empty key", func(bp *BlockProof) { bp.ShortKeys[0] = nil }},
+		{"no terminator", func(bp *BlockProof) { bp.ShortKeys[0][62], bp.ShortKeys[0][63] = 16, 1 }},
+		{"invalid nibble", func(bp *BlockProof) { bp.ShortKeys[0][10] = 17 }},
+		{"invalid short mask", func(bp *BlockProof) {
+			bp.ShortKeys[0] = bp.ShortKeys[0][:10]
+			bp.Masks[4] = 3
+		}},
+		{"missing storage", func(bp *BlockProof) { bp.Contracts = []common.Address{{1}} }},
+	}
+	for _, tt := range tests {
+		bp, root := testProof(t)
+		tt.mutate(bp)
+		if err := bp.Verify(root); err == nil {
+			t.Errorf("%s: malformed proof verified", tt.name)
+		}
+	}
+}
@@ -118,17 +118,33 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, tds
 		allLogs  []*types.Log
 		gp       = new(GasPool).AddGas(block.GasLimit())
 	)
-	// Mutate the block and state according to any hard-fork specs
+	// Record the changes of the transactions if asked to
+	var stateWriter state.StateWriter = tds.TrieStateWriter()
+	diffWriter := tds.StateDiffWriter()
+	if diffWriter != nil {
+		stateWriter = diffWriter
+	}
+	// Mutate the block and state according to any hard-fork specs, leaving the
+	// changes out of the diff of the first transaction
 	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 		misc.ApplyDAOHardFork(statedb)
+		if diffWriter != nil {
+			if err := statedb.Finalise(p.config.IsEIP158(header.Number), diffWriter); err != nil {
+				return nil, nil, 0, err
+			}
+			diffWriter.DiscardTx()
+		}
 	}
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
 		statedb.Prepare(tx.Hash(), block.Hash(), i)
-		receipt, _, err := ApplyTransaction(p.config, p.bc, nil, gp, statedb, tds.TrieStateWriter(), header, tx, usedGas, cfg)
+		receipt, _, err := ApplyTransaction(p.config, p.bc, nil, gp, statedb, stateWriter, header, tx, usedGas, cfg)
 		if err != nil {
 			return nil, nil, 0, err
 		}
+		if diffWriter != nil {
+			diffWriter.FinishTx(statedb)
+		}
 		if !p.config.IsByzantium(header.Number) {
 			rootHash, err := tds.TrieRoot()
 			if err != nil {
@@ -17,6 +17,7 @@
 package eth
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"errors"
@@ -281,7 +282,7 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 	if block == nil {
 		return state.Dump{}, fmt.Errorf("block #%d not found", blockNr)
 	}
-	_, tds, err := api.eth.BlockChain().StateAt(block.Root(), uint64(blockNr))
+	_, tds, err := api.eth.BlockChain().StateAt(block.Root(), block.NumberU64())
 	if err != nil {
 		return state.Dump{}, err
 	}
@@ -358,7 +359,15 @@ func (api *PrivateDebugAPI) StorageRangeAt(ctx context.Context, blockHash common
 	if block == nil {
 		return StorageRangeResult{}, fmt.Errorf("block %x not found", blockHash)
 	}
-	_, _, _, dbstate, _, err := api.computeTxEnv(blockHash, txIndex, 0)
+	// Read the account and its storage from the same snapshot, so that a block
+	// committed in between does not make them inconsistent
+	snap, err := api.eth.ChainDb().Begin()
+	if err != nil {
+		return StorageRangeResult{}, err
+	}
+	defer snap.Close()
+
+	_, _, _, dbstate, _, err := api.computeTxEnv(snap, blockHash, txIndex, 0)
 	if err != nil {
 		return StorageRangeResult{}, err
 	}
@@ -389,6 +398,82 @@ func storageRangeAt(dbstate *state.DbState, contractAddress common.Address, star
 	return result, nil
 }
 
+// AccountDiffResult is the change of an account by a transaction, as returned
+// by debug_getStateDiff.
+type AccountDiffResult struct {
+	Address common.Address      `json:"address"`
+	Pre     *AccountStateResult `json:"pre"`  // nil if the account did not exist
+	Post    *AccountStateResult `json:"post"` // nil if the account was deleted
+	Storage []StorageDiffResult `json:"storage"`
+}
+
+// AccountStateResult is an account before or after a transaction. The code is
+// only given if the transaction has changed it.
+type AccountStateResult struct {
+	Balance  *hexutil.Big   `json:"balance"`
+	Nonce    hexutil.Uint64 `json:"nonce"`
+	CodeHash common.Hash    `json:"codeHash"`
+	Code     hexutil.Bytes  `json:"code,omitempty"`
+}
+
+// StorageDiffResult is the change of a storage item by a transaction.
+type StorageDiffResult struct {
+	Key  common.Hash `json:"key"`
+	Pre  common.Hash `json:"pre"`
+	Post common.Hash `json:"post"`
+}
+
+// GetStateDiff returns the changes made to the state by the transaction of the
+// block, with the values before and after it. The changes are recorded while
+// the blocks are imported with the state diffs turned on, nothing is executed.
+func (api *PrivateDebugAPI) GetStateDiff(ctx context.Context, number rpc.BlockNumber, txIndex uint64) ([]*AccountDiffResult, error) {
+	var block *types.Block
+	if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
+		block = api.eth.blockchain.CurrentBlock()
+	} else {
+		block = api.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	diff, err := api.eth.APIBackend.GetStateDiff(ctx, block.Hash(), txIndex)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*AccountDiffResult, len(diff))
+	for i, d := range diff {
+		results[i] = &AccountDiffResult{
+			Address: d.Address,
+			Storage: make([]StorageDiffResult, len(d.Storage)),
+		}
+		codeChanged := d.Pre == nil || d.Post == nil || !bytes.Equal(d.Pre.CodeHash, d.Post.CodeHash)
+		if d.Pre != nil {
+			results[i].Pre = newAccountStateResult(d.Pre)
+			if codeChanged {
+				results[i].Pre.Code, _ = api.eth.ChainDb().Get(state.CodeBucket, d.Pre.CodeHash)
+			}
+		}
+		if d.Post != nil {
+			results[i].Post = newAccountStateResult(d.Post)
+			if codeChanged {
+				results[i].Post.Code = d.Code
+			}
+		}
+		for j, s := range d.Storage {
+			results[i].Storage[j] = StorageDiffResult{Key: s.Key, Pre: s.Pre, Post: s.Post}
+		}
+	}
+	return results, nil
+}
+
+func newAccountStateResult(account *state.Account) *AccountStateResult {
+	return &AccountStateResult{
+		Balance:  (*hexutil.Big)(account.Balance),
+		Nonce:    hexutil.Uint64(account.Nonce),
+		CodeHash: common.BytesToHash(account.CodeHash),
+	}
+}
+
 // GetModifiedAccountsByNumber returns all accounts that have changed between the
 // two blocks specified. A change is defined as a difference in nonce, balance,
 // code hash, or storage hash.
@@ -18,6 +18,7 @@ package eth
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/accounts"
@@ -98,6 +99,9 @@ func (b *EthAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.
 	if header == nil || err != nil {
 		return nil, nil, err
 	}
+	if err := ethdb.CheckHistoryHorizon(b.eth.chainDb, header.Number.Uint64()+1); err != nil {
+		return nil, nil, err
+	}
 	ds := state.NewDbState(b.eth.chainDb, uint64(blockNr))
 	stateDb := state.New(ds)
 	return stateDb, header, nil
@@ -149,6 +153,22 @@ func (b *EthAPIBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*typ
 	return logs, nil
 }
 
+// GetStateDiff returns the changes made to the state by the transaction of the
+// block, as recorded when the block was imported.
+func (b *EthAPIBackend) GetStateDiff(ctx context.Context, hash common.Hash, index uint64) (state.StateDiff, error) {
+	diffs, err := b.eth.blockchain.GetStateDiffs(hash)
+	if err != nil {
+		return nil, err
+	}
+	if diffs == nil {
+		return nil, fmt.Errorf("state diffs of block %#x not stored", hash)
+	}
+	if index >= uint64(len(diffs)) {
+		return nil, fmt.Errorf("transaction index %d out of range for block %#x", index, hash)
+	}
+	return diffs[index], nil
+}
+
 func (b *EthAPIBackend) GetTd(blockHash common.Hash) *big.Int {
 	return b.eth.blockchain.GetTdByHash(blockHash)
 }
@@ -0,0 +1,106 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/eth/appearances"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// otsDefaultLimit is the number of transactions returned by a search when no
+	// limit is given.
+	otsDefaultLimit = 25
+
+	// otsMaxLimit is the maximum number of transactions returned by a search.
+	otsMaxLimit = 1000
+)
+
+var errAppearancesDisabled = errors.New("address appearance index not enabled (--appearances)")
+
+// PublicOtsAPI provides the searches over the address appearance index, which
+// finds the transactions touching an address in any of their call frames.
+type PublicOtsAPI struct {
+	eth *Ethereum
+}
+
+// NewPublicOtsAPI creates a new API definition for the ots methods of the
+// Ethereum service.
+func NewPublicOtsAPI(eth *Ethereum) *PublicOtsAPI {
+	return &PublicOtsAPI{eth: eth}
+}
+
+// TransactionsPage is a page of the transactions found by a search.
+type TransactionsPage struct {
+	Txs          []*ethapi.RPCTransaction `json:"txs"`
+	NextBlock    *hexutil.Uint64          `json:"nextBlock"`    // Block to continue the search from, nil if done
+	IndexedBlock hexutil.Uint64           `json:"indexedBlock"` // First block not indexed yet
+}
+
+// SearchTransactions returns the transactions in which the address appears as
+// the sender or the recipient of any call frame, in ascending order from the
+// given block on. A page holds the transactions of whole blocks, so it can
+// exceed the limit by the rest of the last block; the search continues from
+// NextBlock. Only the blocks of the complete sections of the index are searched.
+func (api *PublicOtsAPI) SearchTransactions(ctx context.Context, address common.Address, fromBlock rpc.BlockNumber, limit uint64) (*TransactionsPage, error) {
+	indexer := api.eth.appearanceIndexer
+	if indexer == nil {
+		return nil, errAppearancesDisabled
+	}
+	if fromBlock < 0 {
+		return nil, fmt.Errorf("invalid start block %d", fromBlock)
+	}
+	switch {
+	case limit == 0:
+		limit = otsDefaultLimit
+	case limit > otsMaxLimit:
+		limit = otsMaxLimit
+	}
+	sections, _, _ := indexer.Sections()
+	indexed := sections * appearances.SectionSize
+	page := &TransactionsPage{
+		Txs:          []*ethapi.RPCTransaction{},
+		IndexedBlock: hexutil.Uint64(indexed),
+	}
+	found, next, err := appearances.Search(ctx, api.eth.ChainDb(), address, uint64(fromBlock), indexed, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, appearance := range found {
+		block := api.eth.blockchain.GetBlockByNumber(appearance.Number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", appearance.Number)
+		}
+		for _, index := range appearance.Txs {
+			if tx := ethapi.NewRPCTransactionFromBlockIndex(block, index); tx != nil {
+				page.Txs = append(page.Txs, tx)
+			}
+		}
+	}
+	if next != 0 {
+		nextBlock := hexutil.Uint64(next)
+		page.NextBlock = &nextBlock
+	}
+	return page, nil
+}
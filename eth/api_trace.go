@@ -0,0 +1,409 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// PrivateTraceAPI is the collection of the trace_ APIs of Parity, served from
+// the historical state: the transactions of a block are executed on the state
+// as of its parent, read from the history, without re-executing any earlier
+// blocks.
+type PrivateTraceAPI struct {
+	config *params.ChainConfig
+	eth    *Ethereum
+}
+
+// NewPrivateTraceAPI creates a new API definition for the trace methods of the
+// Ethereum service.
+func NewPrivateTraceAPI(config *params.ChainConfig, eth *Ethereum) *PrivateTraceAPI {
+	return &PrivateTraceAPI{config: config, eth: eth}
+}
+
+// TraceFilterArgs are the arguments of trace_filter. The traces are matched by
+// the sender and by the recipient of the frames, an empty list matching any.
+type TraceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"`
+	Count       *uint64          `json:"count"`
+}
+
+// TraceResults is the outcome of a transaction replayed with trace_replay*. The
+// fields not asked for by the trace types are left empty.
+type TraceResults struct {
+	Output          hexutil.Bytes           `json:"output"`
+	StateDiff       tracers.ParityStateDiff `json:"stateDiff"`
+	Trace           []*tracers.ParityTrace  `json:"trace"`
+	VmTrace         *tracers.VmTrace        `json:"vmTrace"`
+	TransactionHash *common.Hash            `json:"transactionHash,omitempty"`
+}
+
+// replayedTx is a transaction of a block executed with a tracer.
+type replayedTx struct {
+	tx     *types.Transaction
+	index  uint64
+	output []byte
+	tracer *tracers.ParityTracer
+}
+
+// Block returns the traces of the call frames of all the transactions in the
+// block, followed by the traces of the mining rewards.
+func (api *PrivateTraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*tracers.ParityTrace, error) {
+	block, err := api.blockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	return api.blockTraces(ctx, block)
+}
+
+// Transaction returns the traces of the call frames of the transaction.
+func (api *PrivateTraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*tracers.ParityTrace, error) {
+	block, index, err := api.transactionBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := api.replayBlock(ctx, block, int(index), false, false)
+	if err != nil {
+		return nil, err
+	}
+	return replayed[0].tracer.Traces(), nil
+}
+
+// Filter returns the traces of the call frames and of the mining rewards in the
+// range of the blocks that match the addresses, skipping the first After of them
+// and returning at most Count. The range defaults to the latest block.
+func (api *PrivateTraceAPI) Filter(ctx context.Context, args TraceFilterArgs) ([]*tracers.ParityTrace, error) {
+	head := api.eth.blockchain.CurrentBlock().NumberU64()
+	from, to := head, head
+	if args.FromBlock != nil && *args.FromBlock >= 0 {
+		from = uint64(*args.FromBlock)
+	}
+	if args.ToBlock != nil && *args.ToBlock >= 0 {
+		to = uint64(*args.ToBlock)
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	var (
+		fromAddresses = make(map[common.Address]bool)
+		toAddresses   = make(map[common.Address]bool)
+		skip          uint64
+	)
+	for _, address := range args.FromAddress {
+		fromAddresses[address] = true
+	}
+	for _, address := range args.ToAddress {
+		toAddresses[address] = true
+	}
+	if args.After != nil {
+		skip = *args.After
+	}
+	traces := []*tracers.ParityTrace{}
+	for number := from; number <= to; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := api.eth.blockchain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		blockTraces, err := api.blockTraces(ctx, block)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range blockTraces {
+			sender, recipient := traceAddresses(trace)
+			if len(fromAddresses) > 0 && (sender == nil || !fromAddresses[*sender]) {
+				continue
+			}
+			if len(toAddresses) > 0 && !toAddresses[recipient] {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			traces = append(traces, trace)
+			if args.Count != nil && uint64(len(traces)) >= *args.Count {
+				return traces, nil
+			}
+		}
+	}
+	return traces, nil
+}
+
+// blockTraces returns the traces of the call frames of all the transactions in
+// the block, followed by the traces of the mining rewards.
+func (api *PrivateTraceAPI) blockTraces(ctx context.Context, block *types.Block) ([]*tracers.ParityTrace, error) {
+	replayed, err := api.replayBlock(ctx, block, -1, false, false)
+	if err != nil {
+		return nil, err
+	}
+	traces := []*tracers.ParityTrace{}
+	for _, r := range replayed {
+		traces = append(traces, r.tracer.Traces()...)
+	}
+	return append(traces, api.rewardTraces(block)...), nil
+}
+
+// rewardTraces returns the traces of the mining rewards of the block: the reward
+// of its miner first, then the rewards of the miners of its uncles. Only ethash
+// pays the rewards, there are none with the other consensus engines.
+func (api *PrivateTraceAPI) rewardTraces(block *types.Block) []*tracers.ParityTrace {
+	if _, ok := api.eth.engine.(*ethash.Ethash); !ok {
+		return nil
+	}
+	var (
+		hash                      = block.Hash()
+		number                    = block.NumberU64()
+		minerReward, uncleRewards = ethash.AccumulateRewards(api.config, block.Header(), block.Uncles())
+	)
+	reward := func(author common.Address, rewardType string, value *big.Int) *tracers.ParityTrace {
+		return &tracers.ParityTrace{
+			Action:       &tracers.ParityRewardAction{Author: author, RewardType: rewardType, Value: (*hexutil.Big)(value)},
+			BlockHash:    &hash,
+			BlockNumber:  &number,
+			TraceAddress: []int{},
+			Type:         "reward",
+		}
+	}
+	traces := []*tracers.ParityTrace{reward(block.Coinbase(), "block", minerReward)}
+	for i, uncle := range block.Uncles() {
+		traces = append(traces, reward(uncle.Coinbase, "uncle", uncleRewards[i]))
+	}
+	return traces
+}
+
+// ReplayBlockTransactions executes the transactions of the block, returning
+// for each the traces asked for by the trace types: "trace" for the call frames,
+// "vmTrace" for the executed code and "stateDiff" for the changed accounts.
+func (api *PrivateTraceAPI) ReplayBlockTransactions(ctx context.Context, number rpc.BlockNumber, traceTypes []string) ([]*TraceResults, error) {
+	trace, vmTrace, stateDiff, err := parseTraceTypes(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	block, err := api.blockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := api.replayBlock(ctx, block, -1, vmTrace, stateDiff)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*TraceResults, len(replayed))
+	for i, r := range replayed {
+		hash := r.tx.Hash()
+		results[i] = traceResults(r, trace, vmTrace, stateDiff)
+		results[i].TransactionHash = &hash
+	}
+	return results, nil
+}
+
+// ReplayTransaction executes the transaction, returning the traces asked for by
+// the trace types, as ReplayBlockTransactions does.
+func (api *PrivateTraceAPI) ReplayTransaction(ctx context.Context, hash common.Hash, traceTypes []string) (*TraceResults, error) {
+	trace, vmTrace, stateDiff, err := parseTraceTypes(traceTypes)
+	if err != nil {
+		return nil, err
+	}
+	block, index, err := api.transactionBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := api.replayBlock(ctx, block, int(index), vmTrace, stateDiff)
+	if err != nil {
+		return nil, err
+	}
+	return traceResults(replayed[0], trace, vmTrace, stateDiff), nil
+}
+
+// blockByNumber returns the block, or an error if it is not known.
+func (api *PrivateTraceAPI) blockByNumber(number rpc.BlockNumber) (*types.Block, error) {
+	var block *types.Block
+
+	switch number {
+	case rpc.PendingBlockNumber:
+		block = api.eth.miner.PendingBlock()
+	case rpc.LatestBlockNumber:
+		block = api.eth.blockchain.CurrentBlock()
+	default:
+		block = api.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	return block, nil
+}
+
+// transactionBlock returns the block including the transaction, and its index.
+func (api *PrivateTraceAPI) transactionBlock(hash common.Hash) (*types.Block, uint64, error) {
+	tx, blockHash, _, index := rawdb.ReadTransaction(api.eth.ChainDb(), hash)
+	if tx == nil {
+		return nil, 0, fmt.Errorf("transaction %#x not found", hash)
+	}
+	block := api.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return nil, 0, fmt.Errorf("block %#x not found", blockHash)
+	}
+	return block, index, nil
+}
+
+// replayBlock executes the transactions of the block on the state as of its
+// parent, tracing either all of them or only the one at txIndex, after which it
+// stops. The traces of the call frames are labelled with the transactions.
+func (api *PrivateTraceAPI) replayBlock(ctx context.Context, block *types.Block, txIndex int, vmTrace, stateDiff bool) ([]*replayedTx, error) {
+	if block.NumberU64() == 0 {
+		return nil, fmt.Errorf("genesis is not traceable")
+	}
+	parent := api.eth.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	snap, err := api.eth.ChainDb().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	if err := ethdb.CheckHistoryHorizon(snap, parent.NumberU64()+1); err != nil {
+		return nil, err
+	}
+	var (
+		dbstate  = state.NewDbState(snap, parent.NumberU64())
+		statedb  = state.New(dbstate)
+		signer   = types.MakeSigner(api.config, block.Number())
+		hash     = block.Hash()
+		number   = block.NumberU64()
+		replayed []*replayedTx
+	)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, _ := tx.AsMessage(signer)
+		vmctx := core.NewEVMContext(msg, block.Header(), api.eth.blockchain, nil)
+		statedb.Prepare(tx.Hash(), hash, i)
+
+		// Only the transactions asked for are traced, the earlier ones just
+		// bring the state up to them
+		var (
+			tracer *tracers.ParityTracer
+			config vm.Config
+		)
+		if txIndex < 0 || txIndex == i {
+			tracer = tracers.NewParityTracer(statedb, vmTrace, stateDiff)
+			config = vm.Config{Debug: true, Tracer: tracer}
+			if stateDiff {
+				statedb.SetTracer(tracer)
+			}
+		}
+		vmenv := vm.NewEVM(vmctx, statedb, api.config, config)
+		output, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()))
+		statedb.SetTracer(nil)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(vmenv.ChainConfig().IsEIP158(block.Number()), dbstate)
+		if tracer == nil {
+			continue
+		}
+		txHash, position := tx.Hash(), uint64(i)
+		for _, trace := range tracer.Traces() {
+			trace.BlockHash, trace.BlockNumber = &hash, &number
+			trace.TransactionHash, trace.TransactionPosition = &txHash, &position
+		}
+		replayed = append(replayed, &replayedTx{tx: tx, index: position, output: output, tracer: tracer})
+		if txIndex == i {
+			return replayed, nil
+		}
+	}
+	if txIndex >= 0 {
+		return nil, fmt.Errorf("transaction index %d out of range for block %#x", txIndex, hash)
+	}
+	return replayed, nil
+}
+
+// traceResults assembles the results of the replayed transaction.
+func traceResults(r *replayedTx, trace, vmTrace, stateDiff bool) *TraceResults {
+	result := &TraceResults{Output: r.output, Trace: []*tracers.ParityTrace{}}
+	if trace {
+		result.Trace = r.tracer.Traces()
+	}
+	if vmTrace {
+		result.VmTrace = r.tracer.VmTrace()
+	}
+	if stateDiff {
+		result.StateDiff = r.tracer.StateDiff()
+	}
+	return result
+}
+
+// parseTraceTypes checks the trace types asked for.
+func parseTraceTypes(traceTypes []string) (trace, vmTrace, stateDiff bool, err error) {
+	for _, traceType := range traceTypes {
+		switch traceType {
+		case "trace":
+			trace = true
+		case "vmTrace":
+			vmTrace = true
+		case "stateDiff":
+			stateDiff = true
+		default:
+			return false, false, false, fmt.Errorf("unknown trace type %q", traceType)
+		}
+	}
+	return trace, vmTrace, stateDiff, nil
+}
+
+// traceAddresses returns the sender and the recipient of the trace. The mining
+// rewards have no sender, their recipient is the miner.
+func traceAddresses(trace *tracers.ParityTrace) (*common.Address, common.Address) {
+	switch action := trace.Action.(type) {
+	case *tracers.ParityCallAction:
+		return &action.From, action.To
+	case *tracers.ParityCreateAction:
+		if result, ok := trace.Result.(*tracers.ParityCreateResult); ok {
+			return &action.From, result.Address
+		}
+		return &action.From, common.Address{}
+	case *tracers.ParitySuicideAction:
+		return &action.Address, action.RefundAddress
+	case *tracers.ParityRewardAction:
+		return nil, action.Author
+	}
+	return &common.Address{}, common.Address{}
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -441,7 +442,9 @@ func (api *PrivateDebugAPI) traceBlock(ctx context.Context, block *types.Block,
 	if parent == nil {
 		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
 	}
-	statedb, dbstate := api.computeStateDB(parent)
+	// The transactions are traced concurrently, so the state is read from the
+	// database itself rather than from a snapshot bound to one goroutine
+	statedb, dbstate := api.computeStateDB(api.eth.ChainDb(), parent)
 	// Execute all the transaction contained within the block concurrently
 	var (
 		signer = types.MakeSigner(api.config, block.Number())
@@ -534,7 +537,13 @@ func (api *PrivateDebugAPI) standardTraceBlockToFile(ctx context.Context, block
 			reexec = *config.Reexec
 		}
 	*/
-	statedb, dbstate := api.computeStateDB(parent)
+	snap, err := api.eth.ChainDb().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	statedb, dbstate := api.computeStateDB(snap, parent)
 	// Retrieve the tracing configurations, or use default values
 	var (
 		logConfig vm.LogConfig
@@ -608,12 +617,11 @@ func (api *PrivateDebugAPI) standardTraceBlockToFile(ctx context.Context, block
 	return dumps, nil
 }
 
-// computeStateDB retrieves the state database associated with a certain block.
-// If no state is locally available for the given block, a number of blocks are
-// attempted to be reexecuted to generate the desired state.
-func (api *PrivateDebugAPI) computeStateDB(block *types.Block) (*state.StateDB, *state.DbState) {
+// computeStateDB retrieves the state database associated with a certain block,
+// reading it from the given database or snapshot.
+func (api *PrivateDebugAPI) computeStateDB(db ethdb.Getter, block *types.Block) (*state.StateDB, *state.DbState) {
 	// If we have the state fully available, use that
-	dbstate := state.NewDbState(api.eth.ChainDb(), block.NumberU64())
+	dbstate := state.NewDbState(db, block.NumberU64())
 	statedb := state.New(dbstate)
 	return statedb, dbstate
 }
@@ -630,7 +638,13 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	if config != nil && config.Reexec != nil {
 		reexec = *config.Reexec
 	}
-	msg, vmctx, statedb, _, _, err := api.computeTxEnv(blockHash, int(index), reexec)
+	snap, err := api.eth.ChainDb().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	msg, vmctx, statedb, _, _, err := api.computeTxEnv(snap, blockHash, int(index), reexec)
 	if err != nil {
 		return nil, err
 	}
@@ -657,15 +671,15 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
+		// Construct the native or the JavaScript tracer to execute with
+		if tracer, err = tracers.NewTracer(*config.Tracer); err != nil {
 			return nil, err
 		}
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
+			tracer.(tracers.ResultTracer).Stop(errors.New("execution timeout"))
 		}()
 		defer cancel()
 
@@ -692,7 +706,7 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *tracers.Tracer:
+	case tracers.ResultTracer:
 		return tracer.GetResult()
 
 	default:
@@ -700,8 +714,9 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 	}
 }
 
-// computeTxEnv returns the execution environment of a certain transaction.
-func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, reexec uint64) (core.Message, vm.Context, *state.StateDB, *state.DbState, uint64, error) {
+// computeTxEnv returns the execution environment of a certain transaction,
+// with the state read from the given database or snapshot.
+func (api *PrivateDebugAPI) computeTxEnv(db ethdb.Getter, blockHash common.Hash, txIndex int, reexec uint64) (core.Message, vm.Context, *state.StateDB, *state.DbState, uint64, error) {
 	// Create the parent state database
 	block := api.eth.blockchain.GetBlockByHash(blockHash)
 	if block == nil {
@@ -711,7 +726,7 @@ func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, ree
 	if parent == nil {
 		return nil, vm.Context{}, nil, nil, 0, fmt.Errorf("parent %x not found", block.ParentHash())
 	}
-	statedb, dbstate := api.computeStateDB(parent)
+	statedb, dbstate := api.computeStateDB(db, parent)
 	// Recompute transactions up to the target index.
 	signer := types.MakeSigner(api.config, block.Number())
 
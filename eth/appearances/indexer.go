@@ -0,0 +1,255 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package appearances implements the index of the transactions touching the
+// addresses in any of their call frames.
+package appearances
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	// SectionSize is the number of blocks in a section of the address appearance
+	// index. The sections are small, as the index is served only up to the last
+	// complete one.
+	SectionSize = 16
+
+	// appearanceConfirms is the number of confirmation blocks before a section
+	// of the address appearance index is processed.
+	appearanceConfirms = 0
+
+	// appearanceThrottling is the time to wait between processing two consecutive
+	// sections of the address appearance index.
+	appearanceThrottling = 100 * time.Millisecond
+)
+
+// Indexer implements a core.ChainIndexer, recording for every block the
+// addresses appearing as the sender or the recipient of any call frame of its
+// transactions, which are found by executing the block on the historical state
+// of its parent.
+type Indexer struct {
+	db      ethdb.Database      // database instance to write index data into
+	config  *params.ChainConfig // chain configuration to execute the blocks with
+	chain   *core.BlockChain    // blockchain to resolve the block hashes of the EVM
+	size    uint64              // section size of the index
+	section uint64              // section number being processed currently
+	batch   ethdb.Mutation      // batch collecting the appearances of the section
+	pruned  bool                // whether the pruned history was already reported
+}
+
+// NewIndexer returns a chain indexer that records the address appearances of
+// the canonical chain.
+func NewIndexer(db ethdb.Database, config *params.ChainConfig, chain *core.BlockChain) *core.ChainIndexer {
+	backend := &Indexer{
+		db:     db,
+		config: config,
+		chain:  chain,
+		size:   SectionSize,
+	}
+	return core.NewChainIndexer(db, rawdb.AppearanceIndexPrefix, backend, SectionSize, appearanceConfirms, appearanceThrottling, "appearances")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new section. Any
+// appearances stored from the section on are left over by a reorg, so they are
+// unwound first.
+func (a *Indexer) Reset(ctx context.Context, section uint64, lastSectionHead common.Hash) error {
+	a.section, a.batch = section, a.db.NewBatch()
+
+	numbers, err := rawdb.ReadAppearanceBlocks(a.db, section*a.size)
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		rawdb.DeleteAppearances(a.batch, number)
+	}
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, executing the transactions of the
+// block and adding the addresses they touch into the index.
+func (a *Indexer) Process(ctx context.Context, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	block := rawdb.ReadBlock(a.db, header.Hash(), number)
+	if block == nil {
+		return fmt.Errorf("block #%d %#x not found", number, header.Hash())
+	}
+	if len(block.Transactions()) == 0 {
+		return nil
+	}
+	// The blocks whose parent state has been pruned cannot be executed, they
+	// are left out of the index instead of stalling it
+	if err := ethdb.CheckHistoryHorizon(a.db, number); err != nil {
+		if !a.pruned {
+			log.Warn("Address appearances not indexed for pruned history", "number", number, "err", err)
+			a.pruned = true
+		}
+		return nil
+	}
+	var (
+		dbstate     = state.NewDbState(a.db, number-1)
+		statedb     = state.New(dbstate)
+		signer      = types.MakeSigner(a.config, header.Number)
+		appearances = make(map[common.Address][]uint64)
+	)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return err
+		}
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		tracer := newAppearanceTracer()
+		tracer.add(msg.From())
+		if to := msg.To(); to != nil {
+			tracer.add(*to)
+		}
+		vmctx := core.NewEVMContext(msg, header, a.chain, nil)
+		vmenv := vm.NewEVM(vmctx, statedb, a.config, vm.Config{Debug: true, Tracer: tracer})
+		if _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas())); err != nil {
+			return fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(a.config.IsEIP158(header.Number), dbstate)
+
+		for address := range tracer.addresses {
+			appearances[address] = append(appearances[address], uint64(i))
+		}
+	}
+	// The transactions are executed in order, so the indexes are sorted already
+	rawdb.WriteAppearances(a.batch, number, appearances)
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, writing the appearances of the
+// section out into the database.
+func (a *Indexer) Commit(blockNr uint64) error {
+	_, err := a.batch.Commit()
+	return err
+}
+
+// Appearance holds the indexes of the transactions of a block in which an
+// address appears.
+type Appearance struct {
+	Number uint64
+	Txs    []uint64
+}
+
+// Search returns the appearances of the address in the blocks from the given
+// one on, in ascending order, up to the first block not indexed yet. Whole
+// blocks are returned, so the transactions found can exceed the limit by the
+// rest of the last block. Once the limit is reached, the number of the block to
+// continue the search from is returned too, zero if the search is done.
+func Search(ctx context.Context, db ethdb.Getter, address common.Address, from, indexed, limit uint64) ([]Appearance, uint64, error) {
+	var (
+		found []Appearance
+		txs   uint64
+		next  uint64
+	)
+	err := rawdb.WalkAppearances(db, address, from, func(number uint64, indexes []uint64) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if number >= indexed {
+			return false, nil
+		}
+		if txs >= limit {
+			next = number
+			return false, nil
+		}
+		found = append(found, Appearance{Number: number, Txs: indexes})
+		txs += uint64(len(indexes))
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return found, next, nil
+}
+
+// appearanceTracer is a vm.Tracer collecting the senders and the recipients of
+// the call frames of a transaction.
+type appearanceTracer struct {
+	addresses map[common.Address]struct{}
+}
+
+func newAppearanceTracer() *appearanceTracer {
+	return &appearanceTracer{addresses: make(map[common.Address]struct{})}
+}
+
+func (t *appearanceTracer) add(address common.Address) {
+	t.addresses[address] = struct{}{}
+}
+
+// CaptureStart implements vm.Tracer, recording the frames entered by CALL and
+// by the contract creations.
+func (t *appearanceTracer) CaptureStart(depth int, from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	t.add(from)
+	t.add(to)
+	return nil
+}
+
+// CaptureState implements vm.Tracer, recording the frames entered by the calls
+// which don't signal CaptureStart.
+func (t *appearanceTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	switch op {
+	case vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if err == nil && stack.Len() > 1 {
+			t.add(contract.Address())
+			t.add(common.BigToAddress(stack.Back(1)))
+		}
+	}
+	return nil
+}
+
+func (t *appearanceTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *appearanceTracer) CaptureEnd(depth int, output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *appearanceTracer) CaptureCreate(creator common.Address, creation common.Address) error {
+	return nil
+}
+
+func (t *appearanceTracer) CaptureAccountRead(account common.Address) error {
+	return nil
+}
+
+func (t *appearanceTracer) CaptureAccountWrite(account common.Address) error {
+	return nil
+}
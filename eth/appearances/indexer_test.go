@@ -0,0 +1,290 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package appearances
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+
+	// The contract calls the callee, which only appears in the inner frames
+	testContract = common.HexToAddress("0xc0")
+	testCallee   = common.HexToAddress("0xbb")
+)
+
+// testRecipient returns the recipient of the transfer in the block generated
+// with the given seed.
+func testRecipient(seed, number int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(seed<<16 | number)))
+}
+
+// testChain is a blockchain whose blocks have a transfer to testRecipient each,
+// two of them in block 2, and a call of the contract in blocks 3 and 5. The
+// blocks generated with different seeds transfer to different recipients.
+type testChain struct {
+	db      ethdb.Database
+	gspec   *core.Genesis
+	genesis *types.Block
+	chain   *core.BlockChain
+}
+
+func newTestChain(t *testing.T) *testChain {
+	code := append([]byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH20),
+	}, testCallee.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.STOP))
+
+	c := &testChain{
+		db: ethdb.NewMemDatabase(),
+		gspec: &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				testAddress:  {Balance: big.NewInt(1000000000000000000)},
+				testContract: {Balance: big.NewInt(0), Code: code},
+			},
+		},
+	}
+	c.genesis = c.gspec.MustCommit(c.db)
+	chain, err := core.NewBlockChain(c.db, nil, c.gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.chain = chain
+	return c
+}
+
+// generate makes the blocks on top of the parent, which is a block generated
+// with the zero seed, with the recipients of the given seed.
+func (c *testChain) generate(t *testing.T, parent *types.Block, n, seed int) []*types.Block {
+	gendb := ethdb.NewMemDatabase()
+	c.gspec.MustCommit(gendb)
+	if parent != c.genesis {
+		// The generator needs the state of the parent
+		blocks, _ := core.GenerateChain(c.gspec.Config, c.genesis, ethash.NewFaker(), gendb, int(parent.NumberU64()), c.gen(t, 0))
+		parent = blocks[len(blocks)-1]
+	}
+	blocks, _ := core.GenerateChain(c.gspec.Config, parent, ethash.NewFaker(), gendb, n, c.gen(t, seed))
+	return blocks
+}
+
+func (c *testChain) gen(t *testing.T, seed int) func(int, *core.BlockGen) {
+	signer := types.HomesteadSigner{}
+	return func(i int, block *core.BlockGen) {
+		number := int(block.Number().Uint64())
+		add := func(to common.Address, gas uint64) {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(testAddress), to, big.NewInt(1), gas, nil, nil), signer, testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(tx)
+		}
+		add(testRecipient(seed, number), params.TxGas)
+		if number == 2 {
+			add(testRecipient(seed, number+100), params.TxGas)
+		}
+		if number == 3 || number == 5 {
+			add(testContract, 100000)
+		}
+	}
+}
+
+// index runs the indexer over the given sections of the canonical chain.
+func (c *testChain) index(t *testing.T, indexer *Indexer, sections ...uint64) {
+	for _, section := range sections {
+		if err := indexer.Reset(context.Background(), section, common.Hash{}); err != nil {
+			t.Fatal(err)
+		}
+		for number := section * SectionSize; number < (section+1)*SectionSize; number++ {
+			header := c.chain.GetHeaderByNumber(number)
+			if header == nil {
+				t.Fatalf("block #%d missing", number)
+			}
+			if err := indexer.Process(context.Background(), header); err != nil {
+				t.Fatalf("block #%d: %v", number, err)
+			}
+		}
+		if err := indexer.Commit((section+1)*SectionSize - 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// appearances returns the appearances of the address in the whole index.
+func appearances(t *testing.T, db ethdb.Database, address common.Address) []Appearance {
+	found, _, err := Search(context.Background(), db, address, 0, ^uint64(0), ^uint64(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestIndexerProcess(t *testing.T) {
+	c := newTestChain(t)
+	if _, err := c.chain.InsertChain(c.generate(t, c.genesis, SectionSize+4, 0)); err != nil {
+		t.Fatal(err)
+	}
+	indexer := &Indexer{db: c.db, config: c.gspec.Config, chain: c.chain, size: SectionSize}
+	c.index(t, indexer, 0)
+
+	tests := []struct {
+		address common.Address
+		want    []Appearance
+	}{
+		{testRecipient(0, 4), []Appearance{{4, []uint64{0}}}},
+		{testRecipient(0, 102), []Appearance{{2, []uint64{1}}}},
+		{testContract, []Appearance{{3, []uint64{1}}, {5, []uint64{1}}}},
+		{testCallee, []Appearance{{3, []uint64{1}}, {5, []uint64{1}}}},
+		// The blocks of the next section are not indexed yet
+		{testRecipient(0, SectionSize+1), nil},
+	}
+	for _, tt := range tests {
+		if have := appearances(t, c.db, tt.address); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("appearances of %x: have %v, want %v", tt.address, have, tt.want)
+		}
+	}
+	found := appearances(t, c.db, testAddress)
+	if len(found) != SectionSize-1 {
+		t.Fatalf("sender appears in %d blocks, want %d", len(found), SectionSize-1)
+	}
+	if want := (Appearance{2, []uint64{0, 1}}); !reflect.DeepEqual(found[1], want) {
+		t.Errorf("sender appearances in block 2: have %v, want %v", found[1], want)
+	}
+}
+
+func TestIndexerReorg(t *testing.T) {
+	c := newTestChain(t)
+	if _, err := c.chain.InsertChain(c.generate(t, c.genesis, 2*SectionSize, 0)); err != nil {
+		t.Fatal(err)
+	}
+	indexer := &Indexer{db: c.db, config: c.gspec.Config, chain: c.chain, size: SectionSize}
+	c.index(t, indexer, 0, 1)
+
+	// Reorganise the second section onto a longer fork with other recipients
+	forkPoint := c.chain.GetBlockByNumber(SectionSize + 4)
+	if _, err := c.chain.InsertChain(c.generate(t, forkPoint, 2*SectionSize, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if head := c.chain.CurrentBlock().NumberU64(); head != 3*SectionSize+4 {
+		t.Fatalf("fork not canonical, head #%d", head)
+	}
+	c.index(t, indexer, 1)
+
+	tests := []struct {
+		address common.Address
+		want    []Appearance
+	}{
+		// The first section and the second one up to the fork are kept
+		{testRecipient(0, 4), []Appearance{{4, []uint64{0}}}},
+		{testRecipient(0, SectionSize+4), []Appearance{{SectionSize + 4, []uint64{0}}}},
+		// The blocks of the old chain after the fork are unwound
+		{testRecipient(0, SectionSize+5), nil},
+		{testRecipient(0, 2*SectionSize-1), nil},
+		{testRecipient(1, SectionSize+5), []Appearance{{SectionSize + 5, []uint64{0}}}},
+		{testRecipient(1, 2*SectionSize-1), []Appearance{{2*SectionSize - 1, []uint64{0}}}},
+	}
+	for _, tt := range tests {
+		if have := appearances(t, c.db, tt.address); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("appearances of %x: have %v, want %v", tt.address, have, tt.want)
+		}
+	}
+	// A reset alone unwinds the section and everything after it
+	if err := indexer.Reset(context.Background(), 1, common.Hash{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexer.Commit(SectionSize); err != nil {
+		t.Fatal(err)
+	}
+	if numbers, err := rawdb.ReadAppearanceBlocks(c.db, SectionSize); err != nil || len(numbers) != 0 {
+		t.Errorf("blocks of the reset section left in the index: %v, %v", numbers, err)
+	}
+	if found := appearances(t, c.db, testAddress); len(found) != SectionSize-1 {
+		t.Errorf("sender appears in %d blocks after the reset, want %d", len(found), SectionSize-1)
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	c := newTestChain(t)
+	if _, err := c.chain.InsertChain(c.generate(t, c.genesis, 2*SectionSize, 0)); err != nil {
+		t.Fatal(err)
+	}
+	indexer := &Indexer{db: c.db, config: c.gspec.Config, chain: c.chain, size: SectionSize}
+	c.index(t, indexer, 0, 1)
+
+	tests := []struct {
+		from, indexed, limit uint64
+		blocks               []uint64
+		next                 uint64
+	}{
+		// Whole blocks are returned, the last one taking the page over the limit
+		{1, 2 * SectionSize, 2, []uint64{1, 2}, 3},
+		{3, 2 * SectionSize, 2, []uint64{3}, 4},
+		{4, 2 * SectionSize, 2, []uint64{4, 5}, 6},
+		// The search stops at the first block not indexed
+		{SectionSize - 2, SectionSize, 100, []uint64{SectionSize - 2, SectionSize - 1}, 0},
+		{SectionSize, SectionSize, 100, nil, 0},
+		// The limit is only checked before a block, so the last page is complete
+		{2*SectionSize - 1, 2 * SectionSize, 1, []uint64{2*SectionSize - 1}, 0},
+	}
+	for i, tt := range tests {
+		found, next, err := Search(context.Background(), c.db, testAddress, tt.from, tt.indexed, tt.limit)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		var blocks []uint64
+		for _, appearance := range found {
+			blocks = append(blocks, appearance.Number)
+		}
+		if !reflect.DeepEqual(blocks, tt.blocks) || next != tt.next {
+			t.Errorf("test %d: have blocks %v next %d, want blocks %v next %d", i, blocks, next, tt.blocks, tt.next)
+		}
+	}
+	// Following the pages from the start finds every block once
+	var blocks []uint64
+	for from := uint64(0); ; {
+		found, next, err := Search(context.Background(), c.db, testAddress, from, 2*SectionSize, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, appearance := range found {
+			blocks = append(blocks, appearance.Number)
+		}
+		if next == 0 {
+			break
+		}
+		from = next
+	}
+	if len(blocks) != 2*SectionSize-1 || blocks[0] != 1 || blocks[len(blocks)-1] != 2*SectionSize-1 {
+		t.Errorf("paged search found blocks %v", blocks)
+	}
+}
@@ -87,6 +87,18 @@ type Config struct {
 	SyncMode  downloader.SyncMode
 	NoPruning bool
 
+	// Number of recent blocks to keep the state history for, 0 keeps all the history
+	HistoryRetain uint64
+
+	// Whether to store the witness of every imported block and serve it to peers
+	StoreWitnesses bool
+
+	// Whether to index the addresses appearing in the call frames of every block
+	IndexAppearances bool
+
+	// Whether to store the state changes of every transaction of the imported blocks
+	StoreStateDiffs bool
+
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
 
@@ -102,6 +114,7 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	DatabaseSpill      int // Memory (MB) of the block import batch past which it spills into files, 0 keeps it in memory
 	TrieCleanCache     int
 	TrieDirtyCache     int
 	TrieTimeout        time.Duration
@@ -28,6 +28,7 @@ import (
 	ethereum "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
@@ -45,6 +46,8 @@ var (
 	MaxReceiptFetch = 256 // Amount of transaction receipts to allow fetching per request
 	MaxStateFetch   = 384 // Amount of node state values to allow fetching per request
 
+	MaxStateRangeBytes = 512 * 1024 // Soft limit of the size of the state range responses
+
 	MaxForkAncestry  = 1000 * params.EpochDuration // Maximum chain reorganisation
 	rttMinEstimate   = 2 * time.Second             // Minimum round-trip time to target for download requests
 	rttMaxEstimate   = 20 * time.Second            // Maximum round-trip time to target for download requests
@@ -89,6 +92,7 @@ var (
 	errCancelBodyFetch         = errors.New("block body download canceled (requested)")
 	errCancelReceiptFetch      = errors.New("receipt download canceled (requested)")
 	errCancelStateFetch        = errors.New("state data download canceled (requested)")
+	errStateUnavailable        = errors.New("no peers have the state of the pivot block")
 	errCancelHeaderProcessing  = errors.New("header processing canceled (requested)")
 	errCancelContentProcessing = errors.New("content processing canceled (requested)")
 	errNoSyncActive            = errors.New("no sync active")
@@ -254,7 +258,7 @@ func (d *Downloader) Progress() ethereum.SyncProgress {
 
 	current := uint64(0)
 	switch d.mode {
-	case FullSync:
+	case FullSync, StatelessSync:
 		current = d.blockchain.CurrentBlock().NumberU64()
 	case FastSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
@@ -1504,40 +1508,40 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 // processFastSyncContent takes fetch results from the queue and writes them to the
 // database. It also controls the synchronisation of state nodes of the pivot block.
 func (d *Downloader) processFastSyncContent(latest *types.Header) error {
-	// Start syncing state of the reported head block. This should get us most of
-	// the state of the pivot block.
-	stateSync := d.syncState(latest.Root)
-	defer stateSync.Cancel()
-	go func() {
-		if err := stateSync.Wait(); err != nil && err != errCancelStateFetch {
-			d.queue.Close() // wake up Results
+	// The state of the pivot block is downloaded by the ranges of the accounts and
+	// the storage once the pivot block arrives. The peers serve the state of the
+	// past blocks from the history, so the pivot stays put even if the sync takes
+	// long; if the peers cannot serve it anymore, the sync fails and restarts.
+	var pivotSync *stateSync
+	defer func() {
+		if pivotSync != nil {
+			pivotSync.Cancel()
 		}
 	}()
-	// Figure out the ideal pivot block. Note, that this goalpost may move if the
-	// sync takes long enough for the chain head to move significantly.
 	pivot := uint64(0)
 	if height := latest.Number.Uint64(); height > uint64(fsMinFullBlocks) {
 		pivot = height - uint64(fsMinFullBlocks)
 	}
-	// To cater for moving pivot points, track the pivot block and subsequently
-	// accumulated download results separately.
+	// Track the pivot block and subsequently accumulated download results separately.
 	var (
-		oldPivot *fetchResult   // Locked in pivot block, might change eventually
+		oldPivot *fetchResult   // Locked in pivot block
 		oldTail  []*fetchResult // Downloaded content after the pivot
 	)
 	for {
-		// Wait for the next batch of downloaded data to be available, and if the pivot
-		// block became stale, move the goalpost
-		results := d.queue.Results(oldPivot == nil) // Block if we're not monitoring pivot staleness
+		// Wait for the next batch of downloaded data to be available
+		results := d.queue.Results(oldPivot == nil) // Block if we're not waiting for the pivot state
 		if len(results) == 0 {
 			// If pivot sync is done, stop
 			if oldPivot == nil {
-				return stateSync.Cancel()
+				if pivotSync == nil {
+					return nil
+				}
+				return pivotSync.Cancel()
 			}
 			// If sync failed, stop
 			select {
 			case <-d.cancelCh:
-				return stateSync.Cancel()
+				return pivotSync.Cancel()
 			default:
 			}
 		}
@@ -1548,36 +1552,26 @@ func (d *Downloader) processFastSyncContent(latest *types.Header) error {
 			results = append(append([]*fetchResult{oldPivot}, oldTail...), results...)
 		}
 		// Split around the pivot block and process the two sides via fast/full sync
-		if atomic.LoadInt32(&d.committed) == 0 {
-			latest = results[len(results)-1].Header
-			if height := latest.Number.Uint64(); height > pivot+2*uint64(fsMinFullBlocks) {
-				log.Warn("Pivot became stale, moving", "old", pivot, "new", height-uint64(fsMinFullBlocks))
-				pivot = height - uint64(fsMinFullBlocks)
-			}
-		}
 		P, beforeP, afterP := splitAroundPivot(pivot, results)
-		if err := d.commitFastSyncData(beforeP, stateSync); err != nil {
+		if err := d.commitFastSyncData(beforeP, pivotSync); err != nil {
 			return err
 		}
 		if P != nil {
-			// If new pivot block found, cancel old state retrieval and restart
+			// If the pivot block arrived, start the retrieval of its state
 			if oldPivot != P {
-				stateSync.Cancel()
-
-				stateSync = d.syncState(P.Header.Root)
-				defer stateSync.Cancel()
-				go func() {
-					if err := stateSync.Wait(); err != nil && err != errCancelStateFetch {
+				pivotSync = d.syncState(P.Header)
+				go func(s *stateSync) {
+					if err := s.Wait(); err != nil && err != errCancelStateFetch {
 						d.queue.Close() // wake up Results
 					}
-				}()
+				}(pivotSync)
 				oldPivot = P
 			}
-			// Wait for completion, occasionally checking for pivot staleness
+			// Wait for completion, occasionally checking for new results
 			select {
-			case <-stateSync.done:
-				if stateSync.err != nil {
-					return stateSync.err
+			case <-pivotSync.done:
+				if pivotSync.err != nil {
+					return pivotSync.err
 				}
 				if err := d.commitPivotBlock(P); err != nil {
 					return err
@@ -1616,10 +1610,14 @@ func (d *Downloader) commitFastSyncData(results []*fetchResult, stateSync *state
 	if len(results) == 0 {
 		return nil
 	}
+	var stateDone chan struct{}
+	if stateSync != nil {
+		stateDone = stateSync.done
+	}
 	select {
 	case <-d.quitCh:
 		return errCancelContentProcessing
-	case <-stateSync.done:
+	case <-stateDone:
 		if err := stateSync.Wait(); err != nil {
 			return err
 		}
@@ -1678,6 +1676,16 @@ func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
 	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
+// DeliverAccountRange injects a range of accounts received from a remote node.
+func (d *Downloader) DeliverAccountRange(id string, hashes []common.Hash, accounts [][]byte, proof [][]byte) (err error) {
+	return d.deliver(id, d.stateCh, &accountRangePack{id, hashes, accounts, proof}, stateInMeter, stateDropMeter)
+}
+
+// DeliverStorageRanges injects a batch of storage ranges received from a remote node.
+func (d *Downloader) DeliverStorageRanges(id string, ranges []state.StorageRange, proof [][]byte) (err error) {
+	return d.deliver(id, d.stateCh, &storageRangesPack{id, ranges, proof}, stateInMeter, stateDropMeter)
+}
+
 // deliver injects a new batch of data received from a remote node.
 func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
@@ -374,6 +374,20 @@ func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// RequestAccountRange constructs a getAccountRange method associated with a
+// particular peer in the download tester. The tester peers have no state to serve.
+func (dlp *downloadTesterPeer) RequestAccountRange(block common.Hash, origin, limit common.Hash, bytes uint64) error {
+	go dlp.dl.downloader.DeliverAccountRange(dlp.id, nil, nil, nil)
+	return nil
+}
+
+// RequestStorageRanges constructs a getStorageRanges method associated with a
+// particular peer in the download tester. The tester peers have no state to serve.
+func (dlp *downloadTesterPeer) RequestStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	go dlp.dl.downloader.DeliverStorageRanges(dlp.id, nil, nil)
+	return nil
+}
+
 // assertOwnChain checks if the local chain contains the correct number of items
 // of the various chain components.
 func assertOwnChain(t *testing.T, tester *downloadTester, length int) {
@@ -1452,6 +1466,12 @@ func (ftp *floodingTestPeer) RequestReceipts(hashes []common.Hash) error {
 func (ftp *floodingTestPeer) RequestNodeData(hashes []common.Hash) error {
 	return ftp.peer.RequestNodeData(hashes)
 }
+func (ftp *floodingTestPeer) RequestAccountRange(block common.Hash, origin, limit common.Hash, bytes uint64) error {
+	return ftp.peer.RequestAccountRange(block, origin, limit, bytes)
+}
+func (ftp *floodingTestPeer) RequestStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	return ftp.peer.RequestStorageRanges(block, accounts, origin, bytes)
+}
 
 func (ftp *floodingTestPeer) RequestHeadersByNumber(from uint64, count, skip int, reverse bool) error {
 	deliveriesDone := make(chan struct{}, 500)
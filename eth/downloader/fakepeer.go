@@ -22,6 +22,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 )
@@ -147,15 +148,43 @@ func (p *FakePeer) RequestReceipts(hashes []common.Hash) error {
 	return nil
 }
 
-// RequestNodeData implements downloader.Peer, returning a batch of state trie
-// nodes corresponding to the specified trie hashes.
+// RequestNodeData implements downloader.Peer, returning a batch of contract
+// codes corresponding to the specified code hashes.
 func (p *FakePeer) RequestNodeData(hashes []common.Hash) error {
 	var data [][]byte
 	for _, hash := range hashes {
-		if entry, err := p.db.Get([]byte("FakePeer"), hash.Bytes()); err == nil {
+		if entry, err := p.db.Get(state.CodeBucket, hash.Bytes()); err == nil {
 			data = append(data, entry)
 		}
 	}
 	p.dl.DeliverNodeData(p.id, data)
 	return nil
 }
+
+// RequestAccountRange implements downloader.Peer, returning a range of the
+// accounts in the state of the specified block.
+func (p *FakePeer) RequestAccountRange(block common.Hash, origin, limit common.Hash, bytes uint64) error {
+	var (
+		hashes          []common.Hash
+		accounts, proof [][]byte
+	)
+	if header := p.hc.GetHeaderByHash(block); header != nil {
+		hashes, accounts, proof, _ = state.ReadAccountRange(p.db, header.Root, header.Number.Uint64(), origin, limit, int(bytes), true)
+	}
+	p.dl.DeliverAccountRange(p.id, hashes, accounts, proof)
+	return nil
+}
+
+// RequestStorageRanges implements downloader.Peer, returning the storage ranges
+// of the accounts in the state of the specified block.
+func (p *FakePeer) RequestStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	var (
+		ranges []state.StorageRange
+		proof  [][]byte
+	)
+	if header := p.hc.GetHeaderByHash(block); header != nil {
+		ranges, proof, _ = state.ReadStorageRanges(p.db, header.Root, header.Number.Uint64(), accounts, origin, int(bytes), true)
+	}
+	p.dl.DeliverStorageRanges(p.id, ranges, proof)
+	return nil
+}
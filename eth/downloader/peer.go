@@ -88,6 +88,14 @@ type Peer interface {
 	RequestBodies([]common.Hash) error
 	RequestReceipts([]common.Hash) error
 	RequestNodeData([]common.Hash) error
+	RequestAccountRange(block common.Hash, origin, limit common.Hash, bytes uint64) error
+	RequestStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error
+}
+
+// rangePeer is implemented by the peers which serve the state ranges over a
+// protocol negotiated separately from eth, and so may not serve them at all.
+type rangePeer interface {
+	ServesStateRanges() bool
 }
 
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
@@ -111,6 +119,12 @@ func (w *lightPeerWrapper) RequestReceipts([]common.Hash) error {
 func (w *lightPeerWrapper) RequestNodeData([]common.Hash) error {
 	panic("RequestNodeData not supported in light client mode sync")
 }
+func (w *lightPeerWrapper) RequestAccountRange(common.Hash, common.Hash, common.Hash, uint64) error {
+	panic("RequestAccountRange not supported in light client mode sync")
+}
+func (w *lightPeerWrapper) RequestStorageRanges(common.Hash, []common.Hash, common.Hash, uint64) error {
+	panic("RequestStorageRanges not supported in light client mode sync")
+}
 
 // newPeerConnection creates a new downloader peer.
 func newPeerConnection(id string, version int, peer Peer, logger log.Logger) *peerConnection {
@@ -222,6 +236,48 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	return nil
 }
 
+// servesStateRanges tells whether the remote peer can serve the state ranges.
+func (p *peerConnection) servesStateRanges() bool {
+	if rp, ok := p.peer.(rangePeer); ok {
+		return rp.ServesStateRanges()
+	}
+	return true
+}
+
+// FetchAccountRange sends an account range retrieval request to the remote peer.
+func (p *peerConnection) FetchAccountRange(block common.Hash, origin, limit common.Hash) error {
+	// Sanity check the protocol version
+	if p.version < 63 {
+		panic(fmt.Sprintf("account range fetch [eth/63+] requested on eth/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.stateStarted = time.Now()
+
+	go p.peer.RequestAccountRange(block, origin, limit, uint64(MaxStateRangeBytes))
+
+	return nil
+}
+
+// FetchStorageRanges sends a storage range retrieval request to the remote peer.
+func (p *peerConnection) FetchStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash) error {
+	// Sanity check the protocol version
+	if p.version < 63 {
+		panic(fmt.Sprintf("storage range fetch [eth/63+] requested on eth/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.stateStarted = time.Now()
+
+	go p.peer.RequestStorageRanges(block, accounts, origin, uint64(MaxStateRangeBytes))
+
+	return nil
+}
+
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
@@ -501,6 +557,20 @@ func (ps *peerSet) ReceiptIdlePeers() ([]*peerConnection, int) {
 	return ps.idlePeers(63, 64, idle, throughput)
 }
 
+// StateRangeIdlePeers retrieves a flat list of all the currently idle peers able
+// to serve the state ranges, sorted by their node data throughput.
+func (ps *peerSet) StateRangeIdlePeers() ([]*peerConnection, int) {
+	idle := func(p *peerConnection) bool {
+		return atomic.LoadInt32(&p.stateIdle) == 0 && p.servesStateRanges()
+	}
+	throughput := func(p *peerConnection) float64 {
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return p.stateThroughput
+	}
+	return ps.idlePeers(63, 64, idle, throughput)
+}
+
 // NodeDataIdlePeers retrieves a flat list of all the currently node-data-idle
 // peers within the active peer set, ordered by their reputation.
 func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
@@ -18,29 +18,23 @@ package downloader
 
 import (
 	"fmt"
-	"hash"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
-	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/trie"
-	"golang.org/x/crypto/sha3"
 )
 
-// stateReq represents a batch of state fetch requests grouped together into
-// a single data retrieval network packet.
+// stateReq represents a request for a part of the state, sent to a single peer.
 type stateReq struct {
-	items    []common.Hash              // Hashes of the state items to download
-	tasks    map[common.Hash]*stateTask // Download tasks to track previous attempts
-	timeout  time.Duration              // Maximum round trip time for this to complete
-	timer    *time.Timer                // Timer to fire when the RTT timeout expires
-	peer     *peerConnection            // Peer that we're requesting from
-	response [][]byte                   // Response data of the peer (nil for timeouts)
-	dropped  bool                       // Flag whether the peer dropped off early
+	task     *state.RangeRequest // Part of the state to download
+	timeout  time.Duration       // Maximum round trip time for this to complete
+	timer    *time.Timer         // Timer to fire when the RTT timeout expires
+	peer     *peerConnection     // Peer that we're requesting from
+	response dataPack            // Response data of the peer (nil for timeouts)
+	dropped  bool                // Flag whether the peer dropped off early
 }
 
 // timedOut returns if this request timed out.
@@ -48,18 +42,16 @@ func (req *stateReq) timedOut() bool {
 	return req.response == nil
 }
 
-// stateSyncStats is a collection of progress stats to report during a state trie
+// stateSyncStats is a collection of progress stats to report during a state
 // sync to RPC requests as well as to display in user logs.
 type stateSyncStats struct {
-	processed  uint64 // Number of state entries processed
-	duplicate  uint64 // Number of state entries downloaded twice
-	unexpected uint64 // Number of non-requested state entries received
-	pending    uint64 // Number of still pending state entries
+	processed uint64 // Number of state entries processed
+	pending   uint64 // Number of still pending state tasks
 }
 
-// syncState starts downloading state with the given root hash.
-func (d *Downloader) syncState(root common.Hash) *stateSync {
-	s := newStateSync(d, root)
+// syncState starts downloading the state of the given block.
+func (d *Downloader) syncState(header *types.Header) *stateSync {
+	s := newStateSync(d, header)
 	select {
 	case d.stateSyncStart <- s:
 	case <-d.quitCh:
@@ -99,7 +91,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 		// available for the next sync.
 		for _, req := range active {
 			req.timer.Stop()
-			req.peer.SetNodeDataIdle(len(req.items))
+			req.peer.SetNodeDataIdle(0)
 		}
 	}()
 	// Run the state sync.
@@ -142,12 +134,12 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			// Discard any data not requested (or previously timed out)
 			req := active[pack.PeerId()]
 			if req == nil {
-				log.Debug("Unrequested node data", "peer", pack.PeerId(), "len", pack.Items())
+				log.Debug("Unrequested state data", "peer", pack.PeerId(), "len", pack.Items())
 				continue
 			}
 			// Finalize the request and queue up for processing
 			req.timer.Stop()
-			req.response = pack.(*statePack).states
+			req.response = pack
 
 			finished = append(finished, req)
 			delete(active, pack.PeerId())
@@ -209,17 +201,15 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 	}
 }
 
-// stateSync schedules requests for downloading a particular state trie defined
-// by a given state root.
+// stateSync schedules requests for downloading the state of a particular block
+// by the ranges of the accounts and the storage.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d      *Downloader   // Downloader instance to access and manage current peerset
+	header *types.Header // Block whose state is downloaded
 
-	sched  *trie.Sync                 // State trie sync scheduler defining the tasks
-	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
-	tasks  map[common.Hash]*stateTask // Set of tasks currently queued for retrieval
-
-	numUncommitted   int
-	bytesUncommitted int
+	sched   *state.RangeSync    // State range sync scheduler defining the tasks
+	lacking map[string]struct{} // Peers that do not have the state of the block
+	logged  time.Time           // Time of the last progress report
 
 	deliver    chan *stateReq // Delivery channel multiplexing peer responses
 	cancel     chan struct{}  // Channel to signal a termination request
@@ -228,20 +218,14 @@ type stateSync struct {
 	err        error          // Any error hit during sync (set before completion)
 }
 
-// stateTask represents a single trie node download task, containing a set of
-// peers already attempted retrieval from to detect stalled syncs and abort.
-type stateTask struct {
-	attempts map[string]struct{}
-}
-
-// newStateSync creates a new state trie download scheduler. This method does not
+// newStateSync creates a new state download scheduler. This method does not
 // yet start the sync. The user needs to call run to initiate.
-func newStateSync(d *Downloader, root common.Hash) *stateSync {
+func newStateSync(d *Downloader, header *types.Header) *stateSync {
 	return &stateSync{
 		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB),
-		keccak:  sha3.NewLegacyKeccak256(),
-		tasks:   make(map[common.Hash]*stateTask),
+		header:  header,
+		lacking: make(map[string]struct{}),
+		logged:  time.Now(),
 		deliver: make(chan *stateReq),
 		cancel:  make(chan struct{}),
 		done:    make(chan struct{}),
@@ -268,13 +252,17 @@ func (s *stateSync) Cancel() error {
 	return s.Wait()
 }
 
-// loop is the main event loop of a state trie sync. It it responsible for the
+// loop is the main event loop of a state sync. It it responsible for the
 // assignment of new tasks to peers (including sending it to them) as well as
 // for the processing of inbound data. Note, that the loop does not directly
 // receive data from peers, rather those are buffered up in the downloader and
 // pushed here async. The reason is to decouple processing from data receipt
-// and timeouts.
+// and timeouts. Once everything is downloaded, the state is healed until it
+// matches the state root of the block.
 func (s *stateSync) loop() (err error) {
+	if s.sched, err = state.NewRangeSync(s.d.stateDB, s.header.Root, s.header.Number.Uint64()); err != nil {
+		return err
+	}
 	// Listen for new peer events to assign tasks to them
 	newPeer := make(chan *peerConnection, 1024)
 	peerSub := s.d.peers.SubscribeNewPeers(newPeer)
@@ -286,11 +274,20 @@ func (s *stateSync) loop() (err error) {
 		}
 	}()
 
-	// Keep assigning new tasks until the sync completes or aborts
-	for s.sched.Pending() > 0 {
+	// Keep assigning new tasks until the state is complete or the sync aborts
+	for {
 		if err = s.commit(false); err != nil {
 			return err
 		}
+		if s.sched.Pending() == 0 {
+			done, err := s.sched.Heal()
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
 		s.assignTasks()
 		// Tasks assigned, wait for something to happen
 		select {
@@ -304,176 +301,118 @@ func (s *stateSync) loop() (err error) {
 			return errCancelStateFetch
 
 		case req := <-s.deliver:
-			// Response, disconnect or timeout triggered, drop the peer if stalling
-			log.Trace("Received node data response", "peer", req.peer.id, "count", len(req.response), "dropped", req.dropped, "timeout", !req.dropped && req.timedOut())
-			if len(req.items) <= 2 && !req.dropped && req.timedOut() {
-				// 2 items are the minimum requested, if even that times out, we've no use of
-				// this peer at the moment.
-				log.Warn("Stalling state sync, dropping peer", "peer", req.peer.id)
-				s.d.dropPeer(req.peer.id)
-			}
-			// Process all the received blobs and check for stale delivery
-			delivered, err := s.process(req)
-			if err != nil {
-				log.Warn("Node data write error", "err", err)
+			// Response, disconnect or timeout triggered
+			log.Trace("Received state response", "peer", req.peer.id, "kind", req.task.Kind, "dropped", req.dropped, "timeout", !req.dropped && req.timedOut())
+			if err := s.process(req); err != nil {
 				return err
 			}
-			req.peer.SetNodeDataIdle(delivered)
 		}
 	}
-	return nil
 }
 
 func (s *stateSync) commit(force bool) error {
-	if !force && s.bytesUncommitted < ethdb.IdealBatchSize {
-		return nil
-	}
-	start := time.Now()
-	b := s.d.stateDB.NewBatch()
-	s.sched.Commit(b)
-	if _, err := b.Commit(); err != nil {
+	if err := s.sched.Commit(force); err != nil {
 		return fmt.Errorf("DB write error: %v", err)
 	}
-	s.updateStats(s.numUncommitted, 0, 0, time.Since(start))
-	s.numUncommitted = 0
-	s.bytesUncommitted = 0
+	if force || time.Since(s.logged) > 8*time.Second {
+		s.updateStats()
+		s.logged = time.Now()
+	}
 	return nil
 }
 
-// assignTasks attempts to assign new tasks to all idle peers, either from the
-// batch currently being retried, or fetching new data from the trie sync itself.
+// assignTasks attempts to assign new tasks to all idle peers that have not yet
+// failed to serve the state of the block.
 func (s *stateSync) assignTasks() {
-	// Iterate over all idle peers and try to assign them state fetches
-	peers, _ := s.d.peers.NodeDataIdlePeers()
+	peers, _ := s.d.peers.StateRangeIdlePeers()
 	for _, p := range peers {
-		// Assign a batch of fetches proportional to the estimated latency/bandwidth
-		cap := p.NodeDataCapacity(s.d.requestRTT())
-		req := &stateReq{peer: p, timeout: s.d.requestTTL()}
-		s.fillTasks(cap, req)
-
-		// If the peer was assigned tasks to fetch, send the network request
-		if len(req.items) > 0 {
-			req.peer.log.Trace("Requesting new batch of data", "type", "state", "count", len(req.items))
-			select {
-			case s.d.trackStateReq <- req:
-				req.peer.FetchNodeData(req.items)
-			case <-s.cancel:
-			case <-s.d.cancelCh:
-			}
-		}
-	}
-}
-
-// fillTasks fills the given request object with a maximum of n state download
-// tasks to send to the remote peer.
-func (s *stateSync) fillTasks(n int, req *stateReq) {
-	// Refill available tasks from the scheduler.
-	if len(s.tasks) < n {
-		new := s.sched.Missing(n - len(s.tasks))
-		for _, hash := range new {
-			s.tasks[hash] = &stateTask{make(map[string]struct{})}
+		if _, ok := s.lacking[p.id]; ok {
+			continue
 		}
-	}
-	// Find tasks that haven't been tried with the request's peer.
-	req.items = make([]common.Hash, 0, n)
-	req.tasks = make(map[common.Hash]*stateTask, n)
-	for hash, t := range s.tasks {
-		// Stop when we've gathered enough requests
-		if len(req.items) == n {
-			break
+		// Assign a batch of items proportional to the estimated latency/bandwidth
+		task := s.sched.Next(p.NodeDataCapacity(s.d.requestRTT()))
+		if task == nil {
+			return
 		}
-		// Skip any requests we've already tried from this peer
-		if _, ok := t.attempts[req.peer.id]; ok {
-			continue
+		req := &stateReq{task: task, peer: p, timeout: s.d.requestTTL()}
+		req.peer.log.Trace("Requesting new batch of data", "type", "state", "kind", task.Kind, "accounts", len(task.Accounts), "codes", len(task.Codes))
+		select {
+		case s.d.trackStateReq <- req:
+			switch task.Kind {
+			case state.AccountRangeRequest:
+				req.peer.FetchAccountRange(s.header.Hash(), task.Origin, task.Limit)
+			case state.StorageRangeRequest:
+				req.peer.FetchStorageRanges(s.header.Hash(), task.Accounts, task.Origin)
+			case state.CodeRequest:
+				req.peer.FetchNodeData(task.Codes)
+			}
+		case <-s.cancel:
+			return
+		case <-s.d.cancelCh:
+			return
 		}
-		// Assign the request to this peer
-		t.attempts[req.peer.id] = struct{}{}
-		req.items = append(req.items, hash)
-		req.tasks[hash] = t
-		delete(s.tasks, hash)
 	}
 }
 
-// process iterates over a batch of delivered state data, injecting each item
-// into a running state sync, re-queuing any items that were requested but not
-// delivered. Returns whether the peer actually managed to deliver anything of
-// value, and any error that occurred.
-func (s *stateSync) process(req *stateReq) (int, error) {
-	// Collect processing stats and update progress if valid data was received
-	duplicate, unexpected, successful := 0, 0, 0
-
-	defer func(start time.Time) {
-		if duplicate > 0 || unexpected > 0 {
-			s.updateStats(0, duplicate, unexpected, time.Since(start))
+// process injects the response of the peer into the state sync. The tasks of
+// the requests that failed are returned to the schedule, and the peers that
+// sent invalid data are dropped.
+func (s *stateSync) process(req *stateReq) error {
+	var (
+		task = req.task
+		err  = errBadPeer
+	)
+	switch pack := req.response.(type) {
+	case nil:
+		// Timed out or dropped, retry the task with another peer
+		s.sched.Revert(task)
+		req.peer.SetNodeDataIdle(0)
+		return nil
+	case *accountRangePack:
+		if task.Kind == state.AccountRangeRequest {
+			err = s.sched.ProcessAccounts(task, pack.hashes, pack.accounts, pack.proof)
 		}
-	}(time.Now())
-
-	// Iterate over all the delivered data and inject one-by-one into the trie
-	for _, blob := range req.response {
-		_, hash, err := s.processNodeData(blob)
-		switch err {
-		case nil:
-			s.numUncommitted++
-			s.bytesUncommitted += len(blob)
-			successful++
-		case trie.ErrNotRequested:
-			unexpected++
-		case trie.ErrAlreadyProcessed:
-			duplicate++
-		default:
-			return successful, fmt.Errorf("invalid state node %s: %v", hash.TerminalString(), err)
+	case *storageRangesPack:
+		if task.Kind == state.StorageRangeRequest {
+			err = s.sched.ProcessStorage(task, pack.ranges, pack.proof)
 		}
-		if _, ok := req.tasks[hash]; ok {
-			delete(req.tasks, hash)
+	case *statePack:
+		if task.Kind == state.CodeRequest {
+			err = s.sched.ProcessCodes(task, pack.states)
 		}
 	}
-	// Put unfulfilled tasks back into the retry queue
-	npeers := s.d.peers.Len()
-	for hash, task := range req.tasks {
-		// If the node did deliver something, missing items may be due to a protocol
-		// limit or a previous timeout + delayed delivery. Both cases should permit
-		// the node to retry the missing items (to avoid single-peer stalls).
-		if len(req.response) > 0 || req.timedOut() {
-			delete(task.attempts, req.peer.id)
-		}
-		// If we've requested the node too many times already, it may be a malicious
-		// sync where nobody has the right data. Abort.
-		if len(task.attempts) >= npeers {
-			return successful, fmt.Errorf("state node %s failed with all peers (%d tries, %d peers)", hash.TerminalString(), len(task.attempts), npeers)
+	switch err {
+	case nil:
+		req.peer.SetNodeDataIdle(req.response.Items())
+
+	case state.ErrRangeUnavailable:
+		// The peer does not have the state, stop if nobody has it
+		req.peer.SetNodeDataIdle(0)
+		s.lacking[req.peer.id] = struct{}{}
+		if len(s.lacking) >= s.d.peers.Len() {
+			return errStateUnavailable
 		}
-		// Missing item, place into the retry queue.
-		s.tasks[hash] = task
-	}
-	return successful, nil
-}
 
-// processNodeData tries to inject a trie node data blob delivered from a remote
-// peer into the state trie, returning whether anything useful was written or any
-// error occurred.
-func (s *stateSync) processNodeData(blob []byte) (bool, common.Hash, error) {
-	res := trie.SyncResult{Data: blob}
-	s.keccak.Reset()
-	s.keccak.Write(blob)
-	s.keccak.Sum(res.Hash[:0])
-	committed, _, err := s.sched.Process([]trie.SyncResult{res})
-	return committed, res.Hash, err
+	default:
+		s.sched.Revert(task)
+		req.peer.SetNodeDataIdle(0)
+		log.Warn("Invalid state data, dropping peer", "peer", req.peer.id, "err", err)
+		s.d.dropPeer(req.peer.id)
+	}
+	return nil
 }
 
-// updateStats bumps the various state sync progress counters and displays a log
+// updateStats updates the state sync progress counters and displays a log
 // message for the user to see.
-func (s *stateSync) updateStats(written, duplicate, unexpected int, duration time.Duration) {
+func (s *stateSync) updateStats() {
+	stats := s.sched.Stats()
+
 	s.d.syncStatsLock.Lock()
 	defer s.d.syncStatsLock.Unlock()
 
 	s.d.syncStatsState.pending = uint64(s.sched.Pending())
-	s.d.syncStatsState.processed += uint64(written)
-	s.d.syncStatsState.duplicate += uint64(duplicate)
-	s.d.syncStatsState.unexpected += uint64(unexpected)
+	s.d.syncStatsState.processed = stats.Accounts + stats.Storage + stats.Codes
 
-	if written > 0 || duplicate > 0 || unexpected > 0 {
-		log.Info("Imported new state entries", "count", written, "elapsed", common.PrettyDuration(duration), "processed", s.d.syncStatsState.processed, "pending", s.d.syncStatsState.pending, "retry", len(s.tasks), "duplicate", s.d.syncStatsState.duplicate, "unexpected", s.d.syncStatsState.unexpected)
-	}
-	if written > 0 {
-		rawdb.WriteFastTrieProgress(s.d.stateDB, s.d.syncStatsState.processed)
-	}
+	log.Info("Imported new state entries", "number", s.header.Number, "accounts", stats.Accounts, "storage", stats.Storage, "codes", stats.Codes, "pending", s.d.syncStatsState.pending)
+	rawdb.WriteFastTrieProgress(s.d.stateDB, s.d.syncStatsState.processed)
 }
@@ -19,6 +19,8 @@ package downloader
 import (
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -77,3 +79,26 @@ type statePack struct {
 func (p *statePack) PeerId() string { return p.peerID }
 func (p *statePack) Items() int     { return len(p.states) }
 func (p *statePack) Stats() string  { return fmt.Sprintf("%d", len(p.states)) }
+
+// accountRangePack is a range of accounts returned by a peer.
+type accountRangePack struct {
+	peerID   string
+	hashes   []common.Hash
+	accounts [][]byte
+	proof    [][]byte
+}
+
+func (p *accountRangePack) PeerId() string { return p.peerID }
+func (p *accountRangePack) Items() int     { return len(p.hashes) }
+func (p *accountRangePack) Stats() string  { return fmt.Sprintf("%d", len(p.hashes)) }
+
+// storageRangesPack is a batch of storage ranges returned by a peer.
+type storageRangesPack struct {
+	peerID string
+	ranges []state.StorageRange
+	proof  [][]byte
+}
+
+func (p *storageRangesPack) PeerId() string { return p.peerID }
+func (p *storageRangesPack) Items() int     { return len(p.ranges) }
+func (p *storageRangesPack) Stats() string  { return fmt.Sprintf("%d", len(p.ranges)) }
@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/eth/fetcher"
@@ -66,6 +67,14 @@ func errResp(code errCode, format string, v ...interface{}) error {
 	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
 }
 
+// responseLimit caps the response size requested by the peer at softResponseLimit.
+func responseLimit(requested uint64) int {
+	if requested == 0 || requested > softResponseLimit {
+		return softResponseLimit
+	}
+	return int(requested)
+}
+
 type ProtocolManager struct {
 	networkID uint64
 
@@ -74,11 +83,13 @@ type ProtocolManager struct {
 
 	txpool      txPool
 	blockchain  *core.BlockChain
+	chaindb     ethdb.Database
 	chainconfig *params.ChainConfig
 	maxPeers    int
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	witnesses  *witnessFetcher // Retrieves block witnesses in stateless mode, nil otherwise
 	peers      *peerSet
 
 	SubProtocols []p2p.Protocol
@@ -110,6 +121,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		eventMux:    mux,
 		txpool:      txpool,
 		blockchain:  blockchain,
+		chaindb:     chaindb,
 		chainconfig: config,
 		peers:       newPeerSet(),
 		whitelist:   whitelist,
@@ -126,6 +138,11 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	if mode == downloader.FastSync {
 		manager.fastSync = uint32(1)
 	}
+	// In stateless mode the blocks are verified against witnesses fetched from peers
+	if mode == downloader.StatelessSync {
+		manager.witnesses = newWitnessFetcher(manager.peers, manager.quitSync)
+		blockchain.SetStateless(manager.witnesses.fetch)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
 	for i, version := range ProtocolVersions {
@@ -164,6 +181,26 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	if len(manager.SubProtocols) == 0 {
 		return nil, errIncompatibleConfig
 	}
+	// The witnesses and the state ranges are served over a protocol of their own,
+	// running alongside eth
+	for i, version := range WitnessProtocolVersions {
+		version := version // Closure for the run
+		manager.SubProtocols = append(manager.SubProtocols, p2p.Protocol{
+			Name:    WitnessProtocolName,
+			Version: version,
+			Length:  WitnessProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				select {
+				case <-manager.quitSync:
+					return p2p.DiscQuitting
+				default:
+				}
+				manager.wg.Add(1)
+				defer manager.wg.Done()
+				return manager.handleWitness(newWitnessPeer(int(version), p, newMeteredWitnessMsgWriter(rw)))
+			},
+		})
+	}
 	// Construct the different synchronisation mechanisms
 	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
 
@@ -328,6 +365,150 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 }
 
+// handleWitness is the callback invoked to manage the life cycle of the witness
+// protocol of a peer. When this function terminates, the peer is disconnected.
+func (pm *ProtocolManager) handleWitness(p *witnessPeer) error {
+	if err := pm.peers.RegisterWitness(p); err != nil {
+		p.Log().Debug("Witness peer registration failed", "err", err)
+		return err
+	}
+	defer pm.peers.UnregisterWitness(p.id)
+
+	// Handle incoming messages until the connection is torn down
+	for {
+		if err := pm.handleWitnessMsg(p); err != nil {
+			p.Log().Debug("Witness message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleWitnessMsg is invoked whenever an inbound message of the witness
+// protocol is received from a remote peer. The remote connection is torn down
+// upon returning any error.
+func (pm *ProtocolManager) handleWitnessMsg(p *witnessPeer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch {
+	case msg.Code == GetBlockWitnessMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather witnesses until the fetch or network limits is reached
+		var (
+			hash      common.Hash
+			bytes     int
+			witnesses [][]byte
+		)
+		for bytes < softResponseLimit && len(witnesses) < downloader.MaxBlockFetch {
+			// Retrieve the hash of the next block
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested block witness, stopping if enough was found
+			if data := pm.blockchain.GetWitness(hash); len(data) != 0 {
+				witnesses = append(witnesses, data)
+				bytes += len(data)
+			}
+		}
+		return p.SendBlockWitnesses(witnesses)
+
+	case msg.Code == BlockWitnessMsg:
+		// A batch of block witnesses arrived to one of our previous requests
+		var witnesses [][]byte
+		if err := msg.Decode(&witnesses); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Deliver them to the pending witness request, if any
+		if pm.witnesses == nil {
+			log.Debug("Dropping unsolicited block witnesses", "peer", p.id, "count", len(witnesses))
+		} else if err := pm.witnesses.deliver(p.id, witnesses); err != nil {
+			log.Debug("Failed to deliver block witnesses", "err", err)
+		}
+
+	case msg.Code == GetAccountRangeMsg:
+		var query getAccountRangeData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Read the range from the state of the block, sending nothing unless it is
+		// the current state, whose proofs are built from the intermediate hashes
+		var (
+			hashes          []common.Hash
+			accounts, proof [][]byte
+		)
+		if header := pm.blockchain.GetHeaderByHash(query.Block); header != nil {
+			var err error
+			hashes, accounts, proof, err = state.ReadAccountRange(pm.chaindb, header.Root, header.Number.Uint64(), query.Origin, query.Limit, responseLimit(query.Bytes), false)
+			if err != nil {
+				log.Debug("Failed to read account range", "block", query.Block, "err", err)
+				hashes, accounts, proof = nil, nil, nil
+			}
+		}
+		return p.SendAccountRange(hashes, accounts, proof)
+
+	case msg.Code == AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		var data accountRangeData
+		if err := msg.Decode(&data); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := pm.downloader.DeliverAccountRange(p.id, data.Hashes, data.Accounts, data.Proof); err != nil {
+			log.Debug("Failed to deliver account range", "err", err)
+		}
+
+	case msg.Code == GetStorageRangesMsg:
+		var query getStorageRangesData
+		if err := msg.Decode(&query); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if len(query.Accounts) > downloader.MaxStateFetch {
+			query.Accounts = query.Accounts[:downloader.MaxStateFetch]
+		}
+		// Read the storage from the state of the block, sending nothing unless it is the current state
+		var (
+			ranges []state.StorageRange
+			proof  [][]byte
+		)
+		if header := pm.blockchain.GetHeaderByHash(query.Block); header != nil {
+			var err error
+			ranges, proof, err = state.ReadStorageRanges(pm.chaindb, header.Root, header.Number.Uint64(), query.Accounts, query.Origin, responseLimit(query.Bytes), false)
+			if err != nil {
+				log.Debug("Failed to read storage ranges", "block", query.Block, "err", err)
+				ranges, proof = nil, nil
+			}
+		}
+		return p.SendStorageRanges(ranges, proof)
+
+	case msg.Code == StorageRangesMsg:
+		// A batch of storage ranges arrived to one of our previous requests
+		var data storageRangesData
+		if err := msg.Decode(&data); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := pm.downloader.DeliverStorageRanges(p.id, data.Ranges, data.Proof); err != nil {
+			log.Debug("Failed to deliver storage ranges", "err", err)
+		}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
 // handleMsg is invoked whenever an inbound message is received from a remote
 // peer. The remote connection is torn down upon returning any error.
 func (pm *ProtocolManager) handleMsg(p *peer) error {
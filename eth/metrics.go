@@ -50,6 +50,10 @@ var (
 	reqReceiptInTrafficMeter  = metrics.NewRegisteredMeter("eth/req/receipts/in/traffic", nil)
 	reqReceiptOutPacketsMeter = metrics.NewRegisteredMeter("eth/req/receipts/out/packets", nil)
 	reqReceiptOutTrafficMeter = metrics.NewRegisteredMeter("eth/req/receipts/out/traffic", nil)
+	reqWitnessInPacketsMeter  = metrics.NewRegisteredMeter("eth/req/witnesses/in/packets", nil)
+	reqWitnessInTrafficMeter  = metrics.NewRegisteredMeter("eth/req/witnesses/in/traffic", nil)
+	reqWitnessOutPacketsMeter = metrics.NewRegisteredMeter("eth/req/witnesses/out/packets", nil)
+	reqWitnessOutTrafficMeter = metrics.NewRegisteredMeter("eth/req/witnesses/out/traffic", nil)
 	miscInPacketsMeter        = metrics.NewRegisteredMeter("eth/misc/in/packets", nil)
 	miscInTrafficMeter        = metrics.NewRegisteredMeter("eth/misc/in/traffic", nil)
 	miscOutPacketsMeter       = metrics.NewRegisteredMeter("eth/misc/out/packets", nil)
@@ -137,3 +141,50 @@ func (rw *meteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
 	// Send the packet to the p2p layer
 	return rw.MsgReadWriter.WriteMsg(msg)
 }
+
+// meteredWitnessMsgReadWriter is a wrapper around the p2p.MsgReadWriter of the
+// witness protocol, which has its own message ids, accumulating the witness
+// metrics based on the data stream contents.
+type meteredWitnessMsgReadWriter struct {
+	p2p.MsgReadWriter // Wrapped message stream to meter
+}
+
+// newMeteredWitnessMsgWriter wraps a p2p MsgReadWriter of the witness protocol
+// with metering support. If the metrics system is disabled, this function
+// returns the original object.
+func newMeteredWitnessMsgWriter(rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+	if !metrics.Enabled {
+		return rw
+	}
+	return &meteredWitnessMsgReadWriter{MsgReadWriter: rw}
+}
+
+func (rw *meteredWitnessMsgReadWriter) ReadMsg() (p2p.Msg, error) {
+	// Read the message and short circuit in case of an error
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	// Account for the data traffic
+	packets, traffic := miscInPacketsMeter, miscInTrafficMeter
+	if msg.Code == BlockWitnessMsg {
+		packets, traffic = reqWitnessInPacketsMeter, reqWitnessInTrafficMeter
+	}
+	packets.Mark(1)
+	traffic.Mark(int64(msg.Size))
+
+	return msg, err
+}
+
+func (rw *meteredWitnessMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	// Account for the data traffic
+	packets, traffic := miscOutPacketsMeter, miscOutTrafficMeter
+	if msg.Code == BlockWitnessMsg {
+		packets, traffic = reqWitnessOutPacketsMeter, reqWitnessOutTrafficMeter
+	}
+	packets.Mark(1)
+	traffic.Mark(int64(msg.Size))
+
+	// Send the packet to the p2p layer
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
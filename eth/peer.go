@@ -20,11 +20,13 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -34,6 +36,7 @@ var (
 	errClosed            = errors.New("peer set is closed")
 	errAlreadyRegistered = errors.New("peer is already registered")
 	errNotRegistered     = errors.New("peer is not registered")
+	errNoWitnessProtocol = errors.New("witness protocol not negotiated with the peer")
 )
 
 const (
@@ -83,6 +86,7 @@ type peer struct {
 
 	head common.Hash
 	td   *big.Int
+	wit  *witnessPeer // Witness protocol of the same connection, nil if not negotiated
 	lock sync.RWMutex
 
 	knownTxs    mapset.Set                // Set of transaction hashes known to be known by this peer
@@ -327,6 +331,63 @@ func (p *peer) RequestReceipts(hashes []common.Hash) error {
 	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
 }
 
+// RequestAccountRange fetches a range of the accounts in the state of a block
+// over the witness protocol.
+func (p *peer) RequestAccountRange(block common.Hash, origin, limit common.Hash, bytes uint64) error {
+	wit := p.witness()
+	if wit == nil {
+		return errNoWitnessProtocol
+	}
+	p.Log().Debug("Fetching range of accounts", "block", block, "origin", origin, "limit", limit)
+	return p2p.Send(wit.rw, GetAccountRangeMsg, &getAccountRangeData{Block: block, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestStorageRanges fetches the storage of a batch of accounts in the state
+// of a block over the witness protocol.
+func (p *peer) RequestStorageRanges(block common.Hash, accounts []common.Hash, origin common.Hash, bytes uint64) error {
+	wit := p.witness()
+	if wit == nil {
+		return errNoWitnessProtocol
+	}
+	p.Log().Debug("Fetching batch of storage ranges", "block", block, "count", len(accounts), "origin", origin)
+	return p2p.Send(wit.rw, GetStorageRangesMsg, &getStorageRangesData{Block: block, Accounts: accounts, Origin: origin, Bytes: bytes})
+}
+
+// RequestWitnesses fetches a batch of block witnesses from a remote node over
+// the witness protocol.
+func (p *peer) RequestWitnesses(hashes []common.Hash) error {
+	wit := p.witness()
+	if wit == nil {
+		return errNoWitnessProtocol
+	}
+	p.Log().Debug("Fetching batch of block witnesses", "count", len(hashes))
+	return p2p.Send(wit.rw, GetBlockWitnessMsg, hashes)
+}
+
+// ServesStateRanges tells the downloader whether the peer speaks the witness
+// protocol, which serves the state ranges.
+func (p *peer) ServesStateRanges() bool {
+	return p.witness() != nil
+}
+
+// witness returns the witness protocol of the peer, or nil if the peer does
+// not speak it.
+func (p *peer) witness() *witnessPeer {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.wit
+}
+
+// setWitness attaches the witness protocol of the connection to the peer, or
+// detaches it if nil.
+func (p *peer) setWitness(wit *witnessPeer) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.wit = wit
+}
+
 // Handshake executes the eth protocol handshake, negotiating version number,
 // network IDs, difficulties, head and genesis blocks.
 func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
@@ -396,18 +457,55 @@ func (p *peer) String() string {
 	)
 }
 
+// witnessPeer is the witness protocol running on the connection of an eth peer.
+type witnessPeer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	version int // Protocol version negotiated
+}
+
+func newWitnessPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *witnessPeer {
+	return &witnessPeer{
+		Peer:    p,
+		rw:      rw,
+		version: version,
+		id:      fmt.Sprintf("%x", p.ID().Bytes()[:8]),
+	}
+}
+
+// SendBlockWitnesses sends a batch of encoded block witnesses, corresponding to
+// the blocks requested.
+func (p *witnessPeer) SendBlockWitnesses(witnesses [][]byte) error {
+	return p2p.Send(p.rw, BlockWitnessMsg, witnesses)
+}
+
+// SendAccountRange sends a range of accounts with its proof.
+func (p *witnessPeer) SendAccountRange(hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	return p2p.Send(p.rw, AccountRangeMsg, &accountRangeData{Hashes: hashes, Accounts: accounts, Proof: proof})
+}
+
+// SendStorageRanges sends a batch of storage ranges with the proof of the last one.
+func (p *witnessPeer) SendStorageRanges(ranges []state.StorageRange, proof [][]byte) error {
+	return p2p.Send(p.rw, StorageRangesMsg, &storageRangesData{Ranges: ranges, Proof: proof})
+}
+
 // peerSet represents the collection of active peers currently participating in
 // the Ethereum sub-protocol.
 type peerSet struct {
-	peers  map[string]*peer
-	lock   sync.RWMutex
-	closed bool
+	peers     map[string]*peer
+	witnesses map[string]*witnessPeer // Witness protocols, attached to the peers of the same id
+	lock      sync.RWMutex
+	closed    bool
 }
 
 // newPeerSet creates a new peer set to track the active participants.
 func newPeerSet() *peerSet {
 	return &peerSet{
-		peers: make(map[string]*peer),
+		peers:     make(map[string]*peer),
+		witnesses: make(map[string]*witnessPeer),
 	}
 }
 
@@ -425,6 +523,9 @@ func (ps *peerSet) Register(p *peer) error {
 		return errAlreadyRegistered
 	}
 	ps.peers[p.id] = p
+	if wit, ok := ps.witnesses[p.id]; ok {
+		p.setWitness(wit)
+	}
 	go p.broadcast()
 
 	return nil
@@ -446,6 +547,42 @@ func (ps *peerSet) Unregister(id string) error {
 	return nil
 }
 
+// RegisterWitness injects the witness protocol of a peer into the working set,
+// attaching it to the eth peer of the same connection, either already
+// registered or when it gets registered.
+func (ps *peerSet) RegisterWitness(wit *witnessPeer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if ps.closed {
+		return errClosed
+	}
+	if _, ok := ps.witnesses[wit.id]; ok {
+		return errAlreadyRegistered
+	}
+	ps.witnesses[wit.id] = wit
+	if p, ok := ps.peers[wit.id]; ok {
+		p.setWitness(wit)
+	}
+	return nil
+}
+
+// UnregisterWitness removes the witness protocol of a peer from the working
+// set, detaching it from the eth peer.
+func (ps *peerSet) UnregisterWitness(id string) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.witnesses[id]; !ok {
+		return errNotRegistered
+	}
+	delete(ps.witnesses, id)
+	if p, ok := ps.peers[id]; ok {
+		p.setWitness(nil)
+	}
+	return nil
+}
+
 // Peer retrieves the registered peer with the given id.
 func (ps *peerSet) Peer(id string) *peer {
 	ps.lock.RLock()
@@ -509,6 +646,26 @@ func (ps *peerSet) BestPeer() *peer {
 	return bestPeer
 }
 
+// WitnessPeers retrieves the peers able to serve block witnesses, ordered by
+// their total difficulty, best first.
+func (ps *peerSet) WitnessPeers() []*peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if p.witness() != nil {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		_, tdi := list[i].Head()
+		_, tdj := list[j].Head()
+		return tdi.Cmp(tdj) > 0
+	})
+	return list
+}
+
 // Close disconnects all peers.
 // No new peers can be registered after Close has returned.
 func (ps *peerSet) Close() {
@@ -23,6 +23,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -45,6 +46,22 @@ var ProtocolLengths = []uint64{17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
+// Constants to match up witness protocol versions and messages
+const (
+	wit1 = 1
+)
+
+// WitnessProtocolName is the short name of the protocol serving the block
+// witnesses and the ranges of the state, which is negotiated alongside eth on
+// the same connection.
+var WitnessProtocolName = "wit"
+
+// WitnessProtocolVersions are the supported versions of the witness protocol (first is primary).
+var WitnessProtocolVersions = []uint{wit1}
+
+// WitnessProtocolLengths are the number of implemented message corresponding to different protocol versions.
+var WitnessProtocolLengths = []uint64{6}
+
 // eth protocol message codes
 const (
 	// Protocol messages belonging to eth/62
@@ -64,6 +81,17 @@ const (
 	ReceiptsMsg    = 0x10
 )
 
+// wit protocol message codes
+const (
+	// Protocol messages belonging to wit/1
+	GetBlockWitnessMsg  = 0x00
+	BlockWitnessMsg     = 0x01
+	GetAccountRangeMsg  = 0x02
+	AccountRangeMsg     = 0x03
+	GetStorageRangesMsg = 0x04
+	StorageRangesMsg    = 0x05
+)
+
 type errCode int
 
 const (
@@ -181,3 +209,37 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// getAccountRangeData is the network packet for the retrieval of a range of the
+// accounts in the state of a block.
+type getAccountRangeData struct {
+	Block  common.Hash // Hash of the block to read the state of
+	Origin common.Hash // Hash of the first account to retrieve
+	Limit  common.Hash // Hash of the last account to retrieve
+	Bytes  uint64      // Soft limit of the response size
+}
+
+// accountRangeData is the network packet for the account range response. The
+// accounts are in the form of the AccountsBucket, with the proof of the range.
+type accountRangeData struct {
+	Hashes   []common.Hash
+	Accounts [][]byte
+	Proof    [][]byte
+}
+
+// getStorageRangesData is the network packet for the retrieval of the storage
+// of the accounts in the state of a block. The storage of the first account
+// starts at the origin, which is only allowed with a single account.
+type getStorageRangesData struct {
+	Block    common.Hash   // Hash of the block to read the state of
+	Accounts []common.Hash // Hashes of the accounts to retrieve the storage of
+	Origin   common.Hash   // Hash of the first storage key to retrieve
+	Bytes    uint64        // Soft limit of the response size
+}
+
+// storageRangesData is the network packet for the storage ranges response. The
+// proof covers the last range, if it is not the whole storage of the account.
+type storageRangesData struct {
+	Ranges []state.StorageRange
+	Proof  [][]byte
+}
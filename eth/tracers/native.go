@@ -0,0 +1,684 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/replay"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ResultTracer is a transaction tracer returning its result as JSON, either one
+// of the JavaScript tracers or one of the native ones.
+type ResultTracer interface {
+	vm.Tracer
+
+	// GetResult returns the result of the tracing, or any accumulated error.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates the tracing at the first opportune moment.
+	Stop(err error)
+}
+
+// natives contains the Go implementations of the built in JavaScript tracers,
+// which are used in their place. Their results are identical to the ones of the
+// JavaScript tracers.
+var natives = map[string]func() ResultTracer{
+	"callTracer":     func() ResultTracer { return newCallTracer() },
+	"prestateTracer": func() ResultTracer { return newPrestateTracer() },
+	"4byteTracer":    func() ResultTracer { return newFourByteTracer() },
+	"opcountTracer":  func() ResultTracer { return newOpcountTracer() },
+}
+
+// init makes the native tracers available for replaying the blocks.
+func init() {
+	for name, constructor := range natives {
+		constructor := constructor
+		replay.Register(name, func() replay.Tracer { return constructor() })
+	}
+}
+
+// NewTracer instantiates the tracer with the given name, or the JavaScript
+// tracer given by its code. The built in tracers having a Go implementation
+// are run natively, the others are run as JavaScript.
+func NewTracer(code string) (ResultTracer, error) {
+	if constructor, ok := natives[code]; ok {
+		return constructor(), nil
+	}
+	tracer, err := New(code)
+	if err != nil {
+		return nil, err
+	}
+	return tracer, nil
+}
+
+// nativeTracer is the state shared by the native tracers.
+type nativeTracer struct {
+	db  vm.StateDB // State of the last executed instruction
+	err error      // Error, if one has occurred
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (nt *nativeTracer) Stop(err error) {
+	nt.reason = err
+	atomic.StoreUint32(&nt.interrupt, 1)
+}
+
+// step checks whether the tracing is to go on with the instruction.
+func (nt *nativeTracer) step(env *vm.EVM) bool {
+	if nt.err != nil {
+		return false
+	}
+	if atomic.LoadUint32(&nt.interrupt) > 0 {
+		nt.err = nt.reason
+		return false
+	}
+	nt.db = env.StateDB
+	return true
+}
+
+func (nt *nativeTracer) CaptureStart(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (nt *nativeTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (nt *nativeTracer) CaptureEnd(depth int, output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+func (nt *nativeTracer) CaptureCreate(creator, creation common.Address) error {
+	return nil
+}
+
+func (nt *nativeTracer) CaptureAccountRead(account common.Address) error {
+	return nil
+}
+
+func (nt *nativeTracer) CaptureAccountWrite(account common.Address) error {
+	return nil
+}
+
+// txContext is the context of the traced transaction, as given to the result
+// function of the JavaScript tracers.
+type txContext struct {
+	create  bool
+	from    common.Address
+	to      common.Address
+	input   []byte
+	gas     uint64
+	value   *big.Int
+	output  []byte
+	gasUsed uint64
+	time    time.Duration
+	err     error
+}
+
+// start fills in the context at the start of the transaction.
+func (ctx *txContext) start(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	if depth == 0 {
+		ctx.create, ctx.from, ctx.to, ctx.input, ctx.gas, ctx.value = create, from, to, input, gas, value
+	}
+}
+
+// end fills in the context at the end of the transaction.
+func (ctx *txContext) end(depth int, output []byte, gasUsed uint64, t time.Duration, err error) {
+	if depth == 0 {
+		ctx.output, ctx.gasUsed, ctx.time, ctx.err = output, gasUsed, t, err
+	}
+}
+
+// orderedObject is a JSON object keeping its keys in the order they were added,
+// as the JavaScript objects do.
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedObject() *orderedObject {
+	return &orderedObject{values: make(map[string]interface{})}
+}
+
+// get returns the value of the key, if present.
+func (o *orderedObject) get(key string) (interface{}, bool) {
+	value, ok := o.values[key]
+	return value, ok
+}
+
+// set sets the value of the key, appending the key if it is new.
+func (o *orderedObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// delete removes the key.
+func (o *orderedObject) delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		enc, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(enc)
+		buf.WriteByte(':')
+		if enc, err = marshalJSON(o.values[key]); err != nil {
+			return nil, err
+		}
+		buf.Write(enc)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSON encodes the value without escaping the HTML characters, as the
+// JavaScript tracers do.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// bigHex formats the number as the JavaScript tracers do: '0x' followed by the
+// hex digits, the sign included.
+func bigHex(n *big.Int) string {
+	return "0x" + n.Text(16)
+}
+
+// jsNumber formats the number as a JavaScript number is converted to a string.
+func jsNumber(n *big.Int) string {
+	f, _ := new(big.Float).SetInt(n).Float64()
+	if math.Abs(f) < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// peekStack returns the nth item from the top of the stack, zero if missing.
+func peekStack(stack *vm.Stack, n int) *big.Int {
+	if stack.Len() <= n {
+		return new(big.Int)
+	}
+	return stack.Back(n)
+}
+
+// sliceMemory returns the memory between begin and end, nil if it is not all
+// allocated.
+func sliceMemory(memory *vm.Memory, begin, end *big.Int) []byte {
+	if !begin.IsInt64() || !end.IsInt64() || int64(memory.Len()) < end.Int64() || begin.Sign() < 0 || begin.Cmp(end) > 0 {
+		return nil
+	}
+	return memory.Get(begin.Int64(), end.Int64()-begin.Int64())
+}
+
+// isPrecompiled reports whether the address is of a precompiled contract.
+func isPrecompiled(address common.Address) bool {
+	_, ok := vm.PrecompiledContractsByzantium[address]
+	return ok
+}
+
+// opcountTracer counts the instructions executed by the transaction.
+type opcountTracer struct {
+	nativeTracer
+	count uint64
+}
+
+func newOpcountTracer() *opcountTracer {
+	return new(opcountTracer)
+}
+
+func (t *opcountTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.step(env) {
+		t.count++
+	}
+	return nil
+}
+
+func (t *opcountTracer) GetResult() (json.RawMessage, error) {
+	return json.RawMessage(strconv.FormatUint(t.count, 10)), t.err
+}
+
+// fourByteTracer counts the method identifiers of the calls, along with the
+// size of the data following them.
+type fourByteTracer struct {
+	nativeTracer
+	ctx txContext
+	ids *orderedObject
+}
+
+func newFourByteTracer() *fourByteTracer {
+	return &fourByteTracer{ids: newOrderedObject()}
+}
+
+func (t *fourByteTracer) CaptureStart(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.start(depth, from, to, create, input, gas, value)
+	return nil
+}
+
+// store counts the identifier with the size of the data.
+func (t *fourByteTracer) store(id []byte, size string) {
+	key := hexutil.Encode(id) + "-" + size
+	count, _ := t.ids.get(key)
+	n, _ := count.(int)
+	t.ids.set(key, n+1)
+}
+
+func (t *fourByteTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if !t.step(env) {
+		return nil
+	}
+	var in int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		in = 3
+	case vm.DELEGATECALL, vm.STATICCALL:
+		in = 2
+	default:
+		return nil
+	}
+	if isPrecompiled(common.BigToAddress(peekStack(stack, 1))) {
+		return nil
+	}
+	size := peekStack(stack, in+1)
+	if size.Cmp(big.NewInt(4)) >= 0 {
+		off := peekStack(stack, in)
+		t.store(sliceMemory(memory, off, new(big.Int).Add(off, big.NewInt(4))), jsNumber(new(big.Int).Sub(size, big.NewInt(4))))
+	}
+	return nil
+}
+
+func (t *fourByteTracer) GetResult() (json.RawMessage, error) {
+	if len(t.ctx.input) >= 4 {
+		t.store(t.ctx.input[:4], strconv.Itoa(len(t.ctx.input)-4))
+	}
+	res, err := marshalJSON(t.ids)
+	if err != nil {
+		return nil, err
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	return res, nil
+}
+
+// prestateAccount is an account in the result of the prestateTracer.
+type prestateAccount struct {
+	Balance string         `json:"balance"`
+	Nonce   int64          `json:"nonce"`
+	Code    string         `json:"code"`
+	Storage *orderedObject `json:"storage"`
+}
+
+// prestateTracer collects the accounts and the storage accessed by the
+// transaction, as they were before it.
+type prestateTracer struct {
+	nativeTracer
+	ctx      txContext
+	prestate *orderedObject
+}
+
+func newPrestateTracer() *prestateTracer {
+	return new(prestateTracer)
+}
+
+func (t *prestateTracer) CaptureStart(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.start(depth, from, to, create, input, gas, value)
+	return nil
+}
+
+// lookupAccount adds the account to the prestate, unless it is already there.
+func (t *prestateTracer) lookupAccount(address common.Address) {
+	key := hexutil.Encode(address[:])
+	if _, ok := t.prestate.get(key); ok {
+		return
+	}
+	t.prestate.set(key, &prestateAccount{
+		Balance: bigHex(t.db.GetBalance(address)),
+		Nonce:   int64(t.db.GetNonce(address)),
+		Code:    hexutil.Encode(t.db.GetCode(address)),
+		Storage: newOrderedObject(),
+	})
+}
+
+// lookupStorage adds the storage item of the account to the prestate, unless
+// it is already there.
+func (t *prestateTracer) lookupStorage(address common.Address, key common.Hash) {
+	t.lookupAccount(address)
+	account, _ := t.prestate.get(hexutil.Encode(address[:]))
+	storage := account.(*prestateAccount).Storage
+
+	idx := hexutil.Encode(key[:])
+	if _, ok := storage.get(idx); !ok {
+		value := t.db.GetState(address, key)
+		storage.set(idx, hexutil.Encode(value[:]))
+	}
+}
+
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if !t.step(env) {
+		return nil
+	}
+	// Add the current account if we just started tracing. The balance includes
+	// the value sent along with the message, which is fixed in the result.
+	if t.prestate == nil {
+		t.prestate = newOrderedObject()
+		t.lookupAccount(contract.Address())
+	}
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 0)))
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, t.db.GetNonce(from)))
+	case vm.CREATE2:
+		offset := peekStack(stack, 1)
+		code := sliceMemory(memory, offset, new(big.Int).Add(offset, peekStack(stack, 2)))
+		t.lookupAccount(crypto.CreateAddress2(contract.Address(), common.BigToHash(peekStack(stack, 3)), crypto.Keccak256(code)))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 1)))
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(peekStack(stack, 0)))
+	}
+	return nil
+}
+
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.prestate == nil {
+		return nil, wrapError("result", errors.New("TypeError: no code executed"))
+	}
+	// The value of the transaction has to be moved back to the sender
+	t.lookupAccount(t.ctx.from)
+
+	from, _ := t.prestate.get(hexutil.Encode(t.ctx.from[:]))
+	to, ok := t.prestate.get(hexutil.Encode(t.ctx.to[:]))
+	if !ok {
+		return nil, wrapError("result", errors.New("TypeError: recipient not traced"))
+	}
+	fromAccount, toAccount := from.(*prestateAccount), to.(*prestateAccount)
+
+	toBalance, _ := new(big.Int).SetString(toAccount.Balance[2:], 16)
+	toAccount.Balance = bigHex(toBalance.Sub(toBalance, t.ctx.value))
+	fromBalance, _ := new(big.Int).SetString(fromAccount.Balance[2:], 16)
+	fromAccount.Balance = bigHex(fromBalance.Add(fromBalance, t.ctx.value))
+
+	// Decrement the nonce of the sender, and remove the empty create target, as
+	// any existing state would have made the transaction invalid
+	fromAccount.Nonce--
+	if t.ctx.create {
+		t.prestate.delete(hexutil.Encode(t.ctx.to[:]))
+	}
+	return marshalJSON(t.prestate)
+}
+
+// callFrame is a call in the result of the callTracer. The exported fields are
+// in the order of the JavaScript tracer, and are left out when they are empty.
+type callFrame struct {
+	Type    string       `json:"type"`
+	From    string       `json:"from,omitempty"`
+	To      string       `json:"to,omitempty"`
+	Value   string       `json:"value,omitempty"`
+	Gas     string       `json:"gas,omitempty"`
+	GasUsed string       `json:"gasUsed,omitempty"`
+	Input   string       `json:"input,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Time    string       `json:"time,omitempty"`
+	Calls   []*callFrame `json:"calls,omitempty"`
+
+	gas     uint64 // Gas given to the call, known once it starts executing
+	gasSet  bool
+	gasIn   uint64 // Gas before the instruction making the call
+	gasCost uint64 // Cost of the instruction making the call
+	outOff  *big.Int
+	outLen  *big.Int
+}
+
+// callTracer collects the calls made by the transaction.
+type callTracer struct {
+	nativeTracer
+	ctx       txContext
+	callstack []*callFrame
+	descended bool // Whether the execution just descended into a call
+}
+
+func newCallTracer() *callTracer {
+	return &callTracer{callstack: []*callFrame{{}}}
+}
+
+func (t *callTracer) CaptureStart(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.start(depth, from, to, create, input, gas, value)
+	return nil
+}
+
+func (t *callTracer) CaptureEnd(depth int, output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.ctx.end(depth, output, gasUsed, d, err)
+	return nil
+}
+
+// top returns the innermost call.
+func (t *callTracer) top() *callFrame {
+	return t.callstack[len(t.callstack)-1]
+}
+
+// pop removes the innermost call.
+func (t *callTracer) pop() *callFrame {
+	call := t.top()
+	t.callstack = t.callstack[:len(t.callstack)-1]
+	return call
+}
+
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if !t.step(env) {
+		return nil
+	}
+	if err != nil {
+		t.fault(err)
+		return nil
+	}
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		inOff := peekStack(stack, 1)
+		t.callstack = append(t.callstack, &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			Input:   hexutil.Encode(sliceMemory(memory, inOff, new(big.Int).Add(inOff, peekStack(stack, 2)))),
+			Value:   bigHex(peekStack(stack, 0)),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return nil
+
+	case vm.SELFDESTRUCT:
+		t.top().Calls = append(t.top().Calls, &callFrame{Type: op.String()})
+		return nil
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// Skip any pre-compile invocations, those are just fancy opcodes
+		to := common.BigToAddress(peekStack(stack, 1))
+		if isPrecompiled(to) {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := peekStack(stack, 2+off)
+		call := &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			To:      hexutil.Encode(to.Bytes()),
+			Input:   hexutil.Encode(sliceMemory(memory, inOff, new(big.Int).Add(inOff, peekStack(stack, 3+off)))),
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  new(big.Int).Set(peekStack(stack, 4+off)),
+			outLen:  new(big.Int).Set(peekStack(stack, 5+off)),
+		}
+		if off == 1 {
+			call.Value = bigHex(peekStack(stack, 2))
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve its true allowance,
+	// which can't be known from the instruction making the call. The gas of the
+	// calls to the plain accounts can't be known at all.
+	if t.descended {
+		if depth >= len(t.callstack) {
+			t.top().gas, t.top().gasSet = gas, true
+		}
+		t.descended = false
+	}
+	if op == vm.REVERT {
+		t.top().Error = "execution reverted"
+		return nil
+	}
+	if depth != len(t.callstack)-1 {
+		return nil
+	}
+	// An existing call returned, retrieve its results
+	call := t.pop()
+	ret := peekStack(stack, 0)
+
+	if call.Type == "CREATE" || call.Type == "CREATE2" {
+		call.GasUsed = bigHex(new(big.Int).SetInt64(int64(call.gasIn) - int64(call.gasCost) - int64(gas)))
+		if ret.Sign() != 0 {
+			address := common.BigToAddress(ret)
+			call.To = hexutil.Encode(address.Bytes())
+			call.Output = hexutil.Encode(t.db.GetCode(address))
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+	} else if call.gasSet {
+		call.GasUsed = bigHex(new(big.Int).SetInt64(int64(call.gasIn) - int64(call.gasCost) + int64(call.gas) - int64(gas)))
+		if ret.Sign() != 0 {
+			call.Output = hexutil.Encode(sliceMemory(memory, call.outOff, new(big.Int).Add(call.outOff, call.outLen)))
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+	}
+	if call.gasSet {
+		call.Gas = hexutil.EncodeUint64(call.gas)
+	}
+	t.top().Calls = append(t.top().Calls, call)
+	return nil
+}
+
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil {
+		t.fault(err)
+	}
+	return nil
+}
+
+// fault fails the innermost call, unless it already reverted.
+func (t *callTracer) fault(err error) {
+	if t.top().Error != "" {
+		return
+	}
+	call := t.pop()
+	call.Error = err.Error()
+
+	// Consume all available gas
+	if call.gasSet {
+		call.Gas = hexutil.EncodeUint64(call.gas)
+		call.GasUsed = call.Gas
+	}
+	// Flatten the failed call into its parent, or leave the last one in the stack
+	if len(t.callstack) > 0 {
+		t.top().Calls = append(t.top().Calls, call)
+		return
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	result := &callFrame{
+		Type:    "CALL",
+		From:    hexutil.Encode(t.ctx.from.Bytes()),
+		To:      hexutil.Encode(t.ctx.to.Bytes()),
+		Value:   "0x0",
+		Gas:     hexutil.EncodeUint64(t.ctx.gas),
+		GasUsed: hexutil.EncodeUint64(t.ctx.gasUsed),
+		Input:   hexutil.Encode(t.ctx.input),
+		Output:  hexutil.Encode(t.ctx.output),
+		Time:    t.ctx.time.String(),
+		Calls:   t.callstack[0].Calls,
+	}
+	if t.ctx.create {
+		result.Type = "CREATE"
+	}
+	if t.ctx.value != nil {
+		result.Value = bigHex(t.ctx.value)
+	}
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	} else if t.ctx.err != nil {
+		result.Error = t.ctx.err.Error()
+	}
+	if result.Error != "" {
+		result.Output = ""
+	}
+	res, err := marshalJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	return res, t.err
+}
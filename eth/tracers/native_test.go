@@ -0,0 +1,134 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// timeField matches the execution time in the result of the callTracer, which
+// differs between the runs.
+var timeField = regexp.MustCompile(`"time":"[^"]*"`)
+
+// runTracerTest executes the transaction of the callTracer test with the tracer.
+func runTracerTest(t *testing.T, test *callTracerTest, tracer vm.Tracer) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	statedb, _, _ := tests.MakePreState(ethdb.NewMemDatabase(), test.Genesis.Alloc, 0)
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+}
+
+// Runs the native tracers against the callTracer test suite, checking that they
+// produce exactly the same output as their JavaScript counterparts.
+func TestNativeTracers(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+			if err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			}
+			test := new(callTracerTest)
+			if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			for name := range natives {
+				native, err := NewTracer(name)
+				if err != nil {
+					t.Fatalf("%s: failed to create native tracer: %v", name, err)
+				}
+				if _, ok := native.(*Tracer); ok {
+					t.Fatalf("%s: JavaScript tracer selected", name)
+				}
+				js, err := New(name)
+				if err != nil {
+					t.Fatalf("%s: failed to create JavaScript tracer: %v", name, err)
+				}
+				runTracerTest(t, test, native)
+				runTracerTest(t, test, js)
+
+				have, haveErr := native.GetResult()
+				want, wantErr := js.GetResult()
+				if (haveErr != nil) != (wantErr != nil) {
+					t.Fatalf("%s: error mismatch: have %v, want %v", name, haveErr, wantErr)
+				}
+				if string(timeField.ReplaceAll(have, nil)) != string(timeField.ReplaceAll(want, nil)) {
+					t.Errorf("%s: result mismatch:\nhave %s\nwant %s", name, have, want)
+				}
+				if name != "callTracer" {
+					continue
+				}
+				ret := new(callTrace)
+				if err := json.Unmarshal(have, ret); err != nil {
+					t.Fatalf("failed to unmarshal trace result: %v", err)
+				}
+				if !reflect.DeepEqual(ret, test.Result) {
+					t.Fatalf("trace mismatch: \nhave %+v\nwant %+v", ret, test.Result)
+				}
+			}
+		})
+	}
+}
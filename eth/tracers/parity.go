@@ -0,0 +1,751 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ParityTrace is the trace of a call frame or of a mining reward in the format
+// of the trace_ namespace of Parity. The block and transaction fields are only
+// filled in for the traces looked up by the block or the transaction, and the
+// transaction fields are null for the rewards, as in Parity.
+type ParityTrace struct {
+	Action              interface{}  `json:"action"`
+	BlockHash           *common.Hash `json:"blockHash,omitempty"`
+	BlockNumber         *uint64      `json:"blockNumber,omitempty"`
+	Error               string       `json:"error,omitempty"`
+	Result              interface{}  `json:"result"`
+	Subtraces           int          `json:"subtraces"`
+	TraceAddress        []int        `json:"traceAddress"`
+	TransactionHash     *common.Hash `json:"transactionHash"`
+	TransactionPosition *uint64      `json:"transactionPosition"`
+	Type                string       `json:"type"`
+}
+
+// ParityCallAction is the action of a message call trace.
+type ParityCallAction struct {
+	CallType string         `json:"callType"`
+	From     common.Address `json:"from"`
+	Gas      hexutil.Uint64 `json:"gas"`
+	Input    hexutil.Bytes  `json:"input"`
+	To       common.Address `json:"to"`
+	Value    *hexutil.Big   `json:"value"`
+}
+
+// ParityCreateAction is the action of a contract creation trace.
+type ParityCreateAction struct {
+	From  common.Address `json:"from"`
+	Gas   hexutil.Uint64 `json:"gas"`
+	Init  hexutil.Bytes  `json:"init"`
+	Value *hexutil.Big   `json:"value"`
+}
+
+// ParitySuicideAction is the action of a self-destruct trace.
+type ParitySuicideAction struct {
+	Address       common.Address `json:"address"`
+	Balance       *hexutil.Big   `json:"balance"`
+	RefundAddress common.Address `json:"refundAddress"`
+}
+
+// ParityRewardAction is the action of a trace of the mining reward, whose type
+// is "block" for the miner of the block and "uncle" for the miners of its uncles.
+type ParityRewardAction struct {
+	Author     common.Address `json:"author"`
+	RewardType string         `json:"rewardType"`
+	Value      *hexutil.Big   `json:"value"`
+}
+
+// ParityCallResult is the result of a successful message call.
+type ParityCallResult struct {
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Output  hexutil.Bytes  `json:"output"`
+}
+
+// ParityCreateResult is the result of a successful contract creation.
+type ParityCreateResult struct {
+	Address common.Address `json:"address"`
+	Code    hexutil.Bytes  `json:"code"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+}
+
+// VmTrace is the trace of the execution of the code of a call frame.
+type VmTrace struct {
+	Code hexutil.Bytes `json:"code"`
+	Ops  []*VmTraceOp  `json:"ops"`
+}
+
+// VmTraceOp is an executed instruction. Ex is missing if the instruction failed,
+// and Sub holds the execution of the code of the frame it entered.
+type VmTraceOp struct {
+	Cost uint64     `json:"cost"`
+	Ex   *VmTraceEx `json:"ex"`
+	Pc   uint64     `json:"pc"`
+	Sub  *VmTrace   `json:"sub"`
+}
+
+// VmTraceEx holds the effects of an instruction: the gas left after it, the
+// items it pushed onto the stack, and the memory and the storage it wrote.
+type VmTraceEx struct {
+	Mem   *VmTraceMem    `json:"mem"`
+	Push  []*hexutil.Big `json:"push"`
+	Store *VmTraceStore  `json:"store"`
+	Used  uint64         `json:"used"`
+}
+
+// VmTraceMem is a piece of memory written by an instruction.
+type VmTraceMem struct {
+	Data hexutil.Bytes `json:"data"`
+	Off  uint64        `json:"off"`
+}
+
+// VmTraceStore is a storage item written by an instruction.
+type VmTraceStore struct {
+	Key *hexutil.Big `json:"key"`
+	Val *hexutil.Big `json:"val"`
+}
+
+// ParityStateDiff holds the changes of the accounts made by a transaction.
+type ParityStateDiff map[common.Address]*ParityAccountDiff
+
+// ParityAccountDiff holds the changes of the fields of an account. Every field
+// is either "=" when unchanged, or an object with the "+" key for the created
+// accounts, the "-" key for the deleted accounts, or the "*" key holding the
+// values before and after the transaction.
+type ParityAccountDiff struct {
+	Balance interface{}                 `json:"balance"`
+	Code    interface{}                 `json:"code"`
+	Nonce   interface{}                 `json:"nonce"`
+	Storage map[common.Hash]interface{} `json:"storage"`
+}
+
+// parityChange is the change of a field of an existing account.
+type parityChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// parityFrame is a call frame entered, but not yet left, by the transaction.
+type parityFrame struct {
+	trace  *ParityTrace
+	call   *ParityCallAction   // Action of a message call
+	create *ParityCreateAction // Action of a contract creation
+
+	gas     uint64 // Gas given to the frame, known once it starts
+	gasSet  bool
+	gasIn   uint64 // Gas of the parent before the instruction that made the frame
+	gasCost uint64 // Cost of that instruction, including the gas given to the frame
+	outOff  uint64 // Memory of the parent the output of a call is copied to
+	outLen  uint64
+	entered bool // Whether the code of the frame started executing
+	ended   bool // Whether the result came with CaptureEnd
+
+	vm   *VmTrace
+	last *parityStep // Last instruction, waiting for its effects
+}
+
+// parityStep is an instruction whose effects are only known at the next one.
+type parityStep struct {
+	op     *VmTraceOp
+	opcode vm.OpCode
+	gas    uint64
+	memOff uint64
+	memLen uint64
+	store  *VmTraceStore
+}
+
+// parityAccount is the state of an account before or after the transaction.
+type parityAccount struct {
+	exists  bool
+	balance *big.Int
+	nonce   uint64
+	code    []byte
+}
+
+// ParityTracer is a native vm.Tracer collecting the traces of a transaction in
+// the format of the trace_ namespace of Parity: the flat list of the call frames
+// in the order they were entered, the trace of the execution of the code, and
+// the changes of the state. The state diff needs the tracer to be notified of
+// the account accesses by the state the transaction is executed on (see
+// state.StateDB.SetTracer), so that the accounts are read before they change.
+type ParityTracer struct {
+	statedb   vm.StateDB
+	vmTrace   bool
+	stateDiff bool
+
+	traces []*ParityTrace
+	frames []*parityFrame
+	root   *VmTrace
+
+	reading bool                                           // Set while the tracer itself reads the state
+	pre     map[common.Address]*parityAccount              // Accounts touched, before the transaction
+	storage map[common.Address]map[common.Hash]common.Hash // Storage written, before the transaction
+}
+
+// NewParityTracer creates a tracer of a transaction executed on the statedb,
+// which also traces the execution of the code if vmTrace is set, and collects
+// the changes of the state if stateDiff is set.
+func NewParityTracer(statedb vm.StateDB, vmTrace, stateDiff bool) *ParityTracer {
+	return &ParityTracer{
+		statedb:   statedb,
+		vmTrace:   vmTrace,
+		stateDiff: stateDiff,
+		traces:    []*ParityTrace{},
+		pre:       make(map[common.Address]*parityAccount),
+		storage:   make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+// Traces returns the traces of the call frames of the transaction.
+func (t *ParityTracer) Traces() []*ParityTrace {
+	return t.traces
+}
+
+// VmTrace returns the trace of the execution of the code of the transaction,
+// nil if it was not requested or no code was executed.
+func (t *ParityTracer) VmTrace() *VmTrace {
+	return t.root
+}
+
+// CaptureStart implements the Tracer interface. The transaction makes the
+// first frame, the nested ones only reveal the gas given to them.
+func (t *ParityTracer) CaptureStart(depth int, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	if depth == 0 {
+		trace := &ParityTrace{TraceAddress: []int{}}
+		frame := &parityFrame{trace: trace, gas: gas, gasSet: true}
+		if create {
+			frame.create = &ParityCreateAction{From: from, Gas: hexutil.Uint64(gas), Init: common.CopyBytes(input), Value: (*hexutil.Big)(new(big.Int).Set(value))}
+			trace.Type, trace.Action, trace.Result = "create", frame.create, &ParityCreateResult{Address: to}
+		} else {
+			frame.call = &ParityCallAction{CallType: "call", From: from, Gas: hexutil.Uint64(gas), Input: common.CopyBytes(input), To: to, Value: (*hexutil.Big)(new(big.Int).Set(value))}
+			trace.Type, trace.Action = "call", frame.call
+		}
+		t.traces = append(t.traces, trace)
+		t.frames = append(t.frames, frame)
+		return nil
+	}
+	if depth < len(t.frames) {
+		frame := t.frames[depth]
+		frame.gas, frame.gasSet = gas, true
+		if create {
+			frame.trace.Result = &ParityCreateResult{Address: to}
+		}
+	}
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface. It is only called for the message
+// calls and the contract creations, the results of the other frames are worked
+// out when the execution returns to the parent frame.
+func (t *ParityTracer) CaptureEnd(depth int, output []byte, gasUsed uint64, _ time.Duration, err error) error {
+	if depth >= len(t.frames) {
+		return nil
+	}
+	// Anything still open above the frame could not have been looked at
+	for len(t.frames) > depth+1 {
+		t.leave(nil, 0, nil, nil)
+	}
+	frame := t.frames[depth]
+	frame.ended = true
+	frame.finish(output, gasUsed, err)
+	if depth == 0 {
+		t.finishStep(frame)
+		t.frames = t.frames[:0]
+	}
+	return nil
+}
+
+// CaptureState implements the Tracer interface, following the frames entered
+// and left, and recording the instructions for the VM trace.
+func (t *ParityTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if depth > len(t.frames) || depth == 0 {
+		return nil
+	}
+	// Leave the frames returned from, the results of the calls are now on the stack
+	for len(t.frames) > depth {
+		t.leave(env, gas, stack, memory)
+	}
+	frame := t.frames[depth-1]
+	if !frame.entered {
+		t.enter(frame, gas, contract, depth)
+	} else if frame.last != nil {
+		frame.last.finish(gas, stack, memory)
+		frame.last = nil
+	}
+	if err != nil {
+		// The instruction failed before it was executed, so it has no effects
+		if t.vmTrace && frame.vm != nil {
+			frame.vm.Ops = append(frame.vm.Ops, &VmTraceOp{Pc: pc, Cost: cost})
+		}
+		frame.fail(err)
+		return nil
+	}
+	if t.vmTrace {
+		t.record(frame, pc, op, gas, cost, stack)
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.call(frame, op, gas, cost, stack, memory, contract)
+	case vm.CREATE, vm.CREATE2:
+		t.create(frame, op, gas, cost, stack, memory, contract)
+	case vm.SELFDESTRUCT:
+		t.suicide(frame, env, stack, contract)
+	case vm.SSTORE:
+		if t.stateDiff {
+			t.touchStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+		}
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface, failing the frame of the
+// instruction.
+func (t *ParityTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if depth > len(t.frames) || depth == 0 {
+		return nil
+	}
+	for len(t.frames) > depth {
+		t.leave(env, gas, stack, memory)
+	}
+	frame := t.frames[depth-1]
+	frame.last = nil
+	frame.fail(err)
+	return nil
+}
+
+// CaptureCreate implements the Tracer interface.
+func (t *ParityTracer) CaptureCreate(creator common.Address, creation common.Address) error {
+	return nil
+}
+
+// CaptureAccountRead implements the Tracer interface, recording the account
+// before the transaction the first time it is accessed.
+func (t *ParityTracer) CaptureAccountRead(account common.Address) error {
+	t.touch(account)
+	return nil
+}
+
+// CaptureAccountWrite implements the Tracer interface, recording the account
+// before the transaction the first time it is accessed.
+func (t *ParityTracer) CaptureAccountWrite(account common.Address) error {
+	t.touch(account)
+	return nil
+}
+
+// enter marks the frame as executing, the first instruction reveals the gas
+// given to it and its code.
+func (t *ParityTracer) enter(frame *parityFrame, gas uint64, contract *vm.Contract, depth int) {
+	frame.entered = true
+	if !frame.gasSet {
+		frame.gas, frame.gasSet = gas, true
+	}
+	if !t.vmTrace {
+		return
+	}
+	frame.vm = &VmTrace{Code: common.CopyBytes(contract.Code), Ops: []*VmTraceOp{}}
+	if depth == 1 {
+		t.root = frame.vm
+	} else if parent := t.frames[depth-2]; parent.last != nil {
+		parent.last.op.Sub = frame.vm
+	}
+}
+
+// record adds the instruction to the VM trace of the frame.
+func (t *ParityTracer) record(frame *parityFrame, pc uint64, op vm.OpCode, gas, cost uint64, stack *vm.Stack) {
+	if frame.vm == nil {
+		return
+	}
+	step := &parityStep{op: &VmTraceOp{Pc: pc, Cost: cost}, opcode: op, gas: gas - cost}
+	switch op {
+	case vm.MSTORE:
+		step.memOff, step.memLen = stackUint64(stack, 0), 32
+	case vm.MSTORE8:
+		step.memOff, step.memLen = stackUint64(stack, 0), 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		step.memOff, step.memLen = stackUint64(stack, 0), stackUint64(stack, 2)
+	case vm.EXTCODECOPY:
+		step.memOff, step.memLen = stackUint64(stack, 1), stackUint64(stack, 3)
+	case vm.CALL, vm.CALLCODE:
+		step.memOff, step.memLen = stackUint64(stack, 5), stackUint64(stack, 6)
+	case vm.DELEGATECALL, vm.STATICCALL:
+		step.memOff, step.memLen = stackUint64(stack, 4), stackUint64(stack, 5)
+	case vm.SSTORE:
+		step.store = &VmTraceStore{Key: (*hexutil.Big)(new(big.Int).Set(stack.Back(0))), Val: (*hexutil.Big)(new(big.Int).Set(stack.Back(1)))}
+	}
+	frame.vm.Ops = append(frame.vm.Ops, step.op)
+	frame.last = step
+}
+
+// push adds a new frame under the parent.
+func (t *ParityTracer) push(parent *parityFrame, frame *parityFrame) {
+	frame.trace.TraceAddress = append(append([]int{}, parent.trace.TraceAddress...), parent.trace.Subtraces)
+	parent.trace.Subtraces++
+	t.traces = append(t.traces, frame.trace)
+	t.frames = append(t.frames, frame)
+}
+
+// call opens the frame of a message call made by the instruction.
+func (t *ParityTracer) call(parent *parityFrame, op vm.OpCode, gas, cost uint64, stack *vm.Stack, memory *vm.Memory, contract *vm.Contract) {
+	action := &ParityCallAction{
+		CallType: strings.ToLower(op.String()),
+		From:     contract.Address(),
+		To:       common.BigToAddress(stack.Back(1)),
+	}
+	off := 0
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		action.Value = (*hexutil.Big)(new(big.Int).Set(stack.Back(2)))
+		off = 1
+	case vm.DELEGATECALL:
+		action.Value = (*hexutil.Big)(new(big.Int).Set(contract.Value()))
+	default:
+		action.Value = (*hexutil.Big)(new(big.Int))
+	}
+	frame := &parityFrame{
+		trace:   &ParityTrace{Type: "call", Action: action},
+		call:    action,
+		gasIn:   gas,
+		gasCost: cost,
+		outOff:  stackUint64(stack, 4+off),
+		outLen:  stackUint64(stack, 5+off),
+	}
+	// The input is only in memory until the instruction is executed
+	action.Input = readMemory(memory, stackUint64(stack, 2+off), stackUint64(stack, 3+off))
+	t.push(parent, frame)
+}
+
+// create opens the frame of a contract creation made by the instruction.
+func (t *ParityTracer) create(parent *parityFrame, op vm.OpCode, gas, cost uint64, stack *vm.Stack, memory *vm.Memory, contract *vm.Contract) {
+	action := &ParityCreateAction{
+		From:  contract.Address(),
+		Value: (*hexutil.Big)(new(big.Int).Set(stack.Back(0))),
+		Init:  readMemory(memory, stackUint64(stack, 1), stackUint64(stack, 2)),
+	}
+	frame := &parityFrame{
+		trace:   &ParityTrace{Type: "create", Action: action},
+		create:  action,
+		gasIn:   gas,
+		gasCost: cost,
+	}
+	t.push(parent, frame)
+}
+
+// suicide adds the trace of the self-destruct made by the instruction.
+func (t *ParityTracer) suicide(parent *parityFrame, env *vm.EVM, stack *vm.Stack, contract *vm.Contract) {
+	trace := &ParityTrace{
+		Type: "suicide",
+		Action: &ParitySuicideAction{
+			Address:       contract.Address(),
+			Balance:       (*hexutil.Big)(new(big.Int).Set(env.StateDB.GetBalance(contract.Address()))),
+			RefundAddress: common.BigToAddress(stack.Back(0)),
+		},
+		TraceAddress: append(append([]int{}, parent.trace.TraceAddress...), parent.trace.Subtraces),
+	}
+	parent.trace.Subtraces++
+	t.traces = append(t.traces, trace)
+}
+
+// leave closes the innermost frame. Unless the result came with CaptureEnd, it
+// is worked out from the state of the parent after the instruction that made
+// the frame: the stack holds the success flag or the created address, the gas
+// left includes the gas returned by the frame, and the memory the output.
+func (t *ParityTracer) leave(env *vm.EVM, gas uint64, stack *vm.Stack, memory *vm.Memory) {
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	t.finishStep(frame)
+	if frame.ended {
+		return
+	}
+	// Frames left along with their parents have no results to look at
+	if env == nil || len(t.frames) == 0 || stack.Len() == 0 || !t.frames[len(t.frames)-1].entered {
+		frame.fail(nil)
+		return
+	}
+	var returned uint64
+	if base := frame.gasIn - frame.gasCost; gas > base && frame.gasIn >= frame.gasCost {
+		returned = gas - base
+	}
+	success := stack.Back(0).Sign() != 0
+	if !frame.gasSet {
+		// The code was never executed, so the frame either called a precompiled
+		// contract or an account without code
+		var used uint64
+		if frame.call != nil {
+			if p := precompile(env, frame.call.To); p != nil && success {
+				used = p.RequiredGas(frame.call.Input)
+			}
+		}
+		frame.gas = returned + used
+	}
+	if !success {
+		frame.fail(nil)
+		frame.setGas()
+		return
+	}
+	var gasUsed uint64
+	if frame.gas > returned {
+		gasUsed = frame.gas - returned
+	}
+	if frame.create != nil {
+		address := common.BigToAddress(stack.Back(0))
+		frame.finish(env.StateDB.GetCode(address), gasUsed, nil)
+		frame.trace.Result.(*ParityCreateResult).Address = address
+		return
+	}
+	frame.finish(readMemory(memory, frame.outOff, frame.outLen), gasUsed, nil)
+}
+
+// finishStep fills in the effects of the last instruction of a frame being left.
+func (t *ParityTracer) finishStep(frame *parityFrame) {
+	if frame.last != nil {
+		frame.last.op.Ex = &VmTraceEx{Push: []*hexutil.Big{}, Used: frame.last.gas}
+		frame.last = nil
+	}
+}
+
+// setGas copies the gas given to the frame into its action.
+func (frame *parityFrame) setGas() {
+	if frame.call != nil {
+		frame.call.Gas = hexutil.Uint64(frame.gas)
+	} else {
+		frame.create.Gas = hexutil.Uint64(frame.gas)
+	}
+}
+
+// finish sets the result of the frame, or its error.
+func (frame *parityFrame) finish(output []byte, gasUsed uint64, err error) {
+	frame.setGas()
+	if err != nil {
+		frame.fail(err)
+		return
+	}
+	if frame.trace.Error != "" {
+		return
+	}
+	if frame.create != nil {
+		result, _ := frame.trace.Result.(*ParityCreateResult)
+		if result == nil {
+			result = new(ParityCreateResult)
+		}
+		result.Code, result.GasUsed = common.CopyBytes(output), hexutil.Uint64(gasUsed)
+		frame.trace.Result = result
+		return
+	}
+	frame.trace.Result = &ParityCallResult{GasUsed: hexutil.Uint64(gasUsed), Output: common.CopyBytes(output)}
+}
+
+// fail marks the frame as failed, keeping the first error seen.
+func (frame *parityFrame) fail(err error) {
+	frame.trace.Result = nil
+	if frame.trace.Error == "" {
+		frame.trace.Error = parityError(err)
+	}
+}
+
+// finish fills in the effects of the instruction from the state of the frame at
+// the next instruction.
+func (step *parityStep) finish(gas uint64, stack *vm.Stack, memory *vm.Memory) {
+	ex := &VmTraceEx{Push: []*hexutil.Big{}, Used: gas, Store: step.store}
+	n := pushed(step.opcode)
+	if n > stack.Len() {
+		n = stack.Len()
+	}
+	for i := n - 1; i >= 0; i-- {
+		ex.Push = append(ex.Push, (*hexutil.Big)(new(big.Int).Set(stack.Back(i))))
+	}
+	if step.memLen > 0 {
+		ex.Mem = &VmTraceMem{Data: readMemory(memory, step.memOff, step.memLen), Off: step.memOff}
+	}
+	step.op.Ex = ex
+}
+
+// touch records the account before the transaction, unless it was already
+// accessed.
+func (t *ParityTracer) touch(address common.Address) {
+	if !t.stateDiff || t.reading {
+		return
+	}
+	if _, ok := t.pre[address]; !ok {
+		t.pre[address] = t.readAccount(address)
+	}
+}
+
+// touchStorage records the storage item before the transaction, unless it was
+// already written.
+func (t *ParityTracer) touchStorage(address common.Address, key common.Hash) {
+	t.touch(address)
+	storage, ok := t.storage[address]
+	if !ok {
+		storage = make(map[common.Hash]common.Hash)
+		t.storage[address] = storage
+	}
+	if _, ok := storage[key]; !ok {
+		t.reading = true
+		storage[key] = t.statedb.GetState(address, key)
+		t.reading = false
+	}
+}
+
+// readAccount reads the account from the state without recording the access.
+func (t *ParityTracer) readAccount(address common.Address) *parityAccount {
+	t.reading = true
+	defer func() { t.reading = false }()
+
+	if !t.statedb.Exist(address) {
+		return &parityAccount{balance: new(big.Int)}
+	}
+	return &parityAccount{
+		exists:  true,
+		balance: new(big.Int).Set(t.statedb.GetBalance(address)),
+		nonce:   t.statedb.GetNonce(address),
+		code:    common.CopyBytes(t.statedb.GetCode(address)),
+	}
+}
+
+// StateDiff returns the changes of the accounts accessed by the transaction.
+// It has to be called once the state is finalised after the transaction.
+func (t *ParityTracer) StateDiff() ParityStateDiff {
+	diff := make(ParityStateDiff)
+	for address, pre := range t.pre {
+		post := t.readAccount(address)
+		if !pre.exists && !post.exists {
+			continue
+		}
+		changed := pre.exists != post.exists
+		account := &ParityAccountDiff{
+			Balance: parityDiff(pre, post, pre.balance.Cmp(post.balance) == 0, (*hexutil.Big)(pre.balance), (*hexutil.Big)(post.balance)),
+			Code:    parityDiff(pre, post, string(pre.code) == string(post.code), hexutil.Bytes(pre.code), hexutil.Bytes(post.code)),
+			Nonce:   parityDiff(pre, post, pre.nonce == post.nonce, hexutil.Uint64(pre.nonce), hexutil.Uint64(post.nonce)),
+			Storage: make(map[common.Hash]interface{}),
+		}
+		if account.Balance != "=" || account.Code != "=" || account.Nonce != "=" {
+			changed = true
+		}
+		for key, from := range t.storage[address] {
+			var to common.Hash
+			if post.exists {
+				t.reading = true
+				to = t.statedb.GetState(address, key)
+				t.reading = false
+			}
+			if (!pre.exists && to == common.Hash{}) || (!post.exists && from == common.Hash{}) || (pre.exists && post.exists && from == to) {
+				continue
+			}
+			account.Storage[key] = parityDiff(pre, post, false, from, to)
+			changed = true
+		}
+		if changed {
+			diff[address] = account
+		}
+	}
+	return diff
+}
+
+// parityDiff describes the change of a field of the account.
+func parityDiff(pre, post *parityAccount, equal bool, from, to interface{}) interface{} {
+	switch {
+	case !pre.exists:
+		return map[string]interface{}{"+": to}
+	case !post.exists:
+		return map[string]interface{}{"-": from}
+	case equal:
+		return "="
+	default:
+		return map[string]interface{}{"*": &parityChange{From: from, To: to}}
+	}
+}
+
+// parityError translates the EVM error into the one reported by Parity.
+func parityError(err error) string {
+	if err == nil {
+		return "Internal failure"
+	}
+	msg := err.Error()
+	switch {
+	case err == vm.ErrOutOfGas || err == vm.ErrCodeStoreOutOfGas:
+		return "Out of gas"
+	case err == vm.ErrDepth:
+		return "Out of stack"
+	case msg == "evm: execution reverted":
+		return "Reverted"
+	case msg == "evm: write protection":
+		return "Mutable Call In Static Context"
+	case strings.HasPrefix(msg, "invalid opcode"):
+		return "Bad instruction"
+	case strings.HasPrefix(msg, "invalid jump destination"):
+		return "Bad jump destination"
+	case strings.HasPrefix(msg, "stack underflow"):
+		return "Stack underflow"
+	}
+	return msg
+}
+
+// pushed returns the number of the stack items pushed by the instruction.
+func pushed(op vm.OpCode) int {
+	switch {
+	case op.IsPush():
+		return 1
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT:
+		return 0
+	}
+	return 1
+}
+
+// precompile returns the precompiled contract at the address, if any.
+func precompile(env *vm.EVM, address common.Address) vm.PrecompiledContract {
+	if env.ChainConfig().IsByzantium(env.BlockNumber) {
+		return vm.PrecompiledContractsByzantium[address]
+	}
+	return vm.PrecompiledContractsHomestead[address]
+}
+
+// stackUint64 returns the stack item, capped to uint64.
+func stackUint64(stack *vm.Stack, n int) uint64 {
+	if v := stack.Back(n); v.IsUint64() {
+		return v.Uint64()
+	}
+	return ^uint64(0)
+}
+
+// readMemory copies the piece of memory, as much of it as is allocated.
+func readMemory(memory *vm.Memory, off, size uint64) []byte {
+	data := memory.Data()
+	if size == 0 || off >= uint64(len(data)) {
+		return nil
+	}
+	end := off + size
+	if end > uint64(len(data)) || end < off {
+		end = uint64(len(data))
+	}
+	return common.CopyBytes(data[off:end])
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// flattenCalls lists the frames of a callTracer result in the order they were
+// entered, which is the order of the Parity traces.
+func flattenCalls(call *callTrace, calls []*callTrace) []*callTrace {
+	calls = append(calls, call)
+	for i := range call.Calls {
+		calls = flattenCalls(&call.Calls[i], calls)
+	}
+	return calls
+}
+
+// Runs the Parity tracer against the callTracer test suite, checking that both
+// see the same frames with the same gas and results.
+func TestParityTracer(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+			if err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			}
+			test := new(callTracerTest)
+			if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			tx := new(types.Transaction)
+			if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+				t.Fatalf("failed to parse testcase input: %v", err)
+			}
+			signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+			origin, _ := signer.Sender(tx)
+
+			context := vm.Context{
+				CanTransfer: core.CanTransfer,
+				Transfer:    core.Transfer,
+				Origin:      origin,
+				Coinbase:    test.Context.Miner,
+				BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+				Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+				Difficulty:  (*big.Int)(test.Context.Difficulty),
+				GasLimit:    uint64(test.Context.GasLimit),
+				GasPrice:    tx.GasPrice(),
+			}
+			statedb, tds, err := tests.MakePreState(ethdb.NewMemDatabase(), test.Genesis.Alloc, 0)
+			if err != nil {
+				t.Fatalf("failed to create the prestate: %v", err)
+			}
+			tracer := NewParityTracer(statedb, true, true)
+			statedb.SetTracer(tracer)
+			evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+			msg, err := tx.AsMessage(signer)
+			if err != nil {
+				t.Fatalf("failed to prepare transaction for tracing: %v", err)
+			}
+			st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+			if _, _, _, err = st.TransitionDb(); err != nil {
+				t.Fatalf("failed to execute transaction: %v", err)
+			}
+			statedb.SetTracer(nil)
+			if err := statedb.Finalise(true, tds.TrieStateWriter()); err != nil {
+				t.Fatalf("failed to finalise the state: %v", err)
+			}
+			want := flattenCalls(test.Result, nil)
+			have := tracer.Traces()
+			if len(have) != len(want) {
+				t.Fatalf("trace count mismatch: have %d, want %d", len(have), len(want))
+			}
+			for i, trace := range have {
+				checkParityTrace(t, i, trace, want[i])
+			}
+			if tracer.VmTrace() == nil || len(tracer.VmTrace().Ops) == 0 {
+				t.Errorf("vm trace missing")
+			}
+			// The sender always pays for the gas, so its balance and nonce change
+			diff := tracer.StateDiff()
+			if account := diff[origin]; account == nil || account.Balance == "=" || account.Nonce == "=" {
+				t.Errorf("sender diff missing: %+v", account)
+			}
+			if _, err := json.Marshal(diff); err != nil {
+				t.Errorf("failed to marshal the state diff: %v", err)
+			}
+		})
+	}
+}
+
+// checkParityTrace compares the Parity trace with the callTracer frame. The gas
+// of the transaction is reported without the intrinsic gas by Parity, and the
+// errors are named differently, so they are not compared.
+func checkParityTrace(t *testing.T, i int, trace *ParityTrace, want *callTrace) {
+	t.Helper()
+
+	if (trace.Error != "") != (want.Error != "") {
+		t.Errorf("trace %d: error mismatch: have %q, want %q", i, trace.Error, want.Error)
+	}
+	switch action := trace.Action.(type) {
+	case *ParityCallAction:
+		if strings.ToUpper(action.CallType) != want.Type || action.From != want.From || action.To != want.To || !bytes.Equal(action.Input, want.Input) {
+			t.Errorf("trace %d: call mismatch: have %+v, want %+v", i, action, want)
+		}
+		if want.Value != nil && action.Value.ToInt().Cmp(want.Value.ToInt()) != 0 {
+			t.Errorf("trace %d: value mismatch: have %v, want %v", i, action.Value, want.Value)
+		}
+		if i > 0 && want.Gas != nil && action.Gas != *want.Gas {
+			t.Errorf("trace %d: gas mismatch: have %d, want %d", i, action.Gas, *want.Gas)
+		}
+		if result, ok := trace.Result.(*ParityCallResult); ok {
+			if !bytes.Equal(result.Output, want.Output) {
+				t.Errorf("trace %d: output mismatch: have %x, want %x", i, result.Output, want.Output)
+			}
+			if i > 0 && want.GasUsed != nil && result.GasUsed != *want.GasUsed {
+				t.Errorf("trace %d: gas used mismatch: have %d, want %d", i, result.GasUsed, *want.GasUsed)
+			}
+		}
+	case *ParityCreateAction:
+		if want.Type != "CREATE" && want.Type != "CREATE2" || action.From != want.From || !bytes.Equal(action.Init, want.Input) {
+			t.Errorf("trace %d: create mismatch: have %+v, want %+v", i, action, want)
+		}
+		if result, ok := trace.Result.(*ParityCreateResult); ok {
+			if result.Address != want.To || !bytes.Equal(result.Code, want.Output) {
+				t.Errorf("trace %d: created %x with %x, want %x with %x", i, result.Address, result.Code, want.To, want.Output)
+			}
+		}
+	case *ParitySuicideAction:
+		if want.Type != "SELFDESTRUCT" || action.Address != want.From || action.RefundAddress != want.To {
+			t.Errorf("trace %d: suicide mismatch: have %+v, want %+v", i, action, want)
+		}
+	default:
+		t.Errorf("trace %d: unexpected action %T", i, trace.Action)
+	}
+}
@@ -167,7 +167,7 @@ func TestPrestateTracerCreate2(t *testing.T) {
 		Code:    []byte{},
 		Balance: big.NewInt(500000000000000),
 	}
-	statedb := tests.MakePreState(ethdb.NewMemDatabase(), alloc)
+	statedb, _, _ := tests.MakePreState(ethdb.NewMemDatabase(), alloc, 0)
 	// Create the tracer, the EVM environment and run it
 	tracer, err := New("prestateTracer")
 	if err != nil {
@@ -241,7 +241,7 @@ func TestCallTracer(t *testing.T) {
 				GasPrice:    tx.GasPrice(),
 			}
 			db := ethdb.NewMemDatabase()
-			statedb, _ := tests.MakePreState(db, test.Genesis.Alloc, 0)
+			statedb, _, _ := tests.MakePreState(db, test.Genesis.Alloc, 0)
 
 			// Create the tracer, the EVM environment and run it
 			tracer, err := New("callTracer")
@@ -0,0 +1,147 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+)
+
+// witnessTimeout is the maximum time to wait for a peer to answer a witness request.
+const witnessTimeout = 10 * time.Second
+
+var (
+	errNoWitnessPeers     = errors.New("no peer could serve the block witnesses")
+	errWitnessTimeout     = errors.New("witness request timed out")
+	errWitnessBusy        = errors.New("witness request already in flight")
+	errWitnessUnsolicited = errors.New("unsolicited witness delivery")
+	errWitnessTerminated  = errors.New("witness retrieval terminated")
+)
+
+// witnessFetcher retrieves the witnesses of blocks from the peers speaking the
+// witness protocol, so that a stateless node can verify the blocks it imports.
+type witnessFetcher struct {
+	peers *peerSet
+	quit  chan struct{}
+
+	lock    sync.Mutex
+	pending map[string]chan [][]byte // Delivery channels of the in-flight requests, by peer
+}
+
+func newWitnessFetcher(peers *peerSet, quit chan struct{}) *witnessFetcher {
+	return &witnessFetcher{
+		peers:   peers,
+		quit:    quit,
+		pending: make(map[string]chan [][]byte),
+	}
+}
+
+// fetch retrieves the witnesses of the given blocks, in the order of the blocks.
+// It satisfies the core.WitnessFetcher signature.
+func (f *witnessFetcher) fetch(blocks types.Blocks) ([][]byte, error) {
+	witnesses := make([][]byte, 0, len(blocks))
+	for len(witnesses) < len(blocks) {
+		batch := blocks[len(witnesses):]
+		if len(batch) > downloader.MaxBlockFetch {
+			batch = batch[:downloader.MaxBlockFetch]
+		}
+		hashes := make([]common.Hash, len(batch))
+		for i, block := range batch {
+			hashes[i] = block.Hash()
+		}
+		fetched, err := f.fetchBatch(hashes)
+		if err != nil {
+			return nil, err
+		}
+		witnesses = append(witnesses, fetched...)
+	}
+	return witnesses, nil
+}
+
+// fetchBatch requests the witnesses of the given blocks from the best peers in
+// turn, until one of them returns all of them. Peers only serve the witnesses
+// they know about, so partial answers cannot be matched up with the blocks.
+func (f *witnessFetcher) fetchBatch(hashes []common.Hash) ([][]byte, error) {
+	for _, p := range f.peers.WitnessPeers() {
+		witnesses, err := f.request(p, hashes)
+		switch {
+		case err == errWitnessTerminated:
+			return nil, err
+		case err != nil:
+			p.Log().Debug("Block witness retrieval failed", "err", err)
+		case len(witnesses) != len(hashes):
+			p.Log().Debug("Peer is missing block witnesses", "requested", len(hashes), "delivered", len(witnesses))
+		default:
+			return witnesses, nil
+		}
+	}
+	return nil, errNoWitnessPeers
+}
+
+// request sends a witness request to the peer and waits for its answer.
+func (f *witnessFetcher) request(p *peer, hashes []common.Hash) ([][]byte, error) {
+	ch := make(chan [][]byte, 1)
+
+	f.lock.Lock()
+	if _, ok := f.pending[p.id]; ok {
+		f.lock.Unlock()
+		return nil, errWitnessBusy
+	}
+	f.pending[p.id] = ch
+	f.lock.Unlock()
+
+	defer func() {
+		f.lock.Lock()
+		if f.pending[p.id] == ch {
+			delete(f.pending, p.id)
+		}
+		f.lock.Unlock()
+	}()
+	if err := p.RequestWitnesses(hashes); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(witnessTimeout)
+	defer timeout.Stop()
+
+	select {
+	case witnesses := <-ch:
+		return witnesses, nil
+	case <-timeout.C:
+		return nil, errWitnessTimeout
+	case <-f.quit:
+		return nil, errWitnessTerminated
+	}
+}
+
+// deliver hands the witnesses received from a peer over to the pending request.
+func (f *witnessFetcher) deliver(id string, witnesses [][]byte) error {
+	f.lock.Lock()
+	ch, ok := f.pending[id]
+	delete(f.pending, id)
+	f.lock.Unlock()
+
+	if !ok {
+		return errWitnessUnsolicited
+	}
+	ch <- witnesses
+	return nil
+}
@@ -360,6 +360,75 @@ func (ec *Client) NonceAt(ctx context.Context, account common.Address, blockNumb
 	return uint64(result), err
 }
 
+// AccountResult is the Merkle proof of an account and its storage items.
+type AccountResult struct {
+	Address      common.Address
+	AccountProof [][]byte
+	Balance      *big.Int
+	CodeHash     common.Hash
+	Nonce        uint64
+	StorageHash  common.Hash
+	StorageProof []StorageResult
+}
+
+// StorageResult is the Merkle proof of a storage item.
+type StorageResult struct {
+	Key   string
+	Value *big.Int
+	Proof [][]byte
+}
+
+// GetProof returns the account and the storage items of the given account,
+// together with their Merkle proofs. The storage keys are given in hex. The
+// block number can be nil, in which case the proof is taken from the latest
+// known block.
+func (ec *Client) GetProof(ctx context.Context, account common.Address, keys []string, blockNumber *big.Int) (*AccountResult, error) {
+	type storageResult struct {
+		Key   string          `json:"key"`
+		Value *hexutil.Big    `json:"value"`
+		Proof []hexutil.Bytes `json:"proof"`
+	}
+	type accountResult struct {
+		Address      common.Address  `json:"address"`
+		AccountProof []hexutil.Bytes `json:"accountProof"`
+		Balance      *hexutil.Big    `json:"balance"`
+		CodeHash     common.Hash     `json:"codeHash"`
+		Nonce        hexutil.Uint64  `json:"nonce"`
+		StorageHash  common.Hash     `json:"storageHash"`
+		StorageProof []storageResult `json:"storageProof"`
+	}
+	var res accountResult
+	if err := ec.c.CallContext(ctx, &res, "eth_getProof", account, keys, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	if res.Balance == nil {
+		return nil, errors.New("invalid eth_getProof result")
+	}
+	toBytes := func(proof []hexutil.Bytes) [][]byte {
+		nodes := make([][]byte, len(proof))
+		for i, node := range proof {
+			nodes[i] = node
+		}
+		return nodes
+	}
+	result := &AccountResult{
+		Address:      res.Address,
+		AccountProof: toBytes(res.AccountProof),
+		Balance:      (*big.Int)(res.Balance),
+		CodeHash:     res.CodeHash,
+		Nonce:        uint64(res.Nonce),
+		StorageHash:  res.StorageHash,
+		StorageProof: make([]StorageResult, len(res.StorageProof)),
+	}
+	for i, sr := range res.StorageProof {
+		result.StorageProof[i] = StorageResult{Key: sr.Key, Proof: toBytes(sr.Proof), Value: new(big.Int)}
+		if sr.Value != nil {
+			result.StorageProof[i].Value = (*big.Int)(sr.Value)
+		}
+	}
+	return result, nil
+}
+
 // Filters
 
 // FilterLogs executes a filter query.
@@ -0,0 +1,794 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func init() {
+	RegisterEngine("badger", func(path string) (Database, error) {
+		return NewBadgerDatabase(path)
+	})
+}
+
+// BadgerDatabase is an ethdb.Database backed by the badger LSM key-value store.
+// Badger has no notion of buckets, so every key is stored prefixed with the
+// length and the name of its bucket.
+type BadgerDatabase struct {
+	badgerReader
+	dir string     // directory for reporting
+	db  *badger.DB // Badger instance
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// badgerLogger routes badger's internal logging into the geth logger.
+type badgerLogger struct {
+	log log.Logger
+}
+
+func (l badgerLogger) Errorf(format string, args ...interface{}) {
+	l.log.Error(fmt.Sprintf(format, args...))
+}
+func (l badgerLogger) Warningf(format string, args ...interface{}) {
+	l.log.Warn(fmt.Sprintf(format, args...))
+}
+func (l badgerLogger) Infof(format string, args ...interface{}) {
+	l.log.Debug(fmt.Sprintf(format, args...))
+}
+func (l badgerLogger) Debugf(format string, args ...interface{}) {
+	l.log.Trace(fmt.Sprintf(format, args...))
+}
+
+// NewBadgerDatabase opens (or creates) a badger database in the given directory.
+func NewBadgerDatabase(dir string) (*BadgerDatabase, error) {
+	logger := log.New("database", dir)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(badgerLogger{logger}))
+	if err != nil {
+		return nil, err
+	}
+	bdb := &BadgerDatabase{
+		badgerReader: badgerReader{view: db.View},
+		dir:          dir,
+		db:           db,
+		log:          logger,
+	}
+	if err := bdb.replayJournal(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bdb, nil
+}
+
+// badgerReader implements the Getter on top of the badger read-only
+// transactions. The database runs every read in a transaction of its own,
+// while all the reads of a snapshot share its transaction.
+type badgerReader struct {
+	view func(fn func(txn *badger.Txn) error) error
+}
+
+// badgerSnapshot is the Snapshot of the badger database, i.e. a read-only
+// transaction, which sees the versions of the keys as of its start.
+type badgerSnapshot struct {
+	badgerReader
+	txn *badger.Txn
+}
+
+func newBadgerSnapshot(txn *badger.Txn) *badgerSnapshot {
+	return &badgerSnapshot{
+		badgerReader: badgerReader{view: func(fn func(txn *badger.Txn) error) error { return fn(txn) }},
+		txn:          txn,
+	}
+}
+
+func (s *badgerSnapshot) Close() {
+	s.txn.Discard()
+}
+
+// View runs the function within a read-only transaction of the database.
+func (db *BadgerDatabase) View(f func(tx Getter) error) error {
+	return db.db.View(func(txn *badger.Txn) error {
+		return f(newBadgerSnapshot(txn))
+	})
+}
+
+// Begin opens a read-only transaction of the database.
+func (db *BadgerDatabase) Begin() (Snapshot, error) {
+	return newBadgerSnapshot(db.db.NewTransaction(false)), nil
+}
+
+// Path returns the path to the database directory.
+func (db *BadgerDatabase) Path() string {
+	return db.dir
+}
+
+// bucketPrefix returns the prefix shared by all the keys of the bucket.
+func bucketPrefix(bucket []byte) []byte {
+	prefix := make([]byte, 1+len(bucket))
+	prefix[0] = byte(len(bucket))
+	copy(prefix[1:], bucket)
+	return prefix
+}
+
+func bucketKey(bucket, key []byte) []byte {
+	k := make([]byte, 1+len(bucket)+len(key))
+	k[0] = byte(len(bucket))
+	copy(k[1:], bucket)
+	copy(k[1+len(bucket):], key)
+	return k
+}
+
+// badgerCursor mimics the subset of the bolt cursor used by the walking
+// functions on top of a badger iterator restricted to one bucket.
+type badgerCursor struct {
+	it     *badger.Iterator
+	prefix []byte
+	err    error
+}
+
+func newBadgerCursor(txn *badger.Txn, bucket []byte) *badgerCursor {
+	prefix := bucketPrefix(bucket)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	return &badgerCursor{it: txn.NewIterator(opts), prefix: prefix}
+}
+
+func (c *badgerCursor) current() ([]byte, []byte) {
+	if c.err != nil || !c.it.ValidForPrefix(c.prefix) {
+		return nil, nil
+	}
+	item := c.it.Item()
+	k := item.KeyCopy(nil)[len(c.prefix):]
+	var v []byte
+	v, c.err = item.ValueCopy(nil)
+	if c.err != nil {
+		return nil, nil
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	return k, v
+}
+
+func (c *badgerCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.it.Seek(bucketKey(c.prefix[1:], seek))
+	return c.current()
+}
+
+// SeekTo is the same as Seek, because badger iterators do not benefit from
+// knowing that the seek only goes forward.
+func (c *badgerCursor) SeekTo(seek []byte) ([]byte, []byte) {
+	return c.Seek(seek)
+}
+
+func (c *badgerCursor) Next() ([]byte, []byte) {
+	c.it.Next()
+	return c.current()
+}
+
+func (c *badgerCursor) Close() {
+	c.it.Close()
+}
+
+func badgerGet(txn *badger.Txn, bucket, key []byte) ([]byte, error) {
+	item, err := txn.Get(bucketKey(bucket, key))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	return v, nil
+}
+
+// Put puts the given key / value to the queue
+func (db *BadgerDatabase) Put(bucket, key []byte, value []byte) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(bucketKey(bucket, key), value)
+	})
+}
+
+// PutS records that the key of the history bucket hBucket had the given value
+// before the block with the given timestamp. The value goes into the change set
+// of the block, the history bucket gets an entry key || timestamp, and the block
+// is added to the history index of the key.
+func (db *BadgerDatabase) PutS(hBucket, key, value []byte, timestamp uint64) error {
+	composite, _ := compositeKeySuffix(key, timestamp)
+	csKey := changeSetKey(timestamp, hBucket)
+	return db.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(bucketKey(hBucket, composite), []byte{}); err != nil {
+			return err
+		}
+		ib := indexBucket(hBucket)
+		ik := indexKey(key, timestamp)
+		container, err := badgerGet(txn, ib, ik)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err = txn.Set(bucketKey(ib, ik), indexAdd(container, timestamp)); err != nil {
+			return err
+		}
+		dat, err := badgerGet(txn, ChangeSetBucket, csKey)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		changeSet, err := DecodeChangeSet(dat)
+		if err != nil {
+			return err
+		}
+		changeSet.Add(key, value)
+		return txn.Set(bucketKey(ChangeSetBucket, csKey), changeSet.Encode())
+	})
+}
+
+// badgerHistoryHorizon returns the history horizon as seen by the transaction.
+func badgerHistoryHorizon(txn *badger.Txn) uint64 {
+	v, _ := badgerGet(txn, HistoryHorizonBucket, historyHorizonKey)
+	return decodeHorizon(v)
+}
+
+// badgerChangeSets returns the function reading the encoded change sets within the transaction.
+// Change sets that cannot be read are reported as empty.
+func badgerChangeSets(txn *badger.Txn) func(csKey []byte) []byte {
+	return func(csKey []byte) []byte {
+		v, _ := badgerGet(txn, ChangeSetBucket, csKey)
+		return v
+	}
+}
+
+// MultiPut writes the (bucket, key, value) tuples in one transaction. Nil values
+// delete the keys. The batches too big for one transaction are written through
+// the journal.
+func (db *BadgerDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
+	txn := db.db.NewTransaction(true)
+	defer txn.Discard()
+	var written uint64
+	for i := 0; i < len(tuples); i += 3 {
+		k := bucketKey(tuples[i], tuples[i+1])
+		var err error
+		if tuples[i+2] == nil {
+			err = txn.Delete(k)
+		} else {
+			err = txn.Set(k, tuples[i+2])
+		}
+		if err == badger.ErrTxnTooBig {
+			txn.Discard()
+			return writeJournaled(db, &tupleSource{tuples: tuples})
+		}
+		if err != nil {
+			return 0, err
+		}
+		written += uint64(len(k) + len(tuples[i+2]))
+	}
+	if err := txn.Commit(); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+func (r *badgerReader) Has(bucket, key []byte) (bool, error) {
+	var has bool
+	err := r.view(func(txn *badger.Txn) error {
+		_, err := txn.Get(bucketKey(bucket, key))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		has = err == nil
+		return err
+	})
+	return has, err
+}
+
+// Buckets returns the names of all the buckets in the database.
+func (db *BadgerDatabase) Buckets() ([][]byte, error) {
+	var buckets [][]byte
+	err := db.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); {
+			k := it.Item().Key()
+			name := common.CopyBytes(k[1 : 1+int(k[0])])
+			buckets = append(buckets, name)
+			// Jump over the rest of the bucket
+			next := bucketPrefix(name)
+			for len(next) > 0 && next[len(next)-1] == 0xff {
+				next = next[:len(next)-1]
+			}
+			if len(next) == 0 {
+				break
+			}
+			next[len(next)-1]++
+			it.Seek(next)
+		}
+		return nil
+	})
+	return buckets, err
+}
+
+func (db *BadgerDatabase) Size() int {
+	lsm, vlog := db.db.Size()
+	return int(lsm + vlog)
+}
+
+// Get returns the given key if it's present.
+func (r *badgerReader) Get(bucket, key []byte) ([]byte, error) {
+	var dat []byte
+	err := r.view(func(txn *badger.Txn) error {
+		var err error
+		dat, err = badgerGet(txn, bucket, key)
+		return err
+	})
+	return dat, err
+}
+
+func (r *badgerReader) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	var dat []byte
+	err := r.view(func(txn *badger.Txn) error {
+		v, ok, err := findInChangeSet(badgerChangeSets(txn)(changeSetKey(timestamp, hBucket)), key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrKeyNotFound
+		}
+		dat = v
+		return nil
+	})
+	return dat, err
+}
+
+// GetAsOf returns the value of the key as it was at the given timestamp
+func (r *badgerReader) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	var dat []byte
+	err := r.view(func(txn *badger.Txn) error {
+		if err := checkHorizon(badgerHistoryHorizon(txn), timestamp); err != nil {
+			return err
+		}
+		iC := newBadgerCursor(txn, indexBucket(hBucket))
+		changeTimestamp, ok := nextChange(iC, key, timestamp)
+		iC.Close()
+		if iC.err != nil {
+			return iC.err
+		}
+		if ok {
+			var err error
+			dat, err = historyValueAt(hBucket, key, changeTimestamp, badgerChangeSets(txn))
+			return err
+		}
+		var err error
+		dat, err = badgerGet(txn, bucket, key)
+		return err
+	})
+	return dat, err
+}
+
+func (r *badgerReader) Walk(bucket, startkey []byte, fixedbits uint, walker func(k, v []byte) (bool, error)) error {
+	fixedbytes, mask := bytesmask(fixedbits)
+	return r.view(func(txn *badger.Txn) error {
+		c := newBadgerCursor(txn, bucket)
+		defer c.Close()
+		k, v := c.Seek(startkey)
+		for k != nil && (fixedbits == 0 || bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) && (k[fixedbytes-1]&mask) == (startkey[fixedbytes-1]&mask)) {
+			goOn, err := walker(k, v)
+			if err != nil {
+				return err
+			}
+			if !goOn {
+				break
+			}
+			k, v = c.Next()
+		}
+		return c.err
+	})
+}
+
+func (r *badgerReader) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
+	if len(startkeys) == 0 {
+		return nil
+	}
+	keyIdx := 0 // What is the current key we are extracting
+	fixedbytes, mask := bytesmask(fixedbits[keyIdx])
+	startkey := startkeys[keyIdx]
+	if err := r.view(func(txn *badger.Txn) error {
+		c := newBadgerCursor(txn, bucket)
+		defer c.Close()
+		k, v := c.Seek(startkey)
+		for k != nil {
+			// Adjust keyIdx if needed
+			if fixedbytes > 0 {
+				cmp := int(-1)
+				for cmp != 0 {
+					cmp = bytes.Compare(k[:fixedbytes-1], startkey[:fixedbytes-1])
+					if cmp == 0 {
+						k1 := k[fixedbytes-1] & mask
+						k2 := startkey[fixedbytes-1] & mask
+						if k1 < k2 {
+							cmp = -1
+						} else if k1 > k2 {
+							cmp = 1
+						}
+					}
+					if cmp < 0 {
+						k, v = c.SeekTo(startkey)
+						if k == nil {
+							return c.err
+						}
+					} else if cmp > 0 {
+						keyIdx++
+						if _, err := walker(keyIdx, nil, nil); err != nil {
+							return err
+						}
+						if keyIdx == len(startkeys) {
+							return nil
+						}
+						fixedbytes, mask = bytesmask(fixedbits[keyIdx])
+						startkey = startkeys[keyIdx]
+					}
+				}
+			}
+			if len(v) > 0 {
+				_, err := walker(keyIdx, k, v)
+				if err != nil {
+					return err
+				}
+			}
+			k, v = c.Next()
+		}
+		return c.err
+	}); err != nil {
+		return err
+	}
+	for keyIdx < len(startkeys) {
+		keyIdx++
+		if _, err := walker(keyIdx, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *badgerReader) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+	fixedbytes, mask := bytesmask(fixedbits)
+	suffix := encodeTimestamp(timestamp)
+	l := len(startkey)
+	sl := l + len(suffix)
+	keyBuffer := make([]byte, l+len(EndSuffix))
+	return r.view(func(txn *badger.Txn) error {
+		if err := checkHorizon(badgerHistoryHorizon(txn), timestamp); err != nil {
+			return err
+		}
+		c := newBadgerCursor(txn, bucket)
+		defer c.Close()
+		hC := newBadgerCursor(txn, hBucket)
+		defer hC.Close()
+		k, v := c.Seek(startkey)
+		hK, _ := hC.Seek(startkey)
+		getChangeSet := badgerChangeSets(txn)
+		goOn := true
+		var err error
+		for goOn {
+			if k != nil && fixedbits > 0 && !bytes.Equal(k[:fixedbytes-1], startkey[:fixedbytes-1]) {
+				k = nil
+			}
+			if k != nil && fixedbits > 0 && (k[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
+				k = nil
+			}
+			if hK != nil && fixedbits > 0 && !bytes.Equal(hK[:fixedbytes-1], startkey[:fixedbytes-1]) {
+				hK = nil
+			}
+			if hK != nil && fixedbits > 0 && (hK[fixedbytes-1]&mask) != (startkey[fixedbytes-1]&mask) {
+				hK = nil
+			}
+			if hK != nil && bytes.Compare(hK[l:], suffix) < 0 {
+				copy(keyBuffer, hK[:l])
+				copy(keyBuffer[l:], suffix)
+				hK, _ = hC.SeekTo(keyBuffer[:sl])
+				continue
+			}
+			var cmp int
+			if k == nil {
+				if hK == nil {
+					break
+				}
+				cmp = 1
+			} else if hK == nil {
+				cmp = -1
+			} else {
+				cmp = bytes.Compare(k, hK[:l])
+			}
+			if cmp < 0 {
+				goOn, err = walker(k, v)
+			} else {
+				var hV []byte
+				if hV, err = historyValue(hBucket, hK, l, getChangeSet); err != nil {
+					return err
+				}
+				goOn, err = walker(hK[:l], hV)
+			}
+			if goOn {
+				if cmp <= 0 {
+					k, v = c.Next()
+				}
+				if cmp >= 0 {
+					copy(keyBuffer, hK[:l])
+					copy(keyBuffer[l:], EndSuffix)
+					hK, _ = hC.SeekTo(keyBuffer)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if c.err != nil {
+			return c.err
+		}
+		return hC.err
+	})
+}
+
+func (r *badgerReader) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
+	if len(startkeys) == 0 {
+		return nil
+	}
+	keyIdx := 0 // What is the current key we are extracting
+	fixedbytes, mask := bytesmask(fixedbits[keyIdx])
+	startkey := startkeys[keyIdx]
+	suffix := encodeTimestamp(timestamp)
+	l := len(startkey)
+	sl := l + len(suffix)
+	keyBuffer := make([]byte, l+len(EndSuffix))
+	if err := r.view(func(txn *badger.Txn) error {
+		if err := checkHorizon(badgerHistoryHorizon(txn), timestamp); err != nil {
+			return err
+		}
+		c := newBadgerCursor(txn, bucket)
+		defer c.Close()
+		hC := newBadgerCursor(txn, hBucket)
+		defer hC.Close()
+		hC1 := newBadgerCursor(txn, hBucket)
+		defer hC1.Close()
+		k, v := c.Seek(startkey)
+		hK, _ := hC.Seek(startkey)
+		getChangeSet := badgerChangeSets(txn)
+		goOn := true
+		var err error
+		for goOn {
+			kFit := k != nil
+			hKFit := hK != nil
+			if fixedbytes > 0 {
+				cmp := 1
+				hCmp := 1
+				for cmp != 0 && hCmp != 0 {
+					if k != nil {
+						cmp = bytes.Compare(k[:fixedbytes-1], startkey[:fixedbytes-1])
+						if cmp == 0 {
+							k1 := k[fixedbytes-1] & mask
+							k2 := startkey[fixedbytes-1] & mask
+							if k1 < k2 {
+								cmp = -1
+							} else if k1 > k2 {
+								cmp = 1
+							}
+						}
+						if cmp < 0 {
+							k, v = c.SeekTo(startkey)
+							if k == nil {
+								cmp = 1
+							}
+						}
+					}
+					if hK != nil {
+						hCmp = bytes.Compare(hK[:fixedbytes-1], startkey[:fixedbytes-1])
+						if hCmp == 0 {
+							k1 := hK[fixedbytes-1] & mask
+							k2 := startkey[fixedbytes-1] & mask
+							if k1 < k2 {
+								hCmp = -1
+							} else if k1 > k2 {
+								hCmp = 1
+							}
+						}
+						if hCmp < 0 {
+							hK, _ = hC.SeekTo(startkey)
+							if hK == nil {
+								hCmp = 1
+							}
+						}
+					}
+					if cmp > 0 && hCmp > 0 {
+						keyIdx++
+						if _, err := walker(keyIdx, nil, nil); err != nil {
+							return err
+						}
+						if keyIdx == len(startkeys) {
+							return nil
+						}
+						fixedbytes, mask = bytesmask(fixedbits[keyIdx])
+						startkey = startkeys[keyIdx]
+					}
+				}
+				kFit = cmp == 0
+				hKFit = hCmp == 0
+			}
+			if hKFit && bytes.Compare(hK[l:], suffix) < 0 {
+				copy(keyBuffer, hK[:l])
+				copy(keyBuffer[l:], suffix)
+				hK, _ = hC.SeekTo(keyBuffer[:sl])
+				continue
+			}
+			var cmp int
+			if !kFit {
+				if !hKFit {
+					break
+				}
+				cmp = 1
+			} else if !hKFit {
+				cmp = -1
+			} else {
+				cmp = bytes.Compare(k, hK[:l])
+			}
+			if cmp < 0 {
+				if hK1, _ := hC1.Seek(k); bytes.HasPrefix(hK1, k) {
+					goOn, err = walker(keyIdx, k, v)
+				}
+			} else {
+				var hV []byte
+				if hV, err = historyValue(hBucket, hK, l, getChangeSet); err != nil {
+					return err
+				}
+				goOn, err = walker(keyIdx, hK[:l], hV)
+			}
+			if goOn {
+				if cmp <= 0 {
+					k, v = c.Next()
+				}
+				if cmp >= 0 {
+					copy(keyBuffer, hK[:l])
+					copy(keyBuffer[l:], EndSuffix)
+					hK, _ = hC.SeekTo(keyBuffer)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if c.err != nil {
+			return c.err
+		}
+		if hC.err != nil {
+			return hC.err
+		}
+		return hC1.err
+	}); err != nil {
+		return err
+	}
+	for keyIdx < len(startkeys) {
+		keyIdx++
+		if _, err := walker(keyIdx, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *BadgerDatabase) RewindData(timestampSrc, timestampDst uint64, df func(hBucket, key, value []byte) error) error {
+	return rewindData(db, timestampSrc, timestampDst, df)
+}
+
+// Delete deletes the key from the queue and database
+func (db *BadgerDatabase) Delete(bucket, key []byte) error {
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(bucketKey(bucket, key))
+	})
+}
+
+// DeleteTimestamp removes the change sets of the given block together with
+// the corresponding entries of the history buckets and their indices
+func (db *BadgerDatabase) DeleteTimestamp(timestamp uint64) error {
+	suffix := encodeTimestamp(timestamp)
+	var csKeys [][]byte
+	var changeSets [][]byte
+	if err := db.Walk(ChangeSetBucket, suffix, uint(8*len(suffix)), func(k, v []byte) (bool, error) {
+		csKeys = append(csKeys, k)
+		changeSets = append(changeSets, v)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	return db.db.Update(func(txn *badger.Txn) error {
+		for i, csKey := range csKeys {
+			hBucket := csKey[len(suffix):]
+			ib := indexBucket(hBucket)
+			if err := walkChangeSet(changeSets[i], func(key, _ []byte) error {
+				composite, _ := compositeKeySuffix(key, timestamp)
+				if err := txn.Delete(bucketKey(hBucket, composite)); err != nil {
+					return err
+				}
+				ik := indexKey(key, timestamp)
+				container, err := badgerGet(txn, ib, ik)
+				if err == ErrKeyNotFound {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if container = indexRemove(container, timestamp); container == nil {
+					return txn.Delete(bucketKey(ib, ik))
+				}
+				return txn.Set(bucketKey(ib, ik), container)
+			}); err != nil {
+				return err
+			}
+			if err := txn.Delete(bucketKey(ChangeSetBucket, csKey)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteBucket removes all the keys of the bucket.
+func (db *BadgerDatabase) DeleteBucket(bucket []byte) error {
+	var deletes [][]byte
+	if err := db.Walk(bucket, nil, 0, func(k, _ []byte) (bool, error) {
+		deletes = append(deletes, bucketKey(bucket, k))
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	wb := db.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range deletes {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (db *BadgerDatabase) Close() {
+	if err := db.db.Close(); err == nil {
+		db.log.Info("Database closed")
+	} else {
+		db.log.Error("Failed to close database", "err", err)
+	}
+}
+
+func (db *BadgerDatabase) NewBatch() Mutation {
+	return newMutation(db)
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// BucketInfo describes one bucket of the database.
+type BucketInfo struct {
+	Name  []byte // Name of the bucket
+	Key   string // Layout of the keys
+	Value string // Encoding of the values
+
+	// HistoryBucket is the bucket with the history of the bucket, nil if the
+	// bucket has no history. The history bucket and its index are registered
+	// together with the bucket.
+	HistoryBucket []byte
+}
+
+var (
+	bucketsLock sync.RWMutex
+	buckets     = make(map[string]BucketInfo)
+)
+
+func registerBucketLocked(info BucketInfo) {
+	if _, dup := buckets[string(info.Name)]; dup {
+		panic("ethdb: bucket registered twice: " + string(info.Name))
+	}
+	buckets[string(info.Name)] = info
+}
+
+// RegisterBucket adds the bucket to the registry and returns its name, so that
+// the bucket can be declared as
+//   var FooBucket = ethdb.RegisterBucket(ethdb.BucketInfo{Name: []byte("foo"), ...})
+// It panics if the name is registered twice.
+func RegisterBucket(info BucketInfo) []byte {
+	bucketsLock.Lock()
+	defer bucketsLock.Unlock()
+	registerBucketLocked(info)
+	if info.HistoryBucket != nil {
+		registerBucketLocked(BucketInfo{
+			Name:  info.HistoryBucket,
+			Key:   "key of " + string(info.Name) + " + encoded block number",
+			Value: "empty",
+		})
+		registerBucketLocked(BucketInfo{
+			Name:  indexBucket(info.HistoryBucket),
+			Key:   "key of " + string(info.Name) + " + container number (uint32 big endian)",
+			Value: "history index container of the numbers of the blocks that modified the key",
+		})
+	}
+	return info.Name
+}
+
+// LookupBucket returns the description of the registered bucket.
+func LookupBucket(name []byte) (BucketInfo, bool) {
+	bucketsLock.RLock()
+	defer bucketsLock.RUnlock()
+	info, ok := buckets[string(name)]
+	return info, ok
+}
+
+// RegisteredBuckets returns the descriptions of all the registered buckets,
+// sorted by name.
+func RegisteredBuckets() []BucketInfo {
+	bucketsLock.RLock()
+	defer bucketsLock.RUnlock()
+	infos := make([]BucketInfo, 0, len(buckets))
+	for _, info := range buckets {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return bytes.Compare(infos[i].Name, infos[j].Name) < 0 })
+	return infos
+}
@@ -0,0 +1,246 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ChangeSetBucket contains, for every block and every history bucket, the keys
+// modified by the block together with their values before the block.
+// The keys of the bucket are encodeTimestamp(blockNr) || hBucket, so that the
+// change sets are ordered by the block number.
+var ChangeSetBucket = RegisterBucket(BucketInfo{
+	Name:  []byte("ChangeSet"),
+	Key:   "encoded block number + history bucket",
+	Value: "encoded change set",
+})
+
+var errCorruptChangeSet = errors.New("ethdb: corrupt change set")
+
+// Change is one modified key with its value before the modification.
+// Empty value means that the key did not exist before.
+type Change struct {
+	Key   []byte
+	Value []byte
+}
+
+// ChangeSet is the list of the changes made to one history bucket by one block, sorted by key.
+//
+// Encoded change set looks like this:
+//   numOfChanges uint32
+//   keyEnds      [numOfChanges]uint32 - offsets of the ends of the keys in the keys section
+//   valueEnds    [numOfChanges]uint32 - offsets of the ends of the values in the values section
+//   keys         concatenated keys, sorted
+//   values       concatenated values, in the same order as keys
+// so that a value can be found by binary search without decoding the whole set.
+type ChangeSet struct {
+	Changes []Change
+}
+
+func (s *ChangeSet) Len() int {
+	return len(s.Changes)
+}
+
+// Add inserts the change into the set, keeping it sorted. If the key is already
+// in the set, its value gets replaced.
+func (s *ChangeSet) Add(key, value []byte) {
+	i := sort.Search(len(s.Changes), func(i int) bool {
+		return bytes.Compare(s.Changes[i].Key, key) >= 0
+	})
+	if i < len(s.Changes) && bytes.Equal(s.Changes[i].Key, key) {
+		s.Changes[i].Value = common.CopyBytes(value)
+		return
+	}
+	s.Changes = append(s.Changes, Change{})
+	copy(s.Changes[i+1:], s.Changes[i:])
+	s.Changes[i] = Change{Key: common.CopyBytes(key), Value: common.CopyBytes(value)}
+}
+
+// Find returns the value of the key recorded in the set.
+func (s *ChangeSet) Find(key []byte) ([]byte, bool) {
+	i := sort.Search(len(s.Changes), func(i int) bool {
+		return bytes.Compare(s.Changes[i].Key, key) >= 0
+	})
+	if i < len(s.Changes) && bytes.Equal(s.Changes[i].Key, key) {
+		return s.Changes[i].Value, true
+	}
+	return nil, false
+}
+
+// Encode serialises the change set into the format described above.
+func (s *ChangeSet) Encode() []byte {
+	n := len(s.Changes)
+	size := 4 + 8*n
+	for _, c := range s.Changes {
+		size += len(c.Key) + len(c.Value)
+	}
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf, uint32(n))
+	keysPos := 4 + 8*n
+	valuesPos := keysPos
+	for _, c := range s.Changes {
+		valuesPos += len(c.Key)
+	}
+	keyEnd, valueEnd := 0, 0
+	for i, c := range s.Changes {
+		copy(buf[keysPos+keyEnd:], c.Key)
+		keyEnd += len(c.Key)
+		binary.BigEndian.PutUint32(buf[4+4*i:], uint32(keyEnd))
+		copy(buf[valuesPos+valueEnd:], c.Value)
+		valueEnd += len(c.Value)
+		binary.BigEndian.PutUint32(buf[4+4*n+4*i:], uint32(valueEnd))
+	}
+	return buf
+}
+
+// DecodeChangeSet parses the encoded change set. Empty input gives an empty set.
+func DecodeChangeSet(b []byte) (*ChangeSet, error) {
+	s := &ChangeSet{}
+	if err := walkChangeSet(b, func(k, v []byte) error {
+		s.Changes = append(s.Changes, Change{Key: common.CopyBytes(k), Value: common.CopyBytes(v)})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// changeSetLayout checks the header of the encoded change set and returns
+// the number of changes and the positions of the keys and values sections.
+func changeSetLayout(b []byte) (n, keysPos, valuesPos int, err error) {
+	if len(b) == 0 {
+		return 0, 0, 0, nil
+	}
+	if len(b) < 4 {
+		return 0, 0, 0, errCorruptChangeSet
+	}
+	n = int(binary.BigEndian.Uint32(b))
+	keysPos = 4 + 8*n
+	if len(b) < keysPos {
+		return 0, 0, 0, errCorruptChangeSet
+	}
+	if n == 0 {
+		return 0, keysPos, keysPos, nil
+	}
+	valuesPos = keysPos + int(binary.BigEndian.Uint32(b[4+4*(n-1):]))
+	if len(b) != valuesPos+int(binary.BigEndian.Uint32(b[4+8*n-4:])) {
+		return 0, 0, 0, errCorruptChangeSet
+	}
+	return n, keysPos, valuesPos, nil
+}
+
+func changeSetItem(b []byte, n, keysPos, valuesPos, i int) (key, value []byte) {
+	var keyStart, valueStart int
+	if i > 0 {
+		keyStart = int(binary.BigEndian.Uint32(b[4+4*(i-1):]))
+		valueStart = int(binary.BigEndian.Uint32(b[4+4*n+4*(i-1):]))
+	}
+	keyEnd := int(binary.BigEndian.Uint32(b[4+4*i:]))
+	valueEnd := int(binary.BigEndian.Uint32(b[4+4*n+4*i:]))
+	return b[keysPos+keyStart : keysPos+keyEnd], b[valuesPos+valueStart : valuesPos+valueEnd]
+}
+
+// walkChangeSet calls the walker for every change in the encoded change set, in the order of keys.
+func walkChangeSet(b []byte, walker func(k, v []byte) error) error {
+	n, keysPos, valuesPos, err := changeSetLayout(b)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		k, v := changeSetItem(b, n, keysPos, valuesPos, i)
+		if err := walker(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findInChangeSet looks up the value of the key in the encoded change set.
+func findInChangeSet(b []byte, key []byte) ([]byte, bool, error) {
+	n, keysPos, valuesPos, err := changeSetLayout(b)
+	if err != nil {
+		return nil, false, err
+	}
+	i := sort.Search(n, func(i int) bool {
+		k, _ := changeSetItem(b, n, keysPos, valuesPos, i)
+		return bytes.Compare(k, key) >= 0
+	})
+	if i == n {
+		return nil, false, nil
+	}
+	k, v := changeSetItem(b, n, keysPos, valuesPos, i)
+	if !bytes.Equal(k, key) {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+func changeSetKey(timestamp uint64, hBucket []byte) []byte {
+	suffix := encodeTimestamp(timestamp)
+	k := make([]byte, len(suffix)+len(hBucket))
+	copy(k, suffix)
+	copy(k[len(suffix):], hBucket)
+	return k
+}
+
+// historyValue returns the value recorded by the history index entry hK, which is
+// the key of length l followed by the encoded timestamp of the change.
+// The value itself is taken from the change set of that timestamp, supplied by getChangeSet.
+func historyValue(hBucket, hK []byte, l int, getChangeSet func(csKey []byte) []byte) ([]byte, error) {
+	timestamp, _ := decodeTimestamp(hK[l:])
+	return historyValueAt(hBucket, hK[:l], timestamp, getChangeSet)
+}
+
+// historyValueAt returns the value of the key recorded in the change set of the given timestamp.
+func historyValueAt(hBucket, key []byte, timestamp uint64, getChangeSet func(csKey []byte) []byte) ([]byte, error) {
+	v, ok, err := findInChangeSet(getChangeSet(changeSetKey(timestamp, hBucket)), key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("ethdb: key %x is missing from the change set of bucket %s at block %d", key, hBucket, timestamp)
+	}
+	return v, nil
+}
+
+// GetChangeSet returns the changes made to the history bucket by the given block.
+func GetChangeSet(db Getter, hBucket []byte, timestamp uint64) (*ChangeSet, error) {
+	enc, err := db.Get(ChangeSetBucket, changeSetKey(timestamp, hBucket))
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	return DecodeChangeSet(enc)
+}
+
+// LastChangeSetAfter returns the last block after the given one that has change
+// sets, or the given block itself if there are none. The state in the database
+// is ahead of the block by the blocks in between.
+func LastChangeSetAfter(db Getter, timestamp uint64) (uint64, error) {
+	last := timestamp
+	err := db.Walk(ChangeSetBucket, encodeTimestamp(timestamp+1), 0, func(k, _ []byte) (bool, error) {
+		last, _ = decodeTimestamp(k)
+		return true, nil
+	})
+	return last, err
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChangeSetEncoding(t *testing.T) {
+	s := &ChangeSet{}
+	s.Add([]byte("key3"), []byte("value3"))
+	s.Add([]byte("key1"), []byte{})
+	s.Add([]byte("key2"), []byte("value2"))
+	s.Add([]byte("key1"), []byte("value1"))
+	if s.Len() != 3 {
+		t.Fatalf("expected 3 changes, got %d", s.Len())
+	}
+	enc := s.Encode()
+	decoded, err := DecodeChangeSet(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Len() != 3 {
+		t.Fatalf("expected 3 decoded changes, got %d", decoded.Len())
+	}
+	for i, exp := range []string{"1", "2", "3"} {
+		c := decoded.Changes[i]
+		if string(c.Key) != "key"+exp || string(c.Value) != "value"+exp {
+			t.Errorf("change %d: got %s=%s", i, c.Key, c.Value)
+		}
+		v, ok, err := findInChangeSet(enc, c.Key)
+		if err != nil || !ok || !bytes.Equal(v, c.Value) {
+			t.Errorf("find %s: got %s, %t, %v", c.Key, v, ok, err)
+		}
+	}
+	if _, ok, err := findInChangeSet(enc, []byte("key0")); ok || err != nil {
+		t.Errorf("found missing key: %t, %v", ok, err)
+	}
+	if _, err := DecodeChangeSet(enc[:len(enc)-1]); err != errCorruptChangeSet {
+		t.Errorf("expected corrupt change set error, got %v", err)
+	}
+	if empty, err := DecodeChangeSet(nil); err != nil || empty.Len() != 0 {
+		t.Errorf("expected empty change set, got %v, %v", empty, err)
+	}
+}
+
+func TestChangeSetMergeOnCommit(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if err := db.PutS(conformanceHBucket, conformanceKey(1), []byte("a"), 5); err != nil {
+			t.Fatal(err)
+		}
+		batch := db.NewBatch()
+		if err := batch.PutS(conformanceHBucket, conformanceKey(2), []byte("b"), 5); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := batch.GetS(conformanceHBucket, conformanceKey(1), 5); err != nil || string(v) != "a" {
+			t.Errorf("batch should see the committed change set, got %s, %v", v, err)
+		}
+		if v, err := batch.GetS(conformanceHBucket, conformanceKey(2), 5); err != nil || string(v) != "b" {
+			t.Errorf("batch should see its own change set, got %s, %v", v, err)
+		}
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		changeSet, err := GetChangeSet(db, conformanceHBucket, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changeSet.Len() != 2 {
+			t.Fatalf("expected 2 changes after commit, got %d", changeSet.Len())
+		}
+		if !bytes.Equal(changeSet.Changes[0].Key, conformanceKey(1)) || !bytes.Equal(changeSet.Changes[1].Key, conformanceKey(2)) {
+			t.Errorf("unexpected change set keys %s, %s", changeSet.Changes[0].Key, changeSet.Changes[1].Key)
+		}
+	})
+}
+
+func TestChangeSetMergedByPutS(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		// The changes are recorded in reverse, and the last value of a key wins
+		for i := 9; i >= 0; i-- {
+			if err := db.PutS(conformanceHBucket, conformanceKey(i), []byte{byte(i)}, 5); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.PutS(conformanceHBucket, conformanceKey(3), []byte("x"), 5); err != nil {
+			t.Fatal(err)
+		}
+		// A read in the middle of the block sees the changes so far, and the
+		// later changes of the block are merged into the change set
+		if v, err := db.GetS(conformanceHBucket, conformanceKey(3), 5); err != nil || string(v) != "x" {
+			t.Errorf("change not visible before the end of the block: %x, %v", v, err)
+		}
+		if err := db.PutS(conformanceHBucket, conformanceKey(10), []byte{10}, 5); err != nil {
+			t.Fatal(err)
+		}
+		// The changes of the next block go into a change set of their own
+		if err := db.PutS(conformanceHBucket, conformanceKey(0), []byte("next"), 6); err != nil {
+			t.Fatal(err)
+		}
+		changeSet, err := GetChangeSet(db, conformanceHBucket, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changeSet.Len() != 11 {
+			t.Fatalf("change count mismatch: have %d, want 11", changeSet.Len())
+		}
+		for i, c := range changeSet.Changes {
+			want := []byte{byte(i)}
+			if i == 3 {
+				want = []byte("x")
+			}
+			if !bytes.Equal(c.Key, conformanceKey(i)) || !bytes.Equal(c.Value, want) {
+				t.Errorf("change %d: have %s=%x, want %s=%x", i, c.Key, c.Value, conformanceKey(i), want)
+			}
+		}
+		if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 6); err != nil || string(v) != "next" {
+			t.Errorf("history of the last block not visible: %x, %v", v, err)
+		}
+	})
+}
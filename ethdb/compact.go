@@ -0,0 +1,183 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// bucketEnumer is implemented by the databases whose buckets are created with
+// or without the support for the enumeration of leaves, which has to be kept
+// when the buckets are copied.
+type bucketEnumer interface {
+	bucketEnum(bucket []byte) (bool, error)
+	createBucket(bucket []byte, enum bool) error
+}
+
+// CopyDatabase copies every bucket of src into dst, which is supposed to be
+// empty, in batches of about IdealBatchSize bytes. Writing the data into a
+// fresh database leaves out the free pages accumulated by src, which is how
+// the databases get compacted. The buckets are created in dst with the same
+// enumeration flag as in src. The progress is reported after every batch.
+func CopyDatabase(src, dst Database, progress func(bucket []byte, keys uint64)) error {
+	inspector, ok := src.(Inspector)
+	if !ok {
+		return fmt.Errorf("database %T cannot enumerate its buckets", src)
+	}
+	buckets, err := inspector.Buckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		var (
+			tuples  [][]byte
+			size    int
+			keys    uint64
+			created bool
+		)
+		flush := func() error {
+			if len(tuples) == 0 {
+				return nil
+			}
+			if !created {
+				// MultiPut would create the bucket without the enumeration
+				if err := copyBucketEnum(src, dst, bucket); err != nil {
+					return err
+				}
+				created = true
+			}
+			if _, err := dst.MultiPut(tuples...); err != nil {
+				return err
+			}
+			tuples, size = tuples[:0], 0
+			if progress != nil {
+				progress(bucket, keys)
+			}
+			return nil
+		}
+		if err := src.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+			tuples = append(tuples, bucket, common.CopyBytes(k), common.CopyBytes(v))
+			size += len(k) + len(v)
+			keys++
+			if size >= IdealBatchSize {
+				return true, flush()
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBucketEnum creates the bucket in dst with the enumeration flag it has in
+// src, if both databases have the flag.
+func copyBucketEnum(src, dst Database, bucket []byte) error {
+	srcEnumer, ok := src.(bucketEnumer)
+	if !ok {
+		return nil
+	}
+	dstEnumer, ok := dst.(bucketEnumer)
+	if !ok {
+		return nil
+	}
+	enum, err := srcEnumer.bucketEnum(bucket)
+	if err != nil {
+		return err
+	}
+	return dstEnumer.createBucket(bucket, enum)
+}
+
+// bucketDigest hashes the whole content of the bucket.
+func bucketDigest(db Database, bucket []byte) ([]byte, uint64, error) {
+	var (
+		h    = sha256.New()
+		keys uint64
+		lens [8]byte
+	)
+	err := db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+		binary.BigEndian.PutUint32(lens[:], uint32(len(k)))
+		binary.BigEndian.PutUint32(lens[4:], uint32(len(v)))
+		h.Write(lens[:])
+		h.Write(k)
+		h.Write(v)
+		keys++
+		return true, nil
+	})
+	return h.Sum(nil), keys, err
+}
+
+// VerifyCopy checks that dst holds exactly the same buckets with the same keys
+// and values as src.
+func VerifyCopy(src, dst Database) error {
+	srcInspector, ok := src.(Inspector)
+	if !ok {
+		return fmt.Errorf("database %T cannot enumerate its buckets", src)
+	}
+	dstInspector, ok := dst.(Inspector)
+	if !ok {
+		return fmt.Errorf("database %T cannot enumerate its buckets", dst)
+	}
+	srcBuckets, err := srcInspector.Buckets()
+	if err != nil {
+		return err
+	}
+	dstBuckets, err := dstInspector.Buckets()
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(dstBuckets))
+	for _, bucket := range dstBuckets {
+		present[string(bucket)] = true
+	}
+	for _, bucket := range srcBuckets {
+		srcDigest, srcKeys, err := bucketDigest(src, bucket)
+		if err != nil {
+			return err
+		}
+		if srcKeys == 0 {
+			// Empty buckets are not copied
+			continue
+		}
+		if !present[string(bucket)] {
+			return fmt.Errorf("bucket %q is missing from the copy", bucket)
+		}
+		delete(present, string(bucket))
+		dstDigest, dstKeys, err := bucketDigest(dst, bucket)
+		if err != nil {
+			return err
+		}
+		if srcKeys != dstKeys {
+			return fmt.Errorf("bucket %q has %d keys in the copy, expected %d", bucket, dstKeys, srcKeys)
+		}
+		if !bytes.Equal(srcDigest, dstDigest) {
+			return fmt.Errorf("content of bucket %q differs in the copy", bucket)
+		}
+	}
+	for bucket := range present {
+		return fmt.Errorf("unexpected bucket %q in the copy", bucket)
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The tests in this file form the conformance suite that every storage engine
+// registered with RegisterEngine must pass.
+
+var (
+	conformanceBucket  = []byte("AT")
+	conformanceHBucket = []byte("hAT")
+)
+
+func forEachEngine(t *testing.T, test func(t *testing.T, db Database)) {
+	for _, engine := range Engines() {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_conformance_")
+			if err != nil {
+				t.Fatalf("failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dirname)
+			db, err := Open(engine, path.Join(dirname, "db"))
+			if err != nil {
+				t.Fatalf("failed to open %s database: %v", engine, err)
+			}
+			defer db.Close()
+			test(t, db)
+		})
+	}
+}
+
+func conformanceKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+func TestConformancePutGet(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		testPutGet(db, t)
+	})
+}
+
+func TestConformanceParallelPutGet(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		testParallelPutGet(db, t)
+	})
+}
+
+func TestConformanceHasDelete(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if has, err := db.Has(conformanceBucket, conformanceKey(1)); err != nil || has {
+			t.Fatalf("expected no key in empty database, got %t, %v", has, err)
+		}
+		if err := db.Put(conformanceBucket, conformanceKey(1), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		if has, err := db.Has(conformanceBucket, conformanceKey(1)); err != nil || !has {
+			t.Fatalf("expected key after put, got %t, %v", has, err)
+		}
+		if has, _ := db.Has(conformanceHBucket, conformanceKey(1)); has {
+			t.Fatalf("key leaked into another bucket")
+		}
+		if err := db.Delete(conformanceBucket, conformanceKey(1)); err != nil {
+			t.Fatal(err)
+		}
+		if has, err := db.Has(conformanceBucket, conformanceKey(1)); err != nil || has {
+			t.Fatalf("expected no key after delete, got %t, %v", has, err)
+		}
+		if _, err := db.Get(conformanceBucket, conformanceKey(1)); err != ErrKeyNotFound {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestConformanceWalk(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		for i := 0; i < 20; i++ {
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A key in a different bucket with the same prefix must not be visited
+		if err := db.Put(conformanceHBucket, conformanceKey(5), []byte{0xff}); err != nil {
+			t.Fatal(err)
+		}
+		var visited []int
+		if err := db.Walk(conformanceBucket, conformanceKey(5), 0, func(k, v []byte) (bool, error) {
+			if !bytes.Equal(k, conformanceKey(int(v[0]))) {
+				t.Errorf("unexpected pair %s: %x", k, v)
+			}
+			visited = append(visited, int(v[0]))
+			return len(visited) < 10, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(visited) != 10 || visited[0] != 5 || visited[9] != 14 {
+			t.Errorf("unexpected walk result: %v", visited)
+		}
+		// Fixed bits restrict the walk to keys "key0001x"
+		count := 0
+		if err := db.Walk(conformanceBucket, conformanceKey(10), 8*7, func(k, v []byte) (bool, error) {
+			count++
+			return true, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if count != 10 {
+			t.Errorf("expected 10 keys with fixed bits, got %d", count)
+		}
+	})
+}
+
+func TestConformanceMultiWalk(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		for i := 0; i < 40; i++ {
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		counts := make(map[int]int)
+		if err := db.MultiWalk(conformanceBucket,
+			[][]byte{conformanceKey(0), conformanceKey(20), conformanceKey(30)},
+			[]uint{8 * 7, 8 * 7, 8 * 7},
+			func(i int, k, v []byte) (bool, error) {
+				if k != nil {
+					counts[i]++
+				}
+				return true, nil
+			},
+		); err != nil {
+			t.Fatal(err)
+		}
+		if counts[0] != 10 || counts[1] != 10 || counts[2] != 10 {
+			t.Errorf("unexpected counts per start key: %v", counts)
+		}
+	})
+}
+
+// putHistory simulates the writes of the blocks 1..3 for keys 0..9
+// Key i is modified in block b if i%b == 0, the value of key i after
+// block b is {i, b}.
+func putHistory(t *testing.T, db Database) {
+	for b := 1; b <= 3; b++ {
+		for i := 0; i < 10; i++ {
+			if i%b != 0 {
+				continue
+			}
+			prev, err := db.Get(conformanceBucket, conformanceKey(i))
+			if err != nil && err != ErrKeyNotFound {
+				t.Fatal(err)
+			}
+			if prev == nil {
+				prev = []byte{}
+			}
+			if err := db.PutS(conformanceHBucket, conformanceKey(i), prev, uint64(b)); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte{byte(i), byte(b)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// expectedAsOf returns the value of key i as of the beginning of the block b.
+func expectedAsOf(i, b int) []byte {
+	for last := b - 1; last >= 1; last-- {
+		if i%last == 0 {
+			return []byte{byte(i), byte(last)}
+		}
+	}
+	return []byte{}
+}
+
+func TestConformanceGetAsOf(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		for b := 1; b <= 4; b++ {
+			for i := 0; i < 10; i++ {
+				v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(i), uint64(b))
+				if err != nil {
+					t.Fatalf("GetAsOf key %d block %d: %v", i, b, err)
+				}
+				if exp := expectedAsOf(i, b); !bytes.Equal(v, exp) {
+					t.Errorf("GetAsOf key %d block %d: expected %x, got %x", i, b, exp, v)
+				}
+			}
+		}
+		v, err := db.GetS(conformanceHBucket, conformanceKey(6), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := expectedAsOf(6, 3); !bytes.Equal(v, exp) {
+			t.Errorf("GetS: expected %x, got %x", exp, v)
+		}
+		if _, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(10), 1); err != ErrKeyNotFound {
+			t.Errorf("expected ErrKeyNotFound for unknown key, got %v", err)
+		}
+	})
+}
+
+func TestConformanceWalkAsOf(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		for b := 1; b <= 4; b++ {
+			seen := make(map[string][]byte)
+			if err := db.WalkAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 0, uint64(b), func(k, v []byte) (bool, error) {
+				seen[string(k)] = common.CopyBytes(v)
+				return true, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) != 10 {
+				t.Errorf("block %d: expected 10 keys, got %d", b, len(seen))
+			}
+			for i := 0; i < 10; i++ {
+				if exp := expectedAsOf(i, b); !bytes.Equal(seen[string(conformanceKey(i))], exp) {
+					t.Errorf("WalkAsOf key %d block %d: expected %x, got %x", i, b, exp, seen[string(conformanceKey(i))])
+				}
+			}
+		}
+	})
+}
+
+func TestConformanceMultiWalkAsOf(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		seen := make(map[string][]byte)
+		if err := db.MultiWalkAsOf(conformanceBucket, conformanceHBucket, [][]byte{conformanceKey(0)}, []uint{8 * 7}, 2, func(i int, k, v []byte) (bool, error) {
+			if k != nil {
+				seen[string(k)] = common.CopyBytes(v)
+			}
+			return true, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if exp := expectedAsOf(i, 2); !bytes.Equal(seen[string(conformanceKey(i))], exp) {
+				t.Errorf("MultiWalkAsOf key %d: expected %x, got %x", i, exp, seen[string(conformanceKey(i))])
+			}
+		}
+	})
+}
+
+func TestConformanceDeleteTimestamp(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		if err := db.DeleteTimestamp(3); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i += 3 {
+			if _, err := db.GetS(conformanceHBucket, conformanceKey(i), 3); err != ErrKeyNotFound {
+				t.Errorf("history of key %d at block 3 survived DeleteTimestamp: %v", i, err)
+			}
+		}
+		if _, err := db.GetS(conformanceHBucket, conformanceKey(2), 2); err != nil {
+			t.Errorf("history at block 2 was deleted: %v", err)
+		}
+	})
+}
+
+func TestConformanceRewindData(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		rewound := make(map[string][]byte)
+		if err := db.RewindData(3, 1, func(bucket, key, value []byte) error {
+			if !bytes.Equal(bucket, conformanceHBucket) {
+				t.Errorf("unexpected bucket %s", bucket)
+			}
+			rewound[string(key)] = common.CopyBytes(value)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// Blocks 2 and 3 modified the keys divisible by 2 or 3
+		expected := 0
+		for i := 0; i < 10; i++ {
+			if i%2 != 0 && i%3 != 0 {
+				continue
+			}
+			expected++
+			if exp := expectedAsOf(i, 2); !bytes.Equal(rewound[string(conformanceKey(i))], exp) {
+				t.Errorf("rewind key %d: expected %x, got %x", i, exp, rewound[string(conformanceKey(i))])
+			}
+		}
+		if len(rewound) != expected {
+			t.Errorf("expected %d rewound keys, got %d", expected, len(rewound))
+		}
+	})
+}
+
+func TestConformanceBatch(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if err := db.Put(conformanceBucket, conformanceKey(0), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		batch := db.NewBatch()
+		for i := 1; i < 100; i++ {
+			if err := batch.Put(conformanceBucket, conformanceKey(i), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := batch.Delete(conformanceBucket, conformanceKey(0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.PutS(conformanceHBucket, conformanceKey(0), []byte("old"), 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := batch.Get(conformanceBucket, conformanceKey(0)); err != ErrKeyNotFound {
+			t.Errorf("batch should see its own delete, got %v", err)
+		}
+		if v, err := db.Get(conformanceBucket, conformanceKey(0)); err != nil || !bytes.Equal(v, []byte("old")) {
+			t.Errorf("database should not see uncommitted delete, got %x, %v", v, err)
+		}
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < 100; i++ {
+			if v, err := db.Get(conformanceBucket, conformanceKey(i)); err != nil || !bytes.Equal(v, []byte{byte(i)}) {
+				t.Errorf("key %d after commit: %x, %v", i, v, err)
+			}
+		}
+		if _, err := db.Get(conformanceBucket, conformanceKey(0)); err != ErrKeyNotFound {
+			t.Errorf("expected deleted key after commit, got %v", err)
+		}
+		if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 1); err != nil || !bytes.Equal(v, []byte("old")) {
+			t.Errorf("expected history written by the batch, got %x, %v", v, err)
+		}
+		if err := db.DeleteTimestamp(1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetS(conformanceHBucket, conformanceKey(0), 1); err != ErrKeyNotFound {
+			t.Errorf("change sets written by the batch were not used by DeleteTimestamp: %v", err)
+		}
+	})
+}
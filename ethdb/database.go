@@ -20,9 +20,7 @@ package ethdb
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
-	"fmt"
 	"os"
 	"path"
 	"sync"
@@ -36,11 +34,22 @@ import (
 
 var OpenFileLimit = 64
 var ErrKeyNotFound = errors.New("boltdb: key not found in range")
-var SuffixBucket = []byte("SUFFIX")
 
 const HeapSize = 512 * 1024 * 1024
 
+// initialMmapSize is the size of the memory map the database starts with.
+// Bolt remaps the file when it grows beyond the map, and the remapping waits
+// for all the read transactions, including the snapshots, to finish.
+const initialMmapSize = 1024 * 1024 * 1024
+
+func init() {
+	RegisterEngine("bolt", func(path string) (Database, error) {
+		return NewLDBDatabase(path)
+	})
+}
+
 type LDBDatabase struct {
+	boltReader
 	fn string   // filename for reporting
 	db *bolt.DB // BoltDB instance
 
@@ -59,16 +68,66 @@ func NewLDBDatabase(file string) (*LDBDatabase, error) {
 		return nil, err
 	}
 	// Open the db and recover any potential corruptions
-	db, err := bolt.Open(file, 0600, &bolt.Options{})
+	db, err := bolt.Open(file, 0600, &bolt.Options{InitialMmapSize: initialMmapSize})
 	// (Re)check for errors and abort if opening of the db failed
 	if err != nil {
 		return nil, err
 	}
+	ldb := newLDBDatabase(file, db, logger)
+	if err := ldb.replayJournal(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ldb, nil
+}
+
+func newLDBDatabase(file string, db *bolt.DB, logger log.Logger) *LDBDatabase {
 	return &LDBDatabase{
-		fn:  file,
-		db:  db,
-		log: logger,
-	}, nil
+		boltReader: boltReader{view: db.View},
+		fn:         file,
+		db:         db,
+		log:        logger,
+	}
+}
+
+// boltReader implements the Getter on top of the bolt read transactions. The
+// database runs every read in a transaction of its own, while all the reads of
+// a snapshot share its transaction.
+type boltReader struct {
+	view func(fn func(tx *bolt.Tx) error) error
+}
+
+// boltSnapshot is the Snapshot of the bolt database, i.e. a read transaction.
+type boltSnapshot struct {
+	boltReader
+	tx *bolt.Tx
+}
+
+func newBoltSnapshot(tx *bolt.Tx) *boltSnapshot {
+	return &boltSnapshot{
+		boltReader: boltReader{view: func(fn func(tx *bolt.Tx) error) error { return fn(tx) }},
+		tx:         tx,
+	}
+}
+
+func (s *boltSnapshot) Close() {
+	s.tx.Rollback()
+}
+
+// View runs the function within a read transaction of the database.
+func (db *LDBDatabase) View(f func(tx Getter) error) error {
+	return db.db.View(func(tx *bolt.Tx) error {
+		return f(newBoltSnapshot(tx))
+	})
+}
+
+// Begin opens a read transaction of the database.
+func (db *LDBDatabase) Begin() (Snapshot, error) {
+	tx, err := db.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return newBoltSnapshot(tx), nil
 }
 
 // Path returns the path to the database directory.
@@ -103,40 +162,67 @@ func historyBucket(bucket []byte) []byte {
 	return hb
 }
 
-// Put puts the given key / value to the queue
+// PutS records that the key of the history bucket hBucket had the given value
+// before the block with the given timestamp. The value is merged into the change
+// set of the block, the history bucket gets an entry key || timestamp, and the
+// block is added to the history index of the key, all in one transaction.
 func (db *LDBDatabase) PutS(hBucket, key, value []byte, timestamp uint64) error {
-	composite, suffix := compositeKeySuffix(key, timestamp)
-	suffixkey := make([]byte, len(suffix)+len(hBucket))
-	copy(suffixkey, suffix)
+	composite, _ := compositeKeySuffix(key, timestamp)
+	csKey := changeSetKey(timestamp, hBucket)
 	err := db.db.Update(func(tx *bolt.Tx) error {
 		hb, err := tx.CreateBucketIfNotExists(hBucket, true)
 		if err != nil {
 			return err
 		}
-		if err = hb.Put(composite, value); err != nil {
+		if err = hb.Put(composite, []byte{}); err != nil {
 			return err
 		}
-		sb, err := tx.CreateBucketIfNotExists(SuffixBucket, true)
+		ib, err := tx.CreateBucketIfNotExists(indexBucket(hBucket), false)
 		if err != nil {
 			return err
 		}
-		dat, _ := sb.Get(suffixkey)
-		var l int
-		if dat == nil {
-			l = 4
-		} else {
-			l = len(dat)
+		ik := indexKey(key, timestamp)
+		container, _ := ib.Get(ik)
+		if err = ib.Put(ik, indexAdd(container, timestamp)); err != nil {
+			return err
+		}
+		csb, err := tx.CreateBucketIfNotExists(ChangeSetBucket, true)
+		if err != nil {
+			return err
+		}
+		dat, _ := csb.Get(csKey)
+		changeSet, err := DecodeChangeSet(dat)
+		if err != nil {
+			return err
 		}
-		dv := make([]byte, l+1+len(key))
-		copy(dv, dat)
-		binary.BigEndian.PutUint32(dv, 1+binary.BigEndian.Uint32(dv)) // Increment the counter of keys
-		dv[l] = byte(len(key))
-		copy(dv[l+1:], key)
-		return sb.Put(suffixkey, dv)
+		changeSet.Add(key, value)
+		return csb.Put(csKey, changeSet.Encode())
 	})
 	return err
 }
 
+// boltHistoryHorizon returns the history horizon as seen by the transaction.
+func boltHistoryHorizon(tx *bolt.Tx) uint64 {
+	b := tx.Bucket(HistoryHorizonBucket)
+	if b == nil {
+		return 0
+	}
+	v, _ := b.Get(historyHorizonKey)
+	return decodeHorizon(v)
+}
+
+// boltChangeSets returns the function reading the encoded change sets within the transaction.
+func boltChangeSets(tx *bolt.Tx) func(csKey []byte) []byte {
+	csb := tx.Bucket(ChangeSetBucket)
+	return func(csKey []byte) []byte {
+		if csb == nil {
+			return nil
+		}
+		v, _ := csb.Get(csKey)
+		return v
+	}
+}
+
 func (db *LDBDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
 	var savedTx *bolt.Tx
 	err := db.db.Update(func(tx *bolt.Tx) error {
@@ -168,9 +254,9 @@ func (db *LDBDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
 	return uint64(savedTx.Stats().Write), nil
 }
 
-func (db *LDBDatabase) Has(bucket, key []byte) (bool, error) {
+func (r *boltReader) Has(bucket, key []byte) (bool, error) {
 	var has bool
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := r.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
 			has = false
@@ -187,11 +273,57 @@ func (db *LDBDatabase) Size() int {
 	return db.db.Size()
 }
 
+// Buckets returns the names of all the buckets in the database.
+func (db *LDBDatabase) Buckets() ([][]byte, error) {
+	var buckets [][]byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, common.CopyBytes(name))
+			return nil
+		})
+	})
+	return buckets, err
+}
+
+func (db *LDBDatabase) pageFill(bucket []byte) (float64, error) {
+	var fill float64
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		bs := b.Stats()
+		if alloc := bs.BranchAlloc + bs.LeafAlloc; alloc > 0 {
+			fill = float64(bs.BranchInuse+bs.LeafInuse) / float64(alloc)
+		}
+		return nil
+	})
+	return fill, err
+}
+
+func (db *LDBDatabase) bucketEnum(bucket []byte) (bool, error) {
+	var enum bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			enum = b.Enum()
+		}
+		return nil
+	})
+	return enum, err
+}
+
+func (db *LDBDatabase) createBucket(bucket []byte, enum bool) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket, enum)
+		return err
+	})
+}
+
 // Get returns the given key if it's present.
-func (db *LDBDatabase) Get(bucket, key []byte) ([]byte, error) {
+func (r *boltReader) Get(bucket, key []byte) ([]byte, error) {
 	// Retrieve the key and increment the miss counter if not found
 	var dat []byte
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := r.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		if b != nil {
 			v, _ := b.Get(key)
@@ -208,38 +340,44 @@ func (db *LDBDatabase) Get(bucket, key []byte) ([]byte, error) {
 	return dat, err
 }
 
-func (db *LDBDatabase) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	composite, _ := compositeKeySuffix(key, timestamp)
-	return db.Get(hBucket, composite)
+func (r *boltReader) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	var dat []byte
+	err := r.view(func(tx *bolt.Tx) error {
+		v, ok, err := findInChangeSet(boltChangeSets(tx)(changeSetKey(timestamp, hBucket)), key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrKeyNotFound
+		}
+		dat = common.CopyBytes(v)
+		return nil
+	})
+	return dat, err
 }
 
-// GetAsOf returns the first pair (k, v) where key is a prefix of k, or nil
-// if there are not such (k, v)
-func (db *LDBDatabase) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	composite, _ := compositeKeySuffix(key, timestamp)
+// GetAsOf returns the value of the key as it was at the given timestamp.
+// The history index points at the first change of the key after the timestamp,
+// if there is no such change, the current value is returned
+func (r *boltReader) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
 	var dat []byte
-	err := db.db.View(func(tx *bolt.Tx) error {
-		{
-			hB := tx.Bucket(hBucket)
-			if hB == nil {
-				return ErrKeyNotFound
-			}
-			hC := hB.Cursor()
-			hK, hV := hC.Seek(composite)
-			if hK != nil && bytes.HasPrefix(hK, key) {
-				dat = make([]byte, len(hV))
-				copy(dat, hV)
+	err := r.view(func(tx *bolt.Tx) error {
+		if err := checkHorizon(boltHistoryHorizon(tx), timestamp); err != nil {
+			return err
+		}
+		if iB := tx.Bucket(indexBucket(hBucket)); iB != nil {
+			if changeTimestamp, ok := nextChange(iB.Cursor(), key, timestamp); ok {
+				hV, err := historyValueAt(hBucket, key, changeTimestamp, boltChangeSets(tx))
+				if err != nil {
+					return err
+				}
+				dat = common.CopyBytes(hV)
 				return nil
 			}
 		}
-		{
-			b := tx.Bucket(bucket)
-			if b == nil {
-				return ErrKeyNotFound
-			}
-			c := b.Cursor()
-			k, v := c.Seek(key)
-			if k != nil && bytes.Equal(k, key) {
+		if b := tx.Bucket(bucket); b != nil {
+			v, _ := b.Get(key)
+			if v != nil {
 				dat = make([]byte, len(v))
 				copy(dat, v)
 				return nil
@@ -260,9 +398,9 @@ func bytesmask(fixedbits uint) (fixedbytes int, mask byte) {
 	return fixedbytes, mask
 }
 
-func (db *LDBDatabase) Walk(bucket, startkey []byte, fixedbits uint, walker func(k, v []byte) (bool, error)) error {
+func (r *boltReader) Walk(bucket, startkey []byte, fixedbits uint, walker func(k, v []byte) (bool, error)) error {
 	fixedbytes, mask := bytesmask(fixedbits)
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := r.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
 			return nil
@@ -284,14 +422,14 @@ func (db *LDBDatabase) Walk(bucket, startkey []byte, fixedbits uint, walker func
 	return err
 }
 
-func (db *LDBDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
+func (r *boltReader) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
 	if len(startkeys) == 0 {
 		return nil
 	}
 	keyIdx := 0 // What is the current key we are extracting
 	fixedbytes, mask := bytesmask(fixedbits[keyIdx])
 	startkey := startkeys[keyIdx]
-	if err := db.db.View(func(tx *bolt.Tx) error {
+	if err := r.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucket)
 		if b == nil {
 			return nil
@@ -356,25 +494,29 @@ func (db *LDBDatabase) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []
 	return nil
 }
 
-func (db *LDBDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+func (r *boltReader) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
 	fixedbytes, mask := bytesmask(fixedbits)
 	suffix := encodeTimestamp(timestamp)
 	l := len(startkey)
 	sl := l + len(suffix)
 	keyBuffer := make([]byte, l+len(EndSuffix))
-	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucket)
-		if b == nil {
-			return nil
+	err := r.view(func(tx *bolt.Tx) error {
+		if err := checkHorizon(boltHistoryHorizon(tx), timestamp); err != nil {
+			return err
 		}
-		hB := tx.Bucket(hBucket)
-		if hB == nil {
-			return nil
+		// Missing buckets are treated as empty, so that the history can be
+		// walked even when the current state is gone, and vice versa
+		var c, hC *bolt.Cursor
+		var k, v, hK []byte
+		getChangeSet := boltChangeSets(tx)
+		if b := tx.Bucket(bucket); b != nil {
+			c = b.Cursor()
+			k, v = c.Seek(startkey)
+		}
+		if hB := tx.Bucket(hBucket); hB != nil {
+			hC = hB.Cursor()
+			hK, _ = hC.Seek(startkey)
 		}
-		c := b.Cursor()
-		hC := hB.Cursor()
-		k, v := c.Seek(startkey)
-		hK, hV := hC.Seek(startkey)
 		goOn := true
 		var err error
 		for goOn {
@@ -393,7 +535,7 @@ func (db *LDBDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint
 			if hK != nil && bytes.Compare(hK[l:], suffix) < 0 {
 				copy(keyBuffer, hK[:l])
 				copy(keyBuffer[l:], suffix)
-				hK, hV = hC.SeekTo(keyBuffer[:sl])
+				hK, _ = hC.SeekTo(keyBuffer[:sl])
 				continue
 			}
 			var cmp int
@@ -412,6 +554,10 @@ func (db *LDBDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint
 			if cmp < 0 {
 				goOn, err = walker(k, v)
 			} else {
+				var hV []byte
+				if hV, err = historyValue(hBucket, hK, l, getChangeSet); err != nil {
+					return err
+				}
 				goOn, err = walker(hK[:l], hV)
 			}
 			if goOn {
@@ -421,7 +567,7 @@ func (db *LDBDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint
 				if cmp >= 0 {
 					copy(keyBuffer, hK[:l])
 					copy(keyBuffer[l:], EndSuffix)
-					hK, hV = hC.SeekTo(keyBuffer)
+					hK, _ = hC.SeekTo(keyBuffer)
 				}
 			}
 		}
@@ -430,7 +576,7 @@ func (db *LDBDatabase) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint
 	return err
 }
 
-func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
+func (r *boltReader) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
 	if len(startkeys) == 0 {
 		return nil
 	}
@@ -441,24 +587,22 @@ func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte,
 	l := len(startkey)
 	sl := l + len(suffix)
 	keyBuffer := make([]byte, l+len(EndSuffix))
-	if err := db.db.View(func(tx *bolt.Tx) error {
-		pre := tx.Bucket([]byte("secure-key-"))
-		if pre == nil {
-			return nil
+	if err := r.view(func(tx *bolt.Tx) error {
+		if err := checkHorizon(boltHistoryHorizon(tx), timestamp); err != nil {
+			return err
 		}
-		b := tx.Bucket(bucket)
-		if b == nil {
-			return nil
+		var c, hC, hC1 *bolt.Cursor
+		var k, v, hK []byte
+		getChangeSet := boltChangeSets(tx)
+		if b := tx.Bucket(bucket); b != nil {
+			c = b.Cursor()
+			k, v = c.Seek(startkey)
 		}
-		hB := tx.Bucket(hBucket)
-		if hB == nil {
-			return nil
+		if hB := tx.Bucket(hBucket); hB != nil {
+			hC = hB.Cursor()
+			hC1 = hB.Cursor()
+			hK, _ = hC.Seek(startkey)
 		}
-		c := b.Cursor()
-		hC := hB.Cursor()
-		hC1 := hB.Cursor()
-		k, v := c.Seek(startkey)
-		hK, hV := hC.Seek(startkey)
 		goOn := true
 		var err error
 		for goOn { // k != nil
@@ -498,7 +642,7 @@ func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte,
 							}
 						}
 						if hCmp < 0 {
-							hK, hV = hC.SeekTo(startkey)
+							hK, _ = hC.SeekTo(startkey)
 							if hK == nil {
 								hCmp = 1
 							}
@@ -522,7 +666,7 @@ func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte,
 			if hKFit && bytes.Compare(hK[l:], suffix) < 0 {
 				copy(keyBuffer, hK[:l])
 				copy(keyBuffer[l:], suffix)
-				hK, hV = hC.SeekTo(keyBuffer[:sl])
+				hK, _ = hC.SeekTo(keyBuffer[:sl])
 				continue
 			}
 			var cmp int
@@ -539,25 +683,15 @@ func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte,
 				cmp = bytes.Compare(k, hK[:l])
 			}
 			if cmp < 0 {
-				if l == 32 {
-					//addr := pre.Get(k)
-					//fmt.Printf("c %x (%x): %x\n", k, addr, v)
-				} else {
-					//fmt.Printf("c %x: %x\n", k, v)
-				}
-				hK1, _ := hC1.Seek(k)
-				if bytes.HasPrefix(hK1, k) {
-					goOn, err = walker(keyIdx, k, v)
+				if hC1 != nil {
+					if hK1, _ := hC1.Seek(k); bytes.HasPrefix(hK1, k) {
+						goOn, err = walker(keyIdx, k, v)
+					}
 				}
 			} else {
-				if hKFit && bytes.Equal(hK[l:], suffix) && timestamp == 3436035 {
-					if l == 32 {
-						addr, _ := pre.Get(hK[:l])
-						fmt.Printf("h %x (%x): %x\n", hK[:l], addr, hV)
-					} else {
-						item, _ := pre.Get(hK[20:52])
-						fmt.Printf("h %x (%x): %x\n", hK[:l], item, hV)
-					}
+				var hV []byte
+				if hV, err = historyValue(hBucket, hK, l, getChangeSet); err != nil {
+					return err
 				}
 				goOn, err = walker(keyIdx, hK[:l], hV)
 			}
@@ -568,7 +702,7 @@ func (db *LDBDatabase) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte,
 				if cmp >= 0 {
 					copy(keyBuffer, hK[:l])
 					copy(keyBuffer[l:], EndSuffix)
-					hK, hV = hC.SeekTo(keyBuffer)
+					hK, _ = hC.SeekTo(keyBuffer)
 				}
 			}
 		}
@@ -603,37 +737,47 @@ func (db *LDBDatabase) Delete(bucket, key []byte) error {
 	return err
 }
 
-// Deletes all keys with specified suffix from all the buckets
+// DeleteTimestamp removes the change sets of the given block together with
+// the corresponding entries of the history buckets and their indices
 func (db *LDBDatabase) DeleteTimestamp(timestamp uint64) error {
 	suffix := encodeTimestamp(timestamp)
 	err := db.db.Update(func(tx *bolt.Tx) error {
-		sb := tx.Bucket(SuffixBucket)
-		if sb == nil {
+		csb := tx.Bucket(ChangeSetBucket)
+		if csb == nil {
 			return nil
 		}
 		var keys [][]byte
-		c := sb.Cursor()
+		c := csb.Cursor()
 		for k, v := c.Seek(suffix); k != nil && bytes.HasPrefix(k, suffix); k, v = c.Next() {
-			hb := tx.Bucket(k[len(suffix):])
-			if hb == nil {
-				return nil
-			}
-			keycount := int(binary.BigEndian.Uint32(v))
-			for i, ki := 4, 0; ki < keycount; ki++ {
-				l := int(v[i])
-				i++
-				kk := make([]byte, l+len(suffix))
-				copy(kk, v[i:i+l])
-				copy(kk[l:], suffix)
-				if err := hb.Delete(kk); err != nil {
-					return err
+			hBucket := k[len(suffix):]
+			hb := tx.Bucket(hBucket)
+			ib := tx.Bucket(indexBucket(hBucket))
+			if err := walkChangeSet(v, func(key, _ []byte) error {
+				if hb != nil {
+					composite, _ := compositeKeySuffix(key, timestamp)
+					if err := hb.Delete(composite); err != nil {
+						return err
+					}
 				}
-				i += l
+				if ib != nil {
+					ik := indexKey(key, timestamp)
+					container, _ := ib.Get(ik)
+					if container == nil {
+						return nil
+					}
+					if container = indexRemove(container, timestamp); container == nil {
+						return ib.Delete(ik)
+					}
+					return ib.Put(ik, container)
+				}
+				return nil
+			}); err != nil {
+				return err
 			}
-			keys = append(keys, k)
+			keys = append(keys, common.CopyBytes(k))
 		}
 		for _, k := range keys {
-			if err := sb.Delete(k); err != nil {
+			if err := csb.Delete(k); err != nil {
 				return err
 			}
 		}
@@ -682,19 +826,23 @@ func (a *PutItem) Less(b llrb.Item) bool {
 }
 
 type mutation struct {
-	puts       map[string]*llrb.LLRB // Map buckets to RB tree containing items
-	suffixkeys map[uint64]map[string][][]byte
+	puts       map[string]*llrb.LLRB            // Map buckets to RB tree containing items
+	changeSets map[uint64]map[string]*ChangeSet // Map timestamps and history buckets to the change sets not yet committed
 	mu         sync.RWMutex
 	db         Database
+	spill      *spiller // Spilling state, nil unless created by NewSpillingBatch
 }
 
-func (db *LDBDatabase) NewBatch() Mutation {
-	m := &mutation{
+func newMutation(db Database) *mutation {
+	return &mutation{
 		db:         db,
 		puts:       make(map[string]*llrb.LLRB),
-		suffixkeys: make(map[uint64]map[string][][]byte),
+		changeSets: make(map[uint64]map[string]*ChangeSet),
 	}
-	return m
+}
+
+func (db *LDBDatabase) NewBatch() Mutation {
+	return newMutation(db)
 }
 
 func (m *mutation) getMem(bucket, key []byte) ([]byte, bool) {
@@ -721,24 +869,60 @@ func (m *mutation) getMem(bucket, key []byte) ([]byte, bool) {
 
 // Can only be called from the worker thread
 func (m *mutation) Get(bucket, key []byte) ([]byte, error) {
+	return m.get(m.db, bucket, key)
+}
+
+// get reads the key from the mutation, falling back to the given database.
+func (m *mutation) get(db Getter, bucket, key []byte) ([]byte, error) {
 	if value, ok := m.getMem(bucket, key); ok {
 		if value == nil {
 			return nil, ErrKeyNotFound
 		}
 		return value, nil
 	}
-	if m.db != nil {
-		return m.db.Get(bucket, key)
+	if value, ok, err := m.getSpilled(bucket, key); ok || err != nil {
+		return value, err
+	}
+	if db != nil {
+		return db.Get(bucket, key)
 	}
 	return nil, ErrKeyNotFound
 }
 
+// getSpilled looks the key up in the spilled runs, if there are any.
+func (m *mutation) getSpilled(bucket, key []byte) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getSpilledNoLock(bucket, key)
+}
+
 func (m *mutation) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	composite, _ := compositeKeySuffix(key, timestamp)
-	return m.Get(hBucket, composite)
+	return m.getS(m.db, hBucket, key, timestamp)
+}
+
+func (m *mutation) getS(db Getter, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if changeSet, ok := m.changeSets[timestamp][string(hBucket)]; ok {
+		if v, ok := changeSet.Find(key); ok {
+			return common.CopyBytes(v), nil
+		}
+	}
+	dat, err := m.getNoLock(db, ChangeSetBucket, changeSetKey(timestamp, hBucket))
+	if err != nil {
+		return nil, err
+	}
+	v, ok, err := findInChangeSet(dat, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
 }
 
-func (m *mutation) getNoLock(bucket, key []byte) ([]byte, error) {
+func (m *mutation) getNoLock(db Getter, bucket, key []byte) ([]byte, error) {
 	if t, ok := m.puts[string(bucket)]; ok {
 		i := t.Get(&PutItem{key: key})
 		if i != nil {
@@ -750,27 +934,44 @@ func (m *mutation) getNoLock(bucket, key []byte) ([]byte, error) {
 			}
 		}
 	}
-	if m.db != nil {
-		return m.db.Get(bucket, key)
+	if value, ok, err := m.getSpilledNoLock(bucket, key); ok || err != nil {
+		return value, err
+	}
+	if db != nil {
+		return db.Get(bucket, key)
 	}
 	return nil, ErrKeyNotFound
 }
 
-func (m *mutation) hasMem(bucket, key []byte) bool {
+// hasMem tells whether the key is in the mutation, and whether it is put
+// rather than deleted there.
+func (m *mutation) hasMem(bucket, key []byte) (has, found bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	if t, ok := m.puts[string(bucket)]; ok {
-		return t.Has(&PutItem{key: key})
+		if i := t.Get(&PutItem{key: key}); i != nil {
+			return i.(*PutItem).value != nil, true
+		}
 	}
-	return false
+	return false, false
 }
 
 func (m *mutation) Has(bucket, key []byte) (bool, error) {
-	if m.hasMem(bucket, key) {
-		return true, nil
+	return m.has(m.db, bucket, key)
+}
+
+func (m *mutation) has(db Getter, bucket, key []byte) (bool, error) {
+	if has, found := m.hasMem(bucket, key); found {
+		return has, nil
 	}
-	if m.db != nil {
-		return m.db.Has(bucket, key)
+	if _, ok, err := m.getSpilled(bucket, key); ok || err != nil {
+		if err == ErrKeyNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if db != nil {
+		return db.Has(bucket, key)
 	}
 	return false, nil
 }
@@ -798,39 +999,40 @@ func (m *mutation) Put(bucket, key []byte, value []byte) error {
 		m.puts[string(bb)] = t
 	}
 	t.ReplaceOrInsert(&PutItem{key: k, value: v})
-	return nil
+	return m.grewNoLock(len(k) + len(v))
 }
 
-// Assumes that bucket, key, and value won't be modified
+// PutS adds the change to the change set of the given timestamp, which
+// gets written out on Commit, and puts the index entry into the history bucket
 func (m *mutation) PutS(hBucket, key, value []byte, timestamp uint64) error {
-	//fmt.Printf("PutS bucket %x key %x value %x timestamp %d\n", bucket, key, value, timestamp)
 	composite, _ := compositeKeySuffix(key, timestamp)
-	suffix_m, ok := m.suffixkeys[timestamp]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changeSets, ok := m.changeSets[timestamp]
 	if !ok {
-		suffix_m = make(map[string][][]byte)
-		m.suffixkeys[timestamp] = suffix_m
+		changeSets = make(map[string]*ChangeSet)
+		m.changeSets[timestamp] = changeSets
 	}
-	suffix_l, ok := suffix_m[string(hBucket)]
+	changeSet, ok := changeSets[string(hBucket)]
 	if !ok {
-		suffix_l = [][]byte{}
+		changeSet = &ChangeSet{}
+		changeSets[string(hBucket)] = changeSet
 	}
-	suffix_l = append(suffix_l, key)
-	suffix_m[string(hBucket)] = suffix_l
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	changeSet.Add(key, value)
 	var ht *llrb.LLRB
 	if ht, ok = m.puts[string(hBucket)]; !ok {
 		ht = llrb.New()
 		m.puts[string(hBucket)] = ht
 	}
-	ht.ReplaceOrInsert(&PutItem{key: composite, value: value})
-	return nil
+	ht.ReplaceOrInsert(&PutItem{key: composite, value: []byte{}})
+	return m.grewNoLock(len(key) + len(value) + len(composite))
 }
 
 func (m *mutation) MultiPut(tuples ...[]byte) (uint64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	l := len(tuples)
+	size := 0
 	for i := 0; i < l; i += 3 {
 		var t *llrb.LLRB
 		var ok bool
@@ -839,8 +1041,9 @@ func (m *mutation) MultiPut(tuples ...[]byte) (uint64, error) {
 			m.puts[string(tuples[i])] = t
 		}
 		t.ReplaceOrInsert(&PutItem{key: tuples[i+1], value: tuples[i+2]})
+		size += len(tuples[i+1]) + len(tuples[i+2])
 	}
-	return 0, nil
+	return 0, m.grewNoLock(size)
 }
 
 func (m *mutation) BatchSize() int {
@@ -850,42 +1053,26 @@ func (m *mutation) BatchSize() int {
 	for _, t := range m.puts {
 		size += t.Len()
 	}
+	if m.spill != nil {
+		size += m.spill.spilled
+	}
 	return size
 }
 
-func (m *mutation) getAsOfMem(hBucket, key []byte, timestamp uint64) ([]byte, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	var t *llrb.LLRB
-	var ok bool
-	if t, ok = m.puts[string(hBucket)]; !ok {
-		return nil, false
-	}
-	composite, _ := compositeKeySuffix(key, timestamp)
-	var dat []byte
-	t.AscendGreaterOrEqual(&PutItem{key: composite}, func(i llrb.Item) bool {
-		item := i.(*PutItem)
-		if !bytes.HasPrefix(item.key, key) {
-			return false
-		}
-		if item.value == nil {
-			return true
-		}
-		dat = make([]byte, len(item.value))
-		copy(dat, item.value)
-		return false
-	})
-	if dat != nil {
-		return dat, true
-	}
-	return nil, false
+func (m *mutation) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	return m.getAsOf(m.db, bucket, hBucket, key, timestamp)
 }
 
-func (m *mutation) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
-	if m.db == nil {
+func (m *mutation) getAsOf(db Getter, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	if m.spill != nil {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.getAsOfMergedNoLock(db, bucket, hBucket, key, timestamp)
+	}
+	if db == nil {
 		panic("Not implemented")
 	} else {
-		return m.db.GetAsOf(bucket, hBucket, key, timestamp)
+		return db.GetAsOf(bucket, hBucket, key, timestamp)
 	}
 }
 
@@ -925,10 +1112,19 @@ func (m *mutation) walkMem(bucket, startkey []byte, fixedbits uint, walker func(
 }
 
 func (m *mutation) Walk(bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
-	if m.db == nil {
+	return m.walk(m.db, bucket, startkey, fixedbits, walker)
+}
+
+func (m *mutation) walk(db Getter, bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
+	if m.spill != nil {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.walkMergedNoLock(db, bucket, startkey, fixedbits, walker)
+	}
+	if db == nil {
 		return m.walkMem(bucket, startkey, fixedbits, walker)
 	} else {
-		return m.db.Walk(bucket, startkey, fixedbits, walker)
+		return db.Walk(bucket, startkey, fixedbits, walker)
 	}
 }
 
@@ -937,26 +1133,38 @@ func (m *mutation) multiWalkMem(bucket []byte, startkeys [][]byte, fixedbits []u
 }
 
 func (m *mutation) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
-	if m.db == nil {
+	return m.multiWalk(m.db, bucket, startkeys, fixedbits, walker)
+}
+
+func (m *mutation) multiWalk(db Getter, bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
+	if db == nil {
 		return m.multiWalkMem(bucket, startkeys, fixedbits, walker)
 	} else {
-		return m.db.MultiWalk(bucket, startkeys, fixedbits, walker)
+		return db.MultiWalk(bucket, startkeys, fixedbits, walker)
 	}
 }
 
 func (m *mutation) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
-	if m.db == nil {
+	return m.walkAsOf(m.db, bucket, hBucket, startkey, fixedbits, timestamp, walker)
+}
+
+func (m *mutation) walkAsOf(db Getter, bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+	if db == nil {
 		panic("Not implemented")
 	} else {
-		return m.db.WalkAsOf(bucket, hBucket, startkey, fixedbits, timestamp, walker)
+		return db.WalkAsOf(bucket, hBucket, startkey, fixedbits, timestamp, walker)
 	}
 }
 
 func (m *mutation) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
-	if m.db == nil {
+	return m.multiWalkAsOf(m.db, bucket, hBucket, startkeys, fixedbits, timestamp, walker)
+}
+
+func (m *mutation) multiWalkAsOf(db Getter, bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
+	if db == nil {
 		panic("Not implemented")
 	} else {
-		return m.db.MultiWalkAsOf(bucket, hBucket, startkeys, fixedbits, timestamp, walker)
+		return db.MultiWalkAsOf(bucket, hBucket, startkeys, fixedbits, timestamp, walker)
 	}
 }
 
@@ -978,96 +1186,136 @@ func (m *mutation) Delete(bucket, key []byte) error {
 	k := make([]byte, len(key))
 	copy(k, key)
 	t.ReplaceOrInsert(&PutItem{key: k, value: nil})
+	return m.grewNoLock(len(k))
+}
+
+// updateIndexNoLock replaces the container of the history index holding the
+// timestamp with the result of the update, nil result deletes the container
+func (m *mutation) updateIndexNoLock(hBucket, key []byte, timestamp uint64, update func(container []byte, timestamp uint64) []byte) error {
+	ib := indexBucket(hBucket)
+	ik := indexKey(key, timestamp)
+	container, err := m.getNoLock(m.db, ib, ik)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	var t *llrb.LLRB
+	var ok bool
+	if t, ok = m.puts[string(ib)]; !ok {
+		t = llrb.New()
+		m.puts[string(ib)] = t
+	}
+	t.ReplaceOrInsert(&PutItem{key: ik, value: update(container, timestamp)})
 	return nil
 }
 
-// Deletes all keys with specified suffix from all the buckets
+// DeleteTimestamp removes the change sets of the given block, both the ones
+// not yet committed and the ones in the database, together with the
+// corresponding entries of the history buckets and their indices
 func (m *mutation) DeleteTimestamp(timestamp uint64) error {
 	suffix := encodeTimestamp(timestamp)
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	deleteHistory := func(hBucket []byte, key []byte) {
+		hBucketStr := string(hBucket)
+		ht, ok := m.puts[hBucketStr]
+		if !ok {
+			ht = llrb.New()
+			m.puts[hBucketStr] = ht
+		}
+		composite, _ := compositeKeySuffix(key, timestamp)
+		ht.ReplaceOrInsert(&PutItem{key: composite, value: nil})
+	}
+	// Change sets that are not committed yet are not in the index either
+	for hBucketStr, changeSet := range m.changeSets[timestamp] {
+		for _, c := range changeSet.Changes {
+			deleteHistory([]byte(hBucketStr), c.Key)
+		}
+	}
+	delete(m.changeSets, timestamp)
+	if m.db == nil {
+		return nil
+	}
+	walk := m.db.Walk
+	if m.spill != nil {
+		walk = func(bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
+			return m.walkMergedNoLock(m.db, bucket, startkey, fixedbits, walker)
+		}
+	}
+	var csKeys [][]byte
+	var changeSets [][]byte
+	if err := walk(ChangeSetBucket, suffix, uint(8*len(suffix)), func(k, v []byte) (bool, error) {
+		csKeys = append(csKeys, common.CopyBytes(k))
+		changeSets = append(changeSets, common.CopyBytes(v))
+		return true, nil
+	}); err != nil {
+		return err
+	}
 	var t *llrb.LLRB
 	var ok bool
-	if t, ok = m.puts[string(SuffixBucket)]; !ok {
+	if t, ok = m.puts[string(ChangeSetBucket)]; !ok {
 		t = llrb.New()
-		m.puts[string(SuffixBucket)] = t
+		m.puts[string(ChangeSetBucket)] = t
 	}
-	err := m.Walk(SuffixBucket, suffix, uint(8*len(suffix)), func(k, v []byte) (bool, error) {
-		hBucket := k[len(suffix):]
-		keycount := int(binary.BigEndian.Uint32(v))
-		var ht *llrb.LLRB
-		var ok bool
-		if keycount > 0 {
-			hBucketStr := string(common.CopyBytes(hBucket))
-			if ht, ok = m.puts[hBucketStr]; !ok {
-				ht = llrb.New()
-				m.puts[hBucketStr] = ht
-			}
-		}
-		for i, ki := 4, 0; ki < keycount; ki++ {
-			l := int(v[i])
-			i++
-			kk := make([]byte, l+len(suffix))
-			copy(kk, v[i:i+l])
-			copy(kk[l:], suffix)
-			ht.ReplaceOrInsert(&PutItem{key: kk, value: nil})
-			i += l
+	for i, csKey := range csKeys {
+		hBucket := csKey[len(suffix):]
+		if err := walkChangeSet(changeSets[i], func(key, _ []byte) error {
+			deleteHistory(hBucket, key)
+			return m.updateIndexNoLock(hBucket, key, timestamp, indexRemove)
+		}); err != nil {
+			return err
 		}
-		t.ReplaceOrInsert(&PutItem{key: common.CopyBytes(k), value: nil})
-		return true, nil
-	})
-	return err
+		t.ReplaceOrInsert(&PutItem{key: csKey, value: nil})
+	}
+	return nil
 }
 
-func (m *mutation) Commit() (uint64, error) {
-	if m.db == nil {
-		return 0, nil
-	}
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// flushChangeSetsNoLock merges the change sets not yet committed into the
+// change set bucket and adds them to the history index
+func (m *mutation) flushChangeSetsNoLock() error {
 	var t *llrb.LLRB
 	var ok bool
-	if len(m.suffixkeys) > 0 {
-		if t, ok = m.puts[string(SuffixBucket)]; !ok {
+	if len(m.changeSets) > 0 {
+		if t, ok = m.puts[string(ChangeSetBucket)]; !ok {
 			t = llrb.New()
-			m.puts[string(SuffixBucket)] = t
+			m.puts[string(ChangeSetBucket)] = t
 		}
 	}
-	for timestamp, suffix_m := range m.suffixkeys {
-		suffix := encodeTimestamp(timestamp)
-		for bucketStr, suffix_l := range suffix_m {
-			hBucket := []byte(bucketStr)
-			suffixkey := make([]byte, len(suffix)+len(hBucket))
-			copy(suffixkey, suffix)
-			copy(suffixkey[len(suffix):], hBucket)
-			dat, err := m.getNoLock(SuffixBucket, suffixkey)
+	for timestamp, changeSets := range m.changeSets {
+		for hBucketStr, changeSet := range changeSets {
+			csKey := changeSetKey(timestamp, []byte(hBucketStr))
+			dat, err := m.getNoLock(m.db, ChangeSetBucket, csKey)
 			if err != nil && err != ErrKeyNotFound {
-				return 0, err
-			}
-			var l int
-			if dat == nil {
-				l = 4
-			} else {
-				l = len(dat)
+				return err
 			}
-			newlen := len(suffix_l)
-			for _, key := range suffix_l {
-				newlen += len(key)
+			merged, err := DecodeChangeSet(dat)
+			if err != nil {
+				return err
 			}
-			dv := make([]byte, l+newlen)
-			copy(dv, dat)
-			binary.BigEndian.PutUint32(dv, uint32(len(suffix_l))+binary.BigEndian.Uint32(dv))
-			i := l
-			for _, key := range suffix_l {
-				dv[i] = byte(len(key))
-				i++
-				copy(dv[i:], key)
-				i += len(key)
+			for _, c := range changeSet.Changes {
+				merged.Add(c.Key, c.Value)
+				if err := m.updateIndexNoLock([]byte(hBucketStr), c.Key, timestamp, indexAdd); err != nil {
+					return err
+				}
 			}
-			t.ReplaceOrInsert(&PutItem{key: suffixkey, value: dv})
+			t.ReplaceOrInsert(&PutItem{key: csKey, value: merged.Encode()})
 		}
 	}
-	m.suffixkeys = make(map[uint64]map[string][][]byte)
+	m.changeSets = make(map[uint64]map[string]*ChangeSet)
+	return nil
+}
+
+func (m *mutation) Commit() (uint64, error) {
+	if m.db == nil {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.flushChangeSetsNoLock(); err != nil {
+		return 0, err
+	}
+	if m.spill != nil && len(m.spill.runs) > 0 {
+		return m.commitSpilledNoLock()
+	}
 	size := 0
 	for _, t := range m.puts {
 		size += t.Len()
@@ -1092,19 +1340,26 @@ func (m *mutation) Commit() (uint64, error) {
 		return 0, putErr
 	}
 	m.puts = make(map[string]*llrb.LLRB)
+	if m.spill != nil {
+		m.spill.size = 0
+	}
 	return written, nil
 }
 
 func (m *mutation) Rollback() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.suffixkeys = make(map[uint64]map[string][][]byte)
+	m.changeSets = make(map[uint64]map[string]*ChangeSet)
 	m.puts = make(map[string]*llrb.LLRB)
+	m.removeRunsNoLock()
 }
 
 func (m *mutation) Keys() [][]byte {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	if m.spill != nil && len(m.spill.runs) > 0 {
+		return m.spilledKeysNoLock()
+	}
 	size := 0
 	for _, t := range m.puts {
 		size += t.Len()
@@ -1129,10 +1384,75 @@ func (m *mutation) Close() {
 }
 
 func (m *mutation) NewBatch() Mutation {
-	mm := &mutation{
-		db:         m,
-		puts:       make(map[string]*llrb.LLRB),
-		suffixkeys: make(map[uint64]map[string][][]byte),
+	return newMutation(m)
+}
+
+// mutationSnapshot layers the changes buffered in the mutation over the
+// snapshot of the database underneath. Only the database part is frozen:
+// the changes put into the mutation later are visible through the snapshot.
+type mutationSnapshot struct {
+	m    *mutation
+	snap Snapshot // Snapshot of the underlying database, nil if there is none
+}
+
+// View runs the function with the snapshot of the mutation.
+func (m *mutation) View(f func(tx Getter) error) error {
+	s, err := m.Begin()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return f(s)
+}
+
+// Begin takes the snapshot of the underlying database and layers the
+// mutation over it.
+func (m *mutation) Begin() (Snapshot, error) {
+	s := &mutationSnapshot{m: m}
+	if m.db != nil {
+		snap, err := m.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		s.snap = snap
+	}
+	return s, nil
+}
+
+func (s *mutationSnapshot) Get(bucket, key []byte) ([]byte, error) {
+	return s.m.get(s.snap, bucket, key)
+}
+
+func (s *mutationSnapshot) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	return s.m.getS(s.snap, hBucket, key, timestamp)
+}
+
+func (s *mutationSnapshot) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	return s.m.getAsOf(s.snap, bucket, hBucket, key, timestamp)
+}
+
+func (s *mutationSnapshot) Has(bucket, key []byte) (bool, error) {
+	return s.m.has(s.snap, bucket, key)
+}
+
+func (s *mutationSnapshot) Walk(bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
+	return s.m.walk(s.snap, bucket, startkey, fixedbits, walker)
+}
+
+func (s *mutationSnapshot) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
+	return s.m.multiWalk(s.snap, bucket, startkeys, fixedbits, walker)
+}
+
+func (s *mutationSnapshot) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+	return s.m.walkAsOf(s.snap, bucket, hBucket, startkey, fixedbits, timestamp, walker)
+}
+
+func (s *mutationSnapshot) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
+	return s.m.multiWalkAsOf(s.snap, bucket, hBucket, startkeys, fixedbits, timestamp, walker)
+}
+
+func (s *mutationSnapshot) Close() {
+	if s.snap != nil {
+		s.snap.Close()
 	}
-	return mm
 }
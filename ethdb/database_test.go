@@ -16,7 +16,7 @@
 
 // +build !js
 
-package ethdb_test
+package ethdb
 
 import (
 	"bytes"
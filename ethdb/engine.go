@@ -0,0 +1,72 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultEngine is the storage engine used when none is specified explicitly.
+const DefaultEngine = "bolt"
+
+// Opener opens (or creates) a database of a particular engine at the given path.
+type Opener func(path string) (Database, error)
+
+var (
+	enginesLock sync.RWMutex
+	engines     = make(map[string]Opener)
+)
+
+// RegisterEngine makes a storage engine available under the given name.
+// It panics if the name is registered twice.
+func RegisterEngine(name string, opener Opener) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+	if _, dup := engines[name]; dup {
+		panic("ethdb: engine registered twice: " + name)
+	}
+	engines[name] = opener
+}
+
+// Engines returns the sorted names of all the registered storage engines.
+func Engines() []string {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the database at the given path with the named storage engine.
+// An empty engine name selects DefaultEngine.
+func Open(engine string, path string) (Database, error) {
+	if engine == "" {
+		engine = DefaultEngine
+	}
+	enginesLock.RLock()
+	opener, ok := engines[engine]
+	enginesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown database engine %q, available: %v", engine, Engines())
+	}
+	return opener(path)
+}
@@ -0,0 +1,188 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// The history index maps every key of a history bucket to the numbers of the
+// blocks that modified it, so that GetAsOf finds the next change after the given
+// block with one seek, instead of walking the history of the key.
+// Like in roaring bitmaps, the block numbers are split by their high bits into
+// containers of 65536 blocks, stored under key || container number (uint32).
+// Sparse containers are sorted arrays of the low 16 bits (uint16 each), dense
+// containers are plain bitmaps of 8192 bytes.
+
+const (
+	indexContainerBits = 16
+	indexContainerSize = 1 << indexContainerBits
+	indexBitmapLen     = indexContainerSize / 8
+	indexMaxArrayLen   = indexBitmapLen/2 - 1 // Arrays are always shorter than bitmaps
+)
+
+// indexBucket returns the name of the bucket holding the index of the history bucket.
+func indexBucket(hBucket []byte) []byte {
+	ib := make([]byte, len(hBucket)+1)
+	ib[0] = byte('i')
+	copy(ib[1:], hBucket)
+	return ib
+}
+
+// indexKey returns the key of the index container holding the timestamp.
+func indexKey(key []byte, timestamp uint64) []byte {
+	k := make([]byte, len(key)+4)
+	copy(k, key)
+	binary.BigEndian.PutUint32(k[len(key):], uint32(timestamp>>indexContainerBits))
+	return k
+}
+
+func bitmapHas(container []byte, lo int) bool {
+	return container[lo>>3]&(1<<uint(lo&7)) != 0
+}
+
+func searchArray(container []byte, lo uint16) int {
+	return sort.Search(len(container)/2, func(i int) bool {
+		return binary.BigEndian.Uint16(container[2*i:]) >= lo
+	})
+}
+
+// indexAdd returns a copy of the container with the timestamp added.
+func indexAdd(container []byte, timestamp uint64) []byte {
+	lo := uint16(timestamp)
+	if len(container) == indexBitmapLen {
+		c := make([]byte, indexBitmapLen)
+		copy(c, container)
+		c[lo>>3] |= 1 << (lo & 7)
+		return c
+	}
+	n := len(container) / 2
+	i := searchArray(container, lo)
+	if i < n && binary.BigEndian.Uint16(container[2*i:]) == lo {
+		c := make([]byte, len(container))
+		copy(c, container)
+		return c
+	}
+	if n == indexMaxArrayLen {
+		// Array is full, convert it into the bitmap
+		c := make([]byte, indexBitmapLen)
+		for j := 0; j < n; j++ {
+			v := binary.BigEndian.Uint16(container[2*j:])
+			c[v>>3] |= 1 << (v & 7)
+		}
+		c[lo>>3] |= 1 << (lo & 7)
+		return c
+	}
+	c := make([]byte, len(container)+2)
+	copy(c, container[:2*i])
+	binary.BigEndian.PutUint16(c[2*i:], lo)
+	copy(c[2*i+2:], container[2*i:])
+	return c
+}
+
+// indexRemove returns a copy of the container with the timestamp removed,
+// or nil if the container becomes empty.
+func indexRemove(container []byte, timestamp uint64) []byte {
+	lo := uint16(timestamp)
+	if len(container) == indexBitmapLen {
+		c := make([]byte, indexBitmapLen)
+		copy(c, container)
+		c[lo>>3] &^= 1 << (lo & 7)
+		var cardinality int
+		for _, b := range c {
+			cardinality += bits.OnesCount8(b)
+		}
+		if cardinality > indexMaxArrayLen {
+			return c
+		}
+		// Sparse enough to be stored as an array again
+		if cardinality == 0 {
+			return nil
+		}
+		arr := make([]byte, 0, 2*cardinality)
+		for v := 0; v < indexContainerSize; v++ {
+			if bitmapHas(c, v) {
+				arr = append(arr, byte(v>>8), byte(v))
+			}
+		}
+		return arr
+	}
+	n := len(container) / 2
+	i := searchArray(container, lo)
+	if i == n || binary.BigEndian.Uint16(container[2*i:]) != lo {
+		c := make([]byte, len(container))
+		copy(c, container)
+		return c
+	}
+	if n == 1 {
+		return nil
+	}
+	c := make([]byte, len(container)-2)
+	copy(c, container[:2*i])
+	copy(c[2*i:], container[2*i+2:])
+	return c
+}
+
+// indexNext returns the first timestamp, not less than the given one, from the
+// container stored under the index key k.
+func indexNext(k, container []byte, timestamp uint64) (uint64, bool) {
+	hi := uint64(binary.BigEndian.Uint32(k[len(k)-4:]))
+	var from int
+	switch {
+	case hi < timestamp>>indexContainerBits:
+		return 0, false
+	case hi == timestamp>>indexContainerBits:
+		from = int(timestamp & (indexContainerSize - 1))
+	}
+	if len(container) == indexBitmapLen {
+		for v := from; v < indexContainerSize; v++ {
+			if v&7 == 0 && container[v>>3] == 0 {
+				v += 7
+				continue
+			}
+			if bitmapHas(container, v) {
+				return hi<<indexContainerBits | uint64(v), true
+			}
+		}
+		return 0, false
+	}
+	i := searchArray(container, uint16(from))
+	if i == len(container)/2 {
+		return 0, false
+	}
+	return hi<<indexContainerBits | uint64(binary.BigEndian.Uint16(container[2*i:])), true
+}
+
+// indexCursor is the part of the bolt cursor used to read the history index.
+type indexCursor interface {
+	Seek(seek []byte) ([]byte, []byte)
+	Next() ([]byte, []byte)
+}
+
+// nextChange uses the history index to find the first block, not earlier than
+// the given timestamp, which modified the key.
+func nextChange(c indexCursor, key []byte, timestamp uint64) (uint64, bool) {
+	for k, v := c.Seek(indexKey(key, timestamp)); k != nil && len(k) == len(key)+4 && bytes.HasPrefix(k, key); k, v = c.Next() {
+		if changeTimestamp, ok := indexNext(k, v, timestamp); ok {
+			return changeTimestamp, true
+		}
+	}
+	return 0, false
+}
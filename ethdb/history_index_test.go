@@ -0,0 +1,121 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIndexContainer(t *testing.T) {
+	key := []byte("key")
+	hi := uint64(3) << indexContainerBits
+	ik := indexKey(key, hi)
+	var container []byte
+	// Every third block, enough to turn the array into the bitmap
+	for lo := uint64(0); lo < 3*(indexMaxArrayLen+10); lo += 3 {
+		container = indexAdd(container, hi|lo)
+	}
+	if len(container) != indexBitmapLen {
+		t.Fatalf("expected bitmap container, got %d bytes", len(container))
+	}
+	for _, ts := range []uint64{0, hi, hi + 1, hi + 3, hi + 3*(indexMaxArrayLen+9) - 1} {
+		exp := hi
+		if ts > hi {
+			exp = hi + (ts-hi+2)/3*3
+		}
+		if next, ok := indexNext(ik, container, ts); !ok || next != exp {
+			t.Errorf("next after %d: expected %d, got %d, %t", ts, exp, next, ok)
+		}
+	}
+	if _, ok := indexNext(ik, container, hi+3*(indexMaxArrayLen+9)+1); ok {
+		t.Errorf("found change after the last one")
+	}
+	if _, ok := indexNext(ik, container, hi+indexContainerSize); ok {
+		t.Errorf("container should not be used for later timestamps")
+	}
+	// Removing back to the array
+	for lo := uint64(0); lo < 3*11; lo += 3 {
+		container = indexRemove(container, hi|lo)
+	}
+	if len(container) != 2*(indexMaxArrayLen-1) {
+		t.Fatalf("expected array container of %d values, got %d bytes", indexMaxArrayLen-1, len(container))
+	}
+	if next, ok := indexNext(ik, container, hi); !ok || next != hi+33 {
+		t.Errorf("next after removal: expected %d, got %d, %t", hi+33, next, ok)
+	}
+	for lo := uint64(33); lo < 3*(indexMaxArrayLen+10); lo += 3 {
+		container = indexRemove(container, hi|lo)
+	}
+	if container != nil {
+		t.Errorf("expected empty container to be nil, got %d bytes", len(container))
+	}
+}
+
+func TestConformanceHistoryIndex(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		hot, cold := conformanceKey(1), conformanceKey(2)
+		// The hot key changes in every block, spanning several index containers,
+		// the cold one only once, far in the future
+		last := uint64(2*indexContainerSize + 100)
+		batch := db.NewBatch()
+		for b := uint64(1); b <= last; b += 7 {
+			if err := batch.PutS(conformanceHBucket, hot, []byte{byte(b >> 16), byte(b >> 8), byte(b)}, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := batch.PutS(conformanceHBucket, cold, []byte("cold"), last); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(conformanceBucket, hot, []byte("current")); err != nil {
+			t.Fatal(err)
+		}
+		for _, ts := range []uint64{0, 1, 2, 8, indexContainerSize - 1, indexContainerSize, indexContainerSize + 1, last - 6, last + 1} {
+			exp := []byte("current")
+			if b := (ts+5)/7*7 + 1; ts <= 1 {
+				exp = []byte{0, 0, 1}
+			} else if b <= last {
+				exp = []byte{byte(b >> 16), byte(b >> 8), byte(b)}
+			}
+			if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, hot, ts); err != nil || !bytes.Equal(v, exp) {
+				t.Errorf("hot key as of %d: expected %x, got %x, %v", ts, exp, v, err)
+			}
+		}
+		if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, cold, 5); err != nil || !bytes.Equal(v, []byte("cold")) {
+			t.Errorf("cold key: expected the only change, got %x, %v", v, err)
+		}
+		// After the deletion of the change, the next one has to be found
+		if err := db.DeleteTimestamp(indexContainerSize + 6); err != nil {
+			t.Fatal(err)
+		}
+		exp := uint64(indexContainerSize + 13)
+		if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, hot, indexContainerSize+1); err != nil || !bytes.Equal(v, []byte{byte(exp >> 16), byte(exp >> 8), byte(exp)}) {
+			t.Errorf("hot key after DeleteTimestamp: got %x, %v", v, err)
+		}
+		if err := db.DeleteTimestamp(last); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetAsOf(conformanceBucket, conformanceHBucket, cold, 5); err != ErrKeyNotFound {
+			t.Errorf("cold key should have no history left, got %v", err)
+		}
+	})
+}
@@ -38,6 +38,17 @@ type Getter interface {
 	MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error
 }
 
+// Snapshot is a read-only view of the database as of the moment it was taken.
+// The reads through the snapshot do not observe the writes committed after that,
+// so the reads of several keys are consistent with each other. The snapshot has
+// to be closed, and it should not be kept open for long, because it holds on to
+// the database pages (or versions) it can see. Like the transactions of the
+// engines, a snapshot is meant to be used by one goroutine at a time.
+type Snapshot interface {
+	Getter
+	Close()
+}
+
 // Deleter wraps the database delete operation supported by both batches and regular databases.
 type Deleter interface {
 	Delete(bucket, key []byte) error
@@ -58,6 +69,11 @@ type Database interface {
 	Close()
 	NewBatch() Mutation
 	Size() int
+	// View runs the function with the snapshot of the database, which is
+	// closed when the function returns.
+	View(f func(tx Getter) error) error
+	// Begin takes the snapshot of the database, which the caller has to close.
+	Begin() (Snapshot, error)
 }
 
 // Extended version of the Batch, with read capabilites
@@ -0,0 +1,243 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The commits that the database cannot write in one transaction, i.e. the
+// commits of the spilled mutations and the batches too big for one badger
+// transaction, go through the journal. The tuples are first written into the
+// journal bucket in chunks, then the marker of the complete journal is put,
+// and only then the chunks are applied to their buckets and the journal is
+// cleared. A crash before the marker is put leaves the database as it was
+// before the commit, and a crash after that is recovered by replaying the
+// journal when the database is opened next time.
+
+// JournalBucket holds the journal of the commit in progress.
+var JournalBucket = RegisterBucket(BucketInfo{
+	Name:  []byte("Journal"),
+	Key:   "\"c\" + chunk number (uint64 big endian), or \"m\" for the marker of the complete journal",
+	Value: "encoded tuples of the chunk, or empty for the marker",
+})
+
+var (
+	journalChunkPrefix = []byte("c")
+	journalCompleteKey = []byte("m")
+)
+
+var errCorruptJournal = errors.New("ethdb: corrupt journal")
+
+func journalChunkKey(n uint64) []byte {
+	k := make([]byte, len(journalChunkPrefix)+8)
+	copy(k, journalChunkPrefix)
+	binary.BigEndian.PutUint64(k[len(journalChunkPrefix):], n)
+	return k
+}
+
+func appendVarBytes(buf, b []byte) []byte {
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(b)))
+	return append(append(buf, l[:n]...), b...)
+}
+
+// encodeTuples serialises the tuples of MultiPut in the same format as the
+// records of the spilled runs, keeping the deletes apart from the empty values.
+func encodeTuples(tuples [][]byte) []byte {
+	var buf []byte
+	var l [binary.MaxVarintLen64]byte
+	for i := 0; i < len(tuples); i += 3 {
+		buf = appendVarBytes(buf, tuples[i])
+		buf = appendVarBytes(buf, tuples[i+1])
+		if tuples[i+2] == nil {
+			buf = append(buf, 0)
+			continue
+		}
+		n := binary.PutUvarint(l[:], uint64(len(tuples[i+2]))+1)
+		buf = append(append(buf, l[:n]...), tuples[i+2]...)
+	}
+	return buf
+}
+
+func decodeTuples(b []byte) ([][]byte, error) {
+	var tuples [][]byte
+	for len(b) > 0 {
+		for i := 0; i < 3; i++ {
+			l, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errCorruptJournal
+			}
+			b = b[n:]
+			if i == 2 {
+				if l == 0 {
+					tuples = append(tuples, nil)
+					continue
+				}
+				l--
+			}
+			if uint64(len(b)) < l {
+				return nil, errCorruptJournal
+			}
+			tuples = append(tuples, common.CopyBytes(b[:l]))
+			b = b[l:]
+		}
+	}
+	return tuples, nil
+}
+
+// tupleSource is the record source over the tuples of MultiPut.
+type tupleSource struct {
+	tuples [][]byte
+}
+
+func (s *tupleSource) current() (spillRecord, bool) {
+	if len(s.tuples) < 3 {
+		return spillRecord{}, false
+	}
+	return spillRecord{bucket: s.tuples[0], key: s.tuples[1], value: s.tuples[2]}, true
+}
+
+func (s *tupleSource) advance() error {
+	s.tuples = s.tuples[3:]
+	return nil
+}
+
+// writeChunks writes the records into the database in the batches of about
+// IdealBatchSize bytes, with the write function.
+func writeChunks(src recordSource, write func(tuples [][]byte) error) error {
+	var (
+		tuples [][]byte
+		size   int
+	)
+	for r, ok := src.current(); ok; r, ok = src.current() {
+		tuples = append(tuples, r.bucket, r.key, r.value)
+		size += len(r.key) + len(r.value)
+		if size >= IdealBatchSize {
+			if err := write(tuples); err != nil {
+				return err
+			}
+			tuples, size = tuples[:0], 0
+		}
+		if err := src.advance(); err != nil {
+			return err
+		}
+	}
+	if len(tuples) > 0 {
+		return write(tuples)
+	}
+	return nil
+}
+
+// writeJournaled writes the records into the database through the journal,
+// so that either all of them get written or none, even if the database
+// cannot write them in one transaction.
+func writeJournaled(db Database, src recordSource) (uint64, error) {
+	// Finish or discard whatever is left from the previous commit
+	if _, err := ReplayJournal(db); err != nil {
+		return 0, err
+	}
+	var chunks uint64
+	if err := writeChunks(src, func(tuples [][]byte) error {
+		if _, err := db.MultiPut(JournalBucket, journalChunkKey(chunks), encodeTuples(tuples)); err != nil {
+			return err
+		}
+		chunks++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if chunks == 0 {
+		return 0, nil
+	}
+	if _, err := db.MultiPut(JournalBucket, journalCompleteKey, []byte{}); err != nil {
+		return 0, err
+	}
+	return ReplayJournal(db)
+}
+
+// ReplayJournal finishes the commit interrupted by a crash: it applies the
+// complete journal to the database, or discards the incomplete one, and
+// clears the journal. It returns the number of bytes written by the replay.
+func ReplayJournal(db Database) (uint64, error) {
+	complete, err := db.Has(JournalBucket, journalCompleteKey)
+	if err != nil {
+		return 0, err
+	}
+	var keys [][]byte
+	if err := db.Walk(JournalBucket, journalChunkPrefix, uint(8*len(journalChunkPrefix)), func(k, _ []byte) (bool, error) {
+		keys = append(keys, common.CopyBytes(k))
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+	var written uint64
+	if complete {
+		// The chunks are read after the walk, so that the writes do not
+		// happen while its read transaction is open
+		for _, k := range keys {
+			chunk, err := db.Get(JournalBucket, k)
+			if err != nil {
+				return 0, err
+			}
+			tuples, err := decodeTuples(chunk)
+			if err != nil {
+				return 0, err
+			}
+			n, err := db.MultiPut(tuples...)
+			if err != nil {
+				return 0, err
+			}
+			written += n
+		}
+	}
+	if len(keys) == 0 && !complete {
+		return 0, nil
+	}
+	clear := make([][]byte, 0, 3*(len(keys)+1))
+	for _, k := range keys {
+		clear = append(clear, JournalBucket, k, nil)
+	}
+	if complete {
+		clear = append(clear, JournalBucket, journalCompleteKey, nil)
+	}
+	if _, err := db.MultiPut(clear...); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+func (db *LDBDatabase) replayJournal() error {
+	written, err := ReplayJournal(db)
+	if written > 0 {
+		db.log.Warn("Replayed the journal of the interrupted commit", "written", written)
+	}
+	return err
+}
+
+func (db *BadgerDatabase) replayJournal() error {
+	written, err := ReplayJournal(db)
+	if written > 0 {
+		db.log.Warn("Replayed the journal of the interrupted commit", "written", written)
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTuplesEncoding(t *testing.T) {
+	tuples := [][]byte{
+		[]byte("a"), []byte("k1"), []byte("v1"),
+		[]byte("a"), []byte("k2"), []byte{},
+		[]byte("b"), []byte("k3"), nil,
+	}
+	decoded, err := decodeTuples(encodeTuples(tuples))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, tuples) {
+		t.Errorf("decoded %q, expected %q", decoded, tuples)
+	}
+	if _, err := decodeTuples([]byte{1, 'a', 5, 'k'}); err != errCorruptJournal {
+		t.Errorf("expected errCorruptJournal, got %v", err)
+	}
+}
+
+// writeJournal writes the journal as it is left by the commit interrupted
+// after the chunks are written.
+func writeJournal(t *testing.T, db Database, complete bool) {
+	chunks := [][][]byte{
+		{conformanceBucket, conformanceKey(1), []byte("v1"), conformanceBucket, conformanceKey(2), nil},
+		{conformanceBucket, conformanceKey(3), []byte("v3")},
+	}
+	for i, chunk := range chunks {
+		if err := db.Put(JournalBucket, journalChunkKey(uint64(i)), encodeTuples(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if complete {
+		if err := db.Put(JournalBucket, journalCompleteKey, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConformanceReplayJournal(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if err := db.Put(conformanceBucket, conformanceKey(2), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		// Incomplete journal is discarded
+		writeJournal(t, db, false)
+		if _, err := ReplayJournal(db); err != nil {
+			t.Fatal(err)
+		}
+		if keys := walkKeys(t, db); len(keys) != 1 {
+			t.Errorf("incomplete journal is applied, keys: %q", keys)
+		}
+		// Complete journal is applied
+		writeJournal(t, db, true)
+		if _, err := ReplayJournal(db); err != nil {
+			t.Fatal(err)
+		}
+		keys := walkKeys(t, db)
+		if len(keys) != 2 || !bytes.Equal(keys[0], conformanceKey(1)) || !bytes.Equal(keys[1], conformanceKey(3)) {
+			t.Errorf("complete journal is not applied, keys: %q", keys)
+		}
+		// Journal is cleared in both cases
+		if err := db.Walk(JournalBucket, nil, 0, func(k, _ []byte) (bool, error) {
+			t.Errorf("journal entry %x is left", k)
+			return true, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestConformanceWriteJournaled(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		var tuples [][]byte
+		value := make([]byte, 1024)
+		for i := 0; i < 3*IdealBatchSize/len(value); i++ {
+			tuples = append(tuples, conformanceBucket, conformanceKey(i), value)
+		}
+		if _, err := writeJournaled(db, &tupleSource{tuples: tuples}); err != nil {
+			t.Fatal(err)
+		}
+		if keys := walkKeys(t, db); len(keys) != len(tuples)/3 {
+			t.Errorf("%d keys written, expected %d", len(keys), len(tuples)/3)
+		}
+		if has, err := db.Has(JournalBucket, journalCompleteKey); err != nil || has {
+			t.Errorf("journal is left complete: %t, %v", has, err)
+		}
+	})
+}
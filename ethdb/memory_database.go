@@ -30,11 +30,7 @@ func NewMemDatabase() *LDBDatabase {
 	if err != nil {
 		panic(err)
 	}
-	return &LDBDatabase{
-		fn:  "in-memory",
-		db:  db,
-		log: logger,
-	}
+	return newLDBDatabase("in-memory", db, logger)
 }
 
 func NewMemDatabase2() (*LDBDatabase, *bolt.DB) {
@@ -45,9 +41,5 @@ func NewMemDatabase2() (*LDBDatabase, *bolt.DB) {
 	if err != nil {
 		panic(err)
 	}
-	return &LDBDatabase{
-		fn:  "in-memory",
-		db:  db,
-		log: logger,
-	}, db
+	return newLDBDatabase("in-memory", db, logger), db
 }
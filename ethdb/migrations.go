@@ -0,0 +1,123 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	// SchemaBucket holds the schema version of the database, which is the
+	// number of the migrations applied to it, and the progress of the
+	// migration in progress.
+	SchemaBucket = RegisterBucket(BucketInfo{
+		Name:  []byte("Schema"),
+		Key:   "\"version\" or \"progress\"",
+		Value: "schema version (uint64 big endian), or the progress saved by the migration in progress",
+	})
+	schemaVersionKey  = []byte("version")
+	schemaProgressKey = []byte("progress")
+)
+
+// Migration is one step of the evolution of the database schema.
+type Migration struct {
+	Name string
+
+	// Up performs a part of the migration, starting from the progress returned
+	// by the previous part (nil for the first one), and returns the progress to
+	// continue from, or nil if the migration is complete. The writes of the part
+	// go into the batch, which is committed together with the progress, so that
+	// the migration interrupted at any point resumes from the last committed part.
+	// The parts should be small enough for the batch to fit in memory.
+	Up func(batch Mutation, progress []byte) ([]byte, error)
+}
+
+// SchemaVersion returns the schema version of the database, i.e. the number of
+// the migrations applied to it.
+func SchemaVersion(db Getter) (uint64, error) {
+	enc, err := db.Get(SchemaBucket, schemaVersionKey)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(enc) != 8 {
+		return 0, fmt.Errorf("ethdb: corrupt schema version %x", enc)
+	}
+	return binary.BigEndian.Uint64(enc), nil
+}
+
+// Migrate applies the migrations that have not been applied to the database
+// yet, in order, and resumes the one that was interrupted. It fails if the
+// database has the schema newer than the migrations know about.
+func Migrate(db Database, migrations []Migration) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > uint64(len(migrations)) {
+		return fmt.Errorf("database schema version is %d, only versions up to %d are supported", version, len(migrations))
+	}
+	for ; version < uint64(len(migrations)); version++ {
+		m := migrations[version]
+		progress, err := db.Get(SchemaBucket, schemaProgressKey)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if progress != nil {
+			log.Info("Resuming database migration", "name", m.Name, "version", version+1)
+		} else {
+			log.Info("Applying database migration", "name", m.Name, "version", version+1)
+		}
+		start := time.Now()
+		for {
+			batch := db.NewBatch()
+			next, err := m.Up(batch, common.CopyBytes(progress))
+			if err != nil {
+				batch.Rollback()
+				return fmt.Errorf("migration %s failed: %v", m.Name, err)
+			}
+			if next == nil {
+				var enc [8]byte
+				binary.BigEndian.PutUint64(enc[:], version+1)
+				if err := batch.Delete(SchemaBucket, schemaProgressKey); err != nil {
+					return err
+				}
+				if err := batch.Put(SchemaBucket, schemaVersionKey, enc[:]); err != nil {
+					return err
+				}
+			} else if err := batch.Put(SchemaBucket, schemaProgressKey, next); err != nil {
+				return err
+			}
+			if _, err := batch.Commit(); err != nil {
+				return err
+			}
+			if next == nil {
+				break
+			}
+			progress = next
+		}
+		log.Info("Applied database migration", "name", m.Name, "version", version+1, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"errors"
+	"testing"
+)
+
+// countingMigration puts the keys 0..n-1, one per part, and fails once at failAt.
+func countingMigration(n int, failAt int) Migration {
+	failed := false
+	return Migration{
+		Name: "counting",
+		Up: func(batch Mutation, progress []byte) ([]byte, error) {
+			i := 0
+			if progress != nil {
+				i = int(progress[0])
+			}
+			if i == failAt && !failed {
+				failed = true
+				return nil, errors.New("interrupted")
+			}
+			if err := batch.Put(conformanceBucket, conformanceKey(i), []byte{byte(i)}); err != nil {
+				return nil, err
+			}
+			if i+1 == n {
+				return nil, nil
+			}
+			return []byte{byte(i + 1)}, nil
+		},
+	}
+}
+
+func TestConformanceMigrate(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		migrations := []Migration{countingMigration(3, -1), countingMigration(10, 5)}
+		if err := Migrate(db, migrations); err == nil {
+			t.Fatal("interrupted migration succeeded")
+		}
+		if version, err := SchemaVersion(db); err != nil || version != 1 {
+			t.Errorf("schema version after the interruption is %d, %v, expected 1", version, err)
+		}
+		if keys := walkKeys(t, db); len(keys) != 5 {
+			t.Errorf("%d keys after the interruption, expected 5", len(keys))
+		}
+		// The second run resumes the interrupted migration
+		if err := Migrate(db, migrations); err != nil {
+			t.Fatal(err)
+		}
+		if version, err := SchemaVersion(db); err != nil || version != 2 {
+			t.Errorf("schema version is %d, %v, expected 2", version, err)
+		}
+		if keys := walkKeys(t, db); len(keys) != 10 {
+			t.Errorf("%d keys after the migration, expected 10", len(keys))
+		}
+		if has, _ := db.Has(SchemaBucket, schemaProgressKey); has {
+			t.Error("progress of the complete migration is left")
+		}
+		if err := Migrate(db, migrations[:1]); err == nil {
+			t.Error("migration of the newer schema succeeded")
+		}
+	})
+}
+
+func TestRegisteredBuckets(t *testing.T) {
+	info, ok := LookupBucket(ChangeSetBucket)
+	if !ok || string(info.Name) != string(ChangeSetBucket) {
+		t.Errorf("change set bucket is not registered: %v", info)
+	}
+	infos := RegisteredBuckets()
+	for i := 1; i < len(infos); i++ {
+		if string(infos[i-1].Name) >= string(infos[i].Name) {
+			t.Errorf("buckets are not sorted: %q, %q", infos[i-1].Name, infos[i].Name)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate registration did not panic")
+		}
+	}()
+	RegisterBucket(BucketInfo{Name: ChangeSetBucket})
+}
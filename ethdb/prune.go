@@ -0,0 +1,129 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrHistoryPruned is returned when the history is requested for a block
+// below the history horizon, i.e. the history has been pruned away.
+var ErrHistoryPruned = errors.New("history is pruned for the requested block")
+
+var (
+	// HistoryHorizonBucket holds the history horizon: the first block whose
+	// change sets are still kept. Absent horizon means that nothing was pruned.
+	HistoryHorizonBucket = RegisterBucket(BucketInfo{
+		Name:  []byte("HistoryHorizon"),
+		Key:   "\"horizon\"",
+		Value: "block number (uint64 big endian)",
+	})
+	historyHorizonKey = []byte("horizon")
+)
+
+func decodeHorizon(enc []byte) uint64 {
+	if len(enc) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(enc)
+}
+
+// checkHorizon returns ErrHistoryPruned if the history as of the timestamp
+// needs change sets that are below the horizon.
+func checkHorizon(horizon, timestamp uint64) error {
+	if timestamp < horizon {
+		return ErrHistoryPruned
+	}
+	return nil
+}
+
+// HistoryHorizon returns the first block whose history is still kept.
+func HistoryHorizon(db Getter) (uint64, error) {
+	enc, err := db.Get(HistoryHorizonBucket, historyHorizonKey)
+	if err != nil && err != ErrKeyNotFound {
+		return 0, err
+	}
+	return decodeHorizon(enc), nil
+}
+
+// CheckHistoryHorizon returns ErrHistoryPruned if the state as of the given
+// timestamp cannot be read from the history anymore.
+func CheckHistoryHorizon(db Getter, timestamp uint64) error {
+	horizon, err := HistoryHorizon(db)
+	if err != nil {
+		return err
+	}
+	return checkHorizon(horizon, timestamp)
+}
+
+// PruneHistory deletes the history of the blocks before the given one, at most
+// limit blocks in one batch, and moves the history horizon accordingly.
+// It returns the new horizon, which is equal to before when the pruning is complete.
+func PruneHistory(db Database, before uint64, limit uint64) (uint64, error) {
+	batch := db.NewBatch()
+	defer batch.Rollback()
+	end, err := PruneHistoryInto(batch, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// PruneHistoryInto is like PruneHistory, but it writes the deletions into the
+// given batch and leaves committing it to the caller. This way the pruning
+// does not race with the other writers of the batch over the history index.
+func PruneHistoryInto(batch GetterPutter, before uint64, limit uint64) (uint64, error) {
+	horizon, err := HistoryHorizon(batch)
+	if err != nil {
+		return 0, err
+	}
+	if horizon >= before {
+		return horizon, nil
+	}
+	// Skip straight to the first block that has a change set
+	first := before
+	if err := batch.Walk(ChangeSetBucket, encodeTimestamp(horizon), 0, func(k, _ []byte) (bool, error) {
+		first, _ = decodeTimestamp(k)
+		return false, nil
+	}); err != nil {
+		return 0, err
+	}
+	if first < before {
+		horizon = first
+	} else {
+		horizon = before
+	}
+	end := before
+	if limit > 0 && end-horizon > limit {
+		end = horizon + limit
+	}
+	for timestamp := horizon; timestamp < end; timestamp++ {
+		if err := batch.DeleteTimestamp(timestamp); err != nil {
+			return 0, err
+		}
+	}
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], end)
+	if err := batch.Put(HistoryHorizonBucket, historyHorizonKey, enc[:]); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
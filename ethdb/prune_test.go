@@ -0,0 +1,102 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConformancePruneHistory(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		// Pruning in batches of one block moves the horizon block by block
+		for _, exp := range []uint64{2, 3, 3} {
+			horizon, err := PruneHistory(db, 3, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if horizon != exp {
+				t.Fatalf("expected horizon %d, got %d", exp, horizon)
+			}
+		}
+		if horizon, err := HistoryHorizon(db); err != nil || horizon != 3 {
+			t.Fatalf("expected stored horizon 3, got %d, %v", horizon, err)
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(i), 2); err != ErrHistoryPruned {
+				t.Errorf("key %d below the horizon: expected ErrHistoryPruned, got %v", i, err)
+			}
+			v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(i), 3)
+			if err != nil && err != ErrKeyNotFound {
+				t.Fatal(err)
+			}
+			if exp := expectedAsOf(i, 3); !bytes.Equal(v, exp) {
+				t.Errorf("key %d at the horizon: expected %x, got %x", i, exp, v)
+			}
+		}
+		if cs, err := GetChangeSet(db, conformanceHBucket, 2); err != nil || cs.Len() != 0 {
+			t.Errorf("change set of block 2 survived pruning: %v, %v", cs, err)
+		}
+		walker := func(k, v []byte) (bool, error) { return true, nil }
+		if err := db.WalkAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 0, 1, walker); err != ErrHistoryPruned {
+			t.Errorf("WalkAsOf below the horizon: expected ErrHistoryPruned, got %v", err)
+		}
+		if err := db.WalkAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 0, 3, walker); err != nil {
+			t.Errorf("WalkAsOf at the horizon: %v", err)
+		}
+		if err := db.RewindData(3, 1, func(_, _, _ []byte) error { return nil }); err != ErrHistoryPruned {
+			t.Errorf("RewindData below the horizon: expected ErrHistoryPruned, got %v", err)
+		}
+		if err := db.RewindData(3, 2, func(_, _, _ []byte) error { return nil }); err != nil {
+			t.Errorf("RewindData to the horizon: %v", err)
+		}
+	})
+}
+
+func TestConformancePruneHistoryInto(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		// The batch writes the history of the next block into the same index
+		// containers the pruning removes the old blocks from
+		batch := db.NewBatch()
+		defer batch.Rollback()
+		if err := batch.PutS(conformanceHBucket, conformanceKey(0), []byte{0, 3}, 4); err != nil {
+			t.Fatal(err)
+		}
+		if horizon, err := PruneHistoryInto(batch, 3, 0); err != nil || horizon != 3 {
+			t.Fatalf("expected horizon 3, got %d, %v", horizon, err)
+		}
+		// Nothing is pruned before the batch is committed
+		if horizon, err := HistoryHorizon(db); err != nil || horizon != 0 {
+			t.Fatalf("expected stored horizon 0 before the commit, got %d, %v", horizon, err)
+		}
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 2); err != ErrHistoryPruned {
+			t.Errorf("below the horizon: expected ErrHistoryPruned, got %v", err)
+		}
+		for b, exp := range map[uint64][]byte{3: expectedAsOf(0, 3), 4: {0, 3}} {
+			if v, err := db.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), b); err != nil || !bytes.Equal(v, exp) {
+				t.Errorf("block %d: expected %x, got %x, %v", b, exp, v, err)
+			}
+		}
+	})
+}
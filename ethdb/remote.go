@@ -0,0 +1,547 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// The remote database gives the read-only access to the database of a running
+// node, which holds the lock of the database file, to the processes on the same
+// machine. The client and the server exchange gob encoded messages over a stream
+// connection, normally a UNIX socket. Every connection serves one request at a
+// time, the client keeps a pool of connections for the concurrent requests.
+//
+// The client starts the connection with the remoteHello naming the database.
+// Every request gets one response, except for the walks: their items come in
+// the responses of up to remoteWalkBatch items, and after every response which
+// is not the last one, the client sends remoteNext to get more or to stop.
+//
+// The snapshot taken with remoteOpBegin gets the id, which the other connections
+// join with remoteOpJoin, so that the reads nested in the walk of the snapshot
+// go over another connection to the same snapshot. The snapshot is closed when
+// the last connection ends it.
+
+// remoteProtocolVersion is increased with every incompatible protocol change.
+const remoteProtocolVersion = 2
+
+// remoteWalkBatch is the maximum number of walk items in one response.
+const remoteWalkBatch = 256
+
+var errRemoteReadOnly = errors.New("ethdb: remote database is read-only")
+
+const (
+	remoteOpGet = iota + 1
+	remoteOpGetS
+	remoteOpGetAsOf
+	remoteOpHas
+	remoteOpWalk
+	remoteOpMultiWalk
+	remoteOpWalkAsOf
+	remoteOpMultiWalkAsOf
+	remoteOpSize
+	remoteOpBegin // Binds the connection to a new snapshot
+	remoteOpJoin  // Binds the connection to the snapshot of another connection
+	remoteOpEnd   // Unbinds the connection from its snapshot
+)
+
+// Error codes of the well-known errors, which the client turns back into the errors.
+const (
+	remoteErrOther = iota + 1
+	remoteErrKeyNotFound
+	remoteErrHistoryPruned
+)
+
+type remoteHello struct {
+	Version uint
+	Name    string
+}
+
+type remoteRequest struct {
+	Op        uint
+	Bucket    []byte
+	HBucket   []byte
+	Key       []byte
+	StartKeys [][]byte
+	FixedBits []uint
+	Timestamp uint64
+	Snapshot  uint64
+}
+
+type remoteNext struct {
+	Stop bool
+}
+
+type remoteItem struct {
+	Index int
+	Key   []byte
+	Value []byte
+}
+
+type remoteResponse struct {
+	ErrCode  uint
+	Err      string
+	Value    []byte
+	Has      bool
+	Size     int
+	Items    []remoteItem
+	Done     bool   // Last response of the walk
+	Snapshot uint64 // Id of the snapshot taken with remoteOpBegin
+}
+
+func encodeRemoteError(err error) (uint, string) {
+	switch err {
+	case nil:
+		return 0, ""
+	case ErrKeyNotFound:
+		return remoteErrKeyNotFound, ""
+	case ErrHistoryPruned:
+		return remoteErrHistoryPruned, ""
+	default:
+		return remoteErrOther, err.Error()
+	}
+}
+
+func decodeRemoteError(code uint, msg string) error {
+	switch code {
+	case 0:
+		return nil
+	case remoteErrKeyNotFound:
+		return ErrKeyNotFound
+	case remoteErrHistoryPruned:
+		return ErrHistoryPruned
+	default:
+		return errors.New(msg)
+	}
+}
+
+// remoteConn is one connection to the server.
+type remoteConn struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+func dialRemote(network, addr, name string) (*remoteConn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &remoteConn{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}
+	if err := c.enc.Encode(&remoteHello{Version: remoteProtocolVersion, Name: name}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var resp remoteResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := decodeRemoteError(resp.ErrCode, resp.Err); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// call sends the request and reads the single response.
+func (c *remoteConn) call(req *remoteRequest) (*remoteResponse, error) {
+	if err := c.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	var resp remoteResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// remoteCallError is the error reported by the server, which leaves the
+// connection usable, unlike the errors of the connection itself.
+type remoteCallError struct {
+	err error
+}
+
+func (e *remoteCallError) Error() string { return e.err.Error() }
+
+// walk sends the walk request and calls the walker for the streamed items.
+func (c *remoteConn) walk(req *remoteRequest, walker func(item *remoteItem) (bool, error)) error {
+	if err := c.enc.Encode(req); err != nil {
+		return err
+	}
+	var walkErr error
+	stopped := false
+	for {
+		var resp remoteResponse
+		if err := c.dec.Decode(&resp); err != nil {
+			return err
+		}
+		if err := decodeRemoteError(resp.ErrCode, resp.Err); err != nil {
+			return &remoteCallError{err}
+		}
+		for i := 0; i < len(resp.Items) && !stopped; i++ {
+			goOn, err := walker(&resp.Items[i])
+			if err != nil {
+				walkErr = err
+			}
+			stopped = err != nil || !goOn
+		}
+		if resp.Done {
+			break
+		}
+		if err := c.enc.Encode(&remoteNext{Stop: stopped}); err != nil {
+			return err
+		}
+	}
+	if walkErr != nil {
+		return &remoteCallError{walkErr}
+	}
+	return nil
+}
+
+// remoteReader implements the Getter on top of the remote connections. The
+// database takes a connection from its pool for every read, while the reads of
+// a snapshot take one of the connections joined to it.
+type remoteReader struct {
+	with func(fn func(c *remoteConn) error) error
+}
+
+func (r *remoteReader) call(req *remoteRequest) (*remoteResponse, error) {
+	var resp *remoteResponse
+	err := r.with(func(c *remoteConn) error {
+		var err error
+		if resp, err = c.call(req); err != nil {
+			return err
+		}
+		if err := decodeRemoteError(resp.ErrCode, resp.Err); err != nil {
+			return &remoteCallError{err}
+		}
+		return nil
+	})
+	return resp, unwrapRemoteError(err)
+}
+
+func (r *remoteReader) walk(req *remoteRequest, walker func(item *remoteItem) (bool, error)) error {
+	return unwrapRemoteError(r.with(func(c *remoteConn) error {
+		return c.walk(req, walker)
+	}))
+}
+
+func unwrapRemoteError(err error) error {
+	if e, ok := err.(*remoteCallError); ok {
+		return e.err
+	}
+	return err
+}
+
+func (r *remoteReader) Get(bucket, key []byte) ([]byte, error) {
+	resp, err := r.call(&remoteRequest{Op: remoteOpGet, Bucket: bucket, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (r *remoteReader) GetS(hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	resp, err := r.call(&remoteRequest{Op: remoteOpGetS, HBucket: hBucket, Key: key, Timestamp: timestamp})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (r *remoteReader) GetAsOf(bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	resp, err := r.call(&remoteRequest{Op: remoteOpGetAsOf, Bucket: bucket, HBucket: hBucket, Key: key, Timestamp: timestamp})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (r *remoteReader) Has(bucket, key []byte) (bool, error) {
+	resp, err := r.call(&remoteRequest{Op: remoteOpHas, Bucket: bucket, Key: key})
+	if err != nil {
+		return false, err
+	}
+	return resp.Has, nil
+}
+
+func (r *remoteReader) Walk(bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
+	req := &remoteRequest{Op: remoteOpWalk, Bucket: bucket, Key: startkey, FixedBits: []uint{fixedbits}}
+	return r.walk(req, func(item *remoteItem) (bool, error) {
+		return walker(item.Key, item.Value)
+	})
+}
+
+func (r *remoteReader) MultiWalk(bucket []byte, startkeys [][]byte, fixedbits []uint, walker func(int, []byte, []byte) (bool, error)) error {
+	req := &remoteRequest{Op: remoteOpMultiWalk, Bucket: bucket, StartKeys: startkeys, FixedBits: fixedbits}
+	return r.walk(req, func(item *remoteItem) (bool, error) {
+		return walker(item.Index, item.Key, item.Value)
+	})
+}
+
+func (r *remoteReader) WalkAsOf(bucket, hBucket, startkey []byte, fixedbits uint, timestamp uint64, walker func([]byte, []byte) (bool, error)) error {
+	req := &remoteRequest{Op: remoteOpWalkAsOf, Bucket: bucket, HBucket: hBucket, Key: startkey, FixedBits: []uint{fixedbits}, Timestamp: timestamp}
+	return r.walk(req, func(item *remoteItem) (bool, error) {
+		return walker(item.Key, item.Value)
+	})
+}
+
+func (r *remoteReader) MultiWalkAsOf(bucket, hBucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error)) error {
+	req := &remoteRequest{Op: remoteOpMultiWalkAsOf, Bucket: bucket, HBucket: hBucket, StartKeys: startkeys, FixedBits: fixedbits, Timestamp: timestamp}
+	return r.walk(req, func(item *remoteItem) (bool, error) {
+		return walker(item.Index, item.Key, item.Value)
+	})
+}
+
+// RemoteDatabase is the read-only Database served by a running node (see
+// RemoteServer). All the writes fail, and the batches can be read through
+// but not committed. Empty values come back as nil.
+type RemoteDatabase struct {
+	remoteReader
+	network, addr, name string
+
+	mu     sync.Mutex
+	idle   []*remoteConn // Pool of the connections not in use
+	closed bool
+}
+
+// NewRemoteDatabase connects to the database with the given name (e.g.
+// "chaindata") served on the UNIX socket at the path.
+func NewRemoteDatabase(path string, name string) (*RemoteDatabase, error) {
+	return DialRemoteDatabase("unix", path, name)
+}
+
+// DialRemoteDatabase connects to the database with the given name served at
+// the address of the given network.
+func DialRemoteDatabase(network, addr, name string) (*RemoteDatabase, error) {
+	db := &RemoteDatabase{network: network, addr: addr, name: name}
+	db.remoteReader = remoteReader{with: db.withConn}
+	// Check that the database is there
+	c, err := dialRemote(network, addr, name)
+	if err != nil {
+		return nil, err
+	}
+	db.idle = append(db.idle, c)
+	return db, nil
+}
+
+// acquire takes the connection from the pool, or dials the new one.
+func (db *RemoteDatabase) acquire() (*remoteConn, error) {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil, errors.New("ethdb: remote database is closed")
+	}
+	var c *remoteConn
+	if n := len(db.idle); n > 0 {
+		c, db.idle = db.idle[n-1], db.idle[:n-1]
+	}
+	db.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	return dialRemote(db.network, db.addr, db.name)
+}
+
+// withConn runs the function with the connection from the pool. The connection
+// goes back to the pool unless the function fails with the connection error.
+func (db *RemoteDatabase) withConn(fn func(c *remoteConn) error) error {
+	c, err := db.acquire()
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	if _, ok := err.(*remoteCallError); err != nil && !ok {
+		c.conn.Close()
+		return err
+	}
+	db.release(c)
+	return err
+}
+
+func (db *RemoteDatabase) release(c *remoteConn) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		c.conn.Close()
+		return
+	}
+	db.idle = append(db.idle, c)
+}
+
+func (db *RemoteDatabase) Put(bucket, key, value []byte) error {
+	return errRemoteReadOnly
+}
+
+func (db *RemoteDatabase) PutS(hBucket, key, value []byte, timestamp uint64) error {
+	return errRemoteReadOnly
+}
+
+func (db *RemoteDatabase) DeleteTimestamp(timestamp uint64) error {
+	return errRemoteReadOnly
+}
+
+func (db *RemoteDatabase) Delete(bucket, key []byte) error {
+	return errRemoteReadOnly
+}
+
+func (db *RemoteDatabase) MultiPut(tuples ...[]byte) (uint64, error) {
+	return 0, errRemoteReadOnly
+}
+
+func (db *RemoteDatabase) RewindData(timestampSrc, timestampDst uint64, df func(bucket, key, value []byte) error) error {
+	return rewindData(db, timestampSrc, timestampDst, df)
+}
+
+// Close closes the connections to the server.
+func (db *RemoteDatabase) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, c := range db.idle {
+		c.conn.Close()
+	}
+	db.idle = nil
+	db.closed = true
+}
+
+func (db *RemoteDatabase) NewBatch() Mutation {
+	return newMutation(db)
+}
+
+// Size returns the size of the database on the server.
+func (db *RemoteDatabase) Size() int {
+	resp, err := db.call(&remoteRequest{Op: remoteOpSize})
+	if err != nil {
+		return 0
+	}
+	return resp.Size
+}
+
+// remoteSnapshot is the snapshot taken on the server. Every read takes one of
+// the connections joined to the snapshot which is not in use, or joins another
+// one from the pool, so the reads can be nested in the walks.
+type remoteSnapshot struct {
+	remoteReader
+	db    *RemoteDatabase
+	id    uint64
+	conns []*remoteConn // Connections joined to the snapshot
+	idle  []*remoteConn // Joined connections not in use
+}
+
+// View runs the function with the snapshot of the remote database.
+func (db *RemoteDatabase) View(f func(tx Getter) error) error {
+	s, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return f(s)
+}
+
+// Begin takes the snapshot of the database on the server.
+func (db *RemoteDatabase) Begin() (Snapshot, error) {
+	c, err := db.acquire()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.call(&remoteRequest{Op: remoteOpBegin})
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	if err := decodeRemoteError(resp.ErrCode, resp.Err); err != nil {
+		db.release(c)
+		return nil, err
+	}
+	s := &remoteSnapshot{db: db, id: resp.Snapshot, conns: []*remoteConn{c}, idle: []*remoteConn{c}}
+	s.remoteReader = remoteReader{with: s.withConn}
+	return s, nil
+}
+
+// join binds another connection from the pool to the snapshot.
+func (s *remoteSnapshot) join() (*remoteConn, error) {
+	if s.conns == nil {
+		return nil, errors.New("ethdb: remote snapshot is closed")
+	}
+	c, err := s.db.acquire()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.call(&remoteRequest{Op: remoteOpJoin, Snapshot: s.id})
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	if err := decodeRemoteError(resp.ErrCode, resp.Err); err != nil {
+		s.db.release(c)
+		return nil, err
+	}
+	s.conns = append(s.conns, c)
+	return c, nil
+}
+
+func (s *remoteSnapshot) withConn(fn func(c *remoteConn) error) error {
+	var c *remoteConn
+	if n := len(s.idle); n > 0 {
+		c, s.idle = s.idle[n-1], s.idle[:n-1]
+	} else {
+		var err error
+		if c, err = s.join(); err != nil {
+			return err
+		}
+	}
+	err := fn(c)
+	if _, ok := err.(*remoteCallError); err != nil && !ok {
+		// The server unbinds the broken connection from the snapshot
+		c.conn.Close()
+		s.drop(c)
+		return err
+	}
+	s.idle = append(s.idle, c)
+	return err
+}
+
+func (s *remoteSnapshot) drop(c *remoteConn) {
+	for i := range s.conns {
+		if s.conns[i] == c {
+			s.conns = append(s.conns[:i], s.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close unbinds the connections from the snapshot, which closes it on the
+// server, and returns them to the pool.
+func (s *remoteSnapshot) Close() {
+	for _, c := range s.conns {
+		if resp, err := c.call(&remoteRequest{Op: remoteOpEnd}); err == nil && resp.ErrCode == 0 {
+			s.db.release(c)
+		} else {
+			c.conn.Close()
+		}
+	}
+	s.conns, s.idle = nil, nil
+}
+
+func (r *remoteRequest) String() string {
+	return fmt.Sprintf("op %d bucket %q key %x", r.Op, r.Bucket, r.Key)
+}
@@ -0,0 +1,332 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// errRemoteWalkStopped is returned by the walker of the server when the client
+// stops the walk.
+var errRemoteWalkStopped = errors.New("walk stopped by the client")
+
+// RemoteServer serves the databases to the RemoteDatabase clients.
+type RemoteServer struct {
+	mu        sync.Mutex
+	dbs       map[string]Database
+	lis       map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	snapshots map[uint64]*remoteServerSnapshot
+	lastID    uint64
+	wg        sync.WaitGroup
+}
+
+// remoteServerSnapshot is the snapshot shared by the connections joined to it.
+// The reads of the connections take turns, a walk lets the others in while it
+// waits for the client.
+type remoteServerSnapshot struct {
+	Snapshot
+	id   uint64
+	mu   sync.Mutex // Held while the snapshot is read
+	refs int        // Number of the connections joined to the snapshot
+}
+
+// NewRemoteServer creates the server without any databases.
+func NewRemoteServer() *RemoteServer {
+	return &RemoteServer{
+		dbs:       make(map[string]Database),
+		lis:       make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		snapshots: make(map[uint64]*remoteServerSnapshot),
+	}
+}
+
+// begin takes the snapshot of the database and joins the connection to it.
+func (s *RemoteServer) begin(db Database) (*remoteServerSnapshot, error) {
+	snapshot, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	shared := &remoteServerSnapshot{Snapshot: snapshot, id: s.lastID, refs: 1}
+	s.snapshots[shared.id] = shared
+	return shared, nil
+}
+
+// join joins the connection to the snapshot with the id.
+func (s *RemoteServer) join(id uint64) (*remoteServerSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shared := s.snapshots[id]
+	if shared == nil {
+		return nil, fmt.Errorf("ethdb: unknown remote snapshot %d", id)
+	}
+	shared.refs++
+	return shared, nil
+}
+
+// end unbinds the connection from the snapshot, and closes the snapshot when
+// it was the last connection.
+func (s *RemoteServer) end(shared *remoteServerSnapshot) {
+	s.mu.Lock()
+	shared.refs--
+	last := shared.refs == 0
+	if last {
+		delete(s.snapshots, shared.id)
+	}
+	s.mu.Unlock()
+	if last {
+		shared.mu.Lock()
+		shared.Close()
+		shared.mu.Unlock()
+	}
+}
+
+// Register makes the database available to the clients under the name.
+func (s *RemoteServer) Register(name string, db Database) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dbs[name] = db
+}
+
+// Serve accepts the connections on the listener until it is closed or the
+// server is stopped.
+func (s *RemoteServer) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	s.lis[lis] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.lis, lis)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Stop closes the listeners and the connections and waits for the requests in
+// progress to finish.
+func (s *RemoteServer) Stop() {
+	s.mu.Lock()
+	for lis := range s.lis {
+		lis.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *RemoteServer) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+
+	var hello remoteHello
+	if err := dec.Decode(&hello); err != nil {
+		return
+	}
+	s.mu.Lock()
+	db := s.dbs[hello.Name]
+	s.mu.Unlock()
+	var helloErr error
+	switch {
+	case hello.Version != remoteProtocolVersion:
+		helloErr = fmt.Errorf("ethdb: remote protocol version %d, expected %d", hello.Version, remoteProtocolVersion)
+	case db == nil:
+		helloErr = fmt.Errorf("ethdb: unknown remote database %q", hello.Name)
+	}
+	code, msg := encodeRemoteError(helloErr)
+	if err := enc.Encode(&remoteResponse{ErrCode: code, Err: msg}); err != nil || helloErr != nil {
+		return
+	}
+
+	// Reads go to the snapshot while the connection has one
+	var snapshot *remoteServerSnapshot
+	defer func() {
+		if snapshot != nil {
+			s.end(snapshot)
+		}
+	}()
+	for {
+		var req remoteRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		var (
+			getter Getter      = db
+			locker sync.Locker = new(sync.Mutex)
+		)
+		if snapshot != nil {
+			getter, locker = snapshot, &snapshot.mu
+		}
+		var err error
+		switch req.Op {
+		case remoteOpWalk, remoteOpMultiWalk, remoteOpWalkAsOf, remoteOpMultiWalkAsOf:
+			err = serveRemoteWalk(getter, locker, &req, enc, dec)
+		case remoteOpBegin, remoteOpJoin:
+			resp := new(remoteResponse)
+			if snapshot != nil {
+				resp.ErrCode, resp.Err = encodeRemoteError(errors.New("ethdb: remote snapshot is open already"))
+			} else {
+				var beginErr error
+				if req.Op == remoteOpBegin {
+					snapshot, beginErr = s.begin(db)
+				} else {
+					snapshot, beginErr = s.join(req.Snapshot)
+				}
+				if beginErr != nil {
+					resp.ErrCode, resp.Err = encodeRemoteError(beginErr)
+				} else {
+					resp.Snapshot = snapshot.id
+				}
+			}
+			err = enc.Encode(resp)
+		case remoteOpEnd:
+			if snapshot != nil {
+				s.end(snapshot)
+				snapshot = nil
+			}
+			err = enc.Encode(new(remoteResponse))
+		default:
+			locker.Lock()
+			resp := serveRemoteCall(db, getter, &req)
+			locker.Unlock()
+			err = enc.Encode(resp)
+		}
+		if err != nil {
+			log.Debug("Remote database connection failed", "op", req.String(), "err", err)
+			return
+		}
+	}
+}
+
+// serveRemoteCall serves the requests with the single response.
+func serveRemoteCall(db Database, getter Getter, req *remoteRequest) *remoteResponse {
+	var (
+		resp = new(remoteResponse)
+		err  error
+	)
+	switch req.Op {
+	case remoteOpGet:
+		resp.Value, err = getter.Get(req.Bucket, req.Key)
+	case remoteOpGetS:
+		resp.Value, err = getter.GetS(req.HBucket, req.Key, req.Timestamp)
+	case remoteOpGetAsOf:
+		resp.Value, err = getter.GetAsOf(req.Bucket, req.HBucket, req.Key, req.Timestamp)
+	case remoteOpHas:
+		resp.Has, err = getter.Has(req.Bucket, req.Key)
+	case remoteOpSize:
+		resp.Size = db.Size()
+	default:
+		err = fmt.Errorf("ethdb: unknown remote operation %d", req.Op)
+	}
+	// The values may be only valid inside the transaction of the getter
+	resp.Value = common.CopyBytes(resp.Value)
+	resp.ErrCode, resp.Err = encodeRemoteError(err)
+	return resp
+}
+
+// serveRemoteWalk streams the items of the walk in the batches, waiting for
+// the client to ask for the next batch. The getter is read under the locker,
+// which is released while waiting, so that the client can read the getter
+// over another connection in between.
+func serveRemoteWalk(getter Getter, locker sync.Locker, req *remoteRequest, enc *gob.Encoder, dec *gob.Decoder) error {
+	var (
+		items   []remoteItem
+		connErr error
+	)
+	flush := func() error {
+		locker.Unlock()
+		defer locker.Lock()
+		if err := enc.Encode(&remoteResponse{Items: items}); err != nil {
+			connErr = err
+			return err
+		}
+		items = items[:0]
+		var next remoteNext
+		if err := dec.Decode(&next); err != nil {
+			connErr = err
+			return err
+		}
+		if next.Stop {
+			return errRemoteWalkStopped
+		}
+		return nil
+	}
+	add := func(i int, k, v []byte) (bool, error) {
+		items = append(items, remoteItem{Index: i, Key: common.CopyBytes(k), Value: common.CopyBytes(v)})
+		if len(items) == remoteWalkBatch {
+			if err := flush(); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	walker := func(k, v []byte) (bool, error) { return add(0, k, v) }
+
+	var fixedbits uint
+	if len(req.FixedBits) > 0 {
+		fixedbits = req.FixedBits[0]
+	}
+	var err error
+	locker.Lock()
+	switch req.Op {
+	case remoteOpWalk:
+		err = getter.Walk(req.Bucket, req.Key, fixedbits, walker)
+	case remoteOpMultiWalk:
+		err = getter.MultiWalk(req.Bucket, req.StartKeys, req.FixedBits, add)
+	case remoteOpWalkAsOf:
+		err = getter.WalkAsOf(req.Bucket, req.HBucket, req.Key, fixedbits, req.Timestamp, walker)
+	case remoteOpMultiWalkAsOf:
+		err = getter.MultiWalkAsOf(req.Bucket, req.HBucket, req.StartKeys, req.FixedBits, req.Timestamp, add)
+	}
+	locker.Unlock()
+	if connErr != nil {
+		return connErr
+	}
+	if err == errRemoteWalkStopped {
+		err, items = nil, nil
+	}
+	resp := &remoteResponse{Items: items, Done: true}
+	resp.ErrCode, resp.Err = encodeRemoteError(err)
+	return enc.Encode(resp)
+}
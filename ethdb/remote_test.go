@@ -0,0 +1,183 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js,!windows
+
+package ethdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+// serveRemote serves the database over the UNIX socket and connects to it.
+func serveRemote(t *testing.T, db Database, test func(t *testing.T, remote *RemoteDatabase)) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ethdb_remote_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "db.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewRemoteServer()
+	server.Register("chaindata", db)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	if _, err := NewRemoteDatabase(socket, "unknown"); err == nil {
+		t.Error("connected to the unknown database")
+	}
+	remote, err := NewRemoteDatabase(socket, "chaindata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	test(t, remote)
+}
+
+func TestConformanceRemote(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		// Enough keys for the walk to take several batches
+		n := 3 * remoteWalkBatch
+		for i := 10; i < n; i++ {
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		serveRemote(t, db, func(t *testing.T, remote *RemoteDatabase) {
+			if v, err := remote.Get(conformanceBucket, conformanceKey(3)); err != nil || !bytes.Equal(v, []byte{3, 3}) {
+				t.Errorf("Get: %x, %v", v, err)
+			}
+			if _, err := remote.Get(conformanceBucket, conformanceKey(n)); err != ErrKeyNotFound {
+				t.Errorf("Get of unknown key: expected ErrKeyNotFound, got %v", err)
+			}
+			if has, err := remote.Has(conformanceBucket, conformanceKey(n)); err != nil || has {
+				t.Errorf("Has of unknown key: %t, %v", has, err)
+			}
+			if v, err := remote.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(6), 3); err != nil || !bytes.Equal(v, expectedAsOf(6, 3)) {
+				t.Errorf("GetAsOf: %x, %v", v, err)
+			}
+			if err := remote.Put(conformanceBucket, conformanceKey(1), []byte{}); err != errRemoteReadOnly {
+				t.Errorf("Put: expected errRemoteReadOnly, got %v", err)
+			}
+			if keys := walkKeys(t, remote); len(keys) != n {
+				t.Errorf("Walk: %d keys, expected %d", len(keys), n)
+			}
+			// Stopping the walk in the middle leaves the connection usable
+			count := 0
+			if err := remote.Walk(conformanceBucket, nil, 0, func(k, _ []byte) (bool, error) {
+				count++
+				return count < remoteWalkBatch+10, nil
+			}); err != nil || count != remoteWalkBatch+10 {
+				t.Errorf("stopped Walk: %d keys, %v", count, err)
+			}
+			counts := make(map[int]int)
+			startkeys := [][]byte{conformanceKey(0), conformanceKey(100)}
+			if err := remote.MultiWalk(conformanceBucket, startkeys, []uint{8 * 6, 8 * 6}, func(i int, k, v []byte) (bool, error) {
+				if k != nil {
+					counts[i]++
+				}
+				return true, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if counts[0] != 100 || counts[1] != 100 {
+				t.Errorf("MultiWalk: unexpected counts per start key: %v", counts)
+			}
+			count = 0
+			if err := remote.WalkAsOf(conformanceBucket, conformanceHBucket, conformanceKey(0), 8*7, 2, func(k, v []byte) (bool, error) {
+				if i := int(k[len(k)-1] - '0'); !bytes.Equal(v, expectedAsOf(i, 2)) {
+					t.Errorf("WalkAsOf key %d: expected %x, got %x", i, expectedAsOf(i, 2), v)
+				}
+				count++
+				return true, nil
+			}); err != nil || count != 10 {
+				t.Errorf("WalkAsOf: %d keys, %v", count, err)
+			}
+			// Snapshot does not see the later writes
+			s, err := remote.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put(conformanceBucket, conformanceKey(n), []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get(conformanceBucket, conformanceKey(n)); err != ErrKeyNotFound {
+				t.Errorf("snapshot sees the later write: %v", err)
+			}
+			s.Close()
+			if _, err := remote.Get(conformanceBucket, conformanceKey(n)); err != nil {
+				t.Errorf("later write is not seen after the snapshot: %v", err)
+			}
+		})
+	})
+}
+
+func TestRemoteSnapshotNestedReads(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		n := 2 * remoteWalkBatch
+		for i := 0; i < n; i++ {
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		serveRemote(t, db, func(t *testing.T, remote *RemoteDatabase) {
+			s, err := remote.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The nested reads see the snapshot, not the later writes
+			if err := db.Put(conformanceBucket, conformanceKey(n), []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			if err := s.Walk(conformanceBucket, nil, 0, func(k, v []byte) (bool, error) {
+				if nested, err := s.Get(conformanceBucket, k); err != nil || !bytes.Equal(nested, v) {
+					t.Fatalf("nested Get of %q: %x, %v", k, nested, err)
+				}
+				if count%remoteWalkBatch == 0 {
+					inner := 0
+					if err := s.Walk(conformanceBucket, k, 0, func(_, _ []byte) (bool, error) {
+						inner++
+						return true, nil
+					}); err != nil || inner != n-count {
+						t.Fatalf("nested Walk from %q: %d keys, %v", k, inner, err)
+					}
+				}
+				count++
+				return true, nil
+			}); err != nil || count != n {
+				t.Errorf("Walk: %d keys, %v", count, err)
+			}
+			s.Close()
+			if _, err := s.Get(conformanceBucket, conformanceKey(0)); err == nil {
+				t.Error("read through the closed snapshot")
+			}
+			// The connections joined to the snapshot go back to the pool
+			if v, err := remote.Get(conformanceBucket, conformanceKey(n)); err != nil || !bytes.Equal(v, []byte{1}) {
+				t.Errorf("Get after the snapshot: %x, %v", v, err)
+			}
+		})
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func walkKeys(t *testing.T, db Getter) [][]byte {
+	var keys [][]byte
+	if err := db.Walk(conformanceBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		keys = append(keys, append([]byte{}, k...))
+		return true, nil
+	}); err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+	return keys
+}
+
+func TestConformanceSnapshot(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if err := db.Put(conformanceBucket, conformanceKey(1), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to take the snapshot: %v", err)
+		}
+		defer snap.Close()
+
+		batch := db.NewBatch()
+		if err := batch.Put(conformanceBucket, conformanceKey(1), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Put(conformanceBucket, conformanceKey(2), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		if v, err := snap.Get(conformanceBucket, conformanceKey(1)); err != nil || !bytes.Equal(v, []byte("v1")) {
+			t.Errorf("snapshot sees %q, %v, expected the value before the commit", v, err)
+		}
+		if has, err := snap.Has(conformanceBucket, conformanceKey(2)); err != nil || has {
+			t.Errorf("snapshot sees the key committed after it was taken: %t, %v", has, err)
+		}
+		if keys := walkKeys(t, snap); len(keys) != 1 {
+			t.Errorf("snapshot walks %d keys, expected 1", len(keys))
+		}
+		if v, err := db.Get(conformanceBucket, conformanceKey(1)); err != nil || !bytes.Equal(v, []byte("v2")) {
+			t.Errorf("database sees %q, %v, expected the committed value", v, err)
+		}
+		if err := db.View(func(tx Getter) error {
+			if keys := walkKeys(t, tx); len(keys) != 2 {
+				t.Errorf("view walks %d keys, expected 2", len(keys))
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("view failed: %v", err)
+		}
+	})
+}
+
+func TestConformanceMutationSnapshot(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		if err := db.Put(conformanceBucket, conformanceKey(1), []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+		batch := db.NewBatch()
+		if err := batch.Put(conformanceBucket, conformanceKey(2), []byte("v2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.View(func(tx Getter) error {
+			if err := db.Put(conformanceBucket, conformanceKey(3), []byte("v3")); err != nil {
+				return err
+			}
+			if v, err := tx.Get(conformanceBucket, conformanceKey(2)); err != nil || !bytes.Equal(v, []byte("v2")) {
+				t.Errorf("snapshot of the mutation sees %q, %v, expected the buffered value", v, err)
+			}
+			if has, err := tx.Has(conformanceBucket, conformanceKey(3)); err != nil || has {
+				t.Errorf("snapshot of the mutation sees the key put after it was taken: %t, %v", has, err)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("view failed: %v", err)
+		}
+	})
+}
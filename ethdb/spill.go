@@ -0,0 +1,660 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/petar/GoLLRB/llrb"
+)
+
+// The spilling mutation keeps its puts in memory only up to a limit. Past the
+// limit, the puts (together with the change sets, which get flushed into their
+// bucket and the history index first) are written out into a temporary file
+// as a run of records sorted by bucket and key, and the memory is released.
+// The reads look at the memory first, then at the runs from the newest to the
+// oldest, and then at the database. On Commit, the runs and the memory are
+// merged into one sorted stream and written into the database.
+//
+// Every record of a run is
+//   bucket length (uvarint) | bucket | key length (uvarint) | key | value length + 1 (uvarint) | value
+// with the value length of 0 marking the deleted key.
+
+// spillIndexInterval is the number of records between the entries of the
+// sparse index of a run, which is kept in memory to find the records.
+const spillIndexInterval = 64
+
+var errCorruptSpillRun = errors.New("ethdb: corrupt spilled run")
+
+// spiller holds the state of the spilling mutation.
+type spiller struct {
+	dir     string      // Directory for the run files, the system default if empty
+	limit   int         // Size of the puts kept in memory that triggers the spill
+	size    int         // Approximate size of the puts kept in memory
+	runs    []*spillRun // Spilled runs, from the oldest to the newest
+	spilled int         // Number of the records in the runs
+}
+
+// NewSpillingBatch returns the mutation which keeps about limit bytes of its
+// puts in memory, and spills the rest into temporary files in the directory
+// dir (or the default directory for temporary files if dir is empty).
+// Get, Has, GetS, Walk and GetAsOf see the merged view of the memory, the
+// spilled runs and the database. Like with the ordinary mutation, the other
+// walks see the database only.
+// The spilled data is committed through the journal (see journal.go), which
+// keeps the commit atomic even though it takes several transactions.
+func NewSpillingBatch(db Database, limit int, dir string) Mutation {
+	m := newMutation(db)
+	m.spill = &spiller{dir: dir, limit: limit}
+	return m
+}
+
+// spillRecord is one record of the run, nil value marks the deleted key.
+type spillRecord struct {
+	bucket, key, value []byte
+}
+
+func compareRecords(bucketA, keyA, bucketB, keyB []byte) int {
+	if c := bytes.Compare(bucketA, bucketB); c != 0 {
+		return c
+	}
+	return bytes.Compare(keyA, keyB)
+}
+
+type spillIndexEntry struct {
+	bucket, key []byte
+	offset      int64
+}
+
+// spillRun is the file with the sorted records.
+type spillRun struct {
+	f     *os.File
+	size  int64
+	count int
+	index []spillIndexEntry // Every spillIndexInterval-th record
+}
+
+func writeVarBytes(w *bufio.Writer, b []byte) (int, error) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(b)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return 0, err
+	}
+	return n + len(b), nil
+}
+
+// writeSpillRun writes the puts into a new run, or returns nil if there are none.
+func writeSpillRun(dir string, puts map[string]*llrb.LLRB) (*spillRun, error) {
+	records := memRecords(puts, nil, nil, 0)
+	if len(records) == 0 {
+		return nil, nil
+	}
+	f, err := ioutil.TempFile(dir, "ethdb-spill-")
+	if err != nil {
+		return nil, err
+	}
+	run := &spillRun{f: f}
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		if run.count%spillIndexInterval == 0 {
+			run.index = append(run.index, spillIndexEntry{bucket: r.bucket, key: r.key, offset: run.size})
+		}
+		var n int
+		if n, err = writeVarBytes(w, r.bucket); err != nil {
+			break
+		}
+		run.size += int64(n)
+		if n, err = writeVarBytes(w, r.key); err != nil {
+			break
+		}
+		run.size += int64(n)
+		var buf [binary.MaxVarintLen64]byte
+		if r.value == nil {
+			n = binary.PutUvarint(buf[:], 0)
+		} else {
+			n = binary.PutUvarint(buf[:], uint64(len(r.value))+1)
+		}
+		if _, err = w.Write(buf[:n]); err != nil {
+			break
+		}
+		run.size += int64(n)
+		if _, err = w.Write(r.value); err != nil {
+			break
+		}
+		run.size += int64(len(r.value))
+		run.count++
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		run.remove()
+		return nil, err
+	}
+	return run, nil
+}
+
+// remove closes and deletes the file of the run.
+func (run *spillRun) remove() {
+	run.f.Close()
+	os.Remove(run.f.Name())
+}
+
+func readVarBytes(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errCorruptSpillRun
+	}
+	return b, nil
+}
+
+// recordSource is the sorted stream of records.
+type recordSource interface {
+	current() (spillRecord, bool)
+	advance() error
+}
+
+// runIterator reads the records of the run one by one.
+type runIterator struct {
+	r   *bufio.Reader
+	cur spillRecord
+	ok  bool
+}
+
+// iterator returns the iterator over the records of the run, positioned at
+// the first record not less than the given bucket and key.
+func (run *spillRun) iterator(bucket, key []byte) (*runIterator, error) {
+	// Start from the last index entry not greater than the bucket and key
+	i := sort.Search(len(run.index), func(i int) bool {
+		return compareRecords(run.index[i].bucket, run.index[i].key, bucket, key) > 0
+	})
+	var offset int64
+	if i > 0 {
+		offset = run.index[i-1].offset
+	}
+	it := &runIterator{r: bufio.NewReader(io.NewSectionReader(run.f, offset, run.size-offset))}
+	if err := it.advance(); err != nil {
+		return nil, err
+	}
+	for it.ok && compareRecords(it.cur.bucket, it.cur.key, bucket, key) < 0 {
+		if err := it.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+func (it *runIterator) current() (spillRecord, bool) {
+	return it.cur, it.ok
+}
+
+func (it *runIterator) advance() error {
+	it.ok = false
+	if _, err := it.r.Peek(1); err == io.EOF {
+		return nil
+	}
+	rec, err := readSpillRecord(it.r)
+	if err != nil {
+		return err
+	}
+	it.cur, it.ok = rec, true
+	return nil
+}
+
+func readSpillRecord(r *bufio.Reader) (spillRecord, error) {
+	var rec spillRecord
+	var err error
+	if rec.bucket, err = readVarBytes(r); err != nil {
+		return rec, errCorruptSpillRun
+	}
+	if rec.key, err = readVarBytes(r); err != nil {
+		return rec, errCorruptSpillRun
+	}
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return rec, errCorruptSpillRun
+	}
+	if l > 0 {
+		rec.value = make([]byte, l-1)
+		if _, err := io.ReadFull(r, rec.value); err != nil {
+			return rec, errCorruptSpillRun
+		}
+	}
+	return rec, nil
+}
+
+// get looks the key up in the run. Found deleted key gives nil value.
+func (run *spillRun) get(bucket, key []byte) ([]byte, bool, error) {
+	it, err := run.iterator(bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if r, ok := it.current(); ok && bytes.Equal(r.bucket, bucket) && bytes.Equal(r.key, key) {
+		return r.value, true, nil
+	}
+	return nil, false, nil
+}
+
+// memSource is the record source over the records collected from memory.
+type memSource struct {
+	records []spillRecord
+	pos     int
+}
+
+func (s *memSource) current() (spillRecord, bool) {
+	if s.pos < len(s.records) {
+		return s.records[s.pos], true
+	}
+	return spillRecord{}, false
+}
+
+func (s *memSource) advance() error {
+	s.pos++
+	return nil
+}
+
+// inRange tells whether the key shares the first fixedbits bits with the startkey.
+func inRange(key, startkey []byte, fixedbits uint) bool {
+	if fixedbits == 0 {
+		return true
+	}
+	fixedbytes, mask := bytesmask(fixedbits)
+	return len(key) >= fixedbytes && bytes.Equal(key[:fixedbytes-1], startkey[:fixedbytes-1]) && (key[fixedbytes-1]&mask) == (startkey[fixedbytes-1]&mask)
+}
+
+// memRecords collects the sorted records of the puts. With nil bucket, it
+// collects every bucket, otherwise the range of the bucket given by the
+// startkey and fixedbits, like in Walk.
+func memRecords(puts map[string]*llrb.LLRB, bucket, startkey []byte, fixedbits uint) []spillRecord {
+	var buckets []string
+	if bucket == nil {
+		for b := range puts {
+			buckets = append(buckets, b)
+		}
+		sort.Strings(buckets)
+	} else if _, ok := puts[string(bucket)]; ok {
+		buckets = []string{string(bucket)}
+	}
+	var records []spillRecord
+	for _, b := range buckets {
+		bb := []byte(b)
+		puts[b].AscendGreaterOrEqual(&PutItem{key: startkey}, func(i llrb.Item) bool {
+			item := i.(*PutItem)
+			if !inRange(item.key, startkey, fixedbits) {
+				return false
+			}
+			records = append(records, spillRecord{bucket: bb, key: item.key, value: item.value})
+			return true
+		})
+	}
+	return records
+}
+
+type sourceItem struct {
+	src      recordSource
+	priority int // Sources with the higher priority shadow the lower ones
+}
+
+type sourceHeap []sourceItem
+
+func (h sourceHeap) Len() int { return len(h) }
+func (h sourceHeap) Less(i, j int) bool {
+	ri, _ := h[i].src.current()
+	rj, _ := h[j].src.current()
+	if c := compareRecords(ri.bucket, ri.key, rj.bucket, rj.key); c != 0 {
+		return c < 0
+	}
+	return h[i].priority > h[j].priority
+}
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(sourceItem)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// mergeIterator merges the record sources, given from the lowest priority to
+// the highest. Of the records with the same bucket and key, only the one from
+// the source with the highest priority is returned.
+type mergeIterator struct {
+	h   sourceHeap
+	cur spillRecord
+	ok  bool
+}
+
+func newMergeIterator(sources []recordSource) (*mergeIterator, error) {
+	it := &mergeIterator{}
+	for i, src := range sources {
+		if _, ok := src.current(); ok {
+			it.h = append(it.h, sourceItem{src: src, priority: i})
+		}
+	}
+	heap.Init(&it.h)
+	if err := it.advance(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func (it *mergeIterator) current() (spillRecord, bool) {
+	return it.cur, it.ok
+}
+
+func (it *mergeIterator) advance() error {
+	if len(it.h) == 0 {
+		it.ok = false
+		return nil
+	}
+	it.cur, it.ok = it.h[0].src.current()
+	// Skip the shadowed records with the same bucket and key
+	for len(it.h) > 0 {
+		r, _ := it.h[0].src.current()
+		if compareRecords(r.bucket, r.key, it.cur.bucket, it.cur.key) != 0 {
+			break
+		}
+		if err := it.h[0].src.advance(); err != nil {
+			return err
+		}
+		if _, ok := it.h[0].src.current(); ok {
+			heap.Fix(&it.h, 0)
+		} else {
+			heap.Pop(&it.h)
+		}
+	}
+	return nil
+}
+
+// walkOverlay walks the range of the bucket in the upper source merged with
+// the lower walk. The upper records replace the lower ones with the same key,
+// and the deleted upper records hide them.
+func walkOverlay(upper recordSource, bucket, startkey []byte, fixedbits uint,
+	walkLower func(walker func(k, v []byte) (bool, error)) error, walker func(k, v []byte) (bool, error)) error {
+	// upperNext returns the next record of the upper source within the range
+	upperNext := func() (spillRecord, bool) {
+		r, ok := upper.current()
+		if !ok || !bytes.Equal(r.bucket, bucket) || !inRange(r.key, startkey, fixedbits) {
+			return spillRecord{}, false
+		}
+		return r, true
+	}
+	stopped := false
+	if err := walkLower(func(k, v []byte) (bool, error) {
+		for {
+			r, ok := upperNext()
+			if !ok || bytes.Compare(r.key, k) > 0 {
+				break
+			}
+			if err := upper.advance(); err != nil {
+				return false, err
+			}
+			if bytes.Equal(r.key, k) {
+				if r.value == nil {
+					return true, nil
+				}
+				v = r.value
+				break
+			}
+			if r.value != nil {
+				if goOn, err := walker(r.key, r.value); err != nil || !goOn {
+					stopped = true
+					return false, err
+				}
+			}
+		}
+		goOn, err := walker(k, v)
+		if err != nil || !goOn {
+			stopped = true
+		}
+		return goOn, err
+	}); err != nil || stopped {
+		return err
+	}
+	for r, ok := upperNext(); ok; r, ok = upperNext() {
+		if err := upper.advance(); err != nil {
+			return err
+		}
+		if r.value == nil {
+			continue
+		}
+		if goOn, err := walker(r.key, r.value); err != nil || !goOn {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSpilledNoLock looks the key up in the spilled runs, from the newest to
+// the oldest. Found deleted key gives ErrKeyNotFound.
+func (m *mutation) getSpilledNoLock(bucket, key []byte) ([]byte, bool, error) {
+	if m.spill == nil {
+		return nil, false, nil
+	}
+	for i := len(m.spill.runs) - 1; i >= 0; i-- {
+		v, ok, err := m.spill.runs[i].get(bucket, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			if v == nil {
+				return nil, true, ErrKeyNotFound
+			}
+			return v, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// spilledSourcesNoLock returns the record sources of the runs, from the
+// oldest to the newest, positioned at the given bucket and key.
+func (m *mutation) spilledSourcesNoLock(bucket, key []byte) ([]recordSource, error) {
+	var sources []recordSource
+	for _, run := range m.spill.runs {
+		it, err := run.iterator(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	return sources, nil
+}
+
+// walkMergedNoLock walks the merged view of the memory, the spilled runs
+// and the database.
+func (m *mutation) walkMergedNoLock(db Getter, bucket, startkey []byte, fixedbits uint, walker func([]byte, []byte) (bool, error)) error {
+	sources, err := m.spilledSourcesNoLock(bucket, startkey)
+	if err != nil {
+		return err
+	}
+	sources = append(sources, &memSource{records: memRecords(m.puts, bucket, startkey, fixedbits)})
+	upper, err := newMergeIterator(sources)
+	if err != nil {
+		return err
+	}
+	return walkOverlay(upper, bucket, startkey, fixedbits, func(w func(k, v []byte) (bool, error)) error {
+		if db == nil {
+			return nil
+		}
+		return db.Walk(bucket, startkey, fixedbits, w)
+	}, walker)
+}
+
+// getAsOfMergedNoLock reads the history through the merged view of the
+// memory, the spilled runs and the database. The change sets that are not
+// yet flushed into the history index are searched directly.
+func (m *mutation) getAsOfMergedNoLock(db Getter, bucket, hBucket, key []byte, timestamp uint64) ([]byte, error) {
+	enc, err := m.getNoLock(db, HistoryHorizonBucket, historyHorizonKey)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	if err := checkHorizon(decodeHorizon(enc), timestamp); err != nil {
+		return nil, err
+	}
+	var (
+		changeTimestamp uint64
+		found, pending  bool
+	)
+	for t, changeSets := range m.changeSets {
+		if t < timestamp || (found && t >= changeTimestamp) {
+			continue
+		}
+		if changeSet, ok := changeSets[string(hBucket)]; ok {
+			if _, ok := changeSet.Find(key); ok {
+				changeTimestamp, found, pending = t, true, true
+			}
+		}
+	}
+	if err := m.walkMergedNoLock(db, indexBucket(hBucket), indexKey(key, timestamp), uint(8*len(key)), func(k, v []byte) (bool, error) {
+		if len(k) != len(key)+4 {
+			return true, nil
+		}
+		if t, ok := indexNext(k, v, timestamp); ok {
+			if !found || t < changeTimestamp {
+				changeTimestamp, found, pending = t, true, false
+			}
+			return false, nil
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	if !found {
+		return m.getNoLock(db, bucket, key)
+	}
+	if pending {
+		v, _ := m.changeSets[changeTimestamp][string(hBucket)].Find(key)
+		return common.CopyBytes(v), nil
+	}
+	return historyValueAt(hBucket, key, changeTimestamp, func(csKey []byte) []byte {
+		v, _ := m.getNoLock(db, ChangeSetBucket, csKey)
+		return v
+	})
+}
+
+// grewNoLock accounts for the data added to the memory of the spilling
+// mutation, and spills it if it is over the limit.
+func (m *mutation) grewNoLock(size int) error {
+	if m.spill == nil {
+		return nil
+	}
+	m.spill.size += size
+	if m.spill.size < m.spill.limit {
+		return nil
+	}
+	return m.spillNoLock()
+}
+
+// spillNoLock flushes the change sets and writes the puts out into a new run.
+func (m *mutation) spillNoLock() error {
+	if err := m.flushChangeSetsNoLock(); err != nil {
+		return err
+	}
+	run, err := writeSpillRun(m.spill.dir, m.puts)
+	if err != nil {
+		return err
+	}
+	if run != nil {
+		m.spill.runs = append(m.spill.runs, run)
+		m.spill.spilled += run.count
+	}
+	m.puts = make(map[string]*llrb.LLRB)
+	m.spill.size = 0
+	return nil
+}
+
+// commitSpilledNoLock merges the runs and the memory into the database. The
+// merged data is too big for one transaction, so it goes through the journal,
+// unless the database is another mutation.
+func (m *mutation) commitSpilledNoLock() (uint64, error) {
+	sources, err := m.spilledSourcesNoLock(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	sources = append(sources, &memSource{records: memRecords(m.puts, nil, nil, 0)})
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return 0, err
+	}
+	var written uint64
+	if _, ok := m.db.(*mutation); ok {
+		err = writeChunks(it, func(tuples [][]byte) error {
+			_, err := m.db.MultiPut(tuples...)
+			return err
+		})
+	} else {
+		written, err = writeJournaled(m.db, it)
+	}
+	if err != nil {
+		return 0, err
+	}
+	m.removeRunsNoLock()
+	m.puts = make(map[string]*llrb.LLRB)
+	return written, nil
+}
+
+// removeRunsNoLock deletes the spilled runs.
+func (m *mutation) removeRunsNoLock() {
+	if m.spill == nil {
+		return
+	}
+	for _, run := range m.spill.runs {
+		run.remove()
+	}
+	m.spill.runs = nil
+	m.spill.spilled = 0
+	m.spill.size = 0
+}
+
+// spilledKeysNoLock returns the buckets and the keys of the merged memory and runs.
+func (m *mutation) spilledKeysNoLock() [][]byte {
+	var pairs [][]byte
+	sources, err := m.spilledSourcesNoLock(nil, nil)
+	if err != nil {
+		return pairs
+	}
+	sources = append(sources, &memSource{records: memRecords(m.puts, nil, nil, 0)})
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return pairs
+	}
+	for r, ok := it.current(); ok; r, ok = it.current() {
+		pairs = append(pairs, r.bucket, r.key)
+		if it.advance() != nil {
+			break
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func spillFiles(t *testing.T, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestConformanceSpillingBatch(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		dir, err := ioutil.TempDir(os.TempDir(), "ethdb_spill_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		for i := 0; i < 10; i += 2 {
+			if err := db.Put(conformanceBucket, conformanceKey(i), []byte("db")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Small limit makes every few puts spill
+		batch := NewSpillingBatch(db, 64, dir)
+		for i := 1; i < 10; i += 2 {
+			if err := batch.Put(conformanceBucket, conformanceKey(i), []byte("batch")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := batch.Put(conformanceBucket, conformanceKey(4), []byte("replaced")); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Delete(conformanceBucket, conformanceKey(2)); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Delete(conformanceBucket, conformanceKey(3)); err != nil {
+			t.Fatal(err)
+		}
+		if spillFiles(t, dir) == 0 {
+			t.Fatal("nothing spilled")
+		}
+
+		check := func(g Getter, what string) {
+			expected := map[string]string{
+				"key00000": "db", "key00001": "batch", "key00004": "replaced", "key00005": "batch",
+				"key00006": "db", "key00007": "batch", "key00008": "db", "key00009": "batch",
+			}
+			for i := 0; i < 10; i++ {
+				k := conformanceKey(i)
+				v, err := g.Get(conformanceBucket, k)
+				if exp, ok := expected[string(k)]; ok {
+					if err != nil || !bytes.Equal(v, []byte(exp)) {
+						t.Errorf("%s: key %d is %q, %v, expected %q", what, i, v, err, exp)
+					}
+				} else if err != ErrKeyNotFound {
+					t.Errorf("%s: deleted key %d is %q, %v", what, i, v, err)
+				}
+				if has, err := g.Has(conformanceBucket, k); err != nil || has != (expected[string(k)] != "") {
+					t.Errorf("%s: Has key %d gives %t, %v", what, i, has, err)
+				}
+			}
+			var walked []string
+			if err := g.Walk(conformanceBucket, nil, 0, func(k, v []byte) (bool, error) {
+				if exp := expected[string(k)]; !bytes.Equal(v, []byte(exp)) {
+					t.Errorf("%s: walk gives %q for %s, expected %q", what, v, k, exp)
+				}
+				walked = append(walked, string(k))
+				return true, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if len(walked) != len(expected) {
+				t.Errorf("%s: walked %v", what, walked)
+			}
+			for i := 1; i < len(walked); i++ {
+				if walked[i-1] >= walked[i] {
+					t.Errorf("%s: walk is out of order: %v", what, walked)
+				}
+			}
+		}
+		check(batch, "batch")
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		check(db, "database")
+		if n := spillFiles(t, dir); n != 0 {
+			t.Errorf("%d spilled files left after commit", n)
+		}
+	})
+}
+
+func TestConformanceSpillingBatchHistory(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		dir, err := ioutil.TempDir(os.TempDir(), "ethdb_spill_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		batch := NewSpillingBatch(db, 100, dir)
+		putHistory(t, batch)
+		checkAsOf := func(g Getter, what string) {
+			for b := 1; b <= 4; b++ {
+				for i := 0; i < 10; i++ {
+					v, err := g.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(i), uint64(b))
+					if err != nil {
+						t.Fatalf("%s: GetAsOf key %d block %d: %v", what, i, b, err)
+					}
+					if exp := expectedAsOf(i, b); !bytes.Equal(v, exp) {
+						t.Errorf("%s: GetAsOf key %d block %d: expected %x, got %x", what, i, b, exp, v)
+					}
+				}
+			}
+			v, err := g.GetS(conformanceHBucket, conformanceKey(6), 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := expectedAsOf(6, 3); !bytes.Equal(v, exp) {
+				t.Errorf("%s: GetS: expected %x, got %x", what, exp, v)
+			}
+		}
+		checkAsOf(batch, "batch")
+		if _, err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkAsOf(db, "database")
+	})
+}
+
+func TestSpillingBatchRollback(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		dir, err := ioutil.TempDir(os.TempDir(), "ethdb_spill_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		batch := NewSpillingBatch(db, 1, dir)
+		for i := 0; i < 10; i++ {
+			if err := batch.Put(conformanceBucket, conformanceKey(i), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := batch.BatchSize(); n != 10 {
+			t.Errorf("batch size is %d, expected 10", n)
+		}
+		batch.Rollback()
+		if n := spillFiles(t, dir); n != 0 {
+			t.Errorf("%d spilled files left after rollback", n)
+		}
+		if has, err := db.Has(conformanceBucket, conformanceKey(0)); err != nil || has {
+			t.Errorf("rolled back key is in the database: %t, %v", has, err)
+		}
+	})
+}
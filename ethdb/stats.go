@@ -0,0 +1,129 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"fmt"
+)
+
+// Inspector is implemented by the databases that can enumerate their buckets.
+type Inspector interface {
+	Buckets() ([][]byte, error)
+}
+
+// pageFiller is implemented by the page based databases, which can tell how
+// much of the space allocated to a bucket is actually in use.
+type pageFiller interface {
+	pageFill(bucket []byte) (float64, error)
+}
+
+// BucketStats describes the content of one bucket.
+type BucketStats struct {
+	Name       string
+	Keys       uint64  // Number of keys in the bucket
+	KeyBytes   uint64  // Total size of the keys
+	ValueBytes uint64  // Total size of the values
+	PageFill   float64 // Share of the allocated pages in use, 0 if the engine is not page based or sampled
+	Sampled    bool    // Whether the bucket was only partially read, the counts are then lower bounds
+
+	// History depth, only filled in for the history buckets
+	ChangeSets   uint64 // Number of blocks with the change sets for the bucket
+	OldestChange uint64 // First block with the change set for the bucket
+	NewestChange uint64 // Last block with the change set for the bucket, 0 if the change sets were sampled
+}
+
+// Stats collects the statistics of every bucket of the database. It reads
+// every key, so it is meant for the offline inspection of a database.
+func Stats(db Database) ([]BucketStats, error) {
+	return collectStats(db, 0)
+}
+
+// SampleStats collects the statistics of every bucket like Stats, but reads at
+// most limit keys of each bucket and skips the page fill, which walks all the
+// pages of a bucket. This keeps the read transactions short enough to be run
+// against a live database.
+func SampleStats(db Database, limit uint64) ([]BucketStats, error) {
+	return collectStats(db, limit)
+}
+
+// collectStats reads at most limit keys of every bucket, or all of them if the
+// limit is 0.
+func collectStats(db Database, limit uint64) ([]BucketStats, error) {
+	inspector, ok := db.(Inspector)
+	if !ok {
+		return nil, fmt.Errorf("database %T cannot enumerate its buckets", db)
+	}
+	buckets, err := inspector.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]BucketStats, len(buckets))
+	byName := make(map[string]*BucketStats, len(buckets))
+	for i, bucket := range buckets {
+		s := &stats[i]
+		s.Name = string(bucket)
+		byName[s.Name] = s
+		if err := db.Walk(bucket, nil, 0, func(k, v []byte) (bool, error) {
+			if limit > 0 && s.Keys == limit {
+				s.Sampled = true
+				return false, nil
+			}
+			s.Keys++
+			s.KeyBytes += uint64(len(k))
+			s.ValueBytes += uint64(len(v))
+			return true, nil
+		}); err != nil {
+			return nil, err
+		}
+		if pf, ok := db.(pageFiller); ok && limit == 0 {
+			if s.PageFill, err = pf.pageFill(bucket); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var changeSets uint64
+	if err := db.Walk(ChangeSetBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		timestamp, hBucket := decodeTimestamp(k)
+		s, ok := byName[string(hBucket)]
+		if !ok {
+			return true, nil
+		}
+		if limit > 0 && changeSets == limit {
+			// The change sets are walked in the order of blocks, so the
+			// newest change of a bucket is not known after the cut
+			for _, s := range byName {
+				if s.ChangeSets > 0 {
+					s.Sampled = true
+					s.NewestChange = 0
+				}
+			}
+			return false, nil
+		}
+		changeSets++
+		if s.ChangeSets == 0 || timestamp < s.OldestChange {
+			s.OldestChange = timestamp
+		}
+		if timestamp > s.NewestChange {
+			s.NewestChange = timestamp
+		}
+		s.ChangeSets++
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
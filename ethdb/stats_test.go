@@ -0,0 +1,147 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package ethdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConformanceStats(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		stats, err := Stats(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := make(map[string]BucketStats)
+		for _, s := range stats {
+			byName[s.Name] = s
+		}
+		current, ok := byName[string(conformanceBucket)]
+		if !ok {
+			t.Fatalf("no stats for bucket %s in %v", conformanceBucket, stats)
+		}
+		if current.Keys != 10 || current.KeyBytes != 10*8 || current.ValueBytes != 10*2 {
+			t.Errorf("unexpected stats of the current bucket: %+v", current)
+		}
+		history := byName[string(conformanceHBucket)]
+		if history.ChangeSets != 3 || history.OldestChange != 1 || history.NewestChange != 3 {
+			t.Errorf("unexpected history depth: %+v", history)
+		}
+		// Keys modified in blocks 1..3, one history entry each
+		if exp := uint64(10 + 5 + 4); history.Keys != exp {
+			t.Errorf("expected %d history entries, got %d", exp, history.Keys)
+		}
+	})
+}
+
+func TestConformanceSampleStats(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, db Database) {
+		putHistory(t, db)
+		stats, err := SampleStats(db, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := make(map[string]BucketStats)
+		for _, s := range stats {
+			byName[s.Name] = s
+		}
+		current := byName[string(conformanceBucket)]
+		if current.Keys != 4 || !current.Sampled || current.PageFill != 0 {
+			t.Errorf("unexpected sampled stats of the current bucket: %+v", current)
+		}
+		history := byName[string(conformanceHBucket)]
+		if history.ChangeSets != 3 || !history.Sampled {
+			t.Errorf("unexpected sampled history: %+v", history)
+		}
+		// Only two of the three change sets are read, so the newest change
+		// of the history bucket is unknown
+		stats, err = SampleStats(db, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range stats {
+			if s.Name == string(conformanceHBucket) && (s.OldestChange != 1 || s.NewestChange != 0) {
+				t.Errorf("unexpected change range of the sampled history: %+v", s)
+			}
+		}
+		stats, err = SampleStats(db, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range stats {
+			if s.Sampled {
+				t.Errorf("bucket %q sampled below the limit: %+v", s.Name, s)
+			}
+		}
+	})
+}
+
+func TestConformanceCopyDatabase(t *testing.T) {
+	forEachEngine(t, func(t *testing.T, src Database) {
+		putHistory(t, src)
+		for _, engine := range Engines() {
+			t.Run(engine, func(t *testing.T) {
+				dirname, err := ioutil.TempDir(os.TempDir(), "ethdb_copy_")
+				if err != nil {
+					t.Fatalf("failed to create temp dir: %v", err)
+				}
+				defer os.RemoveAll(dirname)
+				dst, err := Open(engine, path.Join(dirname, "db"))
+				if err != nil {
+					t.Fatalf("failed to open %s database: %v", engine, err)
+				}
+				defer dst.Close()
+				var batches int
+				if err := CopyDatabase(src, dst, func(bucket []byte, keys uint64) { batches++ }); err != nil {
+					t.Fatal(err)
+				}
+				if batches == 0 {
+					t.Errorf("no progress reported")
+				}
+				if err := VerifyCopy(src, dst); err != nil {
+					t.Fatalf("verification of the copy failed: %v", err)
+				}
+				if srcEnumer, ok := src.(bucketEnumer); ok {
+					if dstEnumer, ok := dst.(bucketEnumer); ok {
+						// The change sets are enumerable, the index is not
+						for _, bucket := range [][]byte{ChangeSetBucket, indexBucket(conformanceHBucket)} {
+							srcEnum, _ := srcEnumer.bucketEnum(bucket)
+							if dstEnum, err := dstEnumer.bucketEnum(bucket); err != nil || dstEnum != srcEnum {
+								t.Errorf("bucket %q: enumeration %v in the copy, %v in the source, %v", bucket, dstEnum, srcEnum, err)
+							}
+						}
+					}
+				}
+				if v, err := dst.GetAsOf(conformanceBucket, conformanceHBucket, conformanceKey(6), 2); err != nil || len(v) != 2 || v[1] != 1 {
+					t.Errorf("history does not work in the copy: %x, %v", v, err)
+				}
+				if err := dst.Put(conformanceBucket, conformanceKey(0), []byte("changed")); err != nil {
+					t.Fatal(err)
+				}
+				if err := VerifyCopy(src, dst); err == nil {
+					t.Errorf("verification did not notice the difference")
+				}
+			})
+		}
+	})
+}
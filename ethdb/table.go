@@ -94,6 +94,14 @@ func (dt *table) NewBatch() Mutation {
 	panic("Not supported")
 }
 
+func (dt *table) View(f func(tx Getter) error) error {
+	panic("Not supported")
+}
+
+func (dt *table) Begin() (Snapshot, error) {
+	panic("Not supported")
+}
+
 func (dt *table) Size() int {
 	return dt.db.Size()
 }
@@ -18,9 +18,7 @@ package ethdb
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
-	//"sort"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/petar/GoLLRB/llrb"
@@ -31,65 +29,38 @@ var EndSuffix []byte = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
 // Generates rewind data for all buckets between the timestamp
 // timestapSrc is the current timestamp, and timestamp Dst is where we rewind
 func rewindData(db Getter, timestampSrc, timestampDst uint64, df func(bucket, key, value []byte) error) error {
-	// Collect list of buckets and keys that need to be considered
-	m := make(map[string]map[string]struct{})
+	// Change sets are visited in the order of timestamps, so the first value
+	// seen for a key is the value it had at timestampDst
+	if err := CheckHistoryHorizon(db, timestampDst+1); err != nil {
+		return err
+	}
+	m := make(map[string]map[string][]byte)
 	suffixDst := encodeTimestamp(timestampDst + 1)
-	if err := db.Walk(SuffixBucket, suffixDst, 0, func(k, v []byte) (bool, error) {
+	if err := db.Walk(ChangeSetBucket, suffixDst, 0, func(k, v []byte) (bool, error) {
 		timestamp, bucket := decodeTimestamp(k)
 		if timestamp > timestampSrc {
 			return false, nil
 		}
-		keycount := int(binary.BigEndian.Uint32(v))
-		if keycount > 0 {
-			bucketStr := string(common.CopyBytes(bucket))
-			var t map[string]struct{}
-			var ok bool
-			if t, ok = m[bucketStr]; !ok {
-				t = make(map[string]struct{})
-				m[bucketStr] = t
-			}
-			i := 4
-			for ki := 0; ki < keycount; ki++ {
-				l := int(v[i])
-				i++
-				/*
-					k := v[i:i+l]
-					var sk []byte
-					if len(k) == 52 {
-						sk = k[20:]
-					} else {
-						sk = k
-					}
-					preimage, _ := db.Get([]byte("secure-key-"), sk)
-					fmt.Printf("timestamp: %d, key: %x, preimage: %x\n", timestamp, k, preimage)
-					if timestamp == 1828654 || timestamp == 2727676 {
-						fmt.Printf("key at block %d, bucket %s: %x\n", timestamp, bucket, v[i:i+l])
-					}
-				*/
-				t[string(common.CopyBytes(v[i:i+l]))] = struct{}{}
-				i += l
-			}
+		bucketStr := string(bucket)
+		t, ok := m[bucketStr]
+		if !ok {
+			t = make(map[string][]byte)
+			m[bucketStr] = t
 		}
-		return true, nil
+		err := walkChangeSet(v, func(key, value []byte) error {
+			if _, ok := t[string(key)]; !ok {
+				t[string(key)] = common.CopyBytes(value)
+			}
+			return nil
+		})
+		return err == nil, err
 	}); err != nil {
 		return err
 	}
-	//suffixDst := encodeTimestamp(timestampDst)
-	//buckets := sort.StringSlice{}
-	//for bucketStr := range m {
-	//	buckets = append(buckets, bucketStr)
-	//}
-	//sort.Sort(buckets)
 	for bucketStr, t := range m {
-		//t := m[bucketStr]
 		bucket := []byte(bucketStr)
-		for keyStr := range t {
-			key := []byte(keyStr)
-			value, err := db.GetAsOf(bucket[1:], bucket, key, timestampDst+1)
-			if err != nil {
-				value = nil
-			}
-			if err := df(bucket, key, value); err != nil {
+		for keyStr, value := range t {
+			if err := df(bucket, []byte(keyStr), value); err != nil {
 				return err
 			}
 		}
@@ -100,22 +71,19 @@ func rewindData(db Getter, timestampSrc, timestampDst uint64, df func(bucket, ke
 func GetModifiedAccounts(db Getter, starttimestamp, endtimestamp uint64) ([]common.Address, error) {
 	t := llrb.New()
 	startCode := encodeTimestamp(starttimestamp)
-	if err := db.Walk(SuffixBucket, startCode, 0, func(k, v []byte) (bool, error) {
+	if err := db.Walk(ChangeSetBucket, startCode, 0, func(k, v []byte) (bool, error) {
 		timestamp, bucket := decodeTimestamp(k)
-		if !bytes.Equal(bucket, []byte("hAT")) {
-			return true, nil
-		}
 		if timestamp > endtimestamp {
 			return false, nil
 		}
-		keycount := int(binary.BigEndian.Uint32(v))
-		for i, ki := 4, 0; ki < keycount; ki++ {
-			l := int(v[i])
-			i++
-			t.ReplaceOrInsert(&PutItem{key: common.CopyBytes(v[i : i+l]), value: nil})
-			i += l
+		if !bytes.Equal(bucket, []byte("hAT")) {
+			return true, nil
 		}
-		return true, nil
+		err := walkChangeSet(v, func(key, _ []byte) error {
+			t.ReplaceOrInsert(&PutItem{key: common.CopyBytes(key), value: nil})
+			return nil
+		})
+		return err == nil, err
 	}); err != nil {
 		return nil, err
 	}
@@ -145,3 +113,30 @@ func GetModifiedAccounts(db Getter, starttimestamp, endtimestamp uint64) ([]comm
 	}
 	return accounts, nil
 }
+
+// DeletePrefix deletes all the keys of the bucket starting with the prefix,
+// or the whole content of the bucket if the prefix is empty.
+func DeletePrefix(db Database, bucket, prefix []byte) error {
+	for {
+		var keys [][]byte
+		if err := db.Walk(bucket, prefix, uint(8*len(prefix)), func(k, _ []byte) (bool, error) {
+			keys = append(keys, common.CopyBytes(k))
+			return len(keys) < 100000, nil
+		}); err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		batch := db.NewBatch()
+		for _, k := range keys {
+			if err := batch.Delete(bucket, k); err != nil {
+				batch.Rollback()
+				return err
+			}
+		}
+		if _, err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+}
@@ -6,12 +6,13 @@ import (
 )
 
 var testbucket = []byte("B")
+var testcbucket = []byte("C")
 
 // multiWalkAsOf(db Getter, varKeys bool, bucket []byte, startkeys [][]byte, fixedbits []uint, timestamp uint64, walker func(int, []byte, []byte) (bool, error))
 func TestEmptyWalk(t *testing.T) {
 	db := NewMemDatabase()
 	count := 0
-	err := db.MultiWalkAsOf(testbucket, [][]byte{}, []uint{}, 0, func(i int, k []byte, v []byte) (bool, error) {
+	err := db.MultiWalkAsOf(testcbucket, testbucket, [][]byte{}, []uint{}, 0, func(i int, k []byte, v []byte) (bool, error) {
 		count++
 		return true, nil
 	})
@@ -29,6 +30,7 @@ func TestWalk2(t *testing.T) {
 	db.PutS(testbucket, []byte("aaaaaccccccccccccccccccccccccccc"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	count := 0
 	err := db.MultiWalkAsOf(
+		testcbucket,
 		testbucket,
 		[][]byte{[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
 		[]uint{10},
@@ -62,6 +64,7 @@ func TestWalkN(t *testing.T) {
 
 	count := make(map[int]int)
 	err := db.MultiWalkAsOf(
+		testcbucket,
 		testbucket,
 		[][]byte{[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("bbaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
 		[]uint{40, 16},
@@ -18,6 +18,7 @@
 package graphql
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net"
@@ -332,6 +333,116 @@ func (t *Transaction) Logs(ctx context.Context) (*[]*Log, error) {
 	return &ret, nil
 }
 
+func (t *Transaction) StateDiff(ctx context.Context) (*[]*AccountDiff, error) {
+	if _, err := t.resolve(ctx); err != nil || t.block == nil {
+		return nil, err
+	}
+	hash, err := t.block.Hash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := t.backend.GetStateDiff(ctx, hash, t.index)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*AccountDiff, 0, len(diff))
+	for _, d := range diff {
+		ret = append(ret, &AccountDiff{backend: t.backend, diff: d})
+	}
+	return &ret, nil
+}
+
+// AccountDiff represents the change of an account by a transaction.
+type AccountDiff struct {
+	backend *eth.EthAPIBackend
+	diff    *state.AccountDiff
+}
+
+func (a *AccountDiff) Address(ctx context.Context) common.Address {
+	return a.diff.Address
+}
+
+// codeChanged returns whether the transaction has changed the code of the account.
+func (a *AccountDiff) codeChanged() bool {
+	pre, post := a.diff.Pre, a.diff.Post
+	return pre == nil || post == nil || !bytes.Equal(pre.CodeHash, post.CodeHash)
+}
+
+func (a *AccountDiff) Pre(ctx context.Context) *AccountState {
+	if a.diff.Pre == nil {
+		return nil
+	}
+	s := &AccountState{account: a.diff.Pre}
+	if a.codeChanged() {
+		code, _ := a.backend.ChainDb().Get(state.CodeBucket, a.diff.Pre.CodeHash)
+		s.code = &code
+	}
+	return s
+}
+
+func (a *AccountDiff) Post(ctx context.Context) *AccountState {
+	if a.diff.Post == nil {
+		return nil
+	}
+	s := &AccountState{account: a.diff.Post}
+	if a.codeChanged() {
+		code := a.diff.Code
+		s.code = &code
+	}
+	return s
+}
+
+func (a *AccountDiff) Storage(ctx context.Context) []*StorageDiff {
+	ret := make([]*StorageDiff, len(a.diff.Storage))
+	for i := range a.diff.Storage {
+		ret[i] = &StorageDiff{diff: &a.diff.Storage[i]}
+	}
+	return ret
+}
+
+// AccountState represents an account before or after a transaction.
+type AccountState struct {
+	account *state.Account
+	code    *[]byte // nil unless the code was changed
+}
+
+func (a *AccountState) Balance(ctx context.Context) hexutil.Big {
+	return hexutil.Big(*a.account.Balance)
+}
+
+func (a *AccountState) Nonce(ctx context.Context) hexutil.Uint64 {
+	return hexutil.Uint64(a.account.Nonce)
+}
+
+func (a *AccountState) CodeHash(ctx context.Context) common.Hash {
+	return common.BytesToHash(a.account.CodeHash)
+}
+
+func (a *AccountState) Code(ctx context.Context) *hexutil.Bytes {
+	if a.code == nil {
+		return nil
+	}
+	code := hexutil.Bytes(*a.code)
+	return &code
+}
+
+// StorageDiff represents the change of a storage slot by a transaction.
+type StorageDiff struct {
+	diff *state.StorageDiff
+}
+
+func (s *StorageDiff) Slot(ctx context.Context) common.Hash {
+	return s.diff.Key
+}
+
+func (s *StorageDiff) Pre(ctx context.Context) common.Hash {
+	return s.diff.Pre
+}
+
+func (s *StorageDiff) Post(ctx context.Context) common.Hash {
+	return s.diff.Post
+}
+
 // Block represennts an Ethereum block.
 // backend, and either num or hash are mandatory. All other fields are lazily fetched
 // when required.
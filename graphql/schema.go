@@ -114,6 +114,45 @@ const schema string = `
         # Logs is a list of log entries emitted by this transaction. If the
         # transaction has not yet been mined, this field will be null.
         logs: [Log!]
+        # StateDiff is the list of the accounts changed by this transaction. It
+        # is only available if the node stores the state diffs, and will be null
+        # if the transaction has not yet been mined.
+        stateDiff: [AccountDiff!]
+    }
+
+    # AccountDiff is the change of an account by a transaction.
+    type AccountDiff {
+        # Address is the address of the account.
+        address: Address!
+        # Pre is the account before the transaction, null if it did not exist.
+        pre: AccountState
+        # Post is the account after the transaction, null if it was deleted.
+        post: AccountState
+        # Storage is the list of the storage slots changed by the transaction.
+        storage: [StorageDiff!]!
+    }
+
+    # AccountState is an account before or after a transaction.
+    type AccountState {
+        # Balance is the balance of the account, in wei.
+        balance: BigInt!
+        # Nonce is the number of transactions sent from the account.
+        nonce: Long!
+        # CodeHash is the hash of the code of the account.
+        codeHash: Bytes32!
+        # Code is the code of the account. This will be null unless the
+        # transaction has changed the code.
+        code: Bytes
+    }
+
+    # StorageDiff is the change of a storage slot by a transaction.
+    type StorageDiff {
+        # Slot is the storage slot.
+        slot: Bytes32!
+        # Pre is the value before the transaction.
+        pre: Bytes32!
+        # Post is the value after the transaction.
+        post: Bytes32!
     }
 
     # BlockFilterCriteria encapsulates log filter criteria for a filter applied
@@ -22,6 +22,8 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sort"
+	"text/tabwriter"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -33,9 +35,11 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
@@ -510,56 +514,67 @@ type StorageResult struct {
 }
 
 // GetProof returns the Merkle-proof for a given account and optionally some storage keys.
+// The proofs at the historical blocks are resolved from the history of the state.
 func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNr rpc.BlockNumber) (*AccountResult, error) {
-	/*
-		state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
-		if state == nil || err != nil {
-			return nil, err
-		}
-
-		storageTrie := state.StorageTrie(address)
-		storageHash := types.EmptyRootHash
-		codeHash := state.GetCodeHash(address)
-		storageProof := make([]StorageResult, len(storageKeys))
+	if blockNr == rpc.PendingBlockNumber {
+		return nil, errors.New("proofs of the pending state are not supported")
+	}
+	header, err := s.b.HeaderByNumber(ctx, blockNr)
+	if header == nil || err != nil {
+		return nil, err
+	}
+	number := header.Number.Uint64()
+	if err := ethdb.CheckHistoryHorizon(s.b.ChainDb(), number+1); err != nil {
+		return nil, err
+	}
+	tds, err := state.NewTrieDbState(header.Root, s.b.ChainDb(), number)
+	if err != nil {
+		return nil, err
+	}
+	tds.SetHistorical(true)
 
-		// if we have a storageTrie, (which means the account exists), we can update the storagehash
-		if storageTrie != nil {
-			storageHash = storageTrie.Hash()
-		} else {
-			// no storageTrie means the account does not exist, so the codeHash is the hash of an empty bytearray.
-			codeHash = crypto.Keccak256Hash(nil)
+	account, err := tds.ReadAccountData(address)
+	if err != nil {
+		return nil, err
+	}
+	result := &AccountResult{
+		Address:      address,
+		Balance:      new(hexutil.Big),
+		CodeHash:     crypto.Keccak256Hash(nil),
+		StorageHash:  types.EmptyRootHash,
+		StorageProof: make([]StorageResult, len(storageKeys)),
+	}
+	// no account means that the storage trie does not exist either
+	if account != nil {
+		result.Balance = (*hexutil.Big)(account.Balance)
+		result.CodeHash = common.BytesToHash(account.CodeHash)
+		result.Nonce = hexutil.Uint64(account.Nonce)
+		result.StorageHash = account.Root
+	}
+	// create the proof for the storageKeys
+	for i, key := range storageKeys {
+		if account == nil {
+			result.StorageProof[i] = StorageResult{key, &hexutil.Big{}, []string{}}
+			continue
 		}
-
-		// create the proof for the storageKeys
-		for i, key := range storageKeys {
-			if storageTrie != nil {
-				proof, storageError := state.GetStorageProof(address, common.HexToHash(key))
-				if storageError != nil {
-					return nil, storageError
-				}
-				storageProof[i] = StorageResult{key, (*hexutil.Big)(state.GetState(address, common.HexToHash(key)).Big()), common.ToHexArray(proof)}
-			} else {
-				storageProof[i] = StorageResult{key, &hexutil.Big{}, []string{}}
-			}
+		hash := common.HexToHash(key)
+		value, err := tds.ReadAccountStorage(address, &hash)
+		if err != nil {
+			return nil, err
 		}
-
-		// create the accountProof
-		accountProof, proofErr := state.GetProof(address)
-		if proofErr != nil {
-			return nil, proofErr
+		proof, err := tds.GetStorageProof(address, hash)
+		if err != nil {
+			return nil, err
 		}
-
-		return &AccountResult{
-			Address:      address,
-			AccountProof: common.ToHexArray(accountProof),
-			Balance:      (*hexutil.Big)(state.GetBalance(address)),
-			CodeHash:     codeHash,
-			Nonce:        hexutil.Uint64(state.GetNonce(address)),
-			StorageHash:  storageHash,
-			StorageProof: storageProof,
-		}, state.Error()
-	*/
-	return nil, nil
+		result.StorageProof[i] = StorageResult{key, (*hexutil.Big)(new(big.Int).SetBytes(value)), common.ToHexArray(proof)}
+	}
+	// create the accountProof
+	accountProof, err := tds.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	result.AccountProof = common.ToHexArray(accountProof)
+	return result, nil
 }
 
 // GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
@@ -996,6 +1011,12 @@ func newRPCTransactionFromBlockIndex(b *types.Block, index uint64) *RPCTransacti
 	return newRPCTransaction(txs[index], b.Hash(), b.NumberU64(), index)
 }
 
+// NewRPCTransactionFromBlockIndex returns a transaction that will serialize to
+// the RPC representation, for the APIs served outside of this package.
+func NewRPCTransactionFromBlockIndex(b *types.Block, index uint64) *RPCTransaction {
+	return newRPCTransactionFromBlockIndex(b, index)
+}
+
 // newRPCRawTransactionFromBlockIndex returns the bytes of a transaction given a block and a transaction index.
 func newRPCRawTransactionFromBlockIndex(b *types.Block, index uint64) hexutil.Bytes {
 	txs := b.Transactions()
@@ -1523,13 +1544,42 @@ func NewPrivateDebugAPI(b Backend) *PrivateDebugAPI {
 	return &PrivateDebugAPI{b: b}
 }
 
-// ChaindbProperty returns leveldb properties of the chain database.
+// chaindbStatsSample is the number of keys read from each bucket for the
+// statistics of the live database, the full scan is left to geth db stats.
+const chaindbStatsSample = 100000
+
+// ChaindbProperty returns the properties of the chain database. The only
+// supported property is "stats", which is the per bucket statistics of a
+// sample of the keys, the buckets read only partially are marked with a +.
 func (api *PrivateDebugAPI) ChaindbProperty(property string) (string, error) {
-	return "N/A", nil
+	if property != "" && property != "stats" {
+		return "", fmt.Errorf("unknown chain database property %q", property)
+	}
+	stats, err := ethdb.SampleStats(api.b.ChainDb(), chaindbStatsSample)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Bucket\tKeys\tKey size\tValue size\tChange sets\tOldest change\tNewest change")
+	for _, s := range stats {
+		sampled := ""
+		if s.Sampled {
+			sampled = "+"
+		}
+		fmt.Fprintf(w, "%q\t%d%s\t%v\t%v\t%d\t%d\t%d\n", s.Name, s.Keys, sampled,
+			common.StorageSize(s.KeyBytes), common.StorageSize(s.ValueBytes),
+			s.ChangeSets, s.OldestChange, s.NewestChange)
+	}
+	w.Flush()
+	return buf.String(), nil
 }
 
+// ChaindbCompact is not supported while the node is running, the database has
+// to be compacted offline by the geth db compact command.
 func (api *PrivateDebugAPI) ChaindbCompact() error {
-	return nil
+	return errors.New("online compaction is not supported, stop the node and run geth db compact")
 }
 
 // SetHead rewinds the head of the blockchain to a previous block.
@@ -69,6 +69,10 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DatabaseEngine is the name of the storage engine used for the databases
+	// opened through the node (see ethdb.Engines). If empty, ethdb.DefaultEngine is used.
+	DatabaseEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -97,6 +101,12 @@ type Config struct {
 	// relative), then that specific path is enforced. An empty path disables IPC.
 	IPCPath string `toml:",omitempty"`
 
+	// DatabaseSocket is the requested location of the UNIX socket serving the
+	// read-only access to the node's databases to the other processes (see
+	// ethdb.RemoteDatabase). A simple file name is placed inside the data
+	// directory, a path name is used as is. An empty path disables the socket.
+	DatabaseSocket string `toml:",omitempty"`
+
 	// HTTPHost is the host interface on which to start the HTTP RPC server. If this
 	// field is empty, no HTTP API endpoint will be started.
 	HTTPHost string `toml:",omitempty"`
@@ -211,6 +221,22 @@ func (c *Config) IPCEndpoint() string {
 	return c.IPCPath
 }
 
+// DatabaseSocketEndpoint resolves the UNIX socket path serving the databases,
+// taking into account the data directory. The socket is not supported on
+// Windows.
+func (c *Config) DatabaseSocketEndpoint() string {
+	if c.DatabaseSocket == "" || runtime.GOOS == "windows" {
+		return ""
+	}
+	if filepath.Base(c.DatabaseSocket) == c.DatabaseSocket {
+		if c.DataDir == "" {
+			return filepath.Join(os.TempDir(), c.DatabaseSocket)
+		}
+		return filepath.Join(c.DataDir, c.DatabaseSocket)
+	}
+	return c.DatabaseSocket
+}
+
 // NodeDB returns the path to the discovery node database.
 func (c *Config) NodeDB() string {
 	if c.DataDir == "" {
@@ -58,6 +58,9 @@ type Node struct {
 	ipcListener net.Listener // IPC RPC listener socket to serve API requests
 	ipcHandler  *rpc.Server  // IPC RPC request handler to process the API requests
 
+	dbEndpoint string              // UNIX socket serving the databases (empty = disabled)
+	dbServer   *ethdb.RemoteServer // Server of the databases opened by the services
+
 	httpEndpoint  string       // HTTP endpoint (interface + port) to listen at (empty = HTTP disabled)
 	httpWhitelist []string     // HTTP RPC modules to allow through this endpoint
 	httpListener  net.Listener // HTTP RPC listener socket to server API requests
@@ -114,6 +117,7 @@ func New(conf *Config) (*Node, error) {
 		config:            conf,
 		serviceFuncs:      []ServiceConstructor{},
 		ipcEndpoint:       conf.IPCEndpoint(),
+		dbEndpoint:        conf.DatabaseSocketEndpoint(),
 		httpEndpoint:      conf.HTTPEndpoint(),
 		wsEndpoint:        conf.WSEndpoint(),
 		eventmux:          new(event.TypeMux),
@@ -188,6 +192,11 @@ func (n *Node) Start() error {
 	running := &p2p.Server{Config: n.serverConfig}
 	n.log.Info("Starting peer-to-peer node", "instance", n.serverConfig.Name)
 
+	// The databases opened by the services are served over the socket if enabled
+	var dbServer *ethdb.RemoteServer
+	if n.dbEndpoint != "" {
+		dbServer = ethdb.NewRemoteServer()
+	}
 	// Otherwise copy and specialize the P2P configuration
 	services := make(map[reflect.Type]Service)
 	for _, constructor := range n.serviceFuncs {
@@ -197,6 +206,7 @@ func (n *Node) Start() error {
 			services:       make(map[reflect.Type]Service),
 			EventMux:       n.eventmux,
 			AccountManager: n.accman,
+			dbServer:       dbServer,
 		}
 		for kind, s := range services { // copy needed for threaded access
 			ctx.services[kind] = s
@@ -234,8 +244,17 @@ func (n *Node) Start() error {
 		// Mark the service started for potential cleanup
 		started = append(started, kind)
 	}
+	// Serve the databases opened by the services to other processes
+	if err := n.startDatabaseSocket(dbServer); err != nil {
+		for _, service := range services {
+			service.Stop()
+		}
+		running.Stop()
+		return err
+	}
 	// Lastly start the configured RPC interfaces
 	if err := n.startRPC(services); err != nil {
+		n.stopDatabaseSocket()
 		for _, service := range services {
 			service.Stop()
 		}
@@ -353,6 +372,40 @@ func (n *Node) stopIPC() {
 	}
 }
 
+// startDatabaseSocket starts serving the databases over the UNIX socket.
+func (n *Node) startDatabaseSocket(server *ethdb.RemoteServer) error {
+	if server == nil {
+		return nil // Database socket disabled.
+	}
+	if err := os.MkdirAll(filepath.Dir(n.dbEndpoint), 0751); err != nil {
+		return err
+	}
+	// Remove the socket left over by the node that did not stop cleanly
+	if err := os.Remove(n.dbEndpoint); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", n.dbEndpoint)
+	if err != nil {
+		return err
+	}
+	os.Chmod(n.dbEndpoint, 0600)
+	go server.Serve(listener)
+	n.dbServer = server
+	n.log.Info("Database socket opened", "path", n.dbEndpoint)
+	return nil
+}
+
+// stopDatabaseSocket stops serving the databases, waiting for the requests in
+// progress to finish, so that the databases can be closed.
+func (n *Node) stopDatabaseSocket() {
+	if n.dbServer != nil {
+		n.dbServer.Stop()
+		n.dbServer = nil
+
+		n.log.Info("Database socket closed", "path", n.dbEndpoint)
+	}
+}
+
 // startHTTP initializes and starts the HTTP RPC endpoint.
 func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors []string, vhosts []string, timeouts rpc.HTTPTimeouts) error {
 	// Short circuit if the HTTP endpoint isn't being exposed
@@ -430,7 +483,8 @@ func (n *Node) Stop() error {
 		return ErrNodeStopped
 	}
 
-	// Terminate the API, services and the p2p server.
+	// Terminate the database socket, API, services and the p2p server.
+	n.stopDatabaseSocket()
 	n.stopWS()
 	n.stopHTTP()
 	n.stopIPC()
@@ -605,7 +659,7 @@ func (n *Node) OpenDatabase(name string) (ethdb.Database, error) {
 	if n.config.DataDir == "" {
 		return ethdb.NewMemDatabase(), nil
 	}
-	return ethdb.NewLDBDatabase(n.config.ResolvePath(name))
+	return ethdb.Open(n.config.DatabaseEngine, n.config.ResolvePath(name))
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -34,18 +34,25 @@ type ServiceContext struct {
 	services       map[reflect.Type]Service // Index of the already constructed services
 	EventMux       *event.TypeMux           // Event multiplexer used for decoupled notifications
 	AccountManager *accounts.Manager        // Account manager created by the node.
+	dbServer       *ethdb.RemoteServer      // Server of the databases to other processes (nil = disabled)
 }
 
 // OpenDatabase opens an existing database with the given name (or creates one
 // if no previous can be found) from within the node's data directory. If the
-// node is an ephemeral one, a memory database is returned.
+// node is an ephemeral one, a memory database is returned. If the database
+// socket is enabled, the database is served over it under the same name.
 func (ctx *ServiceContext) OpenDatabase(name string) (ethdb.Database, error) {
+	var db ethdb.Database
 	if ctx.config.DataDir == "" {
-		return ethdb.NewMemDatabase(), nil
+		db = ethdb.NewMemDatabase()
+	} else {
+		var err error
+		if db, err = ethdb.Open(ctx.config.DatabaseEngine, ctx.config.ResolvePath(name)); err != nil {
+			return nil, err
+		}
 	}
-	db, err := ethdb.NewLDBDatabase(ctx.config.ResolvePath(name))
-	if err != nil {
-		return nil, err
+	if ctx.dbServer != nil {
+		ctx.dbServer.Register(name, db)
 	}
 	return db, nil
 }
@@ -21,7 +21,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 // Tests that databases are correctly created persistent or ephemeral based on
@@ -61,6 +64,54 @@ func TestContextDatabases(t *testing.T) {
 	}
 }
 
+// Tests that the databases opened by the services are served over the database
+// socket while the node is running.
+func TestContextDatabaseSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("database socket is not supported on windows")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := testNodeConfig()
+	config.DatabaseSocket = filepath.Join(dir, "db.sock")
+	stack, err := New(config)
+	if err != nil {
+		t.Fatalf("failed to create protocol stack: %v", err)
+	}
+	defer stack.Close()
+
+	var db ethdb.Database
+	if err := stack.Register(func(ctx *ServiceContext) (Service, error) {
+		if db, err = ctx.OpenDatabase("chaindata"); err != nil {
+			return nil, err
+		}
+		return new(NoopService), db.Put([]byte("b"), []byte("key"), []byte("value"))
+	}); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("failed to start protocol stack: %v", err)
+	}
+	remote, err := ethdb.NewRemoteDatabase(config.DatabaseSocket, "chaindata")
+	if err != nil {
+		t.Fatalf("failed to connect to the database socket: %v", err)
+	}
+	defer remote.Close()
+	if v, err := remote.Get([]byte("b"), []byte("key")); err != nil || string(v) != "value" {
+		t.Errorf("remote value mismatch: have %q, %v, want %q", v, err, "value")
+	}
+	if err := stack.Stop(); err != nil {
+		t.Fatalf("failed to stop protocol stack: %v", err)
+	}
+	if _, err := remote.Get([]byte("b"), []byte("key")); err == nil {
+		t.Errorf("database is served after the node stopped")
+	}
+}
+
 // Tests that already constructed services can be retrieves by later ones.
 func TestContextServices(t *testing.T) {
 	stack, err := New(testNodeConfig())
@@ -193,11 +193,14 @@ func MakePreState(db ethdb.Database, accounts core.GenesisAlloc, blockNr uint64)
 	// Commit and re-open to start with a clean state.
 	tds.SetBlockNr(blockNr + 1)
 	statedb.Finalise(false, tds.TrieStateWriter())
-	statedb.Commit(false, tds.DbStateWriter())
+	// The storage roots of the accounts are only known after the root is computed
 	root, err := tds.IntermediateRoot(statedb, false)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := statedb.Commit(false, tds.DbStateWriter()); err != nil {
+		return nil, nil, err
+	}
 	tds, err = state.NewTrieDbState(root, db, blockNr)
 	if err != nil {
 		return nil, nil, err
@@ -49,8 +49,12 @@ var (
 	memcacheCommitSizeMeter  = metrics.NewRegisteredMeter("trie/memcache/commit/size", nil)
 )
 
-// SecureKeyPrefix is the database key prefix used to store trie node preimages.
-var SecureKeyPrefix = []byte("secure-key-")
+// SecureKeyPrefix is the database bucket used to store trie node preimages.
+var SecureKeyPrefix = ethdb.RegisterBucket(ethdb.BucketInfo{
+	Name:  []byte("secure-key-"),
+	Key:   "hash",
+	Value: "preimage of the hash",
+})
 
 // secureKeyLength is the length of the above prefix + 32byte hash.
 const secureKeyLength = 11 + 32
@@ -297,6 +297,16 @@ func (h *hasher) hashChildren(original node, bufOffset int) []byte {
 					// empty byte array
 					buffer[pos] = byte(128)
 					pos++
+				} else if vn, ok := n.child1.(valueNode); ok {
+					// The value of the branch is a byte array, not embedded RLP
+					if len(vn) == 1 && vn[0] < 128 {
+						buffer[pos] = vn[0]
+						pos++
+					} else {
+						pos = generateByteArrayLen(buffer, pos, len(vn))
+						copy(buffer[pos:], vn)
+						pos += len(vn)
+					}
 				} else {
 					// Reserve one byte for length
 					hashLen := h.hashInternal(n.child1, false, buffer[pos+1:], bufOffset+pos+1)
@@ -314,6 +324,16 @@ func (h *hasher) hashChildren(original node, bufOffset int) []byte {
 					// empty byte array
 					buffer[pos] = byte(128)
 					pos++
+				} else if vn, ok := n.child2.(valueNode); ok {
+					// The value of the branch is a byte array, not embedded RLP
+					if len(vn) == 1 && vn[0] < 128 {
+						buffer[pos] = vn[0]
+						pos++
+					} else {
+						pos = generateByteArrayLen(buffer, pos, len(vn))
+						copy(buffer[pos:], vn)
+						pos += len(vn)
+					}
 				} else {
 					// Reserve one byte for length
 					hashLen := h.hashInternal(n.child2, false, buffer[pos+1:], bufOffset+pos+1)
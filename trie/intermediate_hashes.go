@@ -0,0 +1,282 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// The intermediate hashes are the hashes of the children of the branch nodes
+// in the top levels of the tries, which let the resolver construct these nodes
+// without walking over all the keys below them. The cached nodes are always
+// checked against the expected hash before use, so a stale entry only makes
+// the resolver fall back to the walk over the keys.
+
+// IntermediateHashBucket is the bucket of the intermediate hashes.
+var IntermediateHashBucket = ethdb.RegisterBucket(ethdb.BucketInfo{
+	Name:  []byte("IH"),
+	Key:   "prefix of the storage trie (none for the account trie) + path to the branch node, one byte per nibble, terminated by 16",
+	Value: "mask of the children (uint16 big endian) + hashes of the children in the order of the nibbles",
+})
+
+// IntermediateHashLevels is the number of the top levels of every trie whose
+// branch nodes are cached in the IntermediateHashBucket.
+const IntermediateHashLevels = 5
+
+// intermediateHashKey returns the key of the branch node at the path. The path
+// is terminated like the hex keys, so that the key of the root is not empty.
+func intermediateHashKey(prefix, hex []byte) []byte {
+	key := make([]byte, len(prefix)+len(hex)+1)
+	copy(key, prefix)
+	copy(key[len(prefix):], hex)
+	key[len(key)-1] = 16
+	return key
+}
+
+// childAt returns the child of the branch node at the nibble.
+func childAt(n node, nibble byte) node {
+	switch n := n.(type) {
+	case *fullNode:
+		return n.Children[nibble]
+	case *duoNode:
+		i1, i2 := n.childrenIdx()
+		switch nibble {
+		case i1:
+			return n.child1
+		case i2:
+			return n.child2
+		}
+	}
+	return nil
+}
+
+// setChildAt replaces the existing child of the branch node at the nibble.
+func setChildAt(n node, nibble byte, child node) {
+	switch n := n.(type) {
+	case *fullNode:
+		n.Children[nibble] = child
+	case *duoNode:
+		if i1, _ := n.childrenIdx(); nibble == i1 {
+			n.child1 = child
+		} else {
+			n.child2 = child
+		}
+	}
+}
+
+// encodeBranch encodes the hashes of the children of the branch node. It
+// returns false if the node is not a branch node, or if any of its children is
+// embedded into it rather than referenced by the hash.
+func encodeBranch(h *hasher, n node) ([]byte, bool) {
+	var children [17]node
+	switch n := n.(type) {
+	case *fullNode:
+		children = n.Children
+	case *duoNode:
+		i1, i2 := n.childrenIdx()
+		children[i1], children[i2] = n.child1, n.child2
+	default:
+		return nil, false
+	}
+	if children[16] != nil {
+		return nil, false
+	}
+	var (
+		mask uint16
+		hash common.Hash
+		enc  = make([]byte, 2, 2+16*common.HashLength)
+	)
+	for i, child := range children[:16] {
+		if child == nil {
+			continue
+		}
+		if h.hash(child, false, hash[:]) < common.HashLength {
+			return nil, false
+		}
+		mask |= 1 << uint(i)
+		enc = append(enc, hash[:]...)
+	}
+	binary.BigEndian.PutUint16(enc, mask)
+	return enc, true
+}
+
+// decodeBranch constructs the branch node with the hashNode children from its
+// encoding, or returns nil if the encoding is invalid.
+func decodeBranch(enc []byte) node {
+	if len(enc) < 2 {
+		return nil
+	}
+	mask := binary.BigEndian.Uint16(enc)
+	count := bits.OnesCount16(mask)
+	if count < 2 || len(enc) != 2+count*common.HashLength {
+		return nil
+	}
+	hashes := enc[2:]
+	next := func() hashNode {
+		hn := hashNode(common.CopyBytes(hashes[:common.HashLength]))
+		hashes = hashes[common.HashLength:]
+		return hn
+	}
+	if count == 2 {
+		d := &duoNode{mask: uint32(mask)}
+		d.child1 = next()
+		d.child2 = next()
+		d.flags.dirty = true
+		return d
+	}
+	f := &fullNode{}
+	for i := 0; i < 16; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			f.Children[i] = next()
+		}
+	}
+	f.flags.dirty = true
+	return f
+}
+
+// loadBranch reads the branch node at the path from the intermediate hashes,
+// and returns it if it has the expected hash.
+func loadBranch(db ethdb.Getter, h *hasher, prefix, hex []byte, expected hashNode) node {
+	enc, err := db.Get(IntermediateHashBucket, intermediateHashKey(prefix, hex))
+	if err != nil {
+		return nil
+	}
+	n := decodeBranch(enc)
+	if n == nil {
+		return nil
+	}
+	var hash common.Hash
+	if h.hash(n, false, hash[:]) != common.HashLength || !bytes.Equal(hash[:], expected) {
+		return nil
+	}
+	return n
+}
+
+// HasIntermediateHashes reports whether the intermediate hashes hold the root
+// of the trie with the given prefix and root hash. Only the current tries are
+// kept in the intermediate hashes, so the nodes of such a trie are resolved
+// without walking over all of its keys.
+func HasIntermediateHashes(db ethdb.Getter, prefix []byte, root common.Hash) bool {
+	h := newHasher(false)
+	defer returnHasherToPool(h)
+	return loadBranch(db, h, prefix, nil, root[:]) != nil
+}
+
+// resolveCached resolves the continuation from the intermediate hashes if the
+// node to resolve is cached. Along the resolve key, the resolved node is
+// expanded down to the deepest cached branch node, the rest of it is left
+// as the hashNodes for the later resolution.
+func (tr *TrieResolver) resolveCached(db ethdb.Getter, c *TrieContinuation) bool {
+	if c.resolvePos >= IntermediateHashLevels {
+		return false
+	}
+	root := loadBranch(db, tr.h, c.t.prefix, c.resolveKey[:c.resolvePos], c.resolveHash)
+	if root == nil {
+		return false
+	}
+	parent := root
+	for pos := c.resolvePos + 1; pos < IntermediateHashLevels && pos < len(c.resolveKey); pos++ {
+		nibble := c.resolveKey[pos-1]
+		hn, ok := childAt(parent, nibble).(hashNode)
+		if !ok {
+			break
+		}
+		child := loadBranch(db, tr.h, c.t.prefix, c.resolveKey[:pos], hn)
+		if child == nil {
+			break
+		}
+		setChildAt(parent, nibble, child)
+		parent = child
+	}
+	c.resolved = root
+	return true
+}
+
+// PutIntermediateHashes refreshes the intermediate hashes on the path to the
+// key: the branch nodes in the top IntermediateHashLevels levels are written,
+// and the entries of the levels where the path has no branch node are deleted.
+// It is called after the trie has been updated with the changes of the key.
+// The entries below the parts of the trie that are not loaded are left as they
+// are.
+func (t *Trie) PutIntermediateHashes(db ethdb.Database, key []byte) error {
+	h := newHasher(t.encodeToBytes)
+	defer returnHasherToPool(h)
+	hex := keybytesToHex(key)
+	// deleteTo deletes the entries of the levels from pos up to the given one
+	deleteTo := func(pos, level int) error {
+		for ; pos < level && pos < IntermediateHashLevels; pos++ {
+			if err := db.Delete(IntermediateHashBucket, intermediateHashKey(t.prefix, hex[:pos])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	n, pos := t.root, 0
+	for pos < IntermediateHashLevels {
+		switch nd := n.(type) {
+		case *fullNode, *duoNode:
+			if enc, ok := encodeBranch(h, n); ok {
+				if err := db.Put(IntermediateHashBucket, intermediateHashKey(t.prefix, hex[:pos]), enc); err != nil {
+					return err
+				}
+			} else if err := deleteTo(pos, pos+1); err != nil {
+				return err
+			}
+			n = childAt(n, hex[pos])
+			pos++
+		case *shortNode:
+			nKey := compactToHex(nd.Key)
+			if !bytes.HasPrefix(hex[pos:], nKey) {
+				// The path leaves the trie here
+				return deleteTo(pos, IntermediateHashLevels)
+			}
+			if err := deleteTo(pos, pos+len(nKey)); err != nil {
+				return err
+			}
+			n = nd.Val
+			pos += len(nKey)
+		case hashNode:
+			return nil
+		default:
+			return deleteTo(pos, IntermediateHashLevels)
+		}
+	}
+	return nil
+}
+
+// DeleteIntermediateHashes deletes the intermediate hashes of the storage trie
+// with the given prefix.
+func DeleteIntermediateHashes(db ethdb.Database, prefix []byte) error {
+	var keys [][]byte
+	if err := db.Walk(IntermediateHashBucket, prefix, uint(8*len(prefix)), func(k, _ []byte) (bool, error) {
+		keys = append(keys, common.CopyBytes(k))
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := db.Delete(IntermediateHashBucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
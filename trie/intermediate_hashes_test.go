@@ -0,0 +1,119 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+var (
+	testStorageBucket = []byte("ST")
+	testStoragePrefix = common.HexToAddress("0x1234567890123456789012345678901234567890").Bytes()
+)
+
+// makeStorageTrie fills the storage trie and the database with random keys,
+// and writes the intermediate hashes for all of them to the hash database.
+func makeStorageTrie(t *testing.T, db, hashDb ethdb.Database, n int) (*Trie, [][]byte) {
+	tr := New(common.Hash{}, testStorageBucket, testStoragePrefix, true)
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([][]byte, n)
+	for i := range keys {
+		key := make([]byte, 32)
+		rnd.Read(key)
+		value := []byte{byte(i), byte(i >> 8), 1}
+		tr.Update(db, key, value, 0)
+		if err := db.Put(testStorageBucket, append(common.CopyBytes(testStoragePrefix), key...), value); err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	tr.Hash()
+	for _, key := range keys {
+		if err := tr.PutIntermediateHashes(hashDb, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tr, keys
+}
+
+func resolveRoot(t *testing.T, db ethdb.Database, root common.Hash, key []byte) *TrieContinuation {
+	tr := New(root, testStorageBucket, testStoragePrefix, true)
+	c := tr.NewContinuation(keybytesToHex(key), 0, root[:])
+	r := NewResolver(nil, false, false)
+	r.AddContinuation(c)
+	if err := r.ResolveWithDb(db, 0); err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	return c
+}
+
+func TestIntermediateHashesResolve(t *testing.T) {
+	db, hashDb := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	tr, keys := makeStorageTrie(t, db, hashDb, 2000)
+	root := tr.Hash()
+
+	// The database with the intermediate hashes only is enough to resolve
+	// the top levels of the trie
+	c := resolveRoot(t, hashDb, root, keys[0])
+	hex := keybytesToHex(keys[0])
+	n := c.resolved
+	for pos := 0; pos < 2; pos++ {
+		if _, ok := encodeBranch(newHasher(true), n); !ok {
+			t.Fatalf("level %d is not resolved from the intermediate hashes: %T", pos, n)
+		}
+		n = childAt(n, hex[pos])
+	}
+	// The stale entry makes the resolver fall back to the walk over the keys
+	if err := db.Put(IntermediateHashBucket, intermediateHashKey(testStoragePrefix, nil), []byte{0, 3, 1}); err != nil {
+		t.Fatal(err)
+	}
+	c = resolveRoot(t, db, root, keys[0])
+	if c.resolved == nil {
+		t.Fatal("root is not resolved")
+	}
+}
+
+func TestIntermediateHashesDelete(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	tr, keys := makeStorageTrie(t, db, db, 100)
+	// Deleting all but one key leaves no branch nodes on the path
+	for _, key := range keys[1:] {
+		tr.Delete(db, key, 0)
+	}
+	tr.Hash()
+	for _, key := range keys {
+		if err := tr.PutIntermediateHashes(db, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Get(IntermediateHashBucket, intermediateHashKey(testStoragePrefix, nil)); err != ethdb.ErrKeyNotFound {
+		t.Errorf("root entry is not deleted: %v", err)
+	}
+	if err := DeleteIntermediateHashes(db, testStoragePrefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Walk(IntermediateHashBucket, nil, 0, func(k, _ []byte) (bool, error) {
+		t.Errorf("entry %x is left", k)
+		return true, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
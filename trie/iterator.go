@@ -23,7 +23,6 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
-	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // Iterator is a key-value trie iterator that traverses a Trie.
@@ -189,11 +188,8 @@ func (it *nodeIterator) LeafProof() [][]byte {
 
 			for i, item := range it.stack[:len(it.stack)-1] {
 				// Gather nodes that end up as hash nodes (or the root)
-				ch := hasher.hashChildren(item.node, 0)
-				var hn common.Hash
-				hashLen := hasher.store(ch, false, hn[:])
-				if hashLen == 32 || i == 0 {
-					enc, _ := rlp.EncodeToBytes(hn[:])
+				enc := common.CopyBytes(hasher.hashChildren(item.node, 0))
+				if len(enc) >= 32 || i == 0 {
 					proofs = append(proofs, enc)
 				}
 			}
@@ -94,23 +94,29 @@ func TestIteratorLargeData(t *testing.T) {
 	}
 }
 
-// Tests that the node iterator indeed walks over the entire database contents.
+// Tests that the node iterator indeed walks over the entire trie contents. The
+// nodes of the trie are only kept in memory, so it is checked by the leaves.
 func TestNodeIteratorCoverage(t *testing.T) {
 	// Create some arbitrary test trie to iterate
-	db, trie, _ := makeTestTrie()
+	_, trie, content := makeTestTrie()
 
-	// Gather all the node hashes found by the iterator
-	hashes := make(map[common.Hash]struct{})
+	// Gather all the leaves found by the iterator
+	leaves := make(map[string][]byte)
 	for it := trie.NodeIterator(nil, nil, 0); it.Next(true); {
-		if it.Hash() != (common.Hash{}) {
-			hashes[it.Hash()] = struct{}{}
+		if it.Leaf() {
+			leaves[string(it.LeafKey())] = common.CopyBytes(it.LeafBlob())
 		}
 	}
-	for keys, i := db.(ethdb.Mutation).Keys(), 0; i < len(keys); i += 2 {
-		if _, ok := hashes[common.BytesToHash(keys[i+1])]; !ok {
-			t.Errorf("state entry not reported %x", keys[i+1])
+	for key, val := range content {
+		if have, ok := leaves[key]; !ok {
+			t.Errorf("leaf not reported %x", key)
+		} else if !bytes.Equal(have, val) {
+			t.Errorf("leaf %x value mismatch: have %x, want %x", key, have, val)
 		}
 	}
+	if len(leaves) != len(content) {
+		t.Errorf("leaf count mismatch: have %d, want %d", len(leaves), len(content))
+	}
 }
 
 type kvs struct{ k, v string }
@@ -259,7 +265,7 @@ func TestUnionIterator(t *testing.T) {
 }
 
 func TestIteratorNoDups(t *testing.T) {
-	var tr Trie
+	tr := New(common.Hash{}, testbucket, nil, false)
 	for _, val := range testdata1 {
 		tr.Update(nil, []byte(val.k), []byte(val.v), 0)
 	}
@@ -271,7 +277,7 @@ func testIteratorContinueAfterErrorDisk(t *testing.T)    { testIteratorContinueA
 func testIteratorContinueAfterErrorMemonly(t *testing.T) { testIteratorContinueAfterError(t, true) }
 
 func testIteratorContinueAfterError(t *testing.T, memonly bool) {
-	diskdb := ethdb.NewMemDatabase()
+	diskdb := ethdb.NewMemDatabase().NewBatch()
 
 	bucket := []byte("B")
 	tr := New(common.Hash{}, bucket, nil, false)
@@ -24,7 +24,6 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // Prove constructs a merkle proof for key. The result contains all encoded nodes
@@ -43,21 +42,31 @@ func (t *Trie) Prove(db ethdb.Database, key []byte, fromLevel uint, proofDb ethd
 	for pos < len(key) && tn != nil {
 		switch n := tn.(type) {
 		case *shortNode:
-			if len(key)-pos < len(n.Key) || !bytes.Equal(n.Key, key[pos:pos+len(n.Key)]) {
+			nKey := compactToHex(n.Key)
+			if len(key)-pos < len(nKey) || !bytes.Equal(nKey, key[pos:pos+len(nKey)]) {
 				// The trie doesn't contain the key.
 				tn = nil
 			} else {
 				tn = n.Val
-				pos += len(n.Key)
+				pos += len(nKey)
 			}
 			nodes = append(nodes, n)
+		case *duoNode:
+			tn = childAt(n, key[pos])
+			pos++
+			nodes = append(nodes, n)
 		case *fullNode:
 			tn = n.Children[key[pos]]
 			pos++
 			nodes = append(nodes, n)
 		case hashNode:
+			// The nodes missing from memory are resolved (as of the block
+			// for the historical tries) without changing the trie
 			var err error
 			tn, err = t.resolveHash(db, n, key, pos, blockNr)
+			if err == ErrWalkLimit {
+				return err
+			}
 			if err != nil {
 				log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
 				return err
@@ -66,26 +75,20 @@ func (t *Trie) Prove(db ethdb.Database, key []byte, fromLevel uint, proofDb ethd
 			panic(fmt.Sprintf("%T: invalid node: %v", tn, tn))
 		}
 	}
-	hasher := newHasher(false)
+	hasher := newHasher(t.encodeToBytes)
 	defer returnHasherToPool(hasher)
 	for i, n := range nodes {
-		// Don't bother checking for errors here since hasher panics
-		// if encoding doesn't work and we're not writing to any database.
-		ch := hasher.hashChildren(n, 0)
-		var hn common.Hash
-		hashLen := hasher.store(ch, false, hn[:]) // Since the second argument is nil, no database write will occur
-		hash := hn[:hashLen]
-		if hashLen == 32 || i == 0 {
+		// The encoding refers to the buffers of the hasher, so it is copied
+		enc := common.CopyBytes(hasher.hashChildren(n, 0))
+		if len(enc) >= 32 || i == 0 {
 			// If the node's database encoding is a hash (or is the
 			// root node), it becomes a proof element.
 			if fromLevel > 0 {
 				fromLevel--
 			} else {
-				enc, _ := rlp.EncodeToBytes(hash)
-				if hashLen < 32 {
-					hash = crypto.Keccak256(enc)
+				if err := proofDb.Put([]byte{}, crypto.Keccak256(enc), enc); err != nil {
+					return err
 				}
-				proofDb.Put([]byte{}, hash, enc)
 			}
 		}
 	}
@@ -40,16 +40,16 @@ func makeProvers(trie *Trie) []func(key []byte) ethdb.Database {
 
 	// Create a direct trie based Merkle prover
 	provers = append(provers, func(key []byte) ethdb.Database {
-		proof := ethdb.NewMemDatabase()
+		proof := ethdb.NewMemDatabase().NewBatch()
 		trie.Prove(proof, key, 0, proof, 0)
 		return proof
 	})
 	// Create a leaf iterator based Merkle prover
 	provers = append(provers, func(key []byte) ethdb.Database {
-		proof := ethdb.NewMemDatabase()
+		proof := ethdb.NewMemDatabase().NewBatch()
 		if it := NewIterator(trie.NodeIterator(proof, key, 0)); it.Next() && bytes.Equal(key, it.Key) {
 			for _, p := range it.Prove() {
-				proof.Put(testbucket, crypto.Keccak256(p), p)
+				proof.Put([]byte{}, crypto.Keccak256(p), p)
 			}
 		}
 		return proof
@@ -78,7 +78,7 @@ func TestProof(t *testing.T) {
 }
 
 func testOneElementProof(t *testing.T) {
-	trie := new(Trie)
+	trie := New(common.Hash{}, testbucket, nil, false)
 	db := ethdb.NewMemDatabase()
 	updateString(trie, db, "k", "v")
 	for i, prover := range makeProvers(trie) {
@@ -108,12 +108,13 @@ func TestBadProof(t *testing.T) {
 			if proof == nil {
 				t.Fatalf("prover %d: nil proof", i)
 			}
-			key := proof.(ethdb.Mutation).Keys()[mrand.Intn(proof.Size())/2]
-			val, _ := proof.Get(testbucket, key)
-			proof.Delete(testbucket, key)
+			keys := proof.(ethdb.Mutation).Keys()
+			key := keys[2*mrand.Intn(len(keys)/2)+1]
+			val, _ := proof.Get([]byte{}, key)
+			proof.Delete([]byte{}, key)
 
 			mutateByte(val)
-			proof.Put(testbucket, crypto.Keccak256(val), val)
+			proof.Put([]byte{}, crypto.Keccak256(val), val)
 
 			if _, _, err := VerifyProof(root, kv.k, proof); err == nil {
 				t.Fatalf("prover %d: expected proof to fail for key %x", i, kv.k)
@@ -125,16 +126,16 @@ func TestBadProof(t *testing.T) {
 // Tests that missing keys can also be proven. The test explicitly uses a single
 // entry trie and checks for missing keys both before and after the single entry.
 func TestMissingKeyProof(t *testing.T) {
-	trie := new(Trie)
+	trie := New(common.Hash{}, testbucket, nil, false)
 	db := ethdb.NewMemDatabase()
 	updateString(trie, db, "k", "v")
 
 	for i, key := range []string{"a", "j", "l", "z"} {
-		proof := ethdb.NewMemDatabase()
+		proof := ethdb.NewMemDatabase().NewBatch()
 		trie.Prove(proof, []byte(key), 0, proof, 0)
 
-		if proof.Size() != 1 {
-			t.Errorf("test %d: proof should have one element", i)
+		if n := len(proof.(ethdb.Mutation).Keys()) / 2; n != 1 {
+			t.Errorf("test %d: proof should have one element, has %d", i, n)
 		}
 		val, _, err := VerifyProof(trie.Hash(), []byte(key), proof)
 		if err != nil {
@@ -167,7 +168,7 @@ func benchmarkProve(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		kv := vals[keys[i%len(keys)]]
-		proofs := ethdb.NewMemDatabase()
+		proofs := ethdb.NewMemDatabase().NewBatch()
 		if trie.Prove(nil, kv.k, 0, proofs, 0); len(proofs.Keys()) == 0 {
 			b.Fatalf("zero length proof for %x", kv.k)
 		}
@@ -181,7 +182,7 @@ func benchmarkVerifyProof(b *testing.B) {
 	var proofs []ethdb.Mutation
 	for k := range vals {
 		keys = append(keys, k)
-		proof := ethdb.NewMemDatabase()
+		proof := ethdb.NewMemDatabase().NewBatch()
 		trie.Prove(nil, []byte(k), 0, proof, 0)
 		proofs = append(proofs, proof)
 	}
@@ -198,8 +199,7 @@ func benchmarkVerifyProof(b *testing.B) {
 var testbucket = []byte("B")
 
 func randomTrie(n int) (*Trie, map[string]*kv) {
-	trie := new(Trie)
-	trie.prefix = testbucket
+	trie := New(common.Hash{}, testbucket, nil, false)
 	vals := make(map[string]*kv)
 	for i := byte(0); i < 100; i++ {
 		value := &kv{common.LeftPadBytes([]byte{i}, 32), []byte{i}, false}
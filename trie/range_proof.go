@@ -0,0 +1,265 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+var errRangeMismatch = errors.New("range does not match the root")
+
+// VerifyRangeProof checks that the keys, in the ascending order, with their
+// values are all the leaves of the trie with the given root from origin up to
+// the last of the keys. The proof holds the encoded nodes on the paths to the
+// origin and to the last key, as created by Prove; the subtries left of the
+// origin and right of the last key are only known by their hashes from it.
+// If there are no keys, the proof shows that there is no leaf after origin.
+// Without the proof, the keys must be all the leaves of the trie.
+//
+// The values are given as they are stored in the bucket of the trie, which is
+// why encodeToBytes has to match the trie. The returned flag tells whether the
+// trie has leaves after the last key.
+func VerifyRangeProof(root common.Hash, origin []byte, keys [][]byte, values [][]byte, proof [][]byte, encodeToBytes bool) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("%d keys with %d values", len(keys), len(values))
+	}
+	hexKeys := make([][]byte, len(keys))
+	leaves := make([][]byte, len(values))
+	for i, key := range keys {
+		if len(key) != len(origin) {
+			return false, fmt.Errorf("key %x of wrong length", key)
+		}
+		if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			return false, fmt.Errorf("key %x out of order", key)
+		}
+		if len(values[i]) == 0 {
+			return false, fmt.Errorf("empty value of key %x", key)
+		}
+		hexKeys[i] = keybytesToHex(key)
+		leaves[i] = values[i]
+		if encodeToBytes {
+			enc, err := rlp.EncodeToBytes(values[i])
+			if err != nil {
+				return false, err
+			}
+			leaves[i] = enc
+		}
+	}
+	if len(keys) > 0 && bytes.Compare(keys[0], origin) < 0 {
+		return false, fmt.Errorf("key %x before the origin", keys[0])
+	}
+	if len(proof) == 0 {
+		if hashRange(buildRange(hexKeys, leaves, 0)) != root {
+			return false, errRangeMismatch
+		}
+		return false, nil
+	}
+	v := &rangeVerifier{
+		nodes: make(map[common.Hash][]byte, len(proof)),
+		left:  keybytesToHex(origin),
+	}
+	for _, enc := range proof {
+		v.nodes[crypto.Keccak256Hash(enc)] = enc
+	}
+	if len(keys) > 0 {
+		v.right = hexKeys[len(hexKeys)-1]
+	}
+	n, err := v.rebuild(hashNode(root[:]), nil, hexKeys, leaves, true, v.right != nil)
+	if err != nil {
+		return false, err
+	}
+	if hashRange(n) != root {
+		return false, errRangeMismatch
+	}
+	return v.more, nil
+}
+
+// rangeVerifier rebuilds the trie from the range and the edge proofs.
+type rangeVerifier struct {
+	nodes map[common.Hash][]byte // Proof nodes by their hashes
+	left  []byte                 // Hex path of the origin
+	right []byte                 // Hex path of the last key, nil for the open range
+	more  bool                   // Whether there are leaves right of the range
+}
+
+// rebuild rebuilds the node at the path, whose leaves within the range are the
+// keys. The node lies on the path of the origin if onLeft is set, and on the
+// path of the last key if onRight is set. The parts of the node outside of the
+// range are taken from the proof, the ones inside are built from the keys.
+func (v *rangeVerifier) rebuild(n node, path []byte, keys, values [][]byte, onLeft, onRight bool) (node, error) {
+	if !onLeft && !onRight {
+		return buildRange(keys, values, len(path)), nil
+	}
+	if hn, ok := n.(hashNode); ok {
+		enc, ok := v.nodes[common.BytesToHash(hn)]
+		if !ok {
+			return nil, fmt.Errorf("proof node %x (path %x) missing", []byte(hn), path)
+		}
+		var err error
+		if n, err = decodeNode(hn, enc); err != nil {
+			return nil, fmt.Errorf("bad proof node %x: %v", []byte(hn), err)
+		}
+	}
+	switch n := n.(type) {
+	case nil:
+		// The trie has nothing on the edge
+		if len(keys) > 0 {
+			return nil, errRangeMismatch
+		}
+		return nil, nil
+
+	case *shortNode:
+		full := append(append([]byte{}, path...), n.Key...)
+		leaf := hasTerm(full)
+		if leaf {
+			full = full[:len(full)-1]
+		}
+		if len(full) > len(v.left)-1 || (leaf && len(full) != len(v.left)-1) {
+			return nil, fmt.Errorf("short node key of wrong length (path %x)", path)
+		}
+		if onLeft {
+			switch c := bytes.Compare(full, v.left[:len(full)]); {
+			case c < 0:
+				return normalise(n), nil
+			case c > 0 || leaf:
+				onLeft = false
+			}
+		}
+		if onRight {
+			switch c := bytes.Compare(full, v.right[:len(full)]); {
+			case c > 0:
+				v.more = true
+				return normalise(n), nil
+			case c < 0 || leaf:
+				onRight = false
+			}
+		}
+		if !onLeft && !onRight {
+			return buildRange(keys, values, len(path)), nil
+		}
+		for _, key := range keys {
+			if !bytes.HasPrefix(key, full) {
+				return nil, errRangeMismatch
+			}
+		}
+		child, err := v.rebuild(n.Val, full, keys, values, onLeft, onRight)
+		if err != nil {
+			return nil, err
+		}
+		return &shortNode{Key: hexToCompact(n.Key), Val: child, flags: nodeFlag{dirty: true}}, nil
+
+	case *fullNode:
+		if len(path) >= len(v.left)-1 {
+			return nil, fmt.Errorf("branch node too deep (path %x)", path)
+		}
+		rebuilt := &fullNode{flags: nodeFlag{dirty: true}}
+		rebuilt.Children[16] = n.Children[16]
+		depth := len(path)
+		for i := byte(0); i < 16; i++ {
+			start := 0
+			for start < len(keys) && keys[start][depth] < i {
+				start++
+			}
+			end := start
+			for end < len(keys) && keys[end][depth] == i {
+				end++
+			}
+			child := n.Children[i]
+			switch {
+			case onLeft && i < v.left[depth]:
+				rebuilt.Children[i] = normalise(child)
+			case onRight && i > v.right[depth]:
+				if child != nil {
+					v.more = true
+				}
+				rebuilt.Children[i] = normalise(child)
+			default:
+				childPath := append(append([]byte{}, path...), i)
+				rc, err := v.rebuild(child, childPath, keys[start:end], values[start:end], onLeft && i == v.left[depth], onRight && i == v.right[depth])
+				if err != nil {
+					return nil, err
+				}
+				rebuilt.Children[i] = rc
+			}
+			keys, values = keys[end:], values[end:]
+		}
+		return rebuilt, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected %T node (path %x)", n, path)
+	}
+}
+
+// buildRange builds the node at the given depth out of all its leaves.
+func buildRange(keys, values [][]byte, depth int) node {
+	switch len(keys) {
+	case 0:
+		return nil
+	case 1:
+		return &shortNode{Key: hexToCompact(keys[0][depth:]), Val: valueNode(values[0]), flags: nodeFlag{dirty: true}}
+	}
+	if shared := prefixLen(keys[0][depth:], keys[len(keys)-1][depth:]); shared > 0 {
+		child := buildRange(keys, values, depth+shared)
+		return &shortNode{Key: hexToCompact(keys[0][depth : depth+shared]), Val: child, flags: nodeFlag{dirty: true}}
+	}
+	n := &fullNode{flags: nodeFlag{dirty: true}}
+	for start := 0; start < len(keys); {
+		end := start + 1
+		for end < len(keys) && keys[end][depth] == keys[start][depth] {
+			end++
+		}
+		n.Children[keys[start][depth]] = buildRange(keys[start:end], values[start:end], depth+1)
+		start = end
+	}
+	return n
+}
+
+// normalise converts the node decoded from the proof, with the hex keys,
+// into the form taken by the hasher.
+func normalise(n node) node {
+	switch n := n.(type) {
+	case *shortNode:
+		return &shortNode{Key: hexToCompact(n.Key), Val: normalise(n.Val), flags: nodeFlag{dirty: true}}
+	case *fullNode:
+		c := &fullNode{flags: nodeFlag{dirty: true}}
+		for i, child := range n.Children {
+			c.Children[i] = normalise(child)
+		}
+		return c
+	default:
+		return n
+	}
+}
+
+// hashRange returns the hash of the rebuilt root node.
+func hashRange(n node) common.Hash {
+	if n == nil {
+		return emptyRoot
+	}
+	h := newHasher(false)
+	defer returnHasherToPool(h)
+
+	var hash common.Hash
+	h.hash(n, true, hash[:])
+	return hash
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	mrand "math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type rangeProof [][]byte
+
+func (p *rangeProof) Put(bucket, key, value []byte) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (p *rangeProof) PutS(hBucket, key, value []byte, timestamp uint64) error {
+	panic("rangeProof does not keep the history")
+}
+
+func (p *rangeProof) DeleteTimestamp(timestamp uint64) error {
+	panic("rangeProof does not keep the history")
+}
+
+// sortedTrie creates a trie of random 32 byte keys, returning the entries in
+// the order of the keys. Half of the values are short enough to be embedded.
+func sortedTrie(n int, encodeToBytes bool) (*Trie, []*kv) {
+	trie := New(common.Hash{}, testbucket, nil, encodeToBytes)
+	entries := make([]*kv, n)
+	for i := range entries {
+		size := 1 + mrand.Intn(40)
+		entries[i] = &kv{k: randBytes(32), v: randBytes(size)}
+		trie.Update(nil, entries[i].k, entries[i].v, 0)
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].k, entries[j].k) < 0 })
+	return trie, entries
+}
+
+func proveRange(t *testing.T, trie *Trie, keys ...[]byte) [][]byte {
+	var proof rangeProof
+	for _, key := range keys {
+		if err := trie.Prove(nil, key, 0, &proof, 0); err != nil {
+			t.Fatalf("failed to prove %x: %v", key, err)
+		}
+	}
+	return proof
+}
+
+func splitEntries(entries []*kv) ([][]byte, [][]byte) {
+	keys := make([][]byte, len(entries))
+	values := make([][]byte, len(entries))
+	for i, e := range entries {
+		keys[i], values[i] = e.k, e.v
+	}
+	return keys, values
+}
+
+func TestRangeProof(t *testing.T) {
+	for _, encodeToBytes := range []bool{false, true} {
+		trie, entries := sortedTrie(500, encodeToBytes)
+		root := trie.Hash()
+		for i := 0; i < 200; i++ {
+			start := mrand.Intn(len(entries))
+			end := start + 1 + mrand.Intn(len(entries)-start)
+
+			// The origin is either the first key or a key before it
+			origin := common.CopyBytes(entries[start].k)
+			if i%2 == 1 && origin[31] > 0 && (start == 0 || bytes.Compare(entries[start-1].k[:31], origin[:31]) < 0) {
+				origin[31]--
+			}
+			keys, values := splitEntries(entries[start:end])
+			more, err := VerifyRangeProof(root, origin, keys, values, proveRange(t, trie, origin, keys[len(keys)-1]), encodeToBytes)
+			if err != nil {
+				t.Fatalf("range %d-%d: %v", start, end, err)
+			}
+			if more != (end < len(entries)) {
+				t.Fatalf("range %d-%d: have more %t, want %t", start, end, more, end < len(entries))
+			}
+		}
+		// The whole trie needs no proof
+		keys, values := splitEntries(entries)
+		if more, err := VerifyRangeProof(root, make([]byte, 32), keys, values, nil, encodeToBytes); err != nil || more {
+			t.Fatalf("whole trie: more %t, err %v", more, err)
+		}
+		// Nothing after the last key
+		origin := common.CopyBytes(entries[len(entries)-1].k)
+		origin[31]++
+		if more, err := VerifyRangeProof(root, origin, nil, nil, proveRange(t, trie, origin), encodeToBytes); err != nil || more {
+			t.Fatalf("empty tail: more %t, err %v", more, err)
+		}
+	}
+}
+
+func TestBadRangeProof(t *testing.T) {
+	trie, entries := sortedTrie(500, false)
+	root := trie.Hash()
+	for i := 0; i < 200; i++ {
+		start := mrand.Intn(len(entries) - 3)
+		end := start + 3 + mrand.Intn(len(entries)-start-3)
+
+		keys, values := splitEntries(entries[start:end])
+		proof := proveRange(t, trie, keys[0], keys[len(keys)-1])
+
+		var name string
+		switch i % 5 {
+		case 0:
+			name = "missing first"
+			keys, values = keys[1:], values[1:]
+		case 1:
+			name = "missing middle"
+			idx := 1 + mrand.Intn(len(keys)-2)
+			keys = append(append([][]byte{}, keys[:idx]...), keys[idx+1:]...)
+			values = append(append([][]byte{}, values[:idx]...), values[idx+1:]...)
+		case 2:
+			name = "modified value"
+			idx := mrand.Intn(len(keys))
+			values = append([][]byte{}, values...)
+			values[idx] = append(common.CopyBytes(values[idx]), 0)
+		case 3:
+			name = "extra key"
+			extra := common.CopyBytes(keys[1])
+			extra[31]++
+			if bytes.Equal(extra, keys[2]) {
+				continue
+			}
+			keys = append(append(append([][]byte{}, keys[:2]...), extra), keys[2:]...)
+			values = append(append(append([][]byte{}, values[:2]...), []byte{1}), values[2:]...)
+		case 4:
+			name = "empty range"
+			proof = proveRange(t, trie, keys[0])
+			keys, values = nil, nil
+		}
+		if _, err := VerifyRangeProof(root, entries[start].k, keys, values, proof, false); err == nil {
+			t.Fatalf("%s: range %d-%d verified", name, start, end)
+		}
+	}
+	// Without the proof, the keys must be the whole trie
+	keys, values := splitEntries(entries[1:])
+	if _, err := VerifyRangeProof(root, make([]byte, 32), keys, values, nil, false); err == nil {
+		t.Fatalf("partial trie verified without proof")
+	}
+}
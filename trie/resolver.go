@@ -2,11 +2,13 @@ package trie
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/big"
 	"runtime/debug"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -17,7 +19,11 @@ import (
 
 var emptyHash [32]byte
 
-func (t *Trie) Rebuild(db ethdb.Database, blockNr uint64) hashNode {
+// ErrWalkLimit is returned by the resolver when the walk over the keys of the
+// bucket goes past the limit set by SetWalkLimit.
+var ErrWalkLimit = errors.New("too many keys to resolve")
+
+func (t *Trie) Rebuild(db ethdb.Getter, blockNr uint64) hashNode {
 	if t.root == nil {
 		return nil
 	}
@@ -79,6 +85,8 @@ type TrieResolver struct {
 	keyIdx      int
 	h           *hasher
 	historical  bool
+	walkLimit   int // Number of the keys the walk may go over, no limit if 0
+	walked      int
 }
 
 func NewResolver(dbw ethdb.Putter, hashes bool, accounts bool) *TrieResolver {
@@ -99,6 +107,12 @@ func (tr *TrieResolver) SetHistorical(h bool) {
 	tr.historical = h
 }
 
+// SetWalkLimit limits the number of the keys the resolver walks over. Once
+// the walk goes past the limit, the resolution fails with ErrWalkLimit.
+func (tr *TrieResolver) SetWalkLimit(limit int) {
+	tr.walkLimit = limit
+}
+
 // TrieResolver implements sort.Interface
 func (tr *TrieResolver) Len() int {
 	return len(tr.continuations)
@@ -430,6 +444,11 @@ var emptyCodeHash = crypto.Keccak256(nil)
 
 func (tr *TrieResolver) Walker(keyIdx int, k []byte, v []byte) (bool, error) {
 	//fmt.Printf("%d %x %x\n", keyIdx, k, v)
+	if tr.walkLimit > 0 {
+		if tr.walked++; tr.walked > tr.walkLimit {
+			return false, ErrWalkLimit
+		}
+	}
 	if keyIdx != tr.keyIdx {
 		if tr.key_set {
 			if err := tr.finishPreviousKey(nil); err != nil {
@@ -487,7 +506,7 @@ func (tr *TrieResolver) Walker(keyIdx int, k []byte, v []byte) (bool, error) {
 	return true, nil
 }
 
-func (tr *TrieResolver) ResolveWithDb(db ethdb.Database, blockNr uint64) error {
+func (tr *TrieResolver) ResolveWithDb(db ethdb.Getter, blockNr uint64) error {
 	tr.h = newHasher(!tr.accounts)
 	defer returnHasherToPool(tr.h)
 	startkeys, fixedbits := tr.PrepareResolveParams()
@@ -498,8 +517,26 @@ func (tr *TrieResolver) ResolveWithDb(db ethdb.Database, blockNr uint64) error {
 		for i, sk := range startkeys {
 			fmt.Fprintf(&b, "sk %x, bits: %d\n", sk, fixedbits[i])
 		}
-		return fmt.Errorf("Unexpected resolution: %s at %s", b, debug.Stack())
+		return fmt.Errorf("Unexpected resolution: %s at %s", b.String(), debug.Stack())
+	}
+	// Continuations resolved from the intermediate hashes do not need the walk
+	var (
+		contIndices []int
+		walkKeys    [][]byte
+		walkBits    []uint
+	)
+	for i, idx := range tr.contIndices {
+		if !tr.resolveCached(db, tr.continuations[idx]) {
+			contIndices = append(contIndices, idx)
+			walkKeys = append(walkKeys, startkeys[i])
+			walkBits = append(walkBits, fixedbits[i])
+		}
+	}
+	if len(contIndices) == 0 {
+		return nil
 	}
+	tr.contIndices, startkeys, fixedbits = contIndices, walkKeys, walkBits
+	tr.startLevel = tr.continuations[contIndices[0]].extResolvePos
 	if tr.accounts {
 		if tr.historical {
 			err = db.MultiWalkAsOf([]byte("AT"), []byte("hAT"), startkeys, fixedbits, blockNr+1, tr.Walker)
@@ -516,13 +553,87 @@ func (tr *TrieResolver) ResolveWithDb(db ethdb.Database, blockNr uint64) error {
 	return err
 }
 
-func (t *Trie) rebuildHashes(db ethdb.Database, key []byte, pos int, blockNr uint64, accounts bool, expected hashNode) (node, hashNode, error) {
+func (t *Trie) rebuildHashes(db ethdb.Getter, key []byte, pos int, blockNr uint64, accounts bool, expected hashNode) (node, hashNode, error) {
 	tc := t.NewContinuation(key, pos, expected)
-	r := NewResolver(db, true, accounts)
+	r := NewResolver(nil, true, accounts)
 	r.SetHistorical(t.historical)
+	r.SetWalkLimit(t.walkLimit)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, blockNr); err != nil {
 		return nil, nil, err
 	}
 	return tc.resolved, expected, nil
 }
+
+// parallelResolveMinRanges is the minimal number of the independent key ranges
+// given to every worker of ResolveParallel. Below it, the cost of starting the
+// workers and the read transactions is not worth it.
+const parallelResolveMinRanges = 8
+
+// ResolveParallel resolves the continuations like ResolveWithDb, but with the
+// pool of up to the given number of workers. The continuations are sorted and
+// partitioned into contiguous key ranges, and every worker walks its own range
+// over a separate read transaction of the database. The workers only fill in
+// the resolved nodes of the continuations; these are grafted into the tries
+// when the caller runs the continuations again, in the order of its own, so
+// the resulting tries do not depend on the scheduling of the workers.
+func (tr *TrieResolver) ResolveParallel(db ethdb.Database, blockNr uint64, workers int) error {
+	if len(tr.continuations) == 0 {
+		return nil
+	}
+	sort.Stable(tr)
+	// The continuations strictly contained in the preceding ones can not be
+	// separated from them, so the ranges start at the other continuations only
+	var starts []int
+	var prevC *TrieContinuation
+	for i, c := range tr.continuations {
+		if prevC == nil || c.resolvePos < prevC.resolvePos ||
+			!bytes.Equal(c.t.prefix, prevC.t.prefix) ||
+			!bytes.HasPrefix(c.resolveKey[:c.resolvePos], prevC.resolveKey[:prevC.resolvePos]) {
+			starts = append(starts, i)
+			prevC = c
+		}
+	}
+	if max := len(starts) / parallelResolveMinRanges; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		return tr.ResolveWithDb(db, blockNr)
+	}
+	parts := make([]*TrieResolver, workers)
+	for w := range parts {
+		from, to := starts[w*len(starts)/workers], len(tr.continuations)
+		if w+1 < workers {
+			to = starts[(w+1)*len(starts)/workers]
+		}
+		part := NewResolver(tr.dbw, tr.hashes, tr.accounts)
+		part.SetHistorical(tr.historical)
+		for _, c := range tr.continuations[from:to] {
+			part.AddContinuation(c)
+		}
+		parts[w] = part
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w, part := range parts {
+		wg.Add(1)
+		go func(w int, part *TrieResolver) {
+			defer wg.Done()
+			snapshot, err := db.Begin()
+			if err != nil {
+				errs[w] = err
+				return
+			}
+			defer snapshot.Close()
+			errs[w] = part.ResolveWithDb(snapshot, blockNr)
+		}(w, part)
+	}
+	wg.Wait()
+	// The error of the lowest key range is reported, whichever failed first
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package trie
 import (
 	"bytes"
 	//"fmt"
+	"math/big"
+	"math/rand"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -15,9 +17,7 @@ func TestRebuild(t *testing.T) {
 	defer db.Close()
 	bucket := []byte("AT")
 	writeBlockNr := uint64(0)
-	tr := New(common.Hash{}, bucket, false)
-	l := NewList()
-	tr.MakeListed(l)
+	tr := New(common.Hash{}, bucket, nil, false)
 
 	keys := []string{
 		"FIRSTFIRSTFIRSTFIRSTFIRSTFIRSTFI",
@@ -50,9 +50,7 @@ func TestRebuild(t *testing.T) {
 			t.Errorf("Could not encode value: %v", err)
 		}
 		db.PutS(bucket, key, v1, writeBlockNr)
-		db.Commit()
-		t1 := New(common.BytesToHash(root1), bucket, false)
-		t1.MakeListed(l)
+		t1 := New(common.BytesToHash(root1), bucket, nil, false)
 		t1.Rebuild(db, 0)
 		//fmt.Printf("\n\n")
 	}
@@ -61,7 +59,7 @@ func TestRebuild(t *testing.T) {
 // Put 1 embedded entry into the database and try to resolve it
 func TestResolve1Embedded(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("abcdefghijklmnopqrstuvwxyz012345"), []byte("a"), 0)
 	tc := &TrieContinuation{
 		t:           tr,
@@ -73,9 +71,8 @@ func TestResolve1Embedded(t *testing.T) {
 		resolveHash: nil,
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	r := tr.NewResolver(db, false)
+	r := NewResolver(db, false, true)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, 0); err != nil {
 		t.Errorf("Could not resolve: %v", err)
@@ -85,7 +82,7 @@ func TestResolve1Embedded(t *testing.T) {
 // Put 1 embedded entry into the database and try to resolve it
 func TestResolve1(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	tc := &TrieContinuation{
 		t:           tr,
@@ -97,9 +94,8 @@ func TestResolve1(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("741326629cbf4ba5d5afebd56dd714ba4a531ddb6b07b829aa85dee4d97d34a4").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	r := tr.NewResolver(db, false)
+	r := NewResolver(db, false, true)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, 0); err != nil {
 		t.Errorf("Could not resolve: %v", err)
@@ -109,7 +105,7 @@ func TestResolve1(t *testing.T) {
 
 func TestResolve2(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	db.PutS(testbucket, []byte("aaaaaccccccccccccccccccccccccccc"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	tc := &TrieContinuation{
@@ -122,9 +118,8 @@ func TestResolve2(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("c9f98a7d966d37c7231d11910c72f01a213057111b8171f5f137269bb73e45e4").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	r := tr.NewResolver(db, false)
+	r := NewResolver(db, false, true)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, 0); err != nil {
 		t.Errorf("Could not resolve: %v", err)
@@ -134,7 +129,7 @@ func TestResolve2(t *testing.T) {
 
 func TestResolve2Keep(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	db.PutS(testbucket, []byte("aaaaaccccccccccccccccccccccccccc"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	tc := &TrieContinuation{
@@ -147,9 +142,8 @@ func TestResolve2Keep(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("c9f98a7d966d37c7231d11910c72f01a213057111b8171f5f137269bb73e45e4").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	r := tr.NewResolver(db, false)
+	r := NewResolver(db, false, true)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, 0); err != nil {
 		t.Errorf("Could not resolve: %v", err)
@@ -159,7 +153,7 @@ func TestResolve2Keep(t *testing.T) {
 
 func TestResolve3Keep(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	db.PutS(testbucket, []byte("aaaaabbbbbbbbbbbbbbbbbbbbbbbbbbb"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	db.PutS(testbucket, []byte("aaaaaccccccccccccccccccccccccccc"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
@@ -173,9 +167,8 @@ func TestResolve3Keep(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("03e27bd9cc47c0a03a8480035f765a4ba242c40ae4badfd1628af5a1ca5fd57a").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	r := tr.NewResolver(db, false)
+	r := NewResolver(db, false, true)
 	r.AddContinuation(tc)
 	if err := r.ResolveWithDb(db, 0); err != nil {
 		t.Errorf("Could not resolve: %v", err)
@@ -185,7 +178,7 @@ func TestResolve3Keep(t *testing.T) {
 
 func TestTrieResolver(t *testing.T) {
 	db := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	db.PutS(testbucket, []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 	db.PutS(testbucket, []byte("aaaaaccccccccccccccccccccccccccc"), []byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"), 0)
 
@@ -204,7 +197,6 @@ func TestTrieResolver(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("c9f98a7d966d37c7231d11910c72f01a213057111b8171f5f137269bb73e45e4").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
 	tc2 := &TrieContinuation{
 		t:           tr,
@@ -216,7 +208,6 @@ func TestTrieResolver(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("b183c6dd36a92675ab74e32008a41735f485d20df283be0f349a412c769fe6c9").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
 	tc3 := &TrieContinuation{
 		t:           tr,
@@ -228,9 +219,8 @@ func TestTrieResolver(t *testing.T) {
 		resolveHash: hashNode(common.HexToHash("b183c6dd36a92675ab74e32008a41735f485d20df283be0f349a412c769fe6c9").Bytes()),
 		resolved:    nil,
 		n:           nil,
-		touched:     []Touch{},
 	}
-	resolver := tr.NewResolver(db, false)
+	resolver := NewResolver(db, false, true)
 	resolver.AddContinuation(tc3)
 	resolver.AddContinuation(tc2)
 	resolver.AddContinuation(tc1)
@@ -239,3 +229,95 @@ func TestTrieResolver(t *testing.T) {
 	}
 	//t.Errorf("TestTrieResolver resolved:\n%s\n", tc3.resolved.fstring(""))
 }
+
+// makeAccounts fills the account bucket with the random accounts and returns
+// the root of their trie together with the keys.
+func makeAccounts(t testing.TB, db ethdb.Database, n int) (common.Hash, [][]byte) {
+	bucket := []byte("AT")
+	tr := New(common.Hash{}, bucket, nil, false)
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([][]byte, n)
+	for i := range keys {
+		key := make([]byte, 32)
+		rnd.Read(key)
+		value, err := rlp.EncodeToBytes(Account{
+			Nonce:    uint64(i),
+			Balance:  big.NewInt(int64(i)),
+			Root:     emptyRoot,
+			CodeHash: emptyCodeHash,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Update(db, key, value, 0)
+		if err := db.Put(bucket, key, value); err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	return tr.Hash(), keys
+}
+
+// updateAccounts updates the accounts in the trie loaded from the database,
+// resolving the missing nodes with the given number of workers, and returns
+// the new root.
+func updateAccounts(t testing.TB, db ethdb.Database, root common.Hash, keys [][]byte, workers int) common.Hash {
+	tr := New(root, []byte("AT"), nil, false)
+	var continuations []*TrieContinuation
+	for i, key := range keys {
+		continuations = append(continuations, tr.UpdateAction(key, []byte{byte(i), 1}))
+	}
+	for len(continuations) > 0 {
+		var pending []*TrieContinuation
+		resolver := NewResolver(nil, false, true)
+		for _, c := range continuations {
+			if !c.RunWithDb(db, 0) {
+				pending = append(pending, c)
+				resolver.AddContinuation(c)
+			}
+		}
+		if len(pending) > 0 {
+			var err error
+			if workers > 1 {
+				err = resolver.ResolveParallel(db, 0, workers)
+			} else {
+				err = resolver.ResolveWithDb(db, 0)
+			}
+			if err != nil {
+				t.Fatalf("failed to resolve: %v", err)
+			}
+		}
+		continuations = pending
+	}
+	return tr.Hash()
+}
+
+func TestResolveParallel(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	root, keys := makeAccounts(t, db, 5000)
+	keys = keys[:1000]
+
+	expected := updateAccounts(t, db, root, keys, 1)
+	for _, workers := range []int{2, 4, 16} {
+		if got := updateAccounts(t, db, root, keys, workers); got != expected {
+			t.Errorf("%d workers: root %x, expected %x", workers, got, expected)
+		}
+	}
+}
+
+func benchmarkResolve(b *testing.B, workers int) {
+	db := ethdb.NewMemDatabase()
+	defer db.Close()
+	root, keys := makeAccounts(b, db, 100000)
+	keys = keys[:5000]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		updateAccounts(b, db, root, keys, workers)
+	}
+}
+
+func BenchmarkResolveSequential(b *testing.B) { benchmarkResolve(b, 1) }
+func BenchmarkResolveParallel4(b *testing.B)  { benchmarkResolve(b, 4) }
+func BenchmarkResolveParallel16(b *testing.B) { benchmarkResolve(b, 16) }
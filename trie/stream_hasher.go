@@ -0,0 +1,243 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+const (
+	streamLeaf = iota
+	streamExtension
+	streamBranch
+	streamCollapsed
+)
+
+// streamNode is a node on the path to the last leaf added to the StreamHasher.
+type streamNode struct {
+	kind     int
+	key      []byte          // Hex key of the leaf or the extension, relative to the node
+	value    []byte          // Value of the leaf
+	children [16]*streamNode // Children of the branch, the child of the extension at 0
+	node     node            // Hash of the collapsed node, or the node itself if it is embedded
+}
+
+// StreamHasher computes the root hash of a trie from its leaves, which have to
+// be added in the ascending order of the keys. Only the nodes on the path to the
+// last leaf are kept in memory, the nodes left of it are collapsed into their
+// hashes as soon as they are complete, so that the tries of any size can be
+// hashed straight from the buckets.
+type StreamHasher struct {
+	h      *hasher
+	root   *streamNode
+	last   []byte // Hex key of the last leaf added
+	levels int    // Number of the top levels whose branch nodes are kept
+
+	branches map[string][]byte // Encoded branch nodes of the top levels, by hex path
+}
+
+// NewStreamHasher creates a StreamHasher. The values are given as they are
+// stored in the bucket of the trie, which is why encodeToBytes has to match it.
+func NewStreamHasher(encodeToBytes bool) *StreamHasher {
+	return &StreamHasher{h: newHasher(encodeToBytes)}
+}
+
+// KeepBranches makes the hasher keep the branch nodes in the top levels of the
+// trie, to compare them with the intermediate hashes by Mismatches.
+func (s *StreamHasher) KeepBranches(levels int) {
+	s.levels = levels
+	s.branches = make(map[string][]byte)
+}
+
+// Add adds the leaf to the trie. The key has to follow the key of the previous
+// leaf and be of the same length.
+func (s *StreamHasher) Add(key, value []byte) error {
+	hex := keybytesToHex(key)
+	if s.last != nil && (len(hex) != len(s.last) || bytes.Compare(hex, s.last) <= 0) {
+		return fmt.Errorf("key %x does not follow %x", key, hexToKeybytes(s.last))
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("empty value of key %x", key)
+	}
+	s.root = s.insert(s.root, 0, hex, common.CopyBytes(value))
+	s.last = hex
+	return nil
+}
+
+// Hash returns the root hash of the trie of all the leaves added so far. No
+// more leaves can be added after it.
+func (s *StreamHasher) Hash() common.Hash {
+	if s.root == nil {
+		return emptyRoot
+	}
+	var hash common.Hash
+	s.h.hash(s.node(s.root, 0), true, hash[:])
+	return hash
+}
+
+// insert inserts the leaf with the rest of the hex key into the node at the
+// given depth, returning the replacement of the node. The nodes left of the
+// new leaf are collapsed.
+func (s *StreamHasher) insert(n *streamNode, depth int, key, value []byte) *streamNode {
+	if n == nil {
+		return &streamNode{kind: streamLeaf, key: key, value: value}
+	}
+	switch n.kind {
+	case streamBranch:
+		if prev := s.last[depth]; prev != key[0] {
+			s.collapse(n.children[prev], depth+1)
+		}
+		n.children[key[0]] = s.insert(n.children[key[0]], depth+1, key[1:], value)
+		return n
+
+	case streamExtension:
+		shared := prefixLen(key, n.key)
+		if shared == len(n.key) {
+			n.children[0] = s.insert(n.children[0], depth+shared, key[shared:], value)
+			return n
+		}
+		// The new leaf splits the extension, everything below it is complete
+		old := n.children[0]
+		if shared+1 < len(n.key) {
+			old = &streamNode{kind: streamExtension, key: n.key[shared+1:], children: [16]*streamNode{n.children[0]}}
+		}
+		branch := s.split(depth+shared, n.key[shared], old, key[shared:], value)
+		if shared == 0 {
+			return branch
+		}
+		n.key, n.children[0] = n.key[:shared], branch
+		return n
+
+	case streamLeaf:
+		shared := prefixLen(key, n.key)
+		old := &streamNode{kind: streamLeaf, key: n.key[shared+1:], value: n.value}
+		branch := s.split(depth+shared, n.key[shared], old, key[shared:], value)
+		if shared == 0 {
+			return branch
+		}
+		return &streamNode{kind: streamExtension, key: key[:shared], children: [16]*streamNode{branch}}
+
+	default:
+		panic(fmt.Sprintf("leaf inserted into collapsed node at depth %d", depth))
+	}
+}
+
+// split creates the branch node at the given depth with the complete subtrie
+// at the nibble and the new leaf.
+func (s *StreamHasher) split(depth int, nibble byte, old *streamNode, key, value []byte) *streamNode {
+	s.collapse(old, depth+1)
+	branch := &streamNode{kind: streamBranch}
+	branch.children[nibble] = old
+	branch.children[key[0]] = &streamNode{kind: streamLeaf, key: key[1:], value: value}
+	return branch
+}
+
+// collapse replaces the complete node at the given depth with its hash, or with
+// the node itself if it is small enough to be embedded into its parent.
+func (s *StreamHasher) collapse(n *streamNode, depth int) {
+	if n.kind == streamCollapsed {
+		return
+	}
+	tn := s.node(n, depth)
+	var hash common.Hash
+	if s.h.hash(tn, false, hash[:]) == common.HashLength {
+		tn = hashNode(hash[:])
+	}
+	*n = streamNode{kind: streamCollapsed, node: tn}
+}
+
+// node converts the complete node at the given depth into the trie node,
+// collapsing its children.
+func (s *StreamHasher) node(n *streamNode, depth int) node {
+	switch n.kind {
+	case streamCollapsed:
+		return n.node
+	case streamLeaf:
+		return &shortNode{Key: hexToCompact(n.key), Val: valueNode(n.value), flags: nodeFlag{dirty: true}}
+	case streamExtension:
+		s.collapse(n.children[0], depth+len(n.key))
+		return &shortNode{Key: hexToCompact(n.key), Val: n.children[0].node, flags: nodeFlag{dirty: true}}
+	default:
+		f := &fullNode{flags: nodeFlag{dirty: true}}
+		for i, child := range n.children {
+			if child != nil {
+				s.collapse(child, depth+1)
+				f.Children[i] = child.node
+			}
+		}
+		if depth < s.levels {
+			// The branch nodes are only collapsed on the path to the last leaf
+			if enc, ok := encodeBranch(s.h, f); ok {
+				s.branches[string(s.last[:depth])] = enc
+			}
+		}
+		return f
+	}
+}
+
+// SubtrieMismatch is a subtrie whose hash computed from the leaves differs
+// from the one found in the intermediate hashes.
+type SubtrieMismatch struct {
+	Path     []byte      // Hex path of the subtrie, one byte per nibble
+	Expected common.Hash // Hash of the subtrie in the intermediate hashes, zero if it should not exist
+	Computed common.Hash // Hash of the subtrie computed from the leaves, zero if it is missing
+}
+
+// Mismatches locates the subtries of the hashed trie that differ from the trie
+// with the expected root, whose branch nodes are taken from the intermediate
+// hashes of the trie with the given prefix. Starting from the root, it goes down
+// the differing children as long as the intermediate hashes are consistent with
+// the expected root and the hasher kept the branch nodes, and returns the
+// deepest subtries found to differ.
+func (s *StreamHasher) Mismatches(db ethdb.Getter, prefix []byte, expected common.Hash) []SubtrieMismatch {
+	var (
+		mismatches []SubtrieMismatch
+		descend    func(hex []byte, expected, computed common.Hash)
+	)
+	descend = func(hex []byte, expected, computed common.Hash) {
+		if expected == computed {
+			return
+		}
+		var want, have node
+		if expected != (common.Hash{}) && computed != (common.Hash{}) {
+			want = loadBranch(db, s.h, prefix, hex, expected[:])
+			if enc, ok := s.branches[string(hex)]; ok {
+				have = decodeBranch(enc)
+			}
+		}
+		if want == nil || have == nil {
+			mismatches = append(mismatches, SubtrieMismatch{Path: common.CopyBytes(hex), Expected: expected, Computed: computed})
+			return
+		}
+		for i := byte(0); i < 16; i++ {
+			var e, c common.Hash
+			if hn, ok := childAt(want, i).(hashNode); ok {
+				e = common.BytesToHash(hn)
+			}
+			if hn, ok := childAt(have, i).(hashNode); ok {
+				c = common.BytesToHash(hn)
+			}
+			descend(append(append([]byte{}, hex...), i), e, c)
+		}
+	}
+	descend(nil, expected, s.Hash())
+	return mismatches
+}
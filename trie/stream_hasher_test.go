@@ -0,0 +1,127 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	mrand "math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+func TestStreamHasher(t *testing.T) {
+	for _, encodeToBytes := range []bool{false, true} {
+		for _, n := range []int{0, 1, 2, 17, 500} {
+			trie, entries := sortedTrie(n, encodeToBytes)
+			s := NewStreamHasher(encodeToBytes)
+			for _, e := range entries {
+				if err := s.Add(e.k, e.v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if have, want := s.Hash(), trie.Hash(); have != want {
+				t.Errorf("%d keys (encodeToBytes %t): have root %x, want %x", n, encodeToBytes, have, want)
+			}
+		}
+	}
+}
+
+func TestStreamHasherShortKeys(t *testing.T) {
+	// The short keys with the long shared prefixes make the extension nodes,
+	// and the small values make the embedded nodes
+	for i := 0; i < 50; i++ {
+		trie := New(common.Hash{}, testbucket, nil, false)
+		keys := make(map[string][]byte)
+		for j := 0; j < 1+mrand.Intn(40); j++ {
+			key := []byte{byte(mrand.Intn(3)), 0xaa, byte(mrand.Intn(2)), byte(mrand.Intn(4))}
+			value := []byte{byte(1 + mrand.Intn(255))}
+			keys[string(key)] = value
+			trie.Update(nil, key, value, 0)
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		s := NewStreamHasher(false)
+		for _, key := range sorted {
+			if err := s.Add([]byte(key), keys[key]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if have, want := s.Hash(), trie.Hash(); have != want {
+			t.Fatalf("keys %x: have root %x, want %x", sorted, have, want)
+		}
+	}
+}
+
+func TestStreamHasherOrder(t *testing.T) {
+	s := NewStreamHasher(false)
+	if err := s.Add([]byte{1, 2}, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add([]byte{1, 2}, []byte{2}); err == nil {
+		t.Error("duplicate key accepted")
+	}
+	if err := s.Add([]byte{1, 1}, []byte{2}); err == nil {
+		t.Error("descending key accepted")
+	}
+	if err := s.Add([]byte{1, 3}, nil); err == nil {
+		t.Error("empty value accepted")
+	}
+}
+
+func TestStreamHasherMismatches(t *testing.T) {
+	db, hashDb := ethdb.NewMemDatabase(), ethdb.NewMemDatabase()
+	tr, keys := makeStorageTrie(t, db, hashDb, 1000)
+	root := tr.Hash()
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	hash := func(bad []byte) *StreamHasher {
+		s := NewStreamHasher(true)
+		s.KeepBranches(IntermediateHashLevels)
+		for _, key := range keys {
+			value, _ := db.Get(testStorageBucket, append(common.CopyBytes(testStoragePrefix), key...))
+			if bytes.Equal(key, bad) {
+				value = append(common.CopyBytes(value), 0)
+			}
+			if err := s.Add(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+	if mismatches := hash(nil).Mismatches(hashDb, testStoragePrefix, root); len(mismatches) != 0 {
+		t.Fatalf("mismatches in the intact trie: %v", mismatches)
+	}
+	bad := keys[mrand.Intn(len(keys))]
+	mismatches := hash(bad).Mismatches(hashDb, testStoragePrefix, root)
+	if len(mismatches) != 1 {
+		t.Fatalf("have %d mismatches, want 1", len(mismatches))
+	}
+	if m := mismatches[0]; len(m.Path) == 0 || !bytes.HasPrefix(keybytesToHex(bad), m.Path) {
+		t.Errorf("mismatch at %x, want a path to %x", m.Path, keybytesToHex(bad))
+	}
+	// Without the intermediate hashes only the root is known to differ
+	mismatches = hash(bad).Mismatches(ethdb.NewMemDatabase(), testStoragePrefix, root)
+	if len(mismatches) != 1 || len(mismatches[0].Path) != 0 || mismatches[0].Expected != root {
+		t.Errorf("have mismatches %v, want the root", mismatches)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"unsafe"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -64,6 +65,7 @@ type Trie struct {
 	accounts      bool
 
 	historical     bool
+	walkLimit      int
 	resolveReads   bool
 	joinGeneration func(gen uint64)
 	leftGeneration func(gen uint64)
@@ -687,6 +689,12 @@ func (t *Trie) SetHistorical(h bool) {
 	}
 }
 
+// SetWalkLimit limits the number of the keys walked over by every resolution
+// of the nodes missing from the trie, see TrieResolver.SetWalkLimit.
+func (t *Trie) SetWalkLimit(limit int) {
+	t.walkLimit = limit
+}
+
 func (t *Trie) SetResolveReads(rr bool) {
 	t.resolveReads = rr
 }
@@ -743,9 +751,17 @@ func (t *Trie) TryGet(db ethdb.Database, key []byte, blockNr uint64) (value []by
 	return value, err
 }
 
+// TryGetLoaded looks the key up in the nodes loaded into the trie, without
+// resolving them from the database. It returns false if the key leads into a
+// hash node.
+func (t *Trie) TryGetLoaded(key []byte, blockNr uint64) (value []byte, ok bool) {
+	return t.tryGet1(nil, t.root, keybytesToHex(key), 0, blockNr)
+}
+
 func (t *Trie) tryGet(dbr DatabaseReader, origNode node, key []byte, pos int, blockNr uint64) (value []byte, err error) {
 	if t.historical {
-		value, err = dbr.GetAsOf(t.bucket[1:], t.bucket, append(t.prefix, key...), blockNr)
+		// Like the resolver, read the state after the block
+		value, err = dbr.GetAsOf(t.bucket[1:], t.bucket, append(t.prefix, key...), blockNr+1)
 	} else {
 		value, err = dbr.Get(t.bucket, append(t.prefix, key...))
 	}
@@ -2056,6 +2072,67 @@ func countNodes(n node, m map[uint64]int) int {
 	return 0
 }
 
+// Sizes of the node structures, the byte slices referenced by the nodes are
+// accounted for separately.
+var (
+	fullNodeSize  = int(unsafe.Sizeof(fullNode{}))
+	duoNodeSize   = int(unsafe.Sizeof(duoNode{}))
+	shortNodeSize = int(unsafe.Sizeof(shortNode{}))
+)
+
+// CountNodeSizes adds the memory taken by the loaded nodes of the trie to the
+// sizes by the block the nodes were last touched at, and returns the memory
+// taken by the whole trie. The hash and value nodes, which carry no touch
+// time, are accounted for together with their parents.
+func (t *Trie) CountNodeSizes(m map[uint64]int) int {
+	return countNodeSizes(t.root, m)
+}
+
+func countNodeSizes(n node, m map[uint64]int) int {
+	switch n := n.(type) {
+	case *shortNode:
+		size := shortNodeSize + cap(n.Key)
+		total := 0
+		switch v := n.Val.(type) {
+		case hashNode:
+			size += cap(v)
+		case valueNode:
+			size += cap(v)
+		default:
+			total = countNodeSizes(v, m)
+		}
+		m[n.flags.t] += size
+		return total + size
+	case *duoNode:
+		size, total := duoNodeSize, 0
+		for _, child := range [2]node{n.child1, n.child2} {
+			if hn, ok := child.(hashNode); ok {
+				size += cap(hn)
+			} else {
+				total += countNodeSizes(child, m)
+			}
+		}
+		m[n.flags.t] += size
+		return total + size
+	case *fullNode:
+		size, total := fullNodeSize, 0
+		for _, child := range n.Children {
+			switch c := child.(type) {
+			case nil:
+			case hashNode:
+				size += cap(c)
+			case valueNode:
+				size += cap(c)
+			default:
+				total += countNodeSizes(c, m)
+			}
+		}
+		m[n.flags.t] += size
+		return total + size
+	}
+	return 0
+}
+
 func (t *Trie) CountOccupancies(db ethdb.Database, blockNr uint64, o map[int]map[int]int) {
 	if hn, ok := t.root.(hashNode); ok {
 		n, err := t.resolveHash(db, hn, []byte{}, 0, blockNr)
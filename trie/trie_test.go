@@ -43,12 +43,12 @@ func init() {
 // Used for testing
 func newEmpty() (ethdb.Database, *Trie) {
 	diskdb := ethdb.NewMemDatabase()
-	trie := New(common.Hash{}, testbucket, false)
+	trie := New(common.Hash{}, testbucket, nil, false)
 	return diskdb, trie
 }
 
 func TestEmptyTrie(t *testing.T) {
-	var trie Trie
+	trie := New(common.Hash{}, testbucket, nil, false)
 	res := trie.Hash()
 	exp := emptyRoot
 	if res != common.Hash(exp) {
@@ -57,7 +57,7 @@ func TestEmptyTrie(t *testing.T) {
 }
 
 func TestNull(t *testing.T) {
-	var trie Trie
+	trie := New(common.Hash{}, testbucket, nil, false)
 	key := make([]byte, 32)
 	value := []byte("test")
 	trie.Update(nil, key, value, 0)
@@ -72,32 +72,32 @@ func testMissingNodeMemonly(t *testing.T) { testMissingNode(t, true) }
 func testMissingNode(t *testing.T, memonly bool) {
 	diskdb := ethdb.NewMemDatabase()
 
-	trie := New(common.Hash{}, testbucket, false)
+	trie := New(common.Hash{}, testbucket, nil, false)
 	updateString(trie, diskdb, "120000", "qwerqwerqwerqwerqwerqwerqwerqwer")
 	updateString(trie, diskdb, "123456", "asdfasdfasdfasdfasdfasdfasdfasdf")
 	root := trie.Hash()
 
-	trie = New(root, testbucket, false)
-	_, _, err := trie.TryGet(diskdb, []byte("120000"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err := trie.TryGet(diskdb, []byte("120000"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	trie = New(root, testbucket, false)
-	_, _, err = trie.TryGet(diskdb, []byte("120099"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err = trie.TryGet(diskdb, []byte("120099"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	trie = New(root, testbucket, false)
-	_, _, err = trie.TryGet(diskdb, []byte("123456"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err = trie.TryGet(diskdb, []byte("123456"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	trie = New(root, testbucket, false)
+	trie = New(root, testbucket, nil, false)
 	err = trie.TryUpdate(diskdb, []byte("120099"), []byte("zxcvzxcvzxcvzxcvzxcvzxcvzxcvzxcv"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	trie = New(root, testbucket, false)
+	trie = New(root, testbucket, nil, false)
 	err = trie.TryDelete(diskdb, []byte("123456"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
@@ -108,27 +108,27 @@ func testMissingNode(t *testing.T, memonly bool) {
 		diskdb.Delete(testbucket, hash[:])
 	}
 
-	trie = New(root, testbucket, false)
-	_, _, err = trie.TryGet(diskdb, []byte("120000"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err = trie.TryGet(diskdb, []byte("120000"), 0)
 	if _, ok := err.(*MissingNodeError); !ok {
 		t.Errorf("Wrong error: %v", err)
 	}
-	trie = New(root, testbucket, false)
-	_, _, err = trie.TryGet(diskdb, []byte("120099"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err = trie.TryGet(diskdb, []byte("120099"), 0)
 	if _, ok := err.(*MissingNodeError); !ok {
 		t.Errorf("Wrong error: %v", err)
 	}
-	trie = New(root, testbucket, false)
-	_, _, err = trie.TryGet(diskdb, []byte("123456"), 0)
+	trie = New(root, testbucket, nil, false)
+	_, err = trie.TryGet(diskdb, []byte("123456"), 0)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	trie = New(root, testbucket, false)
+	trie = New(root, testbucket, nil, false)
 	err = trie.TryUpdate(diskdb, []byte("120099"), []byte("zxcv"), 0)
 	if _, ok := err.(*MissingNodeError); !ok {
 		t.Errorf("Wrong error: %v", err)
 	}
-	trie = New(root, testbucket, false)
+	trie = New(root, testbucket, nil, false)
 	err = trie.TryDelete(diskdb, []byte("123456"), 0)
 	if _, ok := err.(*MissingNodeError); !ok {
 		t.Errorf("Wrong error: %v", err)
@@ -250,7 +250,7 @@ func testReplication(t *testing.T) {
 	exp := trie.Hash()
 
 	// create a new trie on top of the database and check that lookups work.
-	trie2 := New(exp, testbucket, false)
+	trie2 := New(exp, testbucket, nil, false)
 	for _, kv := range vals {
 		if string(getString(trie2, diskdb, kv.k)) != kv.v {
 			t.Errorf("trie2 doesn't have %q => %q", kv.k, kv.v)
@@ -353,7 +353,7 @@ func (randTest) Generate(r *rand.Rand, size int) reflect.Value {
 
 func runRandTest(rt randTest) bool {
 	diskdb := ethdb.NewMemDatabase()
-	tr := New(common.Hash{}, testbucket, false)
+	tr := New(common.Hash{}, testbucket, nil, false)
 	values := make(map[string]string) // tracks content of the trie
 
 	for i, step := range rt {
@@ -375,10 +375,10 @@ func runRandTest(rt randTest) bool {
 			tr.Hash()
 		case opReset:
 			hash := tr.Hash()
-			newtr := New(hash, testbucket, false)
+			newtr := New(hash, testbucket, nil, false)
 			tr = newtr
 		case opItercheckhash:
-			checktr := New(common.Hash{}, testbucket, false)
+			checktr := New(common.Hash{}, testbucket, nil, false)
 			it := NewIterator(tr.NodeIterator(diskdb, nil, 0))
 			for it.Next() {
 				checktr.Update(diskdb, it.Key, it.Value, 0)
@@ -413,11 +413,11 @@ func BenchmarkUpdateLE(b *testing.B) { benchUpdate(b, binary.LittleEndian) }
 const benchElemCount = 20000
 
 func benchGet(b *testing.B, commit bool) {
-	trie := new(Trie)
+	trie := New(common.Hash{}, testbucket, nil, false)
 	var tmpdb ethdb.Database
 	if commit {
 		_, tmpdb = tempDB()
-		trie = New(common.Hash{}, testbucket, false)
+		trie = New(common.Hash{}, testbucket, nil, false)
 	}
 	k := make([]byte, 32)
 	for i := 0; i < benchElemCount; i++ {
@@ -489,7 +489,7 @@ func tempDB() (string, ethdb.Database) {
 	if err != nil {
 		panic(fmt.Sprintf("can't create temporary directory: %v", err))
 	}
-	diskdb, err := ethdb.NewLDBDatabase(dir, 256)
+	diskdb, err := ethdb.NewLDBDatabase(dir)
 	if err != nil {
 		panic(fmt.Sprintf("can't create temporary database: %v", err))
 	}
@@ -507,3 +507,40 @@ func updateString(trie *Trie, db ethdb.Database, k, v string) {
 func deleteString(trie *Trie, db ethdb.Database, k string) {
 	trie.Delete(db, []byte(k), 0)
 }
+
+func TestCountNodeSizes(t *testing.T) {
+	leaf := &shortNode{Key: make([]byte, 3, 4), Val: valueNode(make([]byte, 5, 6))}
+	leaf.flags.t = 3
+	ext := &shortNode{Key: make([]byte, 2), Val: hashNode(make([]byte, 32))}
+	ext.flags.t = 2
+	duo := &duoNode{child1: leaf, child2: hashNode(make([]byte, 32))}
+	duo.flags.t = 2
+	full := &fullNode{}
+	full.Children[0] = duo
+	full.Children[1] = ext
+	full.Children[2] = hashNode(make([]byte, 32))
+	full.Children[16] = valueNode(make([]byte, 7))
+	full.flags.t = 1
+	trie := &Trie{root: full}
+
+	sizes := make(map[uint64]int)
+	total := trie.CountNodeSizes(sizes)
+	want := map[uint64]int{
+		1: fullNodeSize + 32 + 7,
+		2: duoNodeSize + 32 + shortNodeSize + 2 + 32,
+		3: shortNodeSize + 4 + 6,
+	}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("sizes mismatch: have %v, want %v", sizes, want)
+	}
+	if exp := want[1] + want[2] + want[3]; total != exp {
+		t.Errorf("total mismatch: have %d, want %d", total, exp)
+	}
+	// The sizes are added to the ones already counted
+	if total := trie.CountNodeSizes(sizes); total != want[1]+want[2]+want[3] || sizes[3] != 2*want[3] {
+		t.Errorf("sizes not accumulated: total %d, sizes %v", total, sizes)
+	}
+	if total := (&Trie{root: hashNode(make([]byte, 32))}).CountNodeSizes(make(map[uint64]int)); total != 0 {
+		t.Errorf("unresolved trie should take no memory, have %d", total)
+	}
+}